@@ -82,13 +82,13 @@ export default function PlaylistPage() {
     }
   };
 
-  const handleRemoveTrack = async (trackId: string) => {
+  const handleRemoveTrack = async (entryId: string) => {
     if (!confirm('Remove this track from the playlist?')) {
       return;
     }
 
     try {
-      await playlistAPI.removeTrack(playlistId, trackId);
+      await playlistAPI.removeTrack(playlistId, entryId);
       await loadPlaylist(); // Reload playlist to get updated tracks
       toast.success('Track removed from playlist');
     } catch (error: any) {
@@ -150,45 +150,47 @@ export default function PlaylistPage() {
               </div>
             </div>
             <div className="flex items-center gap-2">
-              <Dialog open={isAddDialogOpen} onOpenChange={setIsAddDialogOpen}>
-                <DialogTrigger asChild>
-                  <Button variant="outline">
-                    <Plus className="h-4 w-4 mr-2" />
-                    Add Tracks
-                  </Button>
-                </DialogTrigger>
-                <DialogContent className="max-w-2xl">
-                  <DialogHeader>
-                    <DialogTitle>Add Tracks to Playlist</DialogTitle>
-                    <DialogDescription>
-                      Select tracks to add to "{playlist.name}"
-                    </DialogDescription>
-                  </DialogHeader>
-                  <div className="max-h-96 overflow-y-auto space-y-2">
-                    {tracksNotInPlaylist.length === 0 ? (
-                      <p className="text-center text-muted-foreground py-8">
-                        All your tracks are already in this playlist
-                      </p>
-                    ) : (
-                      tracksNotInPlaylist.map((track) => (
-                        <div key={track.id} className="flex items-center justify-between p-3 border rounded-lg">
-                          <div className="flex-1">
-                            <p className="font-medium">{track.title}</p>
-                            <p className="text-sm text-muted-foreground">{track.artist}</p>
+              {!playlist.smart && (
+                <Dialog open={isAddDialogOpen} onOpenChange={setIsAddDialogOpen}>
+                  <DialogTrigger asChild>
+                    <Button variant="outline">
+                      <Plus className="h-4 w-4 mr-2" />
+                      Add Tracks
+                    </Button>
+                  </DialogTrigger>
+                  <DialogContent className="max-w-2xl">
+                    <DialogHeader>
+                      <DialogTitle>Add Tracks to Playlist</DialogTitle>
+                      <DialogDescription>
+                        Select tracks to add to "{playlist.name}"
+                      </DialogDescription>
+                    </DialogHeader>
+                    <div className="max-h-96 overflow-y-auto space-y-2">
+                      {tracksNotInPlaylist.length === 0 ? (
+                        <p className="text-center text-muted-foreground py-8">
+                          All your tracks are already in this playlist
+                        </p>
+                      ) : (
+                        tracksNotInPlaylist.map((track) => (
+                          <div key={track.id} className="flex items-center justify-between p-3 border rounded-lg">
+                            <div className="flex-1">
+                              <p className="font-medium">{track.title}</p>
+                              <p className="text-sm text-muted-foreground">{track.artist}</p>
+                            </div>
+                            <Button
+                              size="sm"
+                              onClick={() => handleAddTrack(track.id)}
+                              disabled={isAdding}
+                            >
+                              Add
+                            </Button>
                           </div>
-                          <Button
-                            size="sm"
-                            onClick={() => handleAddTrack(track.id)}
-                            disabled={isAdding}
-                          >
-                            Add
-                          </Button>
-                        </div>
-                      ))
-                    )}
-                  </div>
-                </DialogContent>
-              </Dialog>
+                        ))
+                      )}
+                    </div>
+                  </DialogContent>
+                </Dialog>
+              )}
               <Button onClick={handlePlayPlaylist} disabled={!playlist.tracks?.length}>
                 <Play className="h-4 w-4 mr-2" />
                 Play All
@@ -204,6 +206,11 @@ export default function PlaylistPage() {
           <Badge variant="secondary">
             {playlist.tracks?.length || 0} tracks
           </Badge>
+          {playlist.smart && (
+            <Badge variant="outline" title={playlist.rule}>
+              Smart playlist
+            </Badge>
+          )}
           <Badge variant="outline">
             {Math.round((playlist.tracks?.reduce((acc, track) => acc + track.duration, 0) || 0) / 60)} min total
           </Badge>
@@ -214,18 +221,26 @@ export default function PlaylistPage() {
           <div className="text-center py-12">
             <Music className="h-12 w-12 mx-auto mb-4 text-muted-foreground" />
             <h3 className="text-lg font-medium mb-2">No tracks in this playlist</h3>
-            <p className="text-muted-foreground mb-4">
-              Add some tracks to get started
-            </p>
-            <Button onClick={() => setIsAddDialogOpen(true)}>
-              <Plus className="h-4 w-4 mr-2" />
-              Add Tracks
-            </Button>
+            {playlist.smart ? (
+              <p className="text-muted-foreground mb-4">
+                No tracks in your library match this playlist's rule
+              </p>
+            ) : (
+              <>
+                <p className="text-muted-foreground mb-4">
+                  Add some tracks to get started
+                </p>
+                <Button onClick={() => setIsAddDialogOpen(true)}>
+                  <Plus className="h-4 w-4 mr-2" />
+                  Add Tracks
+                </Button>
+              </>
+            )}
           </div>
         ) : (
           <div className="space-y-2">
             {playlist.tracks.map((track, index) => (
-              <Card key={track.id} className="hover:shadow-sm transition-shadow">
+              <Card key={track.entry_id ?? track.id} className="hover:shadow-sm transition-shadow">
                 <CardContent className="p-4">
                   <div className="flex items-center gap-4">
                     <div className="flex items-center gap-3">
@@ -263,13 +278,15 @@ export default function PlaylistPage() {
                       >
                         <Plus className="h-4 w-4" />
                       </Button>
-                      <Button
-                        variant="ghost"
-                        size="sm"
-                        onClick={() => handleRemoveTrack(track.id)}
-                      >
-                        <Trash2 className="h-4 w-4" />
-                      </Button>
+                      {track.entry_id && (
+                        <Button
+                          variant="ghost"
+                          size="sm"
+                          onClick={() => handleRemoveTrack(track.entry_id!)}
+                        >
+                          <Trash2 className="h-4 w-4" />
+                        </Button>
+                      )}
                     </div>
                   </div>
                 </CardContent>
@@ -81,6 +81,17 @@ export default function UploadPage() {
 
         toast.success(`Uploaded: ${fileItem.file.name}`);
       } catch (error: any) {
+        // The same file is already in the library or the trash
+        if (error.response?.status === 409 && error.response.data?.track) {
+          setFiles(prev => prev.map(f =>
+            f.id === fileItem.id
+              ? { ...f, status: 'success', progress: 100 }
+              : f
+          ));
+          const { track, in_trash } = error.response.data;
+          toast.info(`${in_trash ? 'Already in your trash' : 'Already in your library'}: ${track.title}`);
+          continue;
+        }
         setFiles(prev => prev.map(f => 
           f.id === fileItem.id 
             ? { 
@@ -90,7 +101,9 @@ export default function UploadPage() {
               }
             : f
         ));
-        toast.error(`Failed to upload: ${fileItem.file.name}`);
+        toast.error(error.response?.status === 507
+          ? `Storage quota exceeded: ${fileItem.file.name}`
+          : `Failed to upload: ${fileItem.file.name}`);
       }
     }
 
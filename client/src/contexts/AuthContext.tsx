@@ -38,6 +38,7 @@ export function AuthProvider({ children }: { children: React.ReactNode }) {
       setUser(response.user);
       
       localStorage.setItem('token', response.token);
+      localStorage.setItem('refresh_token', response.refresh_token);
       localStorage.setItem('user', JSON.stringify(response.user));
     } catch (error) {
       throw error;
@@ -52,6 +53,7 @@ export function AuthProvider({ children }: { children: React.ReactNode }) {
       setUser(response.user);
       
       localStorage.setItem('token', response.token);
+      localStorage.setItem('refresh_token', response.refresh_token);
       localStorage.setItem('user', JSON.stringify(response.user));
     } catch (error) {
       throw error;
@@ -69,6 +71,7 @@ export function AuthProvider({ children }: { children: React.ReactNode }) {
       setToken(null);
       setUser(null);
       localStorage.removeItem('token');
+      localStorage.removeItem('refresh_token');
       localStorage.removeItem('user');
     }
   };
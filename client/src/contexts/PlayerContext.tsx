@@ -1,7 +1,10 @@
 'use client';
 
 import React, { createContext, useContext, useState, useRef, useEffect } from 'react';
-import { Track } from '@/lib/api';
+import { Track, PlayEvent, trackAPI } from '@/lib/api';
+
+// How often playback progress is reported, in seconds
+const PROGRESS_INTERVAL = 15;
 
 interface PlayerContextType {
   currentTrack: Track | null;
@@ -37,6 +40,16 @@ export function PlayerProvider({ children }: { children: React.ReactNode }) {
   
   const audioRef = useRef<HTMLAudioElement | null>(null);
   const currentObjectUrlRef = useRef<string | null>(null);
+  const playRef = useRef<{ trackId: string; playId: string; reportedAt: number } | null>(null);
+
+  const reportPlay = (event: PlayEvent['event'], position: number) => {
+    const current = playRef.current;
+    if (!current) return;
+    current.reportedAt = position;
+    trackAPI
+      .reportPlay(current.trackId, { play_id: current.playId, event, position: Math.floor(position) })
+      .catch(() => {});
+  };
 
   useEffect(() => {
     if (typeof window !== 'undefined') {
@@ -50,9 +63,14 @@ export function PlayerProvider({ children }: { children: React.ReactNode }) {
       
       audio.addEventListener('timeupdate', () => {
         setCurrentTime(audio.currentTime || 0);
+        if (playRef.current && audio.currentTime - playRef.current.reportedAt >= PROGRESS_INTERVAL) {
+          reportPlay('progress', audio.currentTime);
+        }
       });
       
       audio.addEventListener('ended', () => {
+        reportPlay('complete', audio.currentTime);
+        playRef.current = null;
         next();
       });
       
@@ -98,6 +116,9 @@ export function PlayerProvider({ children }: { children: React.ReactNode }) {
     audioRef.current.src = objectUrl;
     await audioRef.current.play();
     setIsPlaying(true);
+
+    playRef.current = { trackId: track.id, playId: crypto.randomUUID(), reportedAt: 0 };
+    reportPlay('start', 0);
   };
 
   const play = (track: Track, newQueue?: Track[]) => {
@@ -29,10 +29,27 @@ api.interceptors.response.use(
     console.log('API Response:', response.status, response.config.url);
     return response;
   },
-  (error) => {
+  async (error) => {
     console.error('API Error:', error.response?.status, error.response?.data, error.config?.url);
+    const original = error.config;
     if (error.response?.status === 401) {
+      const refreshToken = localStorage.getItem('refresh_token');
+      if (refreshToken && original && !original._retry && !original.url?.startsWith('/auth/')) {
+        original._retry = true;
+        try {
+          const response = await axios.post<AuthResponse>(`${API_BASE_URL}/auth/refresh`, {
+            refresh_token: refreshToken,
+          });
+          localStorage.setItem('token', response.data.token);
+          localStorage.setItem('refresh_token', response.data.refresh_token);
+          original.headers.Authorization = `Bearer ${response.data.token}`;
+          return api(original);
+        } catch (refreshError) {
+          console.error('Token refresh failed:', refreshError);
+        }
+      }
       localStorage.removeItem('token');
+      localStorage.removeItem('refresh_token');
       localStorage.removeItem('user');
       window.location.href = '/login';
     }
@@ -44,6 +61,8 @@ export interface User {
   id: string;
   username: string;
   email: string;
+  is_admin?: boolean;
+  plan?: string;
   created_at: string;
 }
 
@@ -54,29 +73,261 @@ export interface Track {
   duration: number;
   file_size: number;
   mime_type: string;
+  play_count: number;
+  broken?: boolean; // file missing or damaged
   created_at: string;
 }
 
+export interface PlaylistTrack extends Track {
+  entry_id?: string; // absent on smart playlists
+  position: number;
+  added_at: string;
+}
+
+export type ArtworkSize = 64 | 300 | 640;
+
+export type ArtworkFormat = 'jpeg' | 'webp';
+
+export type PlaylistVisibility = 'private' | 'unlisted' | 'public';
+
+export type CollaboratorRole = 'viewer' | 'editor';
+
 export interface Playlist {
   id: string;
   name: string;
   description: string;
+  visibility: PlaylistVisibility;
+  slug: string;
+  smart: boolean;
+  rule?: string;
   user_id: string;
-  tracks?: Track[];
+  role?: 'owner' | CollaboratorRole;
+  tracks?: PlaylistTrack[];
   created_at: string;
   updated_at: string;
 }
 
+export interface Collaborator {
+  user_id: string;
+  username: string;
+  role: CollaboratorRole;
+  created_at: string;
+}
+
 export interface AuthResponse {
   token: string;
+  refresh_token: string;
   user: User;
   expires_at: string;
+  refresh_expires_at: string;
+}
+
+export interface Session {
+  id: string;
+  user_agent: string;
+  ip_address: string;
+  created_at: string;
+  last_active_at: string;
+  expires_at: string;
+  current: boolean;
+}
+
+export type SearchHighlights = Record<string, string>;
+
+export interface TrackSearchResult extends Track {
+  rank: number;
+  highlights?: SearchHighlights;
+}
+
+export interface PlaylistSearchResult extends Playlist {
+  rank: number;
+  highlights?: SearchHighlights;
+}
+
+export interface Page {
+  limit: number;
+  total: number;
+  total_estimated: boolean;
+  next?: string;
+  prev?: string;
+}
+
+export interface PlayEvent {
+  play_id: string;
+  event: 'start' | 'progress' | 'complete';
+  position: number;
+}
+
+export interface RecentPlay {
+  play_id: string;
+  played_at: string;
+  track: Track;
+}
+
+export interface TopTrack {
+  track: Track | null; // null when the track has been deleted
+  deleted?: boolean;
+  plays: number;
+  seconds: number;
+}
+
+export interface TopItem {
+  id?: string;
+  name: string;
+  plays: number;
+  seconds: number;
+}
+
+export interface Streaks {
+  current: number;
+  longest: number;
+  longest_from?: string;
+  longest_to?: string;
+}
+
+export interface StorageUsage {
+  plan: string;
+  bytes_used: number;
+  bytes_pending: number;
+  bytes_limit: number | null;
+  bytes_remaining: number | null;
+  tracks_used: number;
+  tracks_limit: number | null;
+  tracks_remaining: number | null;
+}
+
+export type StatsWindow = '7d' | '30d' | '90d' | '365d' | 'all';
+
+export interface UserStats {
+  tracks_count: number;
+  playlists_count: number;
+  storage: StorageUsage;
+  window: StatsWindow;
+  from?: string;
+  listening_seconds: number;
+  plays: number;
+  top_tracks: TopTrack[];
+  top_artists: TopItem[];
+  top_genres: TopItem[];
+  streaks: Streaks;
+  heatmap: number[][];
+}
+
+export interface Wrapped {
+  year: number;
+  listening_seconds: number;
+  plays: number;
+  distinct_tracks: number;
+  distinct_artists: number;
+  top_tracks: TopTrack[];
+  top_artists: TopItem[];
+  top_genres: TopItem[];
+  months: number[];
+  top_month: number;
+  busiest_day: { day: string; seconds: number } | null;
+  longest_streak: Streaks;
+}
+
+export interface TrackListParams {
+  sort?: 'title' | 'artist' | 'album' | 'duration' | 'size' | 'created_at' | 'play_count';
+  order?: 'asc' | 'desc';
+  artist_id?: string;
+  album_id?: string;
+  genre?: string[];
+  mime_type?: string[];
+  year_from?: number;
+  year_to?: number;
+  min_duration?: number;
+  max_duration?: number;
+  not_in_playlist?: boolean;
+}
+
+export interface FacetValue {
+  value: string;
+  label?: string;
+  count: number;
+}
+
+export interface TrackFacets {
+  genre: FacetValue[];
+  mime_type: FacetValue[];
+  artist: FacetValue[];
+  year: FacetValue[];
+}
+
+export type RepeatMode = 'off' | 'all' | 'one';
+
+export interface QueueItem {
+  id: string;
+  track: Track;
+}
+
+export interface PlayerState {
+  queue: QueueItem[];
+  current: QueueItem | null;
+  current_index: number;
+  position: number;
+  playing: boolean;
+  shuffle: boolean;
+  repeat: RepeatMode;
+  device_id?: string;
+  version: number;
+  updated_at: string;
+}
+
+export interface PlayerUpdate {
+  item_id?: string;
+  playing?: boolean;
+  position?: number;
+  shuffle?: boolean;
+  repeat?: RepeatMode;
+  device_id?: string;
+}
+
+export interface Device {
+  id: string;
+  name: string;
+  seen_at: string;
+}
+
+export interface TrashItem {
+  type: 'track' | 'playlist';
+  id: string;
+  name: string;
+  deleted_at: string;
+  purge_at: string;
+  track?: Track;
+  playlist?: Playlist;
+}
+
+export interface FsckIssue {
+  kind: 'orphan' | 'missing' | 'size_mismatch' | 'hash_mismatch' | 'ref_count_mismatch';
+  key: string;
+  track_ids?: string[];
+  expected?: string;
+  actual?: string;
+}
+
+export interface FsckReport {
+  tracks: number;
+  blobs: number;
+  objects: number;
+  issues: FsckIssue[];
+  repaired: boolean;
+  quarantined: number;
+  flagged: number;
+  unflagged: number;
+  started_at: string;
+  duration: number;
 }
 
 export interface SearchResponse {
-  tracks: Track[];
-  playlists: Playlist[];
+  tracks: TrackSearchResult[];
+  playlists: PlaylistSearchResult[];
+  tracks_page: Page;
+  playlists_page: Page;
   total: number;
+  did_you_mean?: string;
 }
 
 export const authAPI = {
@@ -94,6 +345,16 @@ export const authAPI = {
     const response = await api.post('/auth/logout');
     return response.data;
   },
+
+  getSessions: async () => {
+    const response = await api.get<{ sessions: Session[] }>('/users/sessions');
+    return response.data.sessions;
+  },
+
+  revokeSession: async (id: string) => {
+    const response = await api.delete(`/users/sessions/${id}`);
+    return response.data;
+  },
 };
 
 export const userAPI = {
@@ -102,13 +363,18 @@ export const userAPI = {
     return response.data;
   },
 
-  updateProfile: async (data: Partial<User>) => {
+  updateProfile: async (data: Pick<Partial<User>, 'username' | 'email'>) => {
     const response = await api.put<User>('/users/profile', data);
     return response.data;
   },
 
-  getUserStats: async () => {
-    const response = await api.get<{ tracks_count: number; playlists_count: number }>('/users/stats');
+  getUserStats: async (window: StatsWindow = '30d', top = 10) => {
+    const response = await api.get<UserStats>('/users/stats', { params: { window, top } });
+    return response.data;
+  },
+
+  getWrapped: async (year: number) => {
+    const response = await api.get<Wrapped>(`/users/wrapped/${year}`);
     return response.data;
   },
 
@@ -116,6 +382,13 @@ export const userAPI = {
     const response = await api.delete('/users/profile');
     return response.data;
   },
+
+  getRecentlyPlayed: async (limit = 20, cursor?: string) => {
+    const response = await api.get<{ plays: RecentPlay[]; page: Page }>('/users/recently-played', {
+      params: { limit, cursor },
+    });
+    return response.data;
+  },
 };
 
 export const trackAPI = {
@@ -131,9 +404,11 @@ export const trackAPI = {
     return response.data;
   },
 
-  getUserTracks: async (limit = 20, offset = 0) => {
-    const response = await api.get<{ tracks: Track[]; limit: number; offset: number }>('/tracks', {
-      params: { limit, offset },
+  getUserTracks: async (limit = 20, cursor?: string, filters: TrackListParams = {}) => {
+    const response = await api.get<{ tracks: Track[]; page: Page; facets?: TrackFacets }>('/tracks', {
+      params: { ...filters, limit, cursor },
+      // Repeat array params as genre=a&genre=b rather than genre[]=a
+      paramsSerializer: { indexes: null },
     });
     return response.data;
   },
@@ -148,18 +423,60 @@ export const trackAPI = {
     return response.data;
   },
 
+  reportPlay: async (id: string, event: PlayEvent) => {
+    const response = await api.post<{ play_id: string; counted: boolean }>(`/tracks/${id}/plays`, event);
+    return response.data;
+  },
+
   getStreamUrl: (id: string) => `${API_BASE_URL}/tracks/${id}/stream`,
+
+  // Artwork needs the Authorization header, so it is fetched as a blob for
+  // URL.createObjectURL. Falls back to the album's artwork; 404 if none.
+  getArtwork: async (id: string, size: ArtworkSize = 300, format: ArtworkFormat = 'jpeg') => {
+    const response = await api.get<Blob>(`/tracks/${id}/artwork`, {
+      params: { size, format },
+      responseType: 'blob',
+    });
+    return response.data;
+  },
+};
+
+export const albumAPI = {
+  getArtwork: async (id: string, size: ArtworkSize = 300, format: ArtworkFormat = 'jpeg') => {
+    const response = await api.get<Blob>(`/albums/${id}/artwork`, {
+      params: { size, format },
+      responseType: 'blob',
+    });
+    return response.data;
+  },
+
+  setArtwork: async (id: string, file: File) => {
+    const formData = new FormData();
+    formData.append('file', file);
+
+    const response = await api.put(`/albums/${id}/artwork`, formData, {
+      headers: {
+        'Content-Type': 'multipart/form-data',
+      },
+    });
+    return response.data;
+  },
+
+  deleteArtwork: async (id: string) => {
+    const response = await api.delete(`/albums/${id}/artwork`);
+    return response.data;
+  },
 };
 
 export const playlistAPI = {
-  create: async (data: { name: string; description?: string }) => {
+  create: async (data: { name: string; description?: string; visibility?: PlaylistVisibility; rule?: string }) => {
     const response = await api.post<Playlist>('/playlists', data);
     return response.data;
   },
 
-  getUserPlaylists: async (limit = 20, offset = 0) => {
-    const response = await api.get<{ playlists: Playlist[]; limit: number; offset: number }>('/playlists', {
-      params: { limit, offset },
+  getUserPlaylists: async (limit = 20, cursor?: string) => {
+    const response = await api.get<{ playlists: Playlist[]; page: Page }>('/playlists', {
+      params: { limit, cursor },
     });
     return response.data;
   },
@@ -169,7 +486,7 @@ export const playlistAPI = {
     return response.data;
   },
 
-  updatePlaylist: async (id: string, data: { name?: string; description?: string }) => {
+  updatePlaylist: async (id: string, data: { name?: string; description?: string; visibility?: PlaylistVisibility; rule?: string }) => {
     const response = await api.put<Playlist>(`/playlists/${id}`, data);
     return response.data;
   },
@@ -184,30 +501,176 @@ export const playlistAPI = {
     return response.data;
   },
 
-  removeTrack: async (playlistId: string, trackId: string) => {
-    const response = await api.delete(`/playlists/${playlistId}/tracks/${trackId}`);
+  removeTrack: async (playlistId: string, entryId: string) => {
+    const response = await api.delete(`/playlists/${playlistId}/tracks/${entryId}`);
+    return response.data;
+  },
+
+  moveTrack: async (playlistId: string, entryId: string, position: number) => {
+    const response = await api.post<Playlist>(`/playlists/${playlistId}/tracks/${entryId}/move`, { position });
+    return response.data;
+  },
+
+  getSharedPlaylists: async (limit = 20, offset = 0) => {
+    const response = await api.get<{ playlists: Playlist[]; limit: number; offset: number }>('/playlists/shared', {
+      params: { limit, offset },
+    });
+    return response.data;
+  },
+
+  getCollaborators: async (playlistId: string) => {
+    const response = await api.get<{ collaborators: Collaborator[] }>(`/playlists/${playlistId}/collaborators`);
+    return response.data.collaborators;
+  },
+
+  addCollaborator: async (playlistId: string, username: string, role: CollaboratorRole) => {
+    const response = await api.post<Collaborator>(`/playlists/${playlistId}/collaborators`, { username, role });
+    return response.data;
+  },
+
+  removeCollaborator: async (playlistId: string, userId: string) => {
+    const response = await api.delete(`/playlists/${playlistId}/collaborators/${userId}`);
+    return response.data;
+  },
+
+  getPublicPlaylist: async (slug: string) => {
+    const response = await api.get<Playlist>(`/public/playlists/${slug}`);
+    return response.data;
+  },
+};
+
+export const playerAPI = {
+  getState: async () => {
+    const response = await api.get<PlayerState>('/player');
+    return response.data;
+  },
+
+  update: async (data: PlayerUpdate) => {
+    const response = await api.put<PlayerState>('/player', data);
+    return response.data;
+  },
+
+  enqueue: async (trackIds: string[], next = false) => {
+    const response = await api.post<PlayerState>('/player/queue', { track_ids: trackIds, next });
+    return response.data;
+  },
+
+  dequeue: async (itemId: string) => {
+    const response = await api.delete<PlayerState>(`/player/queue/${itemId}`);
+    return response.data;
+  },
+
+  reorderQueue: async (itemIds: string[]) => {
+    const response = await api.put<PlayerState>('/player/queue', { item_ids: itemIds });
+    return response.data;
+  },
+
+  clearQueue: async () => {
+    const response = await api.delete<PlayerState>('/player/queue');
+    return response.data;
+  },
+
+  next: async (ended = false) => {
+    const response = await api.post<PlayerState>('/player/next', { ended });
+    return response.data;
+  },
+
+  previous: async () => {
+    const response = await api.post<PlayerState>('/player/previous');
+    return response.data;
+  },
+
+  getDevices: async () => {
+    const response = await api.get<{ devices: Device[] }>('/player/devices');
+    return response.data;
+  },
+
+  // Streams player state changes from all of the user's devices. EventSource
+  // cannot send the Authorization header, so the stream is read with fetch.
+  // Returns a function that closes the stream.
+  subscribe: (device: { id: string; name: string }, onState: (state: PlayerState) => void) => {
+    const controller = new AbortController();
+    const params = new URLSearchParams({ device_id: device.id, device_name: device.name });
+
+    (async () => {
+      const response = await fetch(`${API_BASE_URL}/player/events?${params}`, {
+        headers: { Authorization: `Bearer ${localStorage.getItem('token')}` },
+        signal: controller.signal,
+      });
+      if (!response.ok || !response.body) {
+        return;
+      }
+
+      const reader = response.body.pipeThrough(new TextDecoderStream()).getReader();
+      let buffer = '';
+      for (;;) {
+        const { value, done } = await reader.read();
+        if (done) {
+          return;
+        }
+        buffer += value;
+        let end;
+        while ((end = buffer.indexOf('\n\n')) >= 0) {
+          const data = buffer
+            .slice(0, end)
+            .split('\n')
+            .filter((line) => line.startsWith('data:'))
+            .map((line) => line.slice(5))
+            .join('\n');
+          buffer = buffer.slice(end + 2);
+          if (data) {
+            onState(JSON.parse(data));
+          }
+        }
+      }
+    })().catch(() => {});
+
+    return () => controller.abort();
+  },
+};
+
+export const trashAPI = {
+  getTrash: async (type?: 'track' | 'playlist', limit = 20, cursor?: string) => {
+    const response = await api.get<{ items: TrashItem[]; page: Page }>('/trash', {
+      params: { type, limit, cursor },
+    });
+    return response.data;
+  },
+
+  restoreTrack: async (id: string) => {
+    const response = await api.post<Track>(`/trash/tracks/${id}/restore`);
+    return response.data;
+  },
+
+  restorePlaylist: async (id: string) => {
+    const response = await api.post<Playlist>(`/trash/playlists/${id}/restore`);
+    return response.data;
+  },
+
+  emptyTrash: async () => {
+    const response = await api.delete<{ message: string; purged: number }>('/trash');
     return response.data;
   },
 };
 
 export const searchAPI = {
-  search: async (query: string, limit = 20, offset = 0) => {
+  search: async (query: string, limit = 20) => {
     const response = await api.get<SearchResponse>('/search', {
-      params: { q: query, limit, offset },
+      params: { q: query, limit },
     });
     return response.data;
   },
 
-  searchTracks: async (query: string, limit = 20, offset = 0) => {
-    const response = await api.get<{ tracks: Track[]; limit: number; offset: number }>('/search/tracks', {
-      params: { q: query, limit, offset },
+  searchTracks: async (query: string, limit = 20, cursor?: string) => {
+    const response = await api.get<{ tracks: TrackSearchResult[]; page: Page }>('/search/tracks', {
+      params: { q: query, limit, cursor },
     });
     return response.data;
   },
 
-  searchPlaylists: async (query: string, limit = 20, offset = 0) => {
-    const response = await api.get<{ playlists: Playlist[]; limit: number; offset: number }>('/search/playlists', {
-      params: { q: query, limit, offset },
+  searchPlaylists: async (query: string, limit = 20, cursor?: string) => {
+    const response = await api.get<{ playlists: PlaylistSearchResult[]; page: Page }>('/search/playlists', {
+      params: { q: query, limit, cursor },
     });
     return response.data;
   },
@@ -220,4 +683,24 @@ export const searchAPI = {
   },
 };
 
+export const adminAPI = {
+  fsck: async (options: { repair?: boolean; hashes?: boolean } = {}) => {
+    const response = await api.post<FsckReport>('/admin/fsck', options);
+    return response.data;
+  },
+
+  getUserQuota: async (userId: string) => {
+    const response = await api.get<StorageUsage>(`/admin/users/${userId}/quota`);
+    return response.data;
+  },
+
+  setUserQuota: async (
+    userId: string,
+    quota: { plan?: string; storage_quota?: number | null; track_quota?: number | null }
+  ) => {
+    const response = await api.put<StorageUsage>(`/admin/users/${userId}/quota`, quota);
+    return response.data;
+  },
+};
+
 export default api;
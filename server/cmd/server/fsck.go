@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"maxify/internal/config"
+	"maxify/internal/database"
+	"maxify/internal/services"
+	"maxify/internal/storage"
+)
+
+// runFsck checks storage against the database and returns the exit code:
+// 0 when everything is consistent or was repaired, 1 when issues were
+// found and left alone.
+func runFsck(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "quarantine orphaned files, fix reference counts and flag broken tracks")
+	hashes := flags.Bool("hashes", false, "re-read every blob and verify its SHA-256")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	flags.Parse(args)
+
+	if err := database.ConnectPostgres(cfg); err != nil {
+		log.Fatalf("Failed to connect to PostgreSQL: %v", err)
+	}
+	if err := storage.Init(cfg); err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+
+	report, err := services.NewFsckService().Check(&services.FsckRequest{Repair: *repair, Hashes: *hashes})
+	if err != nil {
+		log.Fatalf("fsck failed: %v", err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		printFsckReport(report)
+	}
+
+	if len(report.Issues) > 0 && !report.Repaired {
+		return 1
+	}
+	return 0
+}
+
+func printFsckReport(report *services.FsckReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, issue := range report.Issues {
+		detail := ""
+		switch {
+		case issue.Expected != "":
+			detail = fmt.Sprintf("expected %s, got %s", issue.Expected, issue.Actual)
+		case issue.Actual != "":
+			detail = issue.Actual + " bytes"
+		}
+		tracks := make([]string, len(issue.TrackIDs))
+		for i, id := range issue.TrackIDs {
+			tracks[i] = id.String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", issue.Kind, issue.Key, detail, strings.Join(tracks, ","))
+	}
+	w.Flush()
+
+	fmt.Printf("Checked %d tracks, %d blobs and %d files in %.1fs: %d issues\n",
+		report.Tracks, report.Blobs, report.Objects, report.Duration, len(report.Issues))
+	if report.Repaired {
+		fmt.Printf("Repaired: %d files quarantined, %d tracks flagged, %d tracks unflagged\n",
+			report.Quarantined, report.Flagged, report.Unflagged)
+	}
+}
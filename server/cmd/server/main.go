@@ -3,10 +3,14 @@ package main
 import (
 	"log"
 	"os"
+	"time"
 
 	"maxify/internal/config"
 	"maxify/internal/database"
 	"maxify/internal/routes"
+	"maxify/internal/services"
+	"maxify/internal/storage"
+	"maxify/internal/transcode"
 )
 
 func main() {
@@ -16,6 +20,11 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	// Run a maintenance command instead of the server
+	if len(os.Args) > 1 && os.Args[1] == "fsck" {
+		os.Exit(runFsck(cfg, os.Args[2:]))
+	}
+
 	// Connect to PostgreSQL
 	if err := database.ConnectPostgres(cfg); err != nil {
 		log.Fatalf("Failed to connect to PostgreSQL: %v", err)
@@ -26,9 +35,31 @@ func main() {
 		log.Fatalf("Failed to connect to Redis: %v", err)
 	}
 
-	// Create upload directory if it doesn't exist
-	if err := os.MkdirAll(cfg.Storage.UploadDir, 0755); err != nil {
-		log.Fatalf("Failed to create upload directory: %v", err)
+	// Initialize file storage
+	if err := storage.Init(cfg); err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+
+	// Remove abandoned resumable uploads
+	services.NewUploadService(cfg).StartSweeper(10 * time.Minute)
+
+	// Write buffered play events to the database
+	services.NewPlayService(cfg).StartFlusher(10 * time.Second)
+
+	// Precompute listening statistics from play events
+	services.NewUserService(cfg).StartRollups(5 * time.Minute)
+
+	// Permanently delete expired trash
+	services.NewTrashService(cfg).StartPurger(1 * time.Hour)
+
+	// Start transcoding workers
+	if cfg.Transcode.Enabled && cfg.Transcode.Workers > 0 {
+		if transcode.NewFFmpeg(cfg.Transcode.FFmpegPath).Available() {
+			services.NewTranscodeService(cfg).StartWorkers(cfg.Transcode.Workers)
+		} else {
+			log.Printf("ffmpeg not found at %q, transcoding disabled", cfg.Transcode.FFmpegPath)
+			cfg.Transcode.Enabled = false
+		}
 	}
 
 	// Setup routes
@@ -3,17 +3,20 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	Database DatabaseConfig
-	Redis    RedisConfig
-	JWT      JWTConfig
-	Server   ServerConfig
-	Storage  StorageConfig
+	Database  DatabaseConfig
+	Redis     RedisConfig
+	JWT       JWTConfig
+	Server    ServerConfig
+	Storage   StorageConfig
+	Quota     QuotaConfig
+	Transcode TranscodeConfig
 }
 
 type DatabaseConfig struct {
@@ -33,8 +36,9 @@ type RedisConfig struct {
 }
 
 type JWTConfig struct {
-	Secret string
-	Expiry time.Duration
+	Secret        string
+	Expiry        time.Duration // access token lifetime
+	RefreshExpiry time.Duration
 }
 
 type ServerConfig struct {
@@ -43,8 +47,43 @@ type ServerConfig struct {
 }
 
 type StorageConfig struct {
-	UploadDir   string
-	MaxFileSize int64
+	Backend          string // "local" or "s3"
+	UploadDir        string
+	MaxFileSize      int64
+	MaxUploadSize    int64 // limit for resumable uploads
+	UploadSessionTTL time.Duration
+	TrashRetention   time.Duration // how long deleted tracks and playlists can be restored
+	S3               S3Config
+}
+
+// QuotaConfig holds the storage plans users can be on. Users without a
+// plan are on DefaultPlan; a plan that is not configured is unlimited.
+type QuotaConfig struct {
+	DefaultPlan string
+	Plans       map[string]PlanQuota
+}
+
+// PlanQuota limits the bytes and tracks a user can store. Zero is
+// unlimited.
+type PlanQuota struct {
+	StorageBytes int64
+	Tracks       int64
+}
+
+type TranscodeConfig struct {
+	Enabled    bool
+	FFmpegPath string
+	Workers    int
+}
+
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+	PathStyle bool
 }
 
 func Load() (*Config, error) {
@@ -66,16 +105,39 @@ func Load() (*Config, error) {
 			DB:       getEnvAsInt("REDIS_DB", 0),
 		},
 		JWT: JWTConfig{
-			Secret: getEnv("JWT_SECRET", "your-super-secret-jwt-key-here"),
-			Expiry: getEnvAsDuration("JWT_EXPIRY", 24*time.Hour),
+			Secret:        getEnv("JWT_SECRET", "your-super-secret-jwt-key-here"),
+			Expiry:        getEnvAsDuration("JWT_EXPIRY", 15*time.Minute),
+			RefreshExpiry: getEnvAsDuration("JWT_REFRESH_EXPIRY", 30*24*time.Hour),
 		},
 		Server: ServerConfig{
 			Port:    getEnv("PORT", "8080"),
 			GinMode: getEnv("GIN_MODE", "debug"),
 		},
 		Storage: StorageConfig{
-			UploadDir:   getEnv("UPLOAD_DIR", "./uploads"),
-			MaxFileSize: getEnvAsInt64("MAX_FILE_SIZE", 50*1024*1024), // 50MB
+			Backend:          getEnv("STORAGE_BACKEND", "local"),
+			UploadDir:        getEnv("UPLOAD_DIR", "./uploads"),
+			MaxFileSize:      getEnvAsInt64("MAX_FILE_SIZE", 50*1024*1024),       // 50MB
+			MaxUploadSize:    getEnvAsInt64("MAX_UPLOAD_SIZE", 2*1024*1024*1024), // 2GB
+			UploadSessionTTL: getEnvAsDuration("UPLOAD_SESSION_TTL", 24*time.Hour),
+			TrashRetention:   getEnvAsDuration("TRASH_RETENTION", 30*24*time.Hour),
+			S3: S3Config{
+				Endpoint:  getEnv("S3_ENDPOINT", "s3.amazonaws.com"),
+				Region:    getEnv("S3_REGION", "us-east-1"),
+				Bucket:    getEnv("S3_BUCKET", "maxify"),
+				AccessKey: getEnv("S3_ACCESS_KEY", ""),
+				SecretKey: getEnv("S3_SECRET_KEY", ""),
+				UseSSL:    getEnvAsBool("S3_USE_SSL", true),
+				PathStyle: getEnvAsBool("S3_PATH_STYLE", false),
+			},
+		},
+		Quota: QuotaConfig{
+			DefaultPlan: getEnv("QUOTA_DEFAULT_PLAN", "free"),
+			Plans:       getEnvAsPlans("QUOTA_PLANS"),
+		},
+		Transcode: TranscodeConfig{
+			Enabled:    getEnvAsBool("TRANSCODE_ENABLED", true),
+			FFmpegPath: getEnv("FFMPEG_PATH", "ffmpeg"),
+			Workers:    getEnvAsInt("TRANSCODE_WORKERS", 1),
 		},
 	}
 
@@ -107,6 +169,15 @@ func getEnvAsInt64(key string, defaultValue int64) int64 {
 	return defaultValue
 }
 
+func getEnvAsBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
 func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
 	if value := os.Getenv(key); value != "" {
 		if duration, err := time.ParseDuration(value); err == nil {
@@ -115,3 +186,26 @@ func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
 	}
 	return defaultValue
 }
+
+// getEnvAsPlans parses a comma-separated list of name:bytes:tracks plan
+// quotas, e.g. "free:10737418240:5000,premium:0:0". Malformed entries are
+// skipped.
+func getEnvAsPlans(key string) map[string]PlanQuota {
+	plans := make(map[string]PlanQuota)
+	for _, entry := range strings.Split(os.Getenv(key), ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) != 3 || parts[0] == "" {
+			continue
+		}
+		bytes, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || bytes < 0 {
+			continue
+		}
+		tracks, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil || tracks < 0 {
+			continue
+		}
+		plans[parts[0]] = PlanQuota{StorageBytes: bytes, Tracks: tracks}
+	}
+	return plans
+}
@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"maxify/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type AdminController struct {
+	fsckService  *services.FsckService
+	quotaService *services.QuotaService
+}
+
+func NewAdminController(fsckService *services.FsckService, quotaService *services.QuotaService) *AdminController {
+	return &AdminController{
+		fsckService:  fsckService,
+		quotaService: quotaService,
+	}
+}
+
+func (c *AdminController) Fsck(ctx *gin.Context) {
+	var req services.FsckRequest
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	report, err := c.fsckService.Check(&req)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
+
+func (c *AdminController) GetUserQuota(ctx *gin.Context) {
+	userIDStr := ctx.Param("id")
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	usage, err := c.quotaService.GetUsage(userID)
+	if err != nil {
+		ctx.JSON(quotaErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, usage)
+}
+
+func (c *AdminController) SetUserQuota(ctx *gin.Context) {
+	userIDStr := ctx.Param("id")
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req services.QuotaUpdateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	usage, err := c.quotaService.SetUserQuota(userID, &req)
+	if err != nil {
+		ctx.JSON(quotaErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, usage)
+}
+
+func quotaErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrQuotaExceeded):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, services.ErrSizeRequired):
+		return http.StatusLengthRequired
+	case errors.Is(err, services.ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrUnknownPlan):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
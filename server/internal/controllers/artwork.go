@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"maxify/internal/services"
+	"maxify/internal/streaming"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type ArtworkController struct {
+	artworkService *services.ArtworkService
+}
+
+func NewArtworkController(artworkService *services.ArtworkService) *ArtworkController {
+	return &ArtworkController{
+		artworkService: artworkService,
+	}
+}
+
+func (c *ArtworkController) GetTrackArtwork(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	trackIDStr := ctx.Param("id")
+	trackID, err := uuid.Parse(trackIDStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid track ID"})
+		return
+	}
+
+	var req services.ArtworkRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	content, err := c.artworkService.GetTrackArtwork(trackID, userUUID, &req)
+	if err != nil {
+		ctx.JSON(artworkErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	streaming.Serve(ctx.Writer, ctx.Request, content)
+}
+
+func (c *ArtworkController) GetAlbumArtwork(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	albumIDStr := ctx.Param("id")
+	albumID, err := uuid.Parse(albumIDStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid album ID"})
+		return
+	}
+
+	var req services.ArtworkRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	content, err := c.artworkService.GetAlbumArtwork(albumID, userUUID, &req)
+	if err != nil {
+		ctx.JSON(artworkErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	streaming.Serve(ctx.Writer, ctx.Request, content)
+}
+
+func (c *ArtworkController) SetAlbumArtwork(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	albumIDStr := ctx.Param("id")
+	albumID, err := uuid.Parse(albumIDStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid album ID"})
+		return
+	}
+
+	file, err := ctx.FormFile("file")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "File is required"})
+		return
+	}
+
+	if err := c.artworkService.SetAlbumArtwork(albumID, userUUID, file); err != nil {
+		ctx.JSON(artworkErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Artwork updated successfully"})
+}
+
+func (c *ArtworkController) DeleteAlbumArtwork(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	albumIDStr := ctx.Param("id")
+	albumID, err := uuid.Parse(albumIDStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid album ID"})
+		return
+	}
+
+	if err := c.artworkService.DeleteAlbumArtwork(albumID, userUUID); err != nil {
+		ctx.JSON(artworkErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Artwork deleted successfully"})
+}
+
+func artworkErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrTrackNotFound),
+		errors.Is(err, services.ErrAlbumNotFound),
+		errors.Is(err, services.ErrArtworkNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrArtworkTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, services.ErrUnsupportedImage):
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusInternalServerError
+}
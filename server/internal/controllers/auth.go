@@ -1,11 +1,13 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
 
 	"maxify/internal/services"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 type AuthController struct {
@@ -25,6 +27,9 @@ func (c *AuthController) Register(ctx *gin.Context) {
 		return
 	}
 
+	req.UserAgent = ctx.Request.UserAgent()
+	req.IPAddress = ctx.ClientIP()
+
 	response, err := c.authService.Register(&req)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -41,6 +46,9 @@ func (c *AuthController) Login(ctx *gin.Context) {
 		return
 	}
 
+	req.UserAgent = ctx.Request.UserAgent()
+	req.IPAddress = ctx.ClientIP()
+
 	response, err := c.authService.Login(&req)
 	if err != nil {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
@@ -50,6 +58,29 @@ func (c *AuthController) Login(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, response)
 }
 
+func (c *AuthController) Refresh(ctx *gin.Context) {
+	var req services.RefreshRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.UserAgent = ctx.Request.UserAgent()
+	req.IPAddress = ctx.ClientIP()
+
+	response, err := c.authService.Refresh(&req)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrRefreshTokenReused) {
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
 func (c *AuthController) Logout(ctx *gin.Context) {
 	token, exists := ctx.Get("token")
 	if !exists {
@@ -70,3 +101,56 @@ func (c *AuthController) Logout(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
 }
+
+func (c *AuthController) GetSessions(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	sessionID, _ := ctx.Get("session_id")
+	currentSession, _ := sessionID.(uuid.UUID)
+
+	sessions, err := c.authService.GetSessions(userUUID, currentSession)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+func (c *AuthController) RevokeSession(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	sessionIDStr := ctx.Param("id")
+	sessionID, err := uuid.Parse(sessionIDStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	if err := c.authService.RevokeSession(sessionID, userUUID); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully"})
+}
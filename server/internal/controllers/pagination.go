@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"maxify/internal/pagination"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pageRequest reads the limit and cursor query parameters of a cursor
+// paginated listing.
+func pageRequest(ctx *gin.Context) *pagination.Request {
+	req := &pagination.Request{
+		Limit:  pagination.DefaultLimit,
+		Cursor: ctx.Query("cursor"),
+	}
+
+	if limitStr := ctx.Query("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= pagination.MaxLimit {
+			req.Limit = parsedLimit
+		}
+	}
+
+	return req
+}
+
+// pageParams reads the limit and offset query parameters of listings that
+// are not cursor paginated.
+func pageParams(ctx *gin.Context) (limit, offset int) {
+	limit = pagination.DefaultLimit
+
+	if limitStr := ctx.Query("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= pagination.MaxLimit {
+			limit = parsedLimit
+		}
+	}
+
+	if offsetStr := ctx.Query("offset"); offsetStr != "" {
+		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+			offset = parsedOffset
+		}
+	}
+
+	return limit, offset
+}
+
+func listErrorStatus(err error) int {
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
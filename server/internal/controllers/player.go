@@ -0,0 +1,330 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"maxify/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// keepAliveInterval keeps idle event streams from being closed by proxies.
+const keepAliveInterval = 30 * time.Second
+
+type PlayerController struct {
+	playerService *services.PlayerService
+}
+
+func NewPlayerController(playerService *services.PlayerService) *PlayerController {
+	return &PlayerController{
+		playerService: playerService,
+	}
+}
+
+func (c *PlayerController) GetState(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	state, err := c.playerService.GetState(userUUID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, state)
+}
+
+func (c *PlayerController) UpdateState(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	var req services.PlayerUpdateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	state, err := c.playerService.UpdateState(userUUID, &req)
+	if err != nil {
+		ctx.JSON(playerErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, state)
+}
+
+func (c *PlayerController) Enqueue(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	var req services.EnqueueRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	state, err := c.playerService.Enqueue(userUUID, &req)
+	if err != nil {
+		ctx.JSON(playerErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, state)
+}
+
+func (c *PlayerController) Dequeue(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	itemIDStr := ctx.Param("itemId")
+	itemID, err := uuid.Parse(itemIDStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid queue item ID"})
+		return
+	}
+
+	state, err := c.playerService.Dequeue(userUUID, itemID)
+	if err != nil {
+		ctx.JSON(playerErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, state)
+}
+
+func (c *PlayerController) ReorderQueue(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	var req struct {
+		ItemIDs []uuid.UUID `json:"item_ids" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	state, err := c.playerService.ReorderQueue(userUUID, req.ItemIDs)
+	if err != nil {
+		ctx.JSON(playerErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, state)
+}
+
+func (c *PlayerController) ClearQueue(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	state, err := c.playerService.ClearQueue(userUUID)
+	if err != nil {
+		ctx.JSON(playerErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, state)
+}
+
+func (c *PlayerController) Next(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	// The body is optional: a bare POST is a skip.
+	var req struct {
+		Ended bool `json:"ended"`
+	}
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	state, err := c.playerService.Next(userUUID, req.Ended)
+	if err != nil {
+		ctx.JSON(playerErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, state)
+}
+
+func (c *PlayerController) Previous(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	state, err := c.playerService.Previous(userUUID)
+	if err != nil {
+		ctx.JSON(playerErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, state)
+}
+
+func (c *PlayerController) GetDevices(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	devices, err := c.playerService.GetDevices(userUUID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"devices": devices})
+}
+
+// Events streams the player state as server-sent events: a "state" event
+// on connect and after every change. Clients that pass device_id are listed
+// as devices playback can be transferred to while connected.
+func (c *PlayerController) Events(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	var device *services.Device
+	if id := ctx.Query("device_id"); id != "" {
+		if len(id) > 64 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+			return
+		}
+		device = &services.Device{ID: id, Name: ctx.DefaultQuery("device_name", id)}
+	}
+
+	states, err := c.playerService.Subscribe(ctx.Request.Context(), userUUID, device)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Header("X-Accel-Buffering", "no")
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	ctx.Stream(func(w io.Writer) bool {
+		select {
+		case state, ok := <-states:
+			if !ok {
+				return false
+			}
+			ctx.SSEvent("state", state)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		return true
+	})
+}
+
+func playerErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrTrackNotFound),
+		errors.Is(err, services.ErrQueueItemNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrQueueFull),
+		errors.Is(err, services.ErrInvalidQueueOrder):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrPlayerConflict):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
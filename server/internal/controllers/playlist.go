@@ -1,8 +1,8 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
-	"strconv"
 
 	"maxify/internal/services"
 
@@ -63,31 +63,15 @@ func (c *PlaylistController) GetUserPlaylists(ctx *gin.Context) {
 		return
 	}
 
-	limit := 20
-	offset := 0
-
-	if limitStr := ctx.Query("limit"); limitStr != "" {
-		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 100 {
-			limit = parsedLimit
-		}
-	}
-
-	if offsetStr := ctx.Query("offset"); offsetStr != "" {
-		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
-			offset = parsedOffset
-		}
-	}
-
-	playlists, err := c.playlistService.GetUserPlaylists(userUUID, limit, offset)
+	playlists, page, err := c.playlistService.GetUserPlaylists(userUUID, pageRequest(ctx))
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		ctx.JSON(listErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"playlists": playlists,
-		"limit":     limit,
-		"offset":    offset,
+		"page":      page,
 	})
 }
 
@@ -113,7 +97,7 @@ func (c *PlaylistController) GetPlaylist(ctx *gin.Context) {
 
 	playlist, err := c.playlistService.GetPlaylistByID(playlistID, userUUID)
 	if err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		ctx.JSON(playlistErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -140,20 +124,15 @@ func (c *PlaylistController) UpdatePlaylist(ctx *gin.Context) {
 		return
 	}
 
-	var updates map[string]interface{}
-	if err := ctx.ShouldBindJSON(&updates); err != nil {
+	var req services.UpdatePlaylistRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	delete(updates, "id")
-	delete(updates, "user_id")
-	delete(updates, "created_at")
-	delete(updates, "updated_at")
-
-	playlist, err := c.playlistService.UpdatePlaylist(playlistID, userUUID, updates)
+	playlist, err := c.playlistService.UpdatePlaylist(playlistID, userUUID, &req)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		ctx.JSON(playlistErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -181,7 +160,7 @@ func (c *PlaylistController) DeletePlaylist(ctx *gin.Context) {
 	}
 
 	if err := c.playlistService.DeletePlaylist(playlistID, userUUID); err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		ctx.JSON(playlistErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -216,12 +195,16 @@ func (c *PlaylistController) AddTrackToPlaylist(ctx *gin.Context) {
 		return
 	}
 
-	if err := c.playlistService.AddTrackToPlaylist(playlistID, req.TrackID, userUUID); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	entry, err := c.playlistService.AddTrackToPlaylist(playlistID, req.TrackID, userUUID)
+	if err != nil {
+		ctx.JSON(playlistErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"message": "Track added to playlist successfully"})
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Track added to playlist successfully",
+		"entry":   entry,
+	})
 }
 
 func (c *PlaylistController) RemoveTrackFromPlaylist(ctx *gin.Context) {
@@ -244,17 +227,275 @@ func (c *PlaylistController) RemoveTrackFromPlaylist(ctx *gin.Context) {
 		return
 	}
 
-	trackIDStr := ctx.Param("trackId")
-	trackID, err := uuid.Parse(trackIDStr)
+	entryIDStr := ctx.Param("entryId")
+	entryID, err := uuid.Parse(entryIDStr)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid track ID"})
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid entry ID"})
 		return
 	}
 
-	if err := c.playlistService.RemoveTrackFromPlaylist(playlistID, trackID, userUUID); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := c.playlistService.RemoveTrackFromPlaylist(playlistID, entryID, userUUID); err != nil {
+		ctx.JSON(playlistErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{"message": "Track removed from playlist successfully"})
 }
+
+func (c *PlaylistController) MoveEntry(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	playlistIDStr := ctx.Param("id")
+	playlistID, err := uuid.Parse(playlistIDStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid playlist ID"})
+		return
+	}
+
+	entryIDStr := ctx.Param("entryId")
+	entryID, err := uuid.Parse(entryIDStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid entry ID"})
+		return
+	}
+
+	var req struct {
+		Position int `json:"position" binding:"required,min=1"`
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.playlistService.MoveEntry(playlistID, entryID, userUUID, req.Position); err != nil {
+		ctx.JSON(playlistErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	playlist, err := c.playlistService.GetPlaylistByID(playlistID, userUUID)
+	if err != nil {
+		ctx.JSON(playlistErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, playlist)
+}
+
+func (c *PlaylistController) ReorderPlaylistTracks(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	playlistIDStr := ctx.Param("id")
+	playlistID, err := uuid.Parse(playlistIDStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid playlist ID"})
+		return
+	}
+
+	var req struct {
+		Entries []services.EntryOrder `json:"entries" binding:"required,dive"`
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.playlistService.ReorderPlaylistTracks(playlistID, userUUID, req.Entries); err != nil {
+		ctx.JSON(playlistErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	playlist, err := c.playlistService.GetPlaylistByID(playlistID, userUUID)
+	if err != nil {
+		ctx.JSON(playlistErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, playlist)
+}
+
+func (c *PlaylistController) GetSharedPlaylists(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	limit, offset := pageParams(ctx)
+
+	playlists, err := c.playlistService.GetSharedPlaylists(userUUID, limit, offset)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"playlists": playlists,
+		"limit":     limit,
+		"offset":    offset,
+	})
+}
+
+func (c *PlaylistController) GetPublicPlaylists(ctx *gin.Context) {
+	limit, offset := pageParams(ctx)
+
+	playlists, err := c.playlistService.GetPublicPlaylists(limit, offset)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"playlists": playlists,
+		"limit":     limit,
+		"offset":    offset,
+	})
+}
+
+func (c *PlaylistController) GetPublicPlaylist(ctx *gin.Context) {
+	playlist, err := c.playlistService.GetPlaylistBySlug(ctx.Param("slug"))
+	if err != nil {
+		ctx.JSON(playlistErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, playlist)
+}
+
+func (c *PlaylistController) GetCollaborators(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	playlistIDStr := ctx.Param("id")
+	playlistID, err := uuid.Parse(playlistIDStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid playlist ID"})
+		return
+	}
+
+	collaborators, err := c.playlistService.GetCollaborators(playlistID, userUUID)
+	if err != nil {
+		ctx.JSON(playlistErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"collaborators": collaborators})
+}
+
+func (c *PlaylistController) AddCollaborator(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	playlistIDStr := ctx.Param("id")
+	playlistID, err := uuid.Parse(playlistIDStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid playlist ID"})
+		return
+	}
+
+	var req services.AddCollaboratorRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	collaborator, err := c.playlistService.AddCollaborator(playlistID, userUUID, &req)
+	if err != nil {
+		ctx.JSON(playlistErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, collaborator)
+}
+
+func (c *PlaylistController) RemoveCollaborator(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	playlistIDStr := ctx.Param("id")
+	playlistID, err := uuid.Parse(playlistIDStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid playlist ID"})
+		return
+	}
+
+	collaboratorIDStr := ctx.Param("userId")
+	collaboratorID, err := uuid.Parse(collaboratorIDStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := c.playlistService.RemoveCollaborator(playlistID, collaboratorID, userUUID); err != nil {
+		ctx.JSON(playlistErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Collaborator removed successfully"})
+}
+
+func playlistErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrPlaylistNotFound),
+		errors.Is(err, services.ErrCollaboratorNotFound),
+		errors.Is(err, services.ErrPlaylistEntryNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrPlaylistForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, services.ErrSmartPlaylist):
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
@@ -39,26 +39,10 @@ func (c *SearchController) Search(ctx *gin.Context) {
 		return
 	}
 
-	limit := 20
-	offset := 0
-
-	if limitStr := ctx.Query("limit"); limitStr != "" {
-		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 100 {
-			limit = parsedLimit
-		}
-	}
-
-	if offsetStr := ctx.Query("offset"); offsetStr != "" {
-		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
-			offset = parsedOffset
-		}
-	}
-
 	req := &services.SearchRequest{
 		Query:  query,
 		UserID: userUUID,
-		Limit:  limit,
-		Offset: offset,
+		Limit:  pageRequest(ctx).Limit,
 	}
 
 	response, err := c.searchService.Search(req)
@@ -89,31 +73,15 @@ func (c *SearchController) SearchTracks(ctx *gin.Context) {
 		return
 	}
 
-	limit := 20
-	offset := 0
-
-	if limitStr := ctx.Query("limit"); limitStr != "" {
-		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 100 {
-			limit = parsedLimit
-		}
-	}
-
-	if offsetStr := ctx.Query("offset"); offsetStr != "" {
-		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
-			offset = parsedOffset
-		}
-	}
-
-	tracks, err := c.searchService.SearchTracksOnly(query, userUUID, limit, offset)
+	tracks, page, err := c.searchService.SearchTracksOnly(query, userUUID, pageRequest(ctx))
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		ctx.JSON(listErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"tracks": tracks,
-		"limit":  limit,
-		"offset": offset,
+		"page":   page,
 	})
 }
 
@@ -136,31 +104,15 @@ func (c *SearchController) SearchPlaylists(ctx *gin.Context) {
 		return
 	}
 
-	limit := 20
-	offset := 0
-
-	if limitStr := ctx.Query("limit"); limitStr != "" {
-		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 100 {
-			limit = parsedLimit
-		}
-	}
-
-	if offsetStr := ctx.Query("offset"); offsetStr != "" {
-		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
-			offset = parsedOffset
-		}
-	}
-
-	playlists, err := c.searchService.SearchPlaylistsOnly(query, userUUID, limit, offset)
+	playlists, page, err := c.searchService.SearchPlaylistsOnly(query, userUUID, pageRequest(ctx))
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		ctx.JSON(listErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"playlists": playlists,
-		"limit":     limit,
-		"offset":    offset,
+		"page":      page,
 	})
 }
 
@@ -1,15 +1,20 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
-	"strconv"
 
 	"maxify/internal/services"
+	"maxify/internal/streaming"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
+// multipartOverhead allows for the multipart framing around an uploaded
+// file when its size is estimated from Content-Length.
+const multipartOverhead = 16 << 10
+
 type TrackController struct {
 	trackService *services.TrackService
 }
@@ -33,8 +38,33 @@ func (c *TrackController) UploadTrack(ctx *gin.Context) {
 		return
 	}
 
+	// Reject uploads that cannot fit before reading the body. The body
+	// is a little larger than the file; the exact check is made on upload.
+	// A body of unknown length is refused under a byte quota, and no body
+	// may run past the quota while it is spooled.
+	size := ctx.Request.ContentLength
+	if size >= 0 {
+		size -= multipartOverhead
+		if size < 1 {
+			size = 1
+		}
+	}
+	remaining, err := c.trackService.CheckQuota(userUUID, size)
+	if err != nil {
+		ctx.JSON(quotaErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if remaining >= 0 {
+		ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, remaining+multipartOverhead)
+	}
+
 	file, err := ctx.FormFile("file")
 	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			ctx.JSON(http.StatusInsufficientStorage, gin.H{"error": services.ErrQuotaExceeded.Error()})
+			return
+		}
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "File is required"})
 		return
 	}
@@ -46,6 +76,20 @@ func (c *TrackController) UploadTrack(ctx *gin.Context) {
 
 	response, err := c.trackService.UploadTrack(req)
 	if err != nil {
+		var duplicateErr *services.DuplicateTrackError
+		if errors.As(err, &duplicateErr) {
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error(), "track": duplicateErr.Track, "in_trash": duplicateErr.InTrash})
+			return
+		}
+		var mismatchErr *services.ContentTypeMismatchError
+		if errors.Is(err, services.ErrUnsupportedAudio) || errors.As(err, &mismatchErr) {
+			ctx.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrQuotaExceeded) {
+			ctx.JSON(http.StatusInsufficientStorage, gin.H{"error": err.Error()})
+			return
+		}
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -66,32 +110,19 @@ func (c *TrackController) GetUserTracks(ctx *gin.Context) {
 		return
 	}
 
-	limit := 20
-	offset := 0
-
-	if limitStr := ctx.Query("limit"); limitStr != "" {
-		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 100 {
-			limit = parsedLimit
-		}
-	}
-
-	if offsetStr := ctx.Query("offset"); offsetStr != "" {
-		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
-			offset = parsedOffset
-		}
+	var req services.TrackListRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	tracks, err := c.trackService.GetUserTracks(userUUID, limit, offset)
+	response, err := c.trackService.GetUserTracks(userUUID, &req, pageRequest(ctx))
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		ctx.JSON(listErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"tracks": tracks,
-		"limit":  limit,
-		"offset": offset,
-	})
+	ctx.JSON(http.StatusOK, response)
 }
 
 func (c *TrackController) GetTrack(ctx *gin.Context) {
@@ -176,15 +207,80 @@ func (c *TrackController) StreamTrack(ctx *gin.Context) {
 		return
 	}
 
-	filePath, err := c.trackService.GetTrackFile(trackID, userUUID)
+	content, err := c.trackService.GetTrackFile(trackID, userUUID, ctx.Query("quality"))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidQuality) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	streaming.Serve(ctx.Writer, ctx.Request, content)
+}
+
+func (c *TrackController) GetHLSMaster(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	trackIDStr := ctx.Param("id")
+	trackID, err := uuid.Parse(trackIDStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid track ID"})
+		return
+	}
+
+	playlist, err := c.trackService.GetHLSMaster(trackID, userUUID)
 	if err != nil {
+		if errors.Is(err, services.ErrHLSNotReady) {
+			ctx.Header("Retry-After", "10")
+			ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
 		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
 
-	ctx.Header("Content-Type", "audio/mpeg")
-	ctx.Header("Accept-Ranges", "bytes")
+	// Variants are added as transcodes finish, so always revalidate.
 	ctx.Header("Cache-Control", "no-cache")
+	ctx.Data(http.StatusOK, "application/vnd.apple.mpegurl", []byte(playlist))
+}
+
+func (c *TrackController) GetHLSFile(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	trackIDStr := ctx.Param("id")
+	trackID, err := uuid.Parse(trackIDStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid track ID"})
+		return
+	}
+
+	content, err := c.trackService.GetHLSFile(trackID, userUUID, ctx.Param("variant"), ctx.Param("file"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
 
-	ctx.File(filePath)
+	streaming.Serve(ctx.Writer, ctx.Request, content)
 }
@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"maxify/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type TrashController struct {
+	trashService *services.TrashService
+}
+
+func NewTrashController(trashService *services.TrashService) *TrashController {
+	return &TrashController{
+		trashService: trashService,
+	}
+}
+
+func (c *TrashController) GetTrash(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	var req services.TrashRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	items, page, err := c.trashService.GetTrash(userUUID, &req, pageRequest(ctx))
+	if err != nil {
+		ctx.JSON(listErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"items": items,
+		"page":  page,
+	})
+}
+
+func (c *TrashController) RestoreTrack(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	trackIDStr := ctx.Param("id")
+	trackID, err := uuid.Parse(trackIDStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid track ID"})
+		return
+	}
+
+	track, err := c.trashService.RestoreTrack(trackID, userUUID)
+	if err != nil {
+		var duplicateErr *services.DuplicateTrackError
+		if errors.As(err, &duplicateErr) {
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error(), "track": duplicateErr.Track, "in_trash": duplicateErr.InTrash})
+			return
+		}
+		if errors.Is(err, services.ErrTrackNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, track)
+}
+
+func (c *TrashController) RestorePlaylist(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	playlistIDStr := ctx.Param("id")
+	playlistID, err := uuid.Parse(playlistIDStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid playlist ID"})
+		return
+	}
+
+	playlist, err := c.trashService.RestorePlaylist(playlistID, userUUID)
+	if err != nil {
+		ctx.JSON(playlistErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, playlist)
+}
+
+func (c *TrashController) EmptyTrash(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	purged, err := c.trashService.EmptyTrash(userUUID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Trash emptied successfully", "purged": purged})
+}
@@ -0,0 +1,215 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"maxify/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type UploadController struct {
+	uploadService *services.UploadService
+}
+
+func NewUploadController(uploadService *services.UploadService) *UploadController {
+	return &UploadController{
+		uploadService: uploadService,
+	}
+}
+
+func (c *UploadController) CreateUpload(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	var req services.CreateUploadRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.UserID = userUUID
+
+	response, err := c.uploadService.CreateUpload(&req)
+	if err != nil {
+		ctx.JSON(uploadErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Header("Upload-Offset", "0")
+	ctx.JSON(http.StatusCreated, response)
+}
+
+func (c *UploadController) GetUpload(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	uploadIDStr := ctx.Param("id")
+	uploadID, err := uuid.Parse(uploadIDStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid upload ID"})
+		return
+	}
+
+	response, err := c.uploadService.GetUpload(uploadID, userUUID)
+	if err != nil {
+		ctx.JSON(uploadErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Header("Upload-Offset", strconv.FormatInt(response.Offset, 10))
+	ctx.Header("Cache-Control", "no-store")
+	ctx.JSON(http.StatusOK, response)
+}
+
+// AppendChunk accepts the next chunk as the raw request body. The
+// Upload-Offset header must match the offset the server reports.
+func (c *UploadController) AppendChunk(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	uploadIDStr := ctx.Param("id")
+	uploadID, err := uuid.Parse(uploadIDStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid upload ID"})
+		return
+	}
+
+	offset, err := strconv.ParseInt(ctx.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset header is required"})
+		return
+	}
+
+	if ctx.Request.ContentLength < 0 {
+		ctx.JSON(http.StatusLengthRequired, gin.H{"error": "Content-Length header is required"})
+		return
+	}
+
+	response, err := c.uploadService.AppendChunk(uploadID, userUUID, offset, ctx.Request.Body, ctx.Request.ContentLength)
+	if err != nil {
+		var offsetErr *services.UploadOffsetError
+		if errors.As(err, &offsetErr) {
+			ctx.Header("Upload-Offset", strconv.FormatInt(offsetErr.Offset, 10))
+		}
+		ctx.JSON(uploadErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Header("Upload-Offset", strconv.FormatInt(response.Offset, 10))
+	ctx.JSON(http.StatusOK, response)
+}
+
+func (c *UploadController) CompleteUpload(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	uploadIDStr := ctx.Param("id")
+	uploadID, err := uuid.Parse(uploadIDStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid upload ID"})
+		return
+	}
+
+	response, err := c.uploadService.CompleteUpload(uploadID, userUUID)
+	if err != nil {
+		var duplicateErr *services.DuplicateTrackError
+		if errors.As(err, &duplicateErr) {
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error(), "track": duplicateErr.Track, "in_trash": duplicateErr.InTrash})
+			return
+		}
+		ctx.JSON(uploadErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, response)
+}
+
+func (c *UploadController) CancelUpload(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	uploadIDStr := ctx.Param("id")
+	uploadID, err := uuid.Parse(uploadIDStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid upload ID"})
+		return
+	}
+
+	if err := c.uploadService.CancelUpload(uploadID, userUUID); err != nil {
+		ctx.JSON(uploadErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Upload cancelled successfully"})
+}
+
+func uploadErrorStatus(err error) int {
+	var offsetErr *services.UploadOffsetError
+	var mismatchErr *services.ContentTypeMismatchError
+	switch {
+	case errors.Is(err, services.ErrUploadNotFound):
+		return http.StatusNotFound
+	case errors.As(err, &offsetErr),
+		errors.Is(err, services.ErrUploadBusy),
+		errors.Is(err, services.ErrUploadIncomplete):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrUploadTooLarge),
+		errors.Is(err, services.ErrChunkTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, services.ErrUnsupportedAudio),
+		errors.As(err, &mismatchErr):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, services.ErrQuotaExceeded):
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusBadRequest
+}
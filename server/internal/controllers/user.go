@@ -1,7 +1,9 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 
 	"maxify/internal/services"
 
@@ -55,7 +57,13 @@ func (c *UserController) GetUserStats(ctx *gin.Context) {
 		return
 	}
 
-	stats, err := c.userService.GetUserStats(userUUID)
+	var req services.StatsRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	stats, err := c.userService.GetUserStats(userUUID, &req)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -64,6 +72,38 @@ func (c *UserController) GetUserStats(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, stats)
 }
 
+func (c *UserController) GetWrapped(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userUUID, ok := userID.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	year, err := strconv.Atoi(ctx.Param("year"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid year"})
+		return
+	}
+
+	wrapped, err := c.userService.GetWrapped(userUUID, year)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidYear) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, wrapped)
+}
+
 func (c *UserController) UpdateProfile(ctx *gin.Context) {
 	userID, exists := ctx.Get("user_id")
 	if !exists {
@@ -77,18 +117,13 @@ func (c *UserController) UpdateProfile(ctx *gin.Context) {
 		return
 	}
 
-	var updates map[string]interface{}
-	if err := ctx.ShouldBindJSON(&updates); err != nil {
+	var req services.UpdateProfileRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	delete(updates, "id")
-	delete(updates, "created_at")
-	delete(updates, "updated_at")
-	delete(updates, "password_hash")
-
-	user, err := c.userService.UpdateUser(userUUID, updates)
+	user, err := c.userService.UpdateUser(userUUID, &req)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -3,6 +3,8 @@ package database
 import (
 	"fmt"
 	"log"
+	"path/filepath"
+	"unicode/utf8"
 
 	"maxify/internal/config"
 	"maxify/internal/models"
@@ -34,23 +36,157 @@ func ConnectPostgres(cfg *config.Config) error {
 
 	log.Println("Connected to PostgreSQL database")
 
+	if err := migratePlaylistEntryIDs(); err != nil {
+		return fmt.Errorf("failed to migrate playlist entries: %w", err)
+	}
+
+	if err := dropPlayEventTrackConstraint(); err != nil {
+		return fmt.Errorf("failed to migrate play events: %w", err)
+	}
+
 	if err := autoMigrate(); err != nil {
 		return fmt.Errorf("failed to migrate database: %w", err)
 	}
 
+	if err := backfillArtists(); err != nil {
+		return fmt.Errorf("failed to backfill artists: %w", err)
+	}
+
+	if err := migrateStorageKeys(cfg.Storage.UploadDir); err != nil {
+		return fmt.Errorf("failed to migrate storage keys: %w", err)
+	}
+
+	if err := backfillPlaylistSlugs(); err != nil {
+		return fmt.Errorf("failed to backfill playlist slugs: %w", err)
+	}
+
+	if err := createSearchIndexes(); err != nil {
+		return fmt.Errorf("failed to create search indexes: %w", err)
+	}
+
 	return nil
 }
 
 func autoMigrate() error {
 	return DB.AutoMigrate(
 		&models.User{},
+		&models.Artist{},
+		&models.Album{},
 		&models.Track{},
+		&models.Blob{},
+		&models.Artwork{},
+		&models.Rendition{},
 		&models.Playlist{},
 		&models.PlaylistTrack{},
+		&models.PlaylistCollaborator{},
 		&models.AuthToken{},
+		&models.PlayEvent{},
+		&models.ListeningRollup{},
 	)
 }
 
+// migratePlaylistEntryIDs replaces the old (playlist_id, track_id) primary
+// key of playlist_tracks with a generated entry ID. AutoMigrate does not
+// change primary keys, so this runs before it.
+func migratePlaylistEntryIDs() error {
+	migrator := DB.Migrator()
+	if !migrator.HasTable("playlist_tracks") || migrator.HasColumn("playlist_tracks", "id") {
+		return nil
+	}
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range []string{
+			`ALTER TABLE playlist_tracks ADD COLUMN id uuid NOT NULL DEFAULT gen_random_uuid()`,
+			`ALTER TABLE playlist_tracks DROP CONSTRAINT IF EXISTS playlist_tracks_pkey`,
+			`ALTER TABLE playlist_tracks ADD PRIMARY KEY (id)`,
+		} {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// dropPlayEventTrackConstraint removes the foreign key AutoMigrate used to
+// create from play events to tracks, so that purging a track keeps its
+// listening history.
+func dropPlayEventTrackConstraint() error {
+	if !DB.Migrator().HasTable("play_events") {
+		return nil
+	}
+	return DB.Exec(`ALTER TABLE play_events DROP CONSTRAINT IF EXISTS fk_play_events_track`).Error
+}
+
+// backfillArtists links tracks uploaded before artists were first-class
+// entities. The name key must match services.libraryKey.
+func backfillArtists() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`
+			INSERT INTO artists (id, name, name_key, user_id, created_at, updated_at)
+			SELECT gen_random_uuid(), MIN(BTRIM(artist)), LOWER(BTRIM(artist)), user_id, NOW(), NOW()
+			FROM tracks
+			WHERE artist_id IS NULL AND BTRIM(COALESCE(artist, '')) <> ''
+			GROUP BY user_id, LOWER(BTRIM(artist))
+			ON CONFLICT DO NOTHING`).Error; err != nil {
+			return err
+		}
+
+		return tx.Exec(`
+			UPDATE tracks SET artist_id = artists.id
+			FROM artists
+			WHERE tracks.artist_id IS NULL
+				AND artists.user_id = tracks.user_id
+				AND artists.name_key = LOWER(BTRIM(tracks.artist))`).Error
+	})
+}
+
+// migrateStorageKeys rewrites file paths of tracks uploaded before storage
+// backends were introduced into keys relative to the upload directory.
+func migrateStorageKeys(uploadDir string) error {
+	prefix := filepath.ToSlash(filepath.Clean(uploadDir)) + "/"
+	n := utf8.RuneCountInString(prefix)
+	return DB.Exec(`UPDATE tracks SET file_path = SUBSTR(file_path, ?) WHERE LEFT(file_path, ?) = ?`,
+		n+1, n, prefix).Error
+}
+
+// backfillPlaylistSlugs gives playlists created before sharing existed a
+// slug in the same format as models.NewPlaylistSlug.
+func backfillPlaylistSlugs() error {
+	return DB.Exec(`UPDATE playlists SET slug = SUBSTR(MD5(gen_random_uuid()::text), 1, 12) WHERE slug IS NULL OR slug = ''`).Error
+}
+
+// createSearchIndexes adds generated tsvector columns for full-text search
+// and trigram indexes for fuzzy suggestions. The text search configuration
+// must match services.searchConfig.
+func createSearchIndexes() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range []string{
+			`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+			`ALTER TABLE tracks ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+				setweight(to_tsvector('english', COALESCE(title, '')), 'A') ||
+				setweight(to_tsvector('english', COALESCE(artist, '')), 'B') ||
+				setweight(to_tsvector('english', COALESCE(album, '')), 'C') ||
+				setweight(to_tsvector('english', COALESCE(genre, '')), 'D')
+			) STORED`,
+			`CREATE INDEX IF NOT EXISTS idx_tracks_search_vector ON tracks USING GIN (search_vector)`,
+			`ALTER TABLE playlists ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+				setweight(to_tsvector('english', COALESCE(name, '')), 'A') ||
+				setweight(to_tsvector('english', COALESCE(description, '')), 'B')
+			) STORED`,
+			`CREATE INDEX IF NOT EXISTS idx_playlists_search_vector ON playlists USING GIN (search_vector)`,
+			`CREATE INDEX IF NOT EXISTS idx_tracks_title_trgm ON tracks USING GIN (title gin_trgm_ops)`,
+			`CREATE INDEX IF NOT EXISTS idx_tracks_artist_trgm ON tracks USING GIN (artist gin_trgm_ops)`,
+			`CREATE INDEX IF NOT EXISTS idx_playlists_name_trgm ON playlists USING GIN (name gin_trgm_ops)`,
+		} {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func GetDB() *gorm.DB {
 	return DB
 }
@@ -0,0 +1,80 @@
+package metadata
+
+import (
+	"bufio"
+	"io"
+)
+
+var adtsSampleRates = [...]int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+type adtsFrame struct {
+	sampleRate int
+	channels   int
+	samples    int
+	length     int
+}
+
+// isADTSHeader reports whether h starts with an AAC ADTS sync word. Layer
+// bits are always zero for ADTS, which tells it apart from MPEG audio.
+func isADTSHeader(h []byte) bool {
+	_, ok := parseADTSHeader(h)
+	return ok
+}
+
+func parseADTSHeader(h []byte) (*adtsFrame, bool) {
+	if len(h) < 7 || h[0] != 0xFF || h[1]&0xF6 != 0xF0 {
+		return nil, false
+	}
+	rateIndex := int((h[2] >> 2) & 0x0F)
+	if rateIndex >= len(adtsSampleRates) {
+		return nil, false
+	}
+	length := int(h[3]&0x03)<<11 | int(h[4])<<3 | int(h[5]>>5)
+	if length < 7 {
+		return nil, false
+	}
+	return &adtsFrame{
+		sampleRate: adtsSampleRates[rateIndex],
+		channels:   int(h[2]&0x01)<<2 | int(h[3]>>6),
+		samples:    1024 * (int(h[6]&0x03) + 1),
+		length:     length,
+	}, true
+}
+
+func readADTS(r io.ReadSeeker, offset, size int64, m *Metadata) error {
+	end := size
+	if hasID3v1(r, size) {
+		end -= id3v1Size
+	}
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	br := bufio.NewReaderSize(io.LimitReader(r, end-offset), 64<<10)
+
+	var samples uint64
+	header := make([]byte, 7)
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			break
+		}
+		f, ok := parseADTSHeader(header)
+		if !ok {
+			break
+		}
+		if m.SampleRate == 0 {
+			m.SampleRate = f.sampleRate
+			m.Channels = f.channels
+		}
+		if _, err := br.Discard(f.length - 7); err != nil {
+			break
+		}
+		samples += uint64(f.samples)
+	}
+
+	if samples == 0 {
+		return ErrCorrupt
+	}
+	m.Duration = samplesToDuration(samples, m.SampleRate)
+	m.Bitrate = averageBitrate(end-offset, m.Duration)
+	return nil
+}
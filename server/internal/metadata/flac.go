@@ -0,0 +1,159 @@
+package metadata
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"strings"
+)
+
+const (
+	flacStreamInfo    = 0
+	flacVorbisComment = 4
+	flacPicture       = 6
+)
+
+func readFLAC(r io.ReadSeeker, offset int64, m *Metadata) error {
+	pos := offset + 4 // "fLaC"
+	header := make([]byte, 4)
+
+	for {
+		if err := readAt(r, pos, header); err != nil {
+			return err
+		}
+		last := header[0]&0x80 != 0
+		blockType := header[0] & 0x7F
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		pos += 4
+
+		switch blockType {
+		case flacStreamInfo:
+			block := make([]byte, length)
+			if length < 18 {
+				return ErrCorrupt
+			}
+			if err := readAt(r, pos, block); err != nil {
+				return err
+			}
+			parseFLACStreamInfo(block, m)
+		case flacVorbisComment:
+			if length > maxTagSize {
+				return ErrCorrupt
+			}
+			block := make([]byte, length)
+			if err := readAt(r, pos, block); err != nil {
+				return err
+			}
+			parseVorbisComment(block, m)
+		case flacPicture:
+			if length > maxTagSize {
+				return ErrCorrupt
+			}
+			block := make([]byte, length)
+			if err := readAt(r, pos, block); err != nil {
+				return err
+			}
+			m.setPicture(parseFLACPicture(block))
+		}
+
+		pos += length
+		if last {
+			break
+		}
+	}
+
+	if m.SampleRate == 0 {
+		return ErrCorrupt
+	}
+
+	size, err := r.Seek(0, io.SeekEnd)
+	if err == nil {
+		m.Bitrate = averageBitrate(size-pos, m.Duration)
+	}
+	return nil
+}
+
+func parseFLACStreamInfo(block []byte, m *Metadata) {
+	// Bytes 10..17 pack sample rate (20 bits), channels-1 (3 bits),
+	// bits per sample-1 (5 bits) and total samples (36 bits).
+	packed := binary.BigEndian.Uint64(block[10:18])
+	m.SampleRate = int(packed >> 44)
+	m.Channels = int((packed>>41)&0x07) + 1
+	totalSamples := packed & 0xFFFFFFFFF
+	m.Duration = samplesToDuration(totalSamples, m.SampleRate)
+}
+
+// parseVorbisComment parses the little-endian comment structure shared by
+// FLAC, Ogg Vorbis and Opus.
+func parseVorbisComment(data []byte, m *Metadata) {
+	if len(data) < 4 {
+		return
+	}
+	vendorLen := int(binary.LittleEndian.Uint32(data))
+	p := 4 + vendorLen
+	if vendorLen < 0 || p+4 > len(data) {
+		return
+	}
+	count := int(binary.LittleEndian.Uint32(data[p:]))
+	p += 4
+
+	for i := 0; i < count && p+4 <= len(data); i++ {
+		length := int(binary.LittleEndian.Uint32(data[p:]))
+		p += 4
+		if length < 0 || p+length > len(data) {
+			return
+		}
+		comment := string(data[p : p+length])
+		p += length
+
+		key, value, ok := strings.Cut(comment, "=")
+		if !ok {
+			continue
+		}
+		// Ogg files embed pictures as base64 FLAC picture blocks.
+		if strings.EqualFold(key, "METADATA_BLOCK_PICTURE") {
+			if block, err := base64.StdEncoding.DecodeString(value); err == nil {
+				m.setPicture(parseFLACPicture(block))
+			}
+			continue
+		}
+		m.setTag(key, value)
+	}
+}
+
+// parseFLACPicture parses the big-endian picture structure of a FLAC
+// PICTURE block.
+func parseFLACPicture(data []byte) *Picture {
+	// next skips skip bytes and returns the length-prefixed field after them.
+	next := func(skip int) ([]byte, bool) {
+		if len(data) < skip+4 {
+			return nil, false
+		}
+		data = data[skip:]
+		length := binary.BigEndian.Uint32(data)
+		if uint64(length) > uint64(len(data)-4) {
+			return nil, false
+		}
+		field := data[4 : 4+length]
+		data = data[4+length:]
+		return field, true
+	}
+
+	if len(data) < 4 {
+		return nil
+	}
+	pictureType := int(binary.BigEndian.Uint32(data))
+	mimeType, ok := next(4)
+	if !ok {
+		return nil
+	}
+	if _, ok := next(0); !ok { // description
+		return nil
+	}
+	// Width, height, color depth and palette size precede the image.
+	image, ok := next(16)
+	if !ok {
+		return nil
+	}
+	return &Picture{Type: pictureType, MimeType: string(mimeType), Data: image}
+}
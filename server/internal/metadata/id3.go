@@ -0,0 +1,440 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	id3HeaderSize = 10
+	id3v1Size     = 128
+)
+
+// readID3v2 parses the ID3v2 tag at offset and returns its total size
+// including header and footer, so the caller can locate the audio stream.
+func readID3v2(r io.ReadSeeker, offset int64, m *Metadata) (int64, error) {
+	header := make([]byte, id3HeaderSize)
+	if err := readAt(r, offset, header); err != nil {
+		return 0, err
+	}
+
+	version := header[3]
+	flags := header[5]
+	size := int64(syncsafe(header[6:10]))
+	total := id3HeaderSize + size
+	if flags&0x10 != 0 {
+		total += id3HeaderSize
+	}
+
+	if version < 2 || version > 4 || size > maxTagSize {
+		// Unknown major versions must be skipped, not parsed.
+		return total, nil
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, ErrCorrupt
+	}
+
+	if flags&0x80 != 0 && version < 4 {
+		body = removeUnsync(body)
+	}
+
+	if flags&0x40 != 0 && version > 2 && len(body) >= 4 {
+		var extSize int
+		if version == 3 {
+			extSize = int(binary.BigEndian.Uint32(body[:4])) + 4
+		} else {
+			extSize = int(syncsafe(body[:4]))
+		}
+		if extSize > len(body) {
+			return total, nil
+		}
+		body = body[extSize:]
+	}
+
+	parseID3Frames(body, version, flags&0x80 != 0, m)
+	return total, nil
+}
+
+func parseID3Frames(body []byte, version byte, tagUnsync bool, m *Metadata) {
+	idLen, headerLen := 4, 10
+	if version == 2 {
+		idLen, headerLen = 3, 6
+	}
+
+	var lengthHint time.Duration
+	for len(body) >= headerLen {
+		if body[0] == 0 {
+			break // padding
+		}
+
+		id := string(body[:idLen])
+		var frameSize int
+		var formatFlags byte
+		switch version {
+		case 2:
+			frameSize = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		case 3:
+			frameSize = int(binary.BigEndian.Uint32(body[4:8]))
+			formatFlags = body[9]
+		default:
+			frameSize = int(syncsafe(body[4:8]))
+			// Some writers emit plain integers in v2.4 tags.
+			if (body[4]|body[5]|body[6]|body[7])&0x80 != 0 {
+				frameSize = int(binary.BigEndian.Uint32(body[4:8]))
+			}
+			formatFlags = body[9]
+		}
+
+		if frameSize < 0 || headerLen+frameSize > len(body) {
+			break
+		}
+		data := body[headerLen : headerLen+frameSize]
+		body = body[headerLen+frameSize:]
+
+		data, ok := unwrapID3Frame(data, version, formatFlags, tagUnsync)
+		if !ok {
+			continue
+		}
+
+		switch id {
+		case "TIT2", "TT2":
+			m.Title = firstValue(decodeID3Text(data))
+		case "TPE1", "TP1":
+			m.Artist = firstValue(decodeID3Text(data))
+		case "TPE2", "TP2":
+			m.AlbumArtist = firstValue(decodeID3Text(data))
+		case "TALB", "TAL":
+			m.Album = firstValue(decodeID3Text(data))
+		case "TCON", "TCO":
+			m.Genre = parseID3Genre(decodeID3Text(data))
+		case "TRCK", "TRK":
+			m.setTag("TRACKNUMBER", firstValue(decodeID3Text(data)))
+		case "TPOS", "TPA":
+			m.setTag("DISCNUMBER", firstValue(decodeID3Text(data)))
+		case "TDRC", "TYER", "TYE", "TDOR", "TORY":
+			if m.Year == 0 {
+				m.Year = parseYear(firstValue(decodeID3Text(data)))
+			}
+		case "APIC", "PIC":
+			m.setPicture(parseID3Picture(data, version))
+		case "TLEN", "TLE":
+			if ms, err := strconv.Atoi(firstValue(decodeID3Text(data))); err == nil && ms > 0 {
+				lengthHint = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+
+	if m.Duration == 0 {
+		m.Duration = lengthHint
+	}
+}
+
+// parseID3Picture parses an APIC frame, or a PIC frame in ID3v2.2, which
+// has a three letter image format instead of a MIME type.
+func parseID3Picture(data []byte, version byte) *Picture {
+	if len(data) < 1 {
+		return nil
+	}
+	encoding := data[0]
+	data = data[1:]
+
+	var mimeType string
+	if version == 2 {
+		if len(data) < 3 {
+			return nil
+		}
+		switch strings.ToUpper(string(data[:3])) {
+		case "JPG":
+			mimeType = "image/jpeg"
+		case "PNG":
+			mimeType = "image/png"
+		}
+		data = data[3:]
+	} else {
+		end := bytes.IndexByte(data, 0)
+		if end < 0 {
+			return nil
+		}
+		mimeType = strings.ToLower(decodeLatin1(data[:end]))
+		data = data[end+1:]
+	}
+	// "-->" marks a link to an external image.
+	if len(data) < 1 || mimeType == "-->" {
+		return nil
+	}
+	pictureType := int(data[0])
+	data = data[1:]
+
+	// Skip the description, terminated by one zero byte or two in UTF-16.
+	end := -1
+	if encoding == 1 || encoding == 2 {
+		for i := 0; i+1 < len(data); i += 2 {
+			if data[i] == 0 && data[i+1] == 0 {
+				end = i + 2
+				break
+			}
+		}
+	} else if i := bytes.IndexByte(data, 0); i >= 0 {
+		end = i + 1
+	}
+	if end < 0 {
+		return nil
+	}
+	return &Picture{Type: pictureType, MimeType: mimeType, Data: data[end:]}
+}
+
+// unwrapID3Frame strips per-frame grouping, data length and unsynchronisation
+// wrappers. Compressed and encrypted frames are reported as unusable.
+func unwrapID3Frame(data []byte, version, flags byte, tagUnsync bool) ([]byte, bool) {
+	switch version {
+	case 3:
+		if flags&0xC0 != 0 {
+			return nil, false
+		}
+		if flags&0x20 != 0 {
+			if len(data) < 1 {
+				return nil, false
+			}
+			data = data[1:]
+		}
+	case 4:
+		if flags&0x0C != 0 {
+			return nil, false
+		}
+		if flags&0x40 != 0 {
+			if len(data) < 1 {
+				return nil, false
+			}
+			data = data[1:]
+		}
+		if flags&0x01 != 0 {
+			if len(data) < 4 {
+				return nil, false
+			}
+			data = data[4:]
+		}
+		if flags&0x02 != 0 || tagUnsync {
+			data = removeUnsync(data)
+		}
+	}
+	return data, true
+}
+
+func decodeID3Text(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	text := decodeID3String(data[0], data[1:])
+	text = strings.TrimRight(text, "\x00")
+	return strings.Split(text, "\x00")
+}
+
+func decodeID3String(encoding byte, data []byte) string {
+	switch encoding {
+	case 0:
+		return decodeLatin1(data)
+	case 1:
+		return decodeUTF16(data, nil)
+	case 2:
+		return decodeUTF16(data, binary.BigEndian)
+	default:
+		return string(data)
+	}
+}
+
+func decodeLatin1(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// decodeUTF16 decodes UTF-16 text. A nil order means every string carries its
+// own byte order mark, as in ID3 encoding 1.
+func decodeUTF16(data []byte, order binary.ByteOrder) string {
+	var out strings.Builder
+	current := order
+	units := make([]uint16, 0, len(data)/2)
+
+	flush := func() {
+		out.WriteString(string(utf16.Decode(units)))
+		units = units[:0]
+	}
+
+	for i := 0; i+1 < len(data); i += 2 {
+		if order == nil && (current == nil || len(units) == 0) {
+			switch {
+			case data[i] == 0xFF && data[i+1] == 0xFE:
+				current = binary.LittleEndian
+				continue
+			case data[i] == 0xFE && data[i+1] == 0xFF:
+				current = binary.BigEndian
+				continue
+			}
+		}
+		if current == nil {
+			current = binary.LittleEndian
+		}
+
+		unit := current.Uint16(data[i : i+2])
+		if unit == 0 {
+			flush()
+			out.WriteByte(0)
+			if order == nil {
+				current = nil
+			}
+			continue
+		}
+		units = append(units, unit)
+	}
+	flush()
+	return out.String()
+}
+
+func firstValue(values []string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// parseID3Genre resolves numeric references such as "(17)", "17" or
+// "(17)Rock" against the ID3v1 genre table.
+func parseID3Genre(values []string) string {
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		for strings.HasPrefix(v, "(") {
+			end := strings.IndexByte(v, ')')
+			if end < 0 {
+				break
+			}
+			ref := v[1:end]
+			rest := strings.TrimSpace(v[end+1:])
+			if rest != "" && !strings.HasPrefix(rest, "(") {
+				return rest
+			}
+			if genre := lookupGenre(ref); genre != "" {
+				return genre
+			}
+			v = rest
+		}
+
+		if v == "" {
+			continue
+		}
+		if genre := lookupGenre(v); genre != "" {
+			return genre
+		}
+		return v
+	}
+	return ""
+}
+
+func lookupGenre(ref string) string {
+	switch ref {
+	case "RX":
+		return "Remix"
+	case "CR":
+		return "Cover"
+	}
+	n, err := strconv.Atoi(ref)
+	if err != nil || n < 0 || n >= len(id3v1Genres) {
+		return ""
+	}
+	return id3v1Genres[n]
+}
+
+func readID3v1(r io.ReadSeeker, size int64, m *Metadata) bool {
+	if size < id3v1Size {
+		return false
+	}
+	tag := make([]byte, id3v1Size)
+	if err := readAt(r, size-id3v1Size, tag); err != nil {
+		return false
+	}
+	if !bytes.HasPrefix(tag, []byte("TAG")) {
+		return false
+	}
+
+	field := func(b []byte) string {
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			b = b[:i]
+		}
+		return strings.TrimSpace(decodeLatin1(b))
+	}
+
+	m.Title = field(tag[3:33])
+	m.Artist = field(tag[33:63])
+	m.Album = field(tag[63:93])
+	m.Year = parseYear(field(tag[93:97]))
+	// ID3v1.1 stores the track number in the last byte of the comment.
+	if tag[125] == 0 && tag[126] != 0 {
+		m.TrackNumber = int(tag[126])
+	}
+	if int(tag[127]) < len(id3v1Genres) {
+		m.Genre = id3v1Genres[tag[127]]
+	}
+	return true
+}
+
+func hasID3v1(r io.ReadSeeker, size int64) bool {
+	if size < id3v1Size {
+		return false
+	}
+	marker := make([]byte, 3)
+	if err := readAt(r, size-id3v1Size, marker); err != nil {
+		return false
+	}
+	return string(marker) == "TAG"
+}
+
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7f)<<21 | uint32(b[1]&0x7f)<<14 | uint32(b[2]&0x7f)<<7 | uint32(b[3]&0x7f)
+}
+
+func removeUnsync(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		out = append(out, data[i])
+		if data[i] == 0xFF && i+1 < len(data) && data[i+1] == 0x00 {
+			i++
+		}
+	}
+	return out
+}
+
+var id3v1Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge", "Hip-Hop",
+	"Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B", "Rap",
+	"Reggae", "Rock", "Techno", "Industrial", "Alternative", "Ska", "Death Metal", "Pranks",
+	"Soundtrack", "Euro-Techno", "Ambient", "Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance",
+	"Classical", "Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"AlternRock", "Bass", "Soul", "Punk", "Space", "Meditative", "Instrumental Pop", "Instrumental Rock",
+	"Ethnic", "Gothic", "Darkwave", "Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap", "Pop/Funk", "Jungle",
+	"Native American", "Cabaret", "New Wave", "Psychadelic", "Rave", "Showtunes", "Trailer", "Lo-Fi",
+	"Tribal", "Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll", "Hard Rock",
+	"Folk", "Folk-Rock", "National Folk", "Swing", "Fast Fusion", "Bebob", "Latin", "Revival",
+	"Celtic", "Bluegrass", "Avantgarde", "Gothic Rock", "Progressive Rock", "Psychedelic Rock", "Symphonic Rock", "Slow Rock",
+	"Big Band", "Chorus", "Easy Listening", "Acoustic", "Humour", "Speech", "Chanson", "Opera",
+	"Chamber Music", "Sonata", "Symphony", "Booty Bass", "Primus", "Porn Groove", "Satire", "Slow Jam",
+	"Club", "Tango", "Samba", "Folklore", "Ballad", "Power Ballad", "Rhythmic Soul", "Freestyle",
+	"Duet", "Punk Rock", "Drum Solo", "A capella", "Euro-House", "Dance Hall", "Goa", "Drum & Bass",
+	"Club-House", "Hardcore", "Terror", "Indie", "BritPop", "Negerpunk", "Polsk Punk", "Beat",
+	"Christian Gangsta Rap", "Heavy Metal", "Black Metal", "Crossover", "Contemporary Christian", "Christian Rock", "Merengue", "Salsa",
+	"Thrash Metal", "Anime", "JPop", "Synthpop",
+}
@@ -0,0 +1,263 @@
+package metadata
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Format string
+
+const (
+	FormatMP3  Format = "mp3"
+	FormatAAC  Format = "aac"
+	FormatFLAC Format = "flac"
+	FormatOgg  Format = "ogg"
+	FormatOpus Format = "opus"
+	FormatMP4  Format = "mp4"
+	FormatWAV  Format = "wav"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown audio format")
+	ErrCorrupt       = errors.New("corrupt audio file")
+)
+
+// maxTagSize bounds how much of a tag block we are willing to buffer in memory.
+const maxTagSize = 64 << 20
+
+// pictureFrontCover is the ID3 and FLAC picture type of a front cover.
+const pictureFrontCover = 3
+
+type Metadata struct {
+	Format      Format
+	Title       string
+	Artist      string
+	AlbumArtist string
+	Album       string
+	Genre       string
+	Year        int
+	TrackNumber int
+	TrackTotal  int
+	DiscNumber  int
+	DiscTotal   int
+	Duration    time.Duration
+	SampleRate  int
+	Channels    int
+	Bitrate     int      // average bits per second
+	Picture     *Picture // the front cover, or else the first embedded picture
+}
+
+// Picture is an embedded image. Type is the ID3 and FLAC picture type, and
+// MimeType is as declared by the file, which may be empty.
+type Picture struct {
+	Type     int
+	MimeType string
+	Data     []byte
+}
+
+// Read parses tags and stream headers from r. Tag fields that are not present
+// in the file are left empty; the caller decides on fallbacks.
+func Read(r io.ReadSeeker) (*Metadata, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	m := &Metadata{}
+	var offset int64
+
+	head := make([]byte, 12)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, ErrUnknownFormat
+	}
+	head = head[:n]
+
+	// ID3v2 can prefix MP3, AAC and (non-conformant) FLAC files.
+	if bytes.HasPrefix(head, []byte("ID3")) {
+		tagSize, err := readID3v2(r, 0, m)
+		if err != nil {
+			return nil, err
+		}
+		offset = tagSize
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		head = make([]byte, 12)
+		n, _ = io.ReadFull(r, head)
+		head = head[:n]
+	}
+
+	switch containerFormat(head) {
+	case FormatFLAC:
+		m.Format = FormatFLAC
+		err = readFLAC(r, offset, m)
+	case FormatOgg:
+		err = readOgg(r, offset, size, m)
+	case FormatMP4:
+		m.Format = FormatMP4
+		err = readMP4(r, offset, size, m)
+	case FormatWAV:
+		m.Format = FormatWAV
+		err = readWAV(r, offset, size, m)
+	case FormatAAC:
+		m.Format = FormatAAC
+		err = readADTS(r, offset, size, m)
+	default:
+		// MPEG audio may be preceded by junk, so the frame scanner does its own sync search.
+		m.Format = FormatMP3
+		err = readMPEG(r, offset, size, m)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Format == FormatMP3 || m.Format == FormatAAC {
+		var v1 Metadata
+		if readID3v1(r, size, &v1) {
+			m.mergeMissing(&v1)
+		}
+	}
+
+	return m, nil
+}
+
+// containerFormat identifies formats with a fixed signature. MPEG audio has
+// none and is reported as an empty format.
+func containerFormat(head []byte) Format {
+	switch {
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return FormatFLAC
+	case bytes.HasPrefix(head, []byte("OggS")):
+		return FormatOgg
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		return FormatMP4
+	case len(head) >= 12 && string(head[0:4]) == "RIFF" && string(head[8:12]) == "WAVE":
+		return FormatWAV
+	case isADTSHeader(head):
+		return FormatAAC
+	}
+	return ""
+}
+
+func (m *Metadata) mergeMissing(o *Metadata) {
+	if m.Title == "" {
+		m.Title = o.Title
+	}
+	if m.Artist == "" {
+		m.Artist = o.Artist
+	}
+	if m.Album == "" {
+		m.Album = o.Album
+	}
+	if m.Genre == "" {
+		m.Genre = o.Genre
+	}
+	if m.Year == 0 {
+		m.Year = o.Year
+	}
+	if m.TrackNumber == 0 {
+		m.TrackNumber = o.TrackNumber
+	}
+}
+
+// setPicture keeps p if it is the first picture, or the first front cover.
+func (m *Metadata) setPicture(p *Picture) {
+	if p == nil || len(p.Data) == 0 {
+		return
+	}
+	if m.Picture == nil || (p.Type == pictureFrontCover && m.Picture.Type != pictureFrontCover) {
+		// Copy the image so the tag buffer it points into can be freed.
+		p.Data = bytes.Clone(p.Data)
+		m.Picture = p
+	}
+}
+
+// setTag applies a textual tag using Vorbis comment field names, which the
+// other formats are mapped onto.
+func (m *Metadata) setTag(key, value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+
+	switch strings.ToUpper(key) {
+	case "TITLE":
+		m.Title = value
+	case "ARTIST":
+		m.Artist = value
+	case "ALBUMARTIST", "ALBUM ARTIST":
+		m.AlbumArtist = value
+	case "ALBUM":
+		m.Album = value
+	case "GENRE":
+		m.Genre = value
+	case "DATE", "YEAR":
+		m.Year = parseYear(value)
+	case "TRACKNUMBER":
+		m.TrackNumber, m.TrackTotal = parsePosition(value, m.TrackTotal)
+	case "TRACKTOTAL", "TOTALTRACKS":
+		m.TrackTotal, _ = strconv.Atoi(value)
+	case "DISCNUMBER":
+		m.DiscNumber, m.DiscTotal = parsePosition(value, m.DiscTotal)
+	case "DISCTOTAL", "TOTALDISCS":
+		m.DiscTotal, _ = strconv.Atoi(value)
+	}
+}
+
+// parsePosition parses "3" or "3/12" style track and disc numbers.
+func parsePosition(value string, total int) (int, int) {
+	num, tot, found := strings.Cut(value, "/")
+	n, _ := strconv.Atoi(strings.TrimSpace(num))
+	if found {
+		if t, err := strconv.Atoi(strings.TrimSpace(tot)); err == nil {
+			total = t
+		}
+	}
+	return n, total
+}
+
+func parseYear(value string) int {
+	if len(value) < 4 {
+		return 0
+	}
+	year, err := strconv.Atoi(value[:4])
+	if err != nil {
+		return 0
+	}
+	return year
+}
+
+func samplesToDuration(samples uint64, sampleRate int) time.Duration {
+	if sampleRate <= 0 {
+		return 0
+	}
+	seconds := samples / uint64(sampleRate)
+	rem := samples % uint64(sampleRate)
+	return time.Duration(seconds)*time.Second + time.Duration(rem)*time.Second/time.Duration(sampleRate)
+}
+
+func averageBitrate(bytes int64, d time.Duration) int {
+	if d <= 0 || bytes <= 0 {
+		return 0
+	}
+	return int(float64(bytes*8) / d.Seconds())
+}
+
+func readAt(r io.ReadSeeker, offset int64, buf []byte) error {
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return nil
+}
@@ -0,0 +1,572 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// The builders below produce minimal but well-formed files of each format.
+
+// mpegFrames returns n MPEG-1 Layer III frames at 128 kbit/s, 44.1 kHz
+// stereo. Each frame is 417 bytes and holds 1152 samples.
+func mpegFrames(n int) []byte {
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xFF, 0xFB, 0x90, 0x00})
+	return bytes.Repeat(frame, n)
+}
+
+// adtsFrames returns n 100 byte AAC ADTS frames at 44.1 kHz stereo, each
+// holding 1024 samples.
+func adtsFrames(n int) []byte {
+	frame := make([]byte, 100)
+	copy(frame, []byte{0xFF, 0xF1, 0x50, 0x80, 100 >> 3, (100&0x07)<<5 | 0x1F, 0xFC})
+	return bytes.Repeat(frame, n)
+}
+
+func syncsafeBytes(n int) []byte {
+	return []byte{byte(n>>21) & 0x7F, byte(n>>14) & 0x7F, byte(n>>7) & 0x7F, byte(n) & 0x7F}
+}
+
+func id3Tag(version byte, frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	tag := append([]byte{'I', 'D', '3', version, 0, 0}, syncsafeBytes(len(body))...)
+	return append(tag, body...)
+}
+
+func id3Frame(version byte, id string, data []byte) []byte {
+	var frame []byte
+	switch version {
+	case 2:
+		frame = append([]byte(id), byte(len(data)>>16), byte(len(data)>>8), byte(len(data)))
+	case 3:
+		frame = binary.BigEndian.AppendUint32([]byte(id), uint32(len(data)))
+		frame = append(frame, 0, 0)
+	default:
+		frame = append([]byte(id), syncsafeBytes(len(data))...)
+		frame = append(frame, 0, 0)
+	}
+	return append(frame, data...)
+}
+
+// id3Text encodes a text frame body in Latin-1.
+func id3Text(s string) []byte {
+	return append([]byte{0}, s...)
+}
+
+// id3UTF16 encodes a text frame body in UTF-16 with a byte order mark.
+func id3UTF16(s string) []byte {
+	data := []byte{1, 0xFF, 0xFE}
+	for _, unit := range utf16.Encode([]rune(s)) {
+		data = binary.LittleEndian.AppendUint16(data, unit)
+	}
+	return data
+}
+
+func id3v1Tag(title, artist, album, year string, track, genre byte) []byte {
+	tag := make([]byte, id3v1Size)
+	copy(tag, "TAG")
+	copy(tag[3:33], title)
+	copy(tag[33:63], artist)
+	copy(tag[63:93], album)
+	copy(tag[93:97], year)
+	tag[126] = track
+	tag[127] = genre
+	return tag
+}
+
+func vorbisComment(comments ...string) []byte {
+	vendor := "test"
+	data := binary.LittleEndian.AppendUint32(nil, uint32(len(vendor)))
+	data = append(data, vendor...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(comments)))
+	for _, c := range comments {
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(c)))
+		data = append(data, c...)
+	}
+	return data
+}
+
+func flacPictureBlock(pictureType int, mimeType string, image []byte) []byte {
+	data := binary.BigEndian.AppendUint32(nil, uint32(pictureType))
+	data = binary.BigEndian.AppendUint32(data, uint32(len(mimeType)))
+	data = append(data, mimeType...)
+	data = binary.BigEndian.AppendUint32(data, 0) // description
+	data = append(data, make([]byte, 16)...)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(image)))
+	return append(data, image...)
+}
+
+func flacBlock(blockType byte, last bool, data []byte) []byte {
+	if last {
+		blockType |= 0x80
+	}
+	return append([]byte{blockType, byte(len(data) >> 16), byte(len(data) >> 8), byte(len(data))}, data...)
+}
+
+// flacStreamInfoBlock describes a 16 bit stream of totalSamples samples.
+func flacStreamInfoBlock(sampleRate, channels int, totalSamples uint64) []byte {
+	info := make([]byte, 34)
+	packed := uint64(sampleRate)<<44 | uint64(channels-1)<<41 | 15<<36 | totalSamples
+	binary.BigEndian.PutUint64(info[10:18], packed)
+	return info
+}
+
+func flacFile(blocks ...[]byte) []byte {
+	file := append([]byte("fLaC"), bytes.Join(blocks, nil)...)
+	return append(file, make([]byte, 256)...) // audio frames
+}
+
+func oggPacketPage(serial uint32, granule int64, packets ...[]byte) []byte {
+	var lacing, body []byte
+	for _, p := range packets {
+		n := len(p)
+		for ; n >= 255; n -= 255 {
+			lacing = append(lacing, 255)
+		}
+		lacing = append(lacing, byte(n))
+		body = append(body, p...)
+	}
+	return oggRawPage(serial, granule, lacing, body)
+}
+
+// oggRawPage builds a page from an explicit lacing table, which lets a
+// packet continue on the next page.
+func oggRawPage(serial uint32, granule int64, lacing, body []byte) []byte {
+	page := []byte("OggS\x00\x00")
+	page = binary.LittleEndian.AppendUint64(page, uint64(granule))
+	page = binary.LittleEndian.AppendUint32(page, serial)
+	page = append(page, make([]byte, 8)...) // sequence number and CRC
+	page = append(page, byte(len(lacing)))
+	page = append(page, lacing...)
+	return append(page, body...)
+}
+
+func vorbisIDPacket(channels int, sampleRate uint32) []byte {
+	id := append([]byte("\x01vorbis"), 0, 0, 0, 0, byte(channels))
+	id = binary.LittleEndian.AppendUint32(id, sampleRate)
+	return append(id, make([]byte, 14)...)
+}
+
+func opusHeadPacket(channels int, preSkip uint16) []byte {
+	id := append([]byte("OpusHead"), 1, byte(channels))
+	id = binary.LittleEndian.AppendUint16(id, preSkip)
+	id = binary.LittleEndian.AppendUint32(id, 48000)
+	return append(id, 0, 0, 0)
+}
+
+func mp4BoxBytes(kind string, children ...[]byte) []byte {
+	body := bytes.Join(children, nil)
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	box = append(box, kind...)
+	return append(box, body...)
+}
+
+// mp4FullBox prefixes data with a version 0 full box header.
+func mp4FullBox(kind string, data []byte) []byte {
+	return mp4BoxBytes(kind, make([]byte, 4), data)
+}
+
+// mp4Header returns an mvhd or mdhd body with the given timescale and
+// duration.
+func mp4Header(timescale, duration uint32) []byte {
+	data := make([]byte, 8) // creation and modification times
+	data = binary.BigEndian.AppendUint32(data, timescale)
+	return binary.BigEndian.AppendUint32(data, duration)
+}
+
+func mp4Item(kind string, typeIndicator uint32, value []byte) []byte {
+	data := binary.BigEndian.AppendUint32(nil, typeIndicator)
+	data = append(data, 0, 0, 0, 0) // locale
+	return mp4BoxBytes(kind, mp4BoxBytes("data", data, value))
+}
+
+func mp4File(ilst ...[]byte) []byte {
+	entry := make([]byte, 28)
+	binary.BigEndian.PutUint16(entry[16:18], 2)
+	binary.BigEndian.PutUint32(entry[24:28], 44100<<16)
+	stsd := mp4FullBox("stsd", append([]byte{0, 0, 0, 1}, mp4BoxBytes("mp4a", entry)...))
+	hdlr := mp4FullBox("hdlr", append([]byte{0, 0, 0, 0}, "soun"...))
+
+	trak := mp4BoxBytes("trak", mp4BoxBytes("mdia",
+		hdlr,
+		mp4FullBox("mdhd", mp4Header(44100, 44100*3)),
+		mp4BoxBytes("minf", mp4BoxBytes("stbl", stsd)),
+	))
+	udta := mp4BoxBytes("udta", mp4FullBox("meta", append(
+		mp4FullBox("hdlr", append([]byte{0, 0, 0, 0}, "mdir"...)),
+		mp4BoxBytes("ilst", ilst...)...,
+	)))
+	moov := mp4BoxBytes("moov", mp4FullBox("mvhd", mp4Header(1000, 3100)), trak, udta)
+
+	ftyp := mp4BoxBytes("ftyp", []byte("M4A \x00\x00\x00\x00isom"))
+	return bytes.Join([][]byte{ftyp, mp4BoxBytes("mdat", make([]byte, 512)), moov}, nil)
+}
+
+func wavFile(channels int, sampleRate uint32, data []byte, chunks ...[]byte) []byte {
+	fmtChunk := binary.LittleEndian.AppendUint16(nil, 1)
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, uint16(channels))
+	fmtChunk = binary.LittleEndian.AppendUint32(fmtChunk, sampleRate)
+	fmtChunk = binary.LittleEndian.AppendUint32(fmtChunk, sampleRate*uint32(channels)*2)
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, uint16(channels*2))
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, 16)
+
+	body := append([]byte("WAVE"), riffChunk("fmt ", fmtChunk)...)
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	body = append(body, riffChunk("data", data)...)
+	return append(binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(body))), body...)
+}
+
+func riffChunk(id string, data []byte) []byte {
+	chunk := binary.LittleEndian.AppendUint32([]byte(id), uint32(len(data)))
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func cat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+var (
+	jpeg = []byte("\xFF\xD8\xFF\xE0jpeg")
+	png  = []byte("\x89PNG\r\n\x1A\npng")
+)
+
+func TestRead(t *testing.T) {
+	longAlbum := string(bytes.Repeat([]byte("a"), 600))
+	longComment := append([]byte("\x03vorbis"), vorbisComment("TITLE=Title", "ALBUM="+longAlbum)...)
+
+	tests := []struct {
+		name string
+		file []byte
+		want Metadata
+	}{
+		{
+			name: "id3v2.2",
+			file: cat(id3Tag(2,
+				id3Frame(2, "TT2", id3Text("Title")),
+				id3Frame(2, "TP1", id3Text("Artist")),
+				id3Frame(2, "TAL", id3Text("Album")),
+				id3Frame(2, "TCO", id3Text("(17)")),
+				id3Frame(2, "TRK", id3Text("4")),
+				id3Frame(2, "TYE", id3Text("1987")),
+				id3Frame(2, "PIC", cat([]byte("\x00PNG\x03\x00"), png)),
+			), mpegFrames(10)),
+			want: Metadata{
+				Format: FormatMP3, Title: "Title", Artist: "Artist", Album: "Album", Genre: "Rock",
+				Year: 1987, TrackNumber: 4, SampleRate: 44100, Channels: 2,
+				Duration: samplesToDuration(10*1152, 44100),
+				Picture:  &Picture{Type: 3, MimeType: "image/png", Data: png},
+			},
+		},
+		{
+			name: "id3v2.3",
+			file: cat(id3Tag(3,
+				id3Frame(3, "TIT2", id3Text("Title")),
+				id3Frame(3, "TPE1", id3Text("Artist")),
+				id3Frame(3, "TPE2", id3Text("Album Artist")),
+				id3Frame(3, "TALB", id3Text("Album")),
+				id3Frame(3, "TCON", id3Text("(17)Garage")),
+				id3Frame(3, "TRCK", id3Text("3/12")),
+				id3Frame(3, "TPOS", id3Text("1/2")),
+				id3Frame(3, "TYER", id3Text("1999")),
+				id3Frame(3, "APIC", cat([]byte("\x00image/png\x00\x00\x00"), png)),
+				id3Frame(3, "APIC", cat([]byte("\x00image/jpeg\x00\x03cover\x00"), jpeg)),
+			), mpegFrames(10)),
+			want: Metadata{
+				Format: FormatMP3, Title: "Title", Artist: "Artist", AlbumArtist: "Album Artist",
+				Album: "Album", Genre: "Garage", Year: 1999, TrackNumber: 3, TrackTotal: 12,
+				DiscNumber: 1, DiscTotal: 2, SampleRate: 44100, Channels: 2,
+				Duration: samplesToDuration(10*1152, 44100),
+				Picture:  &Picture{Type: 3, MimeType: "image/jpeg", Data: jpeg},
+			},
+		},
+		{
+			name: "id3v2.4",
+			file: cat(id3Tag(4,
+				id3Frame(4, "TIT2", append([]byte{3}, "Tïtle"...)),
+				id3Frame(4, "TPE1", id3UTF16("Ärtist")),
+				id3Frame(4, "TCON", id3Text("Jazz\x00Funk")),
+				id3Frame(4, "TDRC", id3Text("2004-05-06")),
+			), mpegFrames(10)),
+			want: Metadata{
+				Format: FormatMP3, Title: "Tïtle", Artist: "Ärtist", Genre: "Jazz", Year: 2004,
+				SampleRate: 44100, Channels: 2, Duration: samplesToDuration(10*1152, 44100),
+			},
+		},
+		{
+			name: "id3v2 with id3v1 fallback",
+			file: cat(
+				id3Tag(3, id3Frame(3, "TIT2", id3Text("Title"))),
+				mpegFrames(10),
+				id3v1Tag("Other", "Artist", "Album", "2001", 7, 17),
+			),
+			want: Metadata{
+				Format: FormatMP3, Title: "Title", Artist: "Artist", Album: "Album", Genre: "Rock",
+				Year: 2001, TrackNumber: 7, SampleRate: 44100, Channels: 2,
+				Duration: samplesToDuration(10*1152, 44100),
+			},
+		},
+		{
+			name: "unknown id3 version is skipped",
+			file: cat(id3Tag(5, id3Frame(4, "TIT2", id3Text("Title"))), mpegFrames(10)),
+			want: Metadata{
+				Format: FormatMP3, SampleRate: 44100, Channels: 2,
+				Duration: samplesToDuration(10*1152, 44100),
+			},
+		},
+		{
+			name: "id3 frame longer than the tag",
+			file: cat(id3Tag(3,
+				id3Frame(3, "TIT2", id3Text("Title")),
+				[]byte("TALB\x00\x00\x10\x00\x00\x00Album"),
+			), mpegFrames(10)),
+			want: Metadata{
+				Format: FormatMP3, Title: "Title", SampleRate: 44100, Channels: 2,
+				Duration: samplesToDuration(10*1152, 44100),
+			},
+		},
+		{
+			name: "id3 frame with maximum size",
+			file: cat(id3Tag(3, []byte("TIT2\xFF\xFF\xFF\xFF\x00\x00Title")), mpegFrames(10)),
+			want: Metadata{
+				Format: FormatMP3, SampleRate: 44100, Channels: 2,
+				Duration: samplesToDuration(10*1152, 44100),
+			},
+		},
+		{
+			name: "unterminated id3 picture",
+			file: cat(id3Tag(3, id3Frame(3, "APIC", []byte("\x00image/jpeg"))), mpegFrames(10)),
+			want: Metadata{
+				Format: FormatMP3, SampleRate: 44100, Channels: 2,
+				Duration: samplesToDuration(10*1152, 44100),
+			},
+		},
+		{
+			name: "mpeg after junk",
+			file: cat([]byte("junk"), mpegFrames(5)),
+			want: Metadata{
+				Format: FormatMP3, SampleRate: 44100, Channels: 2,
+				Duration: samplesToDuration(5*1152, 44100),
+			},
+		},
+		{
+			name: "adts",
+			file: cat(id3Tag(4, id3Frame(4, "TIT2", id3Text("Title"))), adtsFrames(20)),
+			want: Metadata{
+				Format: FormatAAC, Title: "Title", SampleRate: 44100, Channels: 2,
+				Duration: samplesToDuration(20*1024, 44100),
+			},
+		},
+		{
+			name: "flac",
+			file: flacFile(
+				flacBlock(flacStreamInfo, false, flacStreamInfoBlock(44100, 2, 441000)),
+				flacBlock(flacVorbisComment, false, vorbisComment(
+					"TITLE=Title", "artist=Artist", "ALBUMARTIST=Album Artist", "ALBUM=Album",
+					"GENRE=Ambient", "DATE=2010-01-01", "TRACKNUMBER=2", "TRACKTOTAL=9",
+					"DISCNUMBER=1/1", "no separator",
+				)),
+				flacBlock(flacPicture, false, flacPictureBlock(0, "image/png", png)),
+				flacBlock(flacPicture, true, flacPictureBlock(3, "image/jpeg", jpeg)),
+			),
+			want: Metadata{
+				Format: FormatFLAC, Title: "Title", Artist: "Artist", AlbumArtist: "Album Artist",
+				Album: "Album", Genre: "Ambient", Year: 2010, TrackNumber: 2, TrackTotal: 9,
+				DiscNumber: 1, DiscTotal: 1, SampleRate: 44100, Channels: 2, Duration: 10 * time.Second,
+				Picture: &Picture{Type: 3, MimeType: "image/jpeg", Data: jpeg},
+			},
+		},
+		{
+			name: "flac comment count past the block",
+			file: flacFile(
+				flacBlock(flacStreamInfo, false, flacStreamInfoBlock(48000, 1, 48000)),
+				flacBlock(flacVorbisComment, true, cat(
+					[]byte("\x04\x00\x00\x00test\xFF\xFF\xFF\xFF"),
+					[]byte("\x0B\x00\x00\x00TITLE=Title"),
+					[]byte("\xFF\xFF\xFF\xFFARTIST=Artist"),
+				)),
+			),
+			want: Metadata{
+				Format: FormatFLAC, Title: "Title", SampleRate: 48000, Channels: 1, Duration: time.Second,
+			},
+		},
+		{
+			name: "flac picture field longer than the block",
+			file: flacFile(
+				flacBlock(flacStreamInfo, false, flacStreamInfoBlock(48000, 1, 48000)),
+				flacBlock(flacPicture, true, []byte("\x00\x00\x00\x03\xFF\xFF\xFF\xF0image/png")),
+			),
+			want: Metadata{Format: FormatFLAC, SampleRate: 48000, Channels: 1, Duration: time.Second},
+		},
+		{
+			name: "ogg vorbis",
+			file: cat(
+				oggPacketPage(7, 0, vorbisIDPacket(2, 44100)),
+				oggPacketPage(7, 0, append([]byte("\x03vorbis"), vorbisComment("TITLE=Title", "ARTIST=Artist")...)),
+				oggPacketPage(9, 1000, make([]byte, 100)), // interleaved stream
+				oggPacketPage(7, 44100*4, make([]byte, 300)),
+			),
+			want: Metadata{
+				Format: FormatOgg, Title: "Title", Artist: "Artist",
+				SampleRate: 44100, Channels: 2, Duration: 4 * time.Second,
+			},
+		},
+		{
+			name: "ogg comment spanning pages",
+			file: cat(
+				oggPacketPage(7, 0, vorbisIDPacket(1, 22050)),
+				oggRawPage(7, 0, []byte{255, 255}, longComment[:510]),
+				oggRawPage(7, 0, []byte{byte(len(longComment) - 510)}, longComment[510:]),
+				oggPacketPage(7, 22050, nil),
+			),
+			want: Metadata{
+				Format: FormatOgg, Title: "Title", Album: longAlbum,
+				SampleRate: 22050, Channels: 1, Duration: time.Second,
+			},
+		},
+		{
+			name: "opus",
+			file: cat(
+				oggPacketPage(1, 0, opusHeadPacket(2, 312)),
+				oggPacketPage(1, 0, append([]byte("OpusTags"), vorbisComment("TITLE=Title")...)),
+				oggPacketPage(1, 48000*5+312, make([]byte, 200)),
+			),
+			want: Metadata{
+				Format: FormatOpus, Title: "Title", SampleRate: 48000, Channels: 2, Duration: 5 * time.Second,
+			},
+		},
+		{
+			name: "mp4",
+			file: mp4File(
+				mp4Item("\xa9nam", 1, []byte("Title")),
+				mp4Item("\xa9ART", 1, []byte("Artist")),
+				mp4Item("aART", 1, []byte("Album Artist")),
+				mp4Item("\xa9alb", 1, []byte("Album")),
+				mp4Item("gnre", 0, []byte{0, 18}),
+				mp4Item("\xa9day", 1, []byte("2015-03-01T00:00:00Z")),
+				mp4Item("trkn", 0, []byte{0, 0, 0, 5, 0, 11, 0, 0}),
+				mp4Item("disk", 0, []byte{0, 0, 0, 2, 0, 3}),
+				mp4Item("covr", 13, jpeg),
+				mp4Item("\xa9gen", 1, nil)[:12], // truncated data box
+			),
+			want: Metadata{
+				Format: FormatMP4, Title: "Title", Artist: "Artist", AlbumArtist: "Album Artist",
+				Album: "Album", Genre: "Rock", Year: 2015, TrackNumber: 5, TrackTotal: 11,
+				DiscNumber: 2, DiscTotal: 3, SampleRate: 44100, Channels: 2, Duration: 3 * time.Second,
+				Picture: &Picture{Type: 3, MimeType: "image/jpeg", Data: jpeg},
+			},
+		},
+		{
+			name: "wav",
+			file: wavFile(2, 44100, make([]byte, 176400*2),
+				riffChunk("LIST", cat([]byte("INFO"), riffChunk("INAM", []byte("Title\x00")), riffChunk("IART", []byte("Artist")))),
+			),
+			want: Metadata{
+				Format: FormatWAV, Title: "Title", Artist: "Artist",
+				SampleRate: 44100, Channels: 2, Duration: 2 * time.Second,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Read(bytes.NewReader(tt.file))
+			if err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			if got.Bitrate <= 0 {
+				t.Errorf("Bitrate = %d, want a positive average", got.Bitrate)
+			}
+			got.Bitrate = 0
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("Read =\n%+v\nwant\n%+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	streamInfo := flacBlock(flacStreamInfo, false, flacStreamInfoBlock(44100, 2, 44100))
+
+	tests := []struct {
+		name string
+		file []byte
+		want error
+	}{
+		{"empty", nil, ErrUnknownFormat},
+		{"junk", bytes.Repeat([]byte("not audio "), 100), ErrUnknownFormat},
+		{"id3 tag longer than the file", cat(id3Tag(3, id3Frame(3, "TIT2", id3Text("Title"))), mpegFrames(1))[:20], ErrCorrupt},
+		{"id3 tag without audio", id3Tag(3, id3Frame(3, "TIT2", id3Text("Title"))), ErrUnknownFormat},
+		{"flac truncated streaminfo", flacFile(streamInfo)[:20], ErrCorrupt},
+		{"flac short streaminfo", flacFile(flacBlock(flacStreamInfo, true, make([]byte, 17))), ErrCorrupt},
+		{"flac without streaminfo", flacFile(flacBlock(flacVorbisComment, true, vorbisComment())), ErrCorrupt},
+		{"flac block past the end", flacFile(streamInfo, []byte{0x84, 0xFF, 0xFF, 0xFF}), ErrCorrupt},
+		{"ogg truncated page", oggPacketPage(1, 0, vorbisIDPacket(2, 44100))[:40], ErrCorrupt},
+		{"ogg unknown codec", cat(oggPacketPage(1, 0, []byte("\x80theora")), oggPacketPage(1, 0, []byte("x"))), ErrUnknownFormat},
+		{"mp4 without moov", cat(mp4BoxBytes("ftyp", []byte("M4A \x00\x00\x00\x00")), mp4BoxBytes("mdat", make([]byte, 64))), ErrCorrupt},
+		{"mp4 box smaller than its header", cat(mp4BoxBytes("ftyp", []byte("M4A \x00\x00\x00\x00")), []byte("\x00\x00\x00\x04moov")), ErrCorrupt},
+		{"mp4 box past the end", cat(mp4BoxBytes("ftyp", []byte("M4A \x00\x00\x00\x00")), []byte("\x00\x01\x00\x00moov")), ErrCorrupt},
+		{"mp4 huge 64 bit box", cat(mp4BoxBytes("ftyp", []byte("M4A \x00\x00\x00\x00")), []byte("\x00\x00\x00\x01free\x7F\xFF\xFF\xFF\xFF\xFF\xFF\xF0")), ErrCorrupt},
+		{"mp4 negative 64 bit box", cat(mp4BoxBytes("ftyp", []byte("M4A \x00\x00\x00\x00")), []byte("\x00\x00\x00\x01moov\xFF\xFF\xFF\xFF\xFF\xFF\xFF\xF0")), ErrCorrupt},
+		{"wav without fmt", cat([]byte("RIFF\x00\x00\x00\x00WAVE"), riffChunk("data", make([]byte, 64))), ErrCorrupt},
+		{"wav short fmt", cat([]byte("RIFF\x00\x00\x00\x00WAVE"), riffChunk("fmt ", make([]byte, 8))), ErrCorrupt},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := Read(bytes.NewReader(tt.file))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Read = %+v, %v; want %v", m, err, tt.want)
+			}
+		})
+	}
+}
+
+// TestReadTruncated reads every prefix of each fixture and every fixture
+// with one length byte set to 0xFF. Bad lengths must produce an error or
+// partial metadata, never a panic.
+func TestReadTruncated(t *testing.T) {
+	fixtures := map[string][]byte{
+		"mp3": cat(id3Tag(3,
+			id3Frame(3, "TIT2", id3Text("Title")),
+			id3Frame(3, "APIC", cat([]byte("\x00image/jpeg\x00\x03\x00"), jpeg)),
+		), mpegFrames(3), id3v1Tag("Title", "", "", "", 0, 0)),
+		"id3v2.4": cat(id3Tag(4, id3Frame(4, "TPE1", id3UTF16("Artist"))), mpegFrames(3)),
+		"adts":    adtsFrames(3),
+		"flac": flacFile(
+			flacBlock(flacStreamInfo, false, flacStreamInfoBlock(44100, 2, 44100)),
+			flacBlock(flacVorbisComment, false, vorbisComment("TITLE=Title")),
+			flacBlock(flacPicture, true, flacPictureBlock(3, "image/png", png)),
+		),
+		"ogg": cat(
+			oggPacketPage(1, 0, vorbisIDPacket(2, 44100)),
+			oggPacketPage(1, 0, append([]byte("\x03vorbis"), vorbisComment("TITLE=Title")...)),
+			oggPacketPage(1, 44100, make([]byte, 10)),
+		),
+		"mp4": mp4File(mp4Item("\xa9nam", 1, []byte("Title")), mp4Item("covr", 14, png)),
+		"wav": wavFile(1, 8000, make([]byte, 64), riffChunk("LIST", cat([]byte("INFO"), riffChunk("INAM", []byte("Title"))))),
+	}
+
+	for name, file := range fixtures {
+		t.Run(name, func(t *testing.T) {
+			for n := 0; n < len(file); n++ {
+				Read(bytes.NewReader(file[:n]))
+			}
+			for i := range file {
+				corrupt := bytes.Clone(file)
+				corrupt[i] = 0xFF
+				Read(bytes.NewReader(corrupt))
+			}
+		})
+	}
+}
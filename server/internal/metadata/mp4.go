@@ -0,0 +1,239 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+type mp4Box struct {
+	kind string
+	data []byte
+}
+
+func readMP4(r io.ReadSeeker, offset, size int64, m *Metadata) error {
+	// Walk top-level boxes until moov; mdat is skipped without reading it,
+	// since moov may sit at the end of the file.
+	pos := offset
+	header := make([]byte, 16)
+	for pos+8 <= size {
+		if err := readAt(r, pos, header[:8]); err != nil {
+			return err
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header[:4]))
+		kind := string(header[4:8])
+		headerLen := int64(8)
+
+		switch boxSize {
+		case 0:
+			boxSize = size - pos
+		case 1:
+			if err := readAt(r, pos+8, header[8:16]); err != nil {
+				return err
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerLen = 16
+		}
+		if boxSize < headerLen || boxSize > size-pos {
+			return ErrCorrupt
+		}
+
+		if kind == "moov" {
+			if boxSize > maxTagSize {
+				return ErrCorrupt
+			}
+			moov := make([]byte, boxSize-headerLen)
+			if err := readAt(r, pos+headerLen, moov); err != nil {
+				return err
+			}
+			parseMoov(moov, m)
+			m.Bitrate = averageBitrate(size-offset, m.Duration)
+			return nil
+		}
+		pos += boxSize
+	}
+	return ErrCorrupt
+}
+
+func mp4Children(data []byte) []mp4Box {
+	var boxes []mp4Box
+	for len(data) >= 8 {
+		size := int(binary.BigEndian.Uint32(data[:4]))
+		kind := string(data[4:8])
+		headerLen := 8
+		if size == 1 {
+			if len(data) < 16 {
+				break
+			}
+			size = int(binary.BigEndian.Uint64(data[8:16]))
+			headerLen = 16
+		} else if size == 0 {
+			size = len(data)
+		}
+		if size < headerLen || size > len(data) {
+			break
+		}
+		boxes = append(boxes, mp4Box{kind: kind, data: data[headerLen:size]})
+		data = data[size:]
+	}
+	return boxes
+}
+
+func findMP4Box(data []byte, path ...string) []byte {
+	for _, kind := range path {
+		found := false
+		for _, box := range mp4Children(data) {
+			if box.kind == kind {
+				data = box.data
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+	return data
+}
+
+func parseMoov(moov []byte, m *Metadata) {
+	for _, box := range mp4Children(moov) {
+		switch box.kind {
+		case "mvhd":
+			if m.Duration == 0 {
+				m.Duration = parseMP4Header(box.data, 12)
+			}
+		case "trak":
+			parseMP4Track(box.data, m)
+		case "udta":
+			if meta := findMP4Box(box.data, "meta"); meta != nil {
+				parseMP4Meta(meta, m)
+			}
+		case "meta":
+			parseMP4Meta(box.data, m)
+		}
+	}
+}
+
+// parseMP4Header reads timescale and duration from an mvhd or mdhd full box.
+// fieldOffset is the position of the timescale in a version 0 box.
+func parseMP4Header(data []byte, fieldOffset int) time.Duration {
+	if len(data) < 4 {
+		return 0
+	}
+	var timescale, duration uint64
+	if data[0] == 1 {
+		p := fieldOffset + 8
+		if len(data) < p+12 {
+			return 0
+		}
+		timescale = uint64(binary.BigEndian.Uint32(data[p:]))
+		duration = binary.BigEndian.Uint64(data[p+4:])
+	} else {
+		p := fieldOffset
+		if len(data) < p+8 {
+			return 0
+		}
+		timescale = uint64(binary.BigEndian.Uint32(data[p:]))
+		duration = uint64(binary.BigEndian.Uint32(data[p+4:]))
+	}
+	if timescale == 0 {
+		return 0
+	}
+	return samplesToDuration(duration, int(timescale))
+}
+
+// parseMP4Track takes the duration from the first sound track's media
+// header, which is sample accurate unlike the movie header.
+func parseMP4Track(trak []byte, m *Metadata) {
+	mdia := findMP4Box(trak, "mdia")
+	if mdia == nil {
+		return
+	}
+	hdlr := findMP4Box(mdia, "hdlr")
+	if len(hdlr) < 12 || string(hdlr[8:12]) != "soun" || m.SampleRate != 0 {
+		return
+	}
+
+	if mdhd := findMP4Box(mdia, "mdhd"); mdhd != nil {
+		if d := parseMP4Header(mdhd, 12); d > 0 {
+			m.Duration = d
+		}
+	}
+
+	stsd := findMP4Box(mdia, "minf", "stbl", "stsd")
+	if len(stsd) < 8 {
+		return
+	}
+	// Full box header and entry count precede the first sample entry.
+	entries := mp4Children(stsd[8:])
+	if len(entries) == 0 || len(entries[0].data) < 28 {
+		return
+	}
+	entry := entries[0].data
+	m.Channels = int(binary.BigEndian.Uint16(entry[16:18]))
+	m.SampleRate = int(binary.BigEndian.Uint32(entry[24:28]) >> 16)
+}
+
+func parseMP4Meta(meta []byte, m *Metadata) {
+	// meta is a full box in ISO files but a plain container in some
+	// QuickTime files; the latter starts directly with a child box.
+	if len(meta) >= 8 && string(meta[4:8]) != "hdlr" {
+		meta = meta[4:]
+	}
+	ilst := findMP4Box(meta, "ilst")
+	if ilst == nil {
+		return
+	}
+
+	for _, item := range mp4Children(ilst) {
+		data := findMP4Box(item.data, "data")
+		if len(data) < 8 {
+			continue
+		}
+		value := data[8:] // type indicator and locale
+
+		switch item.kind {
+		case "\xa9nam":
+			m.setTag("TITLE", string(value))
+		case "\xa9ART":
+			m.setTag("ARTIST", string(value))
+		case "aART":
+			m.setTag("ALBUMARTIST", string(value))
+		case "\xa9alb":
+			m.setTag("ALBUM", string(value))
+		case "\xa9gen":
+			m.setTag("GENRE", string(value))
+		case "\xa9day":
+			m.setTag("DATE", string(value))
+		case "gnre":
+			if len(value) >= 2 {
+				if n := int(binary.BigEndian.Uint16(value)); n > 0 && n <= len(id3v1Genres) {
+					m.Genre = id3v1Genres[n-1]
+				}
+			}
+		case "trkn":
+			if len(value) >= 6 {
+				m.TrackNumber = int(binary.BigEndian.Uint16(value[2:4]))
+				m.TrackTotal = int(binary.BigEndian.Uint16(value[4:6]))
+			}
+		case "covr":
+			// The type indicator is 13 for JPEG, 14 for PNG and 27 for BMP.
+			var mimeType string
+			switch binary.BigEndian.Uint32(data[:4]) & 0xFFFFFF {
+			case 13:
+				mimeType = "image/jpeg"
+			case 14:
+				mimeType = "image/png"
+			case 27:
+				mimeType = "image/bmp"
+			}
+			m.setPicture(&Picture{Type: pictureFrontCover, MimeType: mimeType, Data: value})
+		case "disk":
+			if len(value) >= 6 {
+				m.DiscNumber = int(binary.BigEndian.Uint16(value[2:4]))
+				m.DiscTotal = int(binary.BigEndian.Uint16(value[4:6]))
+			}
+		}
+	}
+}
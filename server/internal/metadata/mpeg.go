@@ -0,0 +1,275 @@
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// syncSearchLimit is how far past the tags we look for the first MPEG frame.
+const syncSearchLimit = 64 << 10
+
+type mpegFrame struct {
+	version    int // 1, 2 or 25 (MPEG 2.5)
+	layer      int
+	bitrate    int // bits per second
+	sampleRate int
+	channels   int
+	samples    int // samples per frame
+	length     int // bytes, including header
+}
+
+var mpegBitrates = map[[2]int][15]int{
+	{1, 1}: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+	{1, 2}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+	{1, 3}: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	{2, 1}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+	{2, 2}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	{2, 3}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+var mpegSampleRates = map[int][3]int{
+	1:  {44100, 48000, 32000},
+	2:  {22050, 24000, 16000},
+	25: {11025, 12000, 8000},
+}
+
+func parseMPEGHeader(h []byte) (*mpegFrame, bool) {
+	if len(h) < 4 || h[0] != 0xFF || h[1]&0xE0 != 0xE0 {
+		return nil, false
+	}
+
+	var version int
+	switch (h[1] >> 3) & 0x03 {
+	case 0:
+		version = 25
+	case 2:
+		version = 2
+	case 3:
+		version = 1
+	default:
+		return nil, false
+	}
+
+	layer := 4 - int((h[1]>>1)&0x03)
+	if layer == 4 {
+		return nil, false
+	}
+
+	bitrateIndex := int(h[2] >> 4)
+	sampleRateIndex := int((h[2] >> 2) & 0x03)
+	if bitrateIndex == 0 || bitrateIndex == 15 || sampleRateIndex == 3 {
+		// Free-format streams cannot be measured from headers alone.
+		return nil, false
+	}
+
+	tableVersion := version
+	if tableVersion == 25 {
+		tableVersion = 2
+	}
+	f := &mpegFrame{
+		version:    version,
+		layer:      layer,
+		bitrate:    mpegBitrates[[2]int{tableVersion, layer}][bitrateIndex] * 1000,
+		sampleRate: mpegSampleRates[version][sampleRateIndex],
+		channels:   2,
+	}
+	if h[3]>>6 == 3 {
+		f.channels = 1
+	}
+
+	padding := int((h[2] >> 1) & 0x01)
+	switch {
+	case layer == 1:
+		f.samples = 384
+		f.length = (12*f.bitrate/f.sampleRate + padding) * 4
+	case layer == 3 && version != 1:
+		f.samples = 576
+		f.length = 72*f.bitrate/f.sampleRate + padding
+	default:
+		f.samples = 1152
+		f.length = 144*f.bitrate/f.sampleRate + padding
+	}
+	if f.length < 4 {
+		return nil, false
+	}
+	return f, true
+}
+
+// sideInfoSize is the Layer III side information length, after which the
+// Xing/Info header lives in the first frame.
+func (f *mpegFrame) sideInfoSize() int {
+	switch {
+	case f.version == 1 && f.channels == 1:
+		return 17
+	case f.version == 1:
+		return 32
+	case f.channels == 1:
+		return 9
+	default:
+		return 17
+	}
+}
+
+func readMPEG(r io.ReadSeeker, offset, size int64, m *Metadata) error {
+	end := size
+	if hasID3v1(r, size) {
+		end -= id3v1Size
+	}
+
+	start, first, err := findMPEGSync(r, offset, end)
+	if err != nil {
+		return err
+	}
+
+	m.SampleRate = first.sampleRate
+	m.Channels = first.channels
+
+	frameLen := int64(first.length)
+	if start+frameLen > end {
+		frameLen = end - start
+	}
+	buf := make([]byte, frameLen)
+	if err := readAt(r, start, buf); err != nil {
+		return err
+	}
+
+	if samples, ok := xingSamples(buf, first); ok {
+		m.Duration = samplesToDuration(samples, first.sampleRate)
+		m.Bitrate = averageBitrate(end-start-int64(first.length), m.Duration)
+		return nil
+	}
+
+	samples, err := countMPEGSamples(r, start, end)
+	if err != nil {
+		return err
+	}
+	m.Duration = samplesToDuration(samples, first.sampleRate)
+	m.Bitrate = averageBitrate(end-start, m.Duration)
+	return nil
+}
+
+// findMPEGSync locates the first frame header that is followed by another
+// valid header, which rules out false sync words inside junk data.
+func findMPEGSync(r io.ReadSeeker, offset, end int64) (int64, *mpegFrame, error) {
+	limit := int64(syncSearchLimit)
+	if offset+limit > end {
+		limit = end - offset
+	}
+	if limit < 4 {
+		return 0, nil, ErrUnknownFormat
+	}
+
+	window := make([]byte, limit)
+	if err := readAt(r, offset, window); err != nil {
+		return 0, nil, err
+	}
+
+	for i := 0; i+4 <= len(window); i++ {
+		if window[i] != 0xFF {
+			continue
+		}
+		f, ok := parseMPEGHeader(window[i:])
+		if !ok {
+			continue
+		}
+
+		next := int64(i + f.length)
+		if offset+next >= end {
+			// A single frame file is still a valid stream.
+			return offset + int64(i), f, nil
+		}
+		nextHeader := make([]byte, 4)
+		if next+4 <= int64(len(window)) {
+			copy(nextHeader, window[next:])
+		} else if err := readAt(r, offset+next, nextHeader); err != nil {
+			continue
+		}
+		if n, ok := parseMPEGHeader(nextHeader); ok && n.version == f.version && n.layer == f.layer {
+			return offset + int64(i), f, nil
+		}
+	}
+	return 0, nil, ErrUnknownFormat
+}
+
+// xingSamples reads the frame count from a Xing/Info or VBRI header and
+// subtracts the LAME encoder delay and padding when present.
+func xingSamples(frame []byte, f *mpegFrame) (uint64, bool) {
+	pos := 4 + f.sideInfoSize()
+	if pos+8 <= len(frame) {
+		tag := string(frame[pos : pos+4])
+		if tag == "Xing" || tag == "Info" {
+			flags := binary.BigEndian.Uint32(frame[pos+4 : pos+8])
+			p := pos + 8
+			if flags&0x01 == 0 || p+4 > len(frame) {
+				return 0, false
+			}
+			frames := uint64(binary.BigEndian.Uint32(frame[p : p+4]))
+			p += 4
+			if flags&0x02 != 0 {
+				p += 4
+			}
+			if flags&0x04 != 0 {
+				p += 100
+			}
+			if flags&0x08 != 0 {
+				p += 4
+			}
+
+			samples := frames * uint64(f.samples)
+			if p+24 <= len(frame) && isLAMETag(frame[p:p+4]) {
+				d := frame[p+21 : p+24]
+				delay := uint64(d[0])<<4 | uint64(d[1]>>4)
+				padding := uint64(d[1]&0x0F)<<8 | uint64(d[2])
+				if delay+padding < samples {
+					samples -= delay + padding
+				}
+			}
+			return samples, true
+		}
+	}
+
+	vbri := 4 + 32
+	if vbri+18 <= len(frame) && string(frame[vbri:vbri+4]) == "VBRI" {
+		frames := uint64(binary.BigEndian.Uint32(frame[vbri+14 : vbri+18]))
+		return frames * uint64(f.samples), true
+	}
+	return 0, false
+}
+
+func isLAMETag(b []byte) bool {
+	return bytes.Equal(b, []byte("LAME")) || bytes.Equal(b, []byte("Lavf")) ||
+		bytes.Equal(b, []byte("Lavc")) || bytes.Equal(b, []byte("L3.9"))
+}
+
+// countMPEGSamples walks every frame header between start and end. This is
+// exact for CBR and VBR streams without a Xing header.
+func countMPEGSamples(r io.ReadSeeker, start, end int64) (uint64, error) {
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+	br := bufio.NewReaderSize(io.LimitReader(r, end-start), 64<<10)
+
+	var samples uint64
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			break
+		}
+		f, ok := parseMPEGHeader(header)
+		if !ok {
+			// Trailing APE/Lyrics tags or garbage end the stream.
+			break
+		}
+		if _, err := br.Discard(f.length - 4); err != nil {
+			break
+		}
+		samples += uint64(f.samples)
+	}
+
+	if samples == 0 {
+		return 0, ErrCorrupt
+	}
+	return samples, nil
+}
@@ -0,0 +1,163 @@
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+const (
+	oggPageHeaderSize = 27
+	oggTailWindow     = 64 << 10
+	opusSampleRate    = 48000
+)
+
+type oggPage struct {
+	granule  int64
+	serial   uint32
+	segments []byte
+}
+
+func readOggPage(r io.Reader) (*oggPage, []byte, error) {
+	header := make([]byte, oggPageHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, err
+	}
+	if string(header[:4]) != "OggS" {
+		return nil, nil, ErrCorrupt
+	}
+
+	page := &oggPage{
+		granule:  int64(binary.LittleEndian.Uint64(header[6:14])),
+		serial:   binary.LittleEndian.Uint32(header[14:18]),
+		segments: make([]byte, header[26]),
+	}
+	if _, err := io.ReadFull(r, page.segments); err != nil {
+		return nil, nil, ErrCorrupt
+	}
+
+	var bodySize int
+	for _, s := range page.segments {
+		bodySize += int(s)
+	}
+	body := make([]byte, bodySize)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, ErrCorrupt
+	}
+	return page, body, nil
+}
+
+// readOggPackets reassembles the first n packets of the first logical stream.
+func readOggPackets(r io.Reader, n int) ([][]byte, uint32, error) {
+	var packets [][]byte
+	var current []byte
+	var serial uint32
+	var total int
+	first := true
+
+	for len(packets) < n {
+		page, body, err := readOggPage(r)
+		if err != nil {
+			return nil, 0, err
+		}
+		if first {
+			serial = page.serial
+			first = false
+		} else if page.serial != serial {
+			continue // interleaved stream
+		}
+
+		pos := 0
+		for _, seg := range page.segments {
+			current = append(current, body[pos:pos+int(seg)]...)
+			pos += int(seg)
+			total += int(seg)
+			if total > maxTagSize {
+				return nil, 0, ErrCorrupt
+			}
+			if seg < 255 {
+				packets = append(packets, current)
+				current = nil
+				if len(packets) == n {
+					break
+				}
+			}
+		}
+	}
+	return packets, serial, nil
+}
+
+func readOgg(r io.ReadSeeker, offset, size int64, m *Metadata) error {
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	packets, serial, err := readOggPackets(bufio.NewReader(r), 2)
+	if err != nil {
+		return err
+	}
+
+	id, comment := packets[0], packets[1]
+	var preSkip int64
+	switch {
+	case bytes.HasPrefix(id, []byte("\x01vorbis")) && len(id) >= 16:
+		m.Format = FormatOgg
+		m.Channels = int(id[11])
+		m.SampleRate = int(binary.LittleEndian.Uint32(id[12:16]))
+		if bytes.HasPrefix(comment, []byte("\x03vorbis")) {
+			parseVorbisComment(comment[7:], m)
+		}
+	case bytes.HasPrefix(id, []byte("OpusHead")) && len(id) >= 19:
+		m.Format = FormatOpus
+		m.Channels = int(id[9])
+		preSkip = int64(binary.LittleEndian.Uint16(id[10:12]))
+		// Opus granule positions always count 48 kHz samples.
+		m.SampleRate = opusSampleRate
+		if bytes.HasPrefix(comment, []byte("OpusTags")) {
+			parseVorbisComment(comment[8:], m)
+		}
+	default:
+		return ErrUnknownFormat
+	}
+
+	granule, err := lastOggGranule(r, offset, size, serial)
+	if err != nil {
+		return err
+	}
+	if granule > preSkip {
+		m.Duration = samplesToDuration(uint64(granule-preSkip), m.SampleRate)
+	}
+	m.Bitrate = averageBitrate(size-offset, m.Duration)
+	return nil
+}
+
+// lastOggGranule scans backwards from the end of the file for the last page
+// of the stream, doubling the window until one is found.
+func lastOggGranule(r io.ReadSeeker, offset, size int64, serial uint32) (int64, error) {
+	window := int64(oggTailWindow)
+	for {
+		start := size - window
+		if start < offset {
+			start = offset
+		}
+		buf := make([]byte, size-start)
+		if err := readAt(r, start, buf); err != nil {
+			return 0, err
+		}
+
+		for i := len(buf) - oggPageHeaderSize; i >= 0; i-- {
+			if buf[i] != 'O' || !bytes.HasPrefix(buf[i:], []byte("OggS")) {
+				continue
+			}
+			granule := int64(binary.LittleEndian.Uint64(buf[i+6 : i+14]))
+			if binary.LittleEndian.Uint32(buf[i+14:i+18]) == serial && granule != -1 {
+				return granule, nil
+			}
+		}
+
+		if start == offset {
+			return 0, ErrCorrupt
+		}
+		window *= 2
+	}
+}
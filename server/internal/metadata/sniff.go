@@ -0,0 +1,259 @@
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"strings"
+)
+
+// sniffFrames is how many consecutive MPEG or ADTS frame headers must line
+// up before a headerless stream is accepted as audio. Machine code such as
+// x86 PLT stubs can fake a few back-to-back frames, so this is generous.
+const sniffFrames = 8
+
+var mimeTypes = map[Format]string{
+	FormatMP3:  "audio/mpeg",
+	FormatAAC:  "audio/aac",
+	FormatFLAC: "audio/flac",
+	FormatOgg:  "audio/ogg",
+	FormatOpus: "audio/ogg",
+	FormatMP4:  "audio/mp4",
+	FormatWAV:  "audio/wav",
+}
+
+// declaredFormats maps client supplied MIME types, including the common
+// non-standard aliases browsers send, to the formats they may describe.
+var declaredFormats = map[string][]Format{
+	"audio/mpeg":      {FormatMP3},
+	"audio/mp3":       {FormatMP3},
+	"audio/mpeg3":     {FormatMP3},
+	"audio/x-mpeg-3":  {FormatMP3},
+	"audio/x-mp3":     {FormatMP3},
+	"audio/aac":       {FormatAAC, FormatMP4},
+	"audio/x-aac":     {FormatAAC},
+	"audio/aacp":      {FormatAAC},
+	"audio/flac":      {FormatFLAC},
+	"audio/x-flac":    {FormatFLAC},
+	"audio/ogg":       {FormatOgg, FormatOpus},
+	"audio/vorbis":    {FormatOgg},
+	"audio/opus":      {FormatOpus},
+	"application/ogg": {FormatOgg, FormatOpus},
+	"audio/mp4":       {FormatMP4},
+	"audio/m4a":       {FormatMP4},
+	"audio/x-m4a":     {FormatMP4},
+	"audio/wav":       {FormatWAV},
+	"audio/wave":      {FormatWAV},
+	"audio/x-wav":     {FormatWAV},
+	"audio/vnd.wave":  {FormatWAV},
+}
+
+// mp4AudioBrands are ftyp brands used by audio-only MP4 files.
+var mp4AudioBrands = []string{"M4A ", "M4B ", "M4P ", "mp41", "mp42", "isom", "iso2", "dash"}
+
+func (f Format) MimeType() string {
+	return mimeTypes[f]
+}
+
+// MatchesMimeType reports whether a declared Content-Type is consistent with
+// the detected format. Unknown audio/* types never match.
+func (f Format) MatchesMimeType(declared string) bool {
+	mediaType, _, err := mime.ParseMediaType(declared)
+	if err != nil {
+		return false
+	}
+	for _, candidate := range declaredFormats[strings.ToLower(mediaType)] {
+		if candidate == f {
+			return true
+		}
+	}
+	return false
+}
+
+// Sniff identifies the audio format from magic bytes and frame headers
+// without trusting file names or declared types.
+func Sniff(r io.ReadSeeker) (Format, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", err
+	}
+
+	head := make([]byte, 12)
+	var offset int64
+	if err := readAt(r, 0, head[:4]); err != nil {
+		return "", ErrUnknownFormat
+	}
+	if bytes.HasPrefix(head, []byte("ID3")) {
+		header := make([]byte, id3HeaderSize)
+		if err := readAt(r, 0, header); err != nil {
+			return "", ErrUnknownFormat
+		}
+		offset = id3HeaderSize + int64(syncsafe(header[6:10]))
+		if header[5]&0x10 != 0 {
+			offset += id3HeaderSize
+		}
+	}
+
+	n := int64(len(head))
+	if offset+n > size {
+		n = size - offset
+	}
+	if n < 4 {
+		return "", ErrUnknownFormat
+	}
+	head = head[:n]
+	if err := readAt(r, offset, head); err != nil {
+		return "", ErrUnknownFormat
+	}
+
+	switch containerFormat(head) {
+	case FormatFLAC:
+		// The first metadata block must be STREAMINFO.
+		block := make([]byte, 1)
+		if err := readAt(r, offset+4, block); err != nil || block[0]&0x7F != flacStreamInfo {
+			return "", ErrUnknownFormat
+		}
+		return FormatFLAC, nil
+	case FormatOgg:
+		return sniffOgg(r, offset)
+	case FormatMP4:
+		return sniffMP4(r, offset)
+	case FormatWAV:
+		return FormatWAV, nil
+	case FormatAAC:
+		if !framesLineUp(r, offset, size, 7, func(h []byte) (int, int, bool) {
+			f, ok := parseADTSHeader(h)
+			if !ok {
+				return 0, 0, false
+			}
+			return f.length, f.sampleRate<<4 | f.channels, true
+		}) {
+			return "", ErrUnknownFormat
+		}
+		return FormatAAC, nil
+	}
+
+	// Unlike the tag reader, sniffing does not search for a sync word past
+	// arbitrary junk: the first frame must follow the tag or zero padding.
+	start, err := skipZeroPadding(r, offset, size)
+	if err != nil {
+		return "", ErrUnknownFormat
+	}
+	if !framesLineUp(r, start, size, 4, func(h []byte) (int, int, bool) {
+		f, ok := parseMPEGHeader(h)
+		if !ok {
+			return 0, 0, false
+		}
+		return f.length, f.version<<20 | f.layer<<18 | f.sampleRate, true
+	}) {
+		return "", ErrUnknownFormat
+	}
+	return FormatMP3, nil
+}
+
+func skipZeroPadding(r io.ReadSeeker, offset, size int64) (int64, error) {
+	limit := int64(syncSearchLimit)
+	if offset+limit > size {
+		limit = size - offset
+	}
+	if limit <= 0 {
+		return 0, ErrUnknownFormat
+	}
+	window := make([]byte, limit)
+	if err := readAt(r, offset, window); err != nil {
+		return 0, err
+	}
+	for i, b := range window {
+		if b != 0 {
+			return offset + int64(i), nil
+		}
+	}
+	return 0, ErrUnknownFormat
+}
+
+// framesLineUp checks that sniffFrames frame headers with the same stream
+// parameters follow each other back to back, or that the stream ends
+// cleanly before that. parse returns the frame length and a signature of
+// the parameters that must not change between frames.
+func framesLineUp(r io.ReadSeeker, offset, size int64, headerLen int, parse func([]byte) (int, int, bool)) bool {
+	end := size
+	if hasID3v1(r, size) {
+		end -= id3v1Size
+	}
+
+	header := make([]byte, headerLen)
+	first := -1
+	for i := 0; i < sniffFrames; i++ {
+		if offset == end && i > 0 {
+			return true
+		}
+		if offset+int64(headerLen) > end {
+			return false
+		}
+		if err := readAt(r, offset, header); err != nil {
+			return false
+		}
+		length, signature, ok := parse(header)
+		if !ok {
+			return false
+		}
+		if first == -1 {
+			first = signature
+		} else if signature != first {
+			return false
+		}
+		offset += int64(length)
+	}
+	return true
+}
+
+func sniffOgg(r io.ReadSeeker, offset int64) (Format, error) {
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return "", err
+	}
+	packets, _, err := readOggPackets(bufio.NewReader(r), 1)
+	if err != nil {
+		return "", ErrUnknownFormat
+	}
+
+	switch id := packets[0]; {
+	case bytes.HasPrefix(id, []byte("\x01vorbis")):
+		return FormatOgg, nil
+	case bytes.HasPrefix(id, []byte("OpusHead")):
+		return FormatOpus, nil
+	case bytes.HasPrefix(id, []byte("\x7fFLAC")):
+		return FormatOgg, nil
+	}
+	return "", ErrUnknownFormat
+}
+
+func sniffMP4(r io.ReadSeeker, offset int64) (Format, error) {
+	header := make([]byte, 8)
+	if err := readAt(r, offset, header); err != nil {
+		return "", ErrUnknownFormat
+	}
+	boxSize := int64(header[0])<<24 | int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+	if boxSize < 16 || boxSize > 4096 {
+		return "", ErrUnknownFormat
+	}
+
+	ftyp := make([]byte, boxSize-8)
+	if err := readAt(r, offset+8, ftyp); err != nil {
+		return "", ErrUnknownFormat
+	}
+
+	// Major brand, minor version, then the compatible brands.
+	brands := [][]byte{ftyp[:4]}
+	for p := 8; p+4 <= len(ftyp); p += 4 {
+		brands = append(brands, ftyp[p:p+4])
+	}
+	for _, brand := range brands {
+		for _, audio := range mp4AudioBrands {
+			if string(brand) == audio {
+				return FormatMP4, nil
+			}
+		}
+	}
+	return "", ErrUnknownFormat
+}
@@ -0,0 +1,140 @@
+package metadata
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSniff(t *testing.T) {
+	// A 48 kHz frame cannot continue a 44.1 kHz stream.
+	frame48k := make([]byte, 384)
+	copy(frame48k, []byte{0xFF, 0xFB, 0x94, 0x00})
+
+	streamInfo := flacBlock(flacStreamInfo, true, flacStreamInfoBlock(44100, 2, 44100))
+	ftyp := func(box []byte) []byte { return cat(box, mp4BoxBytes("mdat", make([]byte, 64))) }
+
+	tests := []struct {
+		name string
+		file []byte
+		want Format
+	}{
+		{"mp3", mpegFrames(10), FormatMP3},
+		{"mp3 after id3", cat(id3Tag(3, id3Frame(3, "TIT2", id3Text("Title"))), mpegFrames(10)), FormatMP3},
+		{"mp3 after id3 with padding", cat(id3Tag(4), make([]byte, 100), mpegFrames(10)), FormatMP3},
+		{"mp3 with id3 footer flag", cat([]byte("ID3\x04\x00\x10\x00\x00\x00\x00"), make([]byte, 10), mpegFrames(10)), FormatMP3},
+		{"short mp3 ending cleanly", mpegFrames(3), FormatMP3},
+		{"short mp3 before id3v1", cat(mpegFrames(3), id3v1Tag("Title", "", "", "", 0, 0)), FormatMP3},
+		{"aac", adtsFrames(10), FormatAAC},
+		{"aac after id3", cat(id3Tag(3), adtsFrames(10)), FormatAAC},
+		{"flac", flacFile(streamInfo), FormatFLAC},
+		{"flac after id3", cat(id3Tag(3), flacFile(streamInfo)), FormatFLAC},
+		{"ogg vorbis", oggPacketPage(1, 0, vorbisIDPacket(2, 44100)), FormatOgg},
+		{"ogg flac", oggPacketPage(1, 0, []byte("\x7fFLAC\x01\x00")), FormatOgg},
+		{"opus", oggPacketPage(1, 0, opusHeadPacket(2, 312)), FormatOpus},
+		{"mp4", mp4File(), FormatMP4},
+		{"mp4 compatible brand", ftyp(mp4BoxBytes("ftyp", []byte("qt  \x00\x00\x00\x00isom"))), FormatMP4},
+		{"wav", wavFile(2, 44100, make([]byte, 64)), FormatWAV},
+
+		{"empty", nil, ""},
+		{"too short", []byte("ID"), ""},
+		{"text", bytes.Repeat([]byte("plain text "), 100), ""},
+		{"zeros", make([]byte, 4096), ""},
+		{"mp3 after junk", cat([]byte("junk"), mpegFrames(10)), ""},
+		{"single mp3 frame before junk", cat(mpegFrames(1), bytes.Repeat([]byte("junk"), 500)), ""},
+		{"mp3 sample rate change", cat(mpegFrames(4), frame48k, mpegFrames(4)), ""},
+		{"mp3 frame cut short", mpegFrames(3)[:1000], ""},
+		{"aac after junk", cat([]byte("junk"), adtsFrames(10)), ""},
+		{"id3 without audio", id3Tag(3, id3Frame(3, "TIT2", id3Text("Title"))), ""},
+		{"id3 longer than the file", cat([]byte("ID3\x03\x00\x00\x00\x00\x7F\x7F"), mpegFrames(10)), ""},
+		{"flac without streaminfo first", flacFile(flacBlock(flacVorbisComment, false, vorbisComment()), streamInfo), ""},
+		{"flac signature only", []byte("fLaC"), ""},
+		{"ogg theora", oggPacketPage(1, 0, []byte("\x80theora")), ""},
+		{"ogg truncated page", oggPacketPage(1, 0, vorbisIDPacket(2, 44100))[:30], ""},
+		{"mp4 video brand", ftyp(mp4BoxBytes("ftyp", []byte("qt  \x00\x00\x00\x00avc1"))), ""},
+		{"mp4 ftyp too small", cat([]byte("\x00\x00\x00\x0CftypM4A "), make([]byte, 64)), ""},
+		{"mp4 ftyp too large", cat([]byte("\x00\x00\x20\x00ftypM4A "), make([]byte, 64)), ""},
+		{"mp4 ftyp past the end", []byte("\x00\x00\x01\x00ftypM4A \x00\x00\x00\x00"), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Sniff(bytes.NewReader(tt.file))
+			if tt.want == "" {
+				if !errors.Is(err, ErrUnknownFormat) {
+					t.Errorf("Sniff = %q, %v; want ErrUnknownFormat", got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Sniff = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+// TestSniffTruncated sniffs every prefix of each fixture, which must never
+// panic whatever length field the cut lands in.
+func TestSniffTruncated(t *testing.T) {
+	fixtures := [][]byte{
+		cat(id3Tag(3, id3Frame(3, "TIT2", id3Text("Title"))), mpegFrames(2)),
+		adtsFrames(2),
+		flacFile(flacBlock(flacStreamInfo, true, flacStreamInfoBlock(44100, 2, 44100))),
+		oggPacketPage(1, 0, make([]byte, 600)),
+		mp4File(),
+		wavFile(1, 8000, make([]byte, 16)),
+	}
+	for _, file := range fixtures {
+		for n := 0; n < len(file); n++ {
+			Sniff(bytes.NewReader(file[:n]))
+		}
+	}
+}
+
+func TestMatchesMimeType(t *testing.T) {
+	tests := []struct {
+		format   Format
+		declared string
+		want     bool
+	}{
+		{FormatMP3, "audio/mpeg", true},
+		{FormatMP3, "audio/MP3", true},
+		{FormatMP3, "audio/mpeg; charset=binary", true},
+		{FormatAAC, "audio/aac", true},
+		{FormatMP4, "audio/aac", true},
+		{FormatMP4, "audio/x-m4a", true},
+		{FormatAAC, "audio/mp4", false},
+		{FormatOgg, "application/ogg", true},
+		{FormatOpus, "audio/ogg", true},
+		{FormatOpus, "audio/vorbis", false},
+		{FormatWAV, "audio/x-wav", true},
+		{FormatFLAC, "audio/x-flac", true},
+		{FormatFLAC, "audio/mpeg", false},
+		{FormatMP3, "audio/x-unknown", false},
+		{FormatMP3, "application/octet-stream", false},
+		{FormatMP3, "", false},
+		{FormatMP3, "audio/mpeg;;", false},
+	}
+	for _, tt := range tests {
+		if got := tt.format.MatchesMimeType(tt.declared); got != tt.want {
+			t.Errorf("%s.MatchesMimeType(%q) = %v, want %v", tt.format, tt.declared, got, tt.want)
+		}
+	}
+}
+
+func TestMimeType(t *testing.T) {
+	for format, want := range map[Format]string{
+		FormatMP3:  "audio/mpeg",
+		FormatAAC:  "audio/aac",
+		FormatFLAC: "audio/flac",
+		FormatOgg:  "audio/ogg",
+		FormatOpus: "audio/ogg",
+		FormatMP4:  "audio/mp4",
+		FormatWAV:  "audio/wav",
+		"":         "",
+	} {
+		if got := format.MimeType(); got != want {
+			t.Errorf("%q.MimeType() = %q, want %q", format, got, want)
+		}
+	}
+}
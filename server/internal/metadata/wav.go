@@ -0,0 +1,97 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+)
+
+func readWAV(r io.ReadSeeker, offset, size int64, m *Metadata) error {
+	pos := offset + 12 // "RIFF", size, "WAVE"
+	header := make([]byte, 8)
+
+	var byteRate, dataSize int64
+	for pos+8 <= size {
+		if err := readAt(r, pos, header); err != nil {
+			return err
+		}
+		id := string(header[:4])
+		chunkSize := int64(binary.LittleEndian.Uint32(header[4:8]))
+		pos += 8
+
+		switch id {
+		case "fmt ":
+			if chunkSize < 16 {
+				return ErrCorrupt
+			}
+			chunk := make([]byte, 16)
+			if err := readAt(r, pos, chunk); err != nil {
+				return err
+			}
+			m.Channels = int(binary.LittleEndian.Uint16(chunk[2:4]))
+			m.SampleRate = int(binary.LittleEndian.Uint32(chunk[4:8]))
+			byteRate = int64(binary.LittleEndian.Uint32(chunk[8:12]))
+		case "data":
+			dataSize = chunkSize
+			// Streams written without a known length leave the size unset.
+			if dataSize == 0 || pos+dataSize > size {
+				dataSize = size - pos
+			}
+		case "LIST":
+			if chunkSize >= 4 && chunkSize <= maxTagSize {
+				chunk := make([]byte, chunkSize)
+				if err := readAt(r, pos, chunk); err != nil {
+					return err
+				}
+				if bytes.HasPrefix(chunk, []byte("INFO")) {
+					parseRIFFInfo(chunk[4:], m)
+				}
+			}
+		case "id3 ", "ID3 ":
+			if _, err := readID3v2(r, pos, m); err != nil {
+				return err
+			}
+		}
+
+		pos += chunkSize + chunkSize%2
+	}
+
+	if byteRate == 0 {
+		return ErrCorrupt
+	}
+	m.Duration = samplesToDuration(uint64(dataSize), int(byteRate))
+	m.Bitrate = int(byteRate * 8)
+	return nil
+}
+
+func parseRIFFInfo(data []byte, m *Metadata) {
+	for len(data) >= 8 {
+		id := string(data[:4])
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		if size < 0 || 8+size > len(data) {
+			return
+		}
+		value := strings.TrimRight(string(data[8:8+size]), "\x00")
+		next := 8 + size + size%2
+		if next > len(data) {
+			next = len(data)
+		}
+		data = data[next:]
+
+		switch id {
+		case "INAM":
+			m.setTag("TITLE", value)
+		case "IART":
+			m.setTag("ARTIST", value)
+		case "IPRD":
+			m.setTag("ALBUM", value)
+		case "IGNR":
+			m.setTag("GENRE", value)
+		case "ICRD":
+			m.setTag("DATE", value)
+		case "ITRK", "IPRT":
+			m.setTag("TRACKNUMBER", value)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+
+	"maxify/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// AdminMiddleware only lets administrators through. It must run after
+// AuthMiddleware. The flag is read from the database on every request so
+// that revoking it takes effect immediately.
+func AdminMiddleware(userService *services.UserService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := c.MustGet("user_id").(uuid.UUID)
+		if !ok {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+			c.Abort()
+			return
+		}
+
+		user, err := userService.GetUserByID(userID)
+		if err != nil || !user.IsAdmin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
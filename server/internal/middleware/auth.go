@@ -1,6 +1,8 @@
 package middleware
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"strings"
 
@@ -26,6 +28,13 @@ func AuthMiddleware(authService *services.AuthService) gin.HandlerFunc {
 		}
 
 		claims, err := authService.ValidateToken(tokenString)
+		if errors.Is(err, services.ErrRevocationCheck) {
+			// Not the client's fault, so do not make it log out.
+			log.Printf("Rejecting token: %v", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Authentication is temporarily unavailable"})
+			c.Abort()
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
@@ -34,6 +43,7 @@ func AuthMiddleware(authService *services.AuthService) gin.HandlerFunc {
 
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
+		c.Set("session_id", claims.SessionID)
 		c.Set("token", tokenString)
 
 		c.Next()
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type Album struct {
+	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Title       string    `json:"title" gorm:"not null"`
+	TitleKey    string    `json:"-" gorm:"not null;uniqueIndex:idx_albums_user_artist_title_key"`
+	ArtistID    uuid.UUID `json:"artist_id" gorm:"type:uuid;not null;uniqueIndex:idx_albums_user_artist_title_key"`
+	UserID      uuid.UUID `json:"user_id" gorm:"type:uuid;not null;uniqueIndex:idx_albums_user_artist_title_key"`
+	Year        int       `json:"year"`
+	ArtworkHash *string   `json:"-" gorm:"size:64"` // uploaded cover art, shown for tracks without their own
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	User   User    `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Artist Artist  `json:"artist,omitempty" gorm:"foreignKey:ArtistID"`
+	Tracks []Track `json:"tracks,omitempty" gorm:"foreignKey:AlbumID"`
+}
+
+func (a *Album) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type Artist struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Name      string    `json:"name" gorm:"not null"`
+	NameKey   string    `json:"-" gorm:"not null;uniqueIndex:idx_artists_user_name_key"`
+	UserID    uuid.UUID `json:"user_id" gorm:"type:uuid;not null;uniqueIndex:idx_artists_user_name_key"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	User   User    `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Albums []Album `json:"albums,omitempty" gorm:"foreignKey:ArtistID"`
+	Tracks []Track `json:"tracks,omitempty" gorm:"foreignKey:ArtistID"`
+}
+
+func (a *Artist) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return nil
+}
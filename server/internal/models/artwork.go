@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Artwork is a stored cover image, addressed by its SHA-256 like Blob.
+// Tracks and albums with the same image share one row; RefCount is the
+// number of them using it and the image is deleted with the last reference,
+// along with its resized variants.
+type Artwork struct {
+	Hash      string    `json:"hash" gorm:"primaryKey;size:64"` // hex SHA-256
+	Key       string    `json:"key" gorm:"not null"`            // storage key of the original
+	Size      int64     `json:"size" gorm:"not null"`
+	MimeType  string    `json:"mime_type" gorm:"not null"`
+	Width     int       `json:"width" gorm:"not null"`
+	Height    int       `json:"height" gorm:"not null"`
+	RefCount  int64     `json:"ref_count" gorm:"not null;default:0"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
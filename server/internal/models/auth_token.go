@@ -7,12 +7,21 @@ import (
 	"gorm.io/gorm"
 )
 
+// AuthToken is a refresh token. Every refresh rotates the token, and all
+// tokens issued from one login share a FamilyID, which is the session ID
+// shown to the user and embedded in access tokens.
 type AuthToken struct {
-	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
-	Token     string    `json:"-" gorm:"uniqueIndex;not null"`
-	UserID    uuid.UUID `json:"user_id" gorm:"type:uuid;not null"`
-	ExpiresAt time.Time `json:"expires_at" gorm:"not null"`
-	CreatedAt time.Time `json:"created_at"`
+	ID        uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Token     string     `json:"-" gorm:"uniqueIndex;not null"` // SHA-256 of the refresh token
+	FamilyID  uuid.UUID  `json:"family_id" gorm:"type:uuid;not null;index"`
+	UserID    uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	UserAgent string     `json:"user_agent"`
+	IPAddress string     `json:"ip_address"`
+	LoginAt   time.Time  `json:"login_at" gorm:"not null"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	RotatedAt *time.Time `json:"rotated_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt time.Time  `json:"created_at"`
 
 	User User `json:"user,omitempty" gorm:"foreignKey:UserID"`
 }
@@ -23,7 +32,3 @@ func (a *AuthToken) BeforeCreate(tx *gorm.DB) error {
 	}
 	return nil
 }
-
-func (a *AuthToken) IsExpired() bool {
-	return time.Now().After(a.ExpiresAt)
-}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Blob is stored audio content, addressed by its SHA-256. Tracks with the
+// same content share one blob; RefCount is the number of tracks using it.
+// The object and the row are deleted once the last reference is released.
+type Blob struct {
+	Hash      string    `json:"hash" gorm:"primaryKey;size:64"` // hex SHA-256
+	Key       string    `json:"key" gorm:"not null"`            // storage key
+	Size      int64     `json:"size" gorm:"not null"`
+	MimeType  string    `json:"mime_type" gorm:"not null"`
+	RefCount  int64     `json:"ref_count" gorm:"not null;default:0"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
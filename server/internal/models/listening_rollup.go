@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ListeningRollup sums a user's play events per track and UTC hour. Stats
+// are computed from rollups rather than from raw events.
+type ListeningRollup struct {
+	UserID  uuid.UUID `json:"user_id" gorm:"type:uuid;primaryKey"`
+	Day     time.Time `json:"day" gorm:"type:date;primaryKey"`
+	Hour    int       `json:"hour" gorm:"primaryKey;autoIncrement:false"`
+	TrackID uuid.UUID `json:"track_id" gorm:"type:uuid;primaryKey;index"`
+	Plays   int64     `json:"plays" gorm:"not null;default:0"`
+	Seconds int64     `json:"seconds" gorm:"not null;default:0"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	PlayEventStart    = "start"
+	PlayEventProgress = "progress"
+	PlayEventComplete = "complete"
+)
+
+// PlayEvent is a playback report from a client. All events of one listen
+// share a PlayID; the event that crossed the play threshold has Counted set
+// and is what increments the track's play count. Seconds is the listening
+// time since the previous event of the play. Events are summed into
+// ListeningRollups and marked RolledUp.
+type PlayEvent struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	PlayID    uuid.UUID `json:"play_id" gorm:"type:uuid;not null;index"`
+	UserID    uuid.UUID `json:"user_id" gorm:"type:uuid;not null;index:idx_play_events_user_counted,priority:1,where:counted"`
+	TrackID   uuid.UUID `json:"track_id" gorm:"type:uuid;not null;index"`
+	Type      string    `json:"type" gorm:"not null"`
+	Position  int       `json:"position"` // seconds into the track
+	Seconds   int       `json:"seconds" gorm:"not null;default:0"`
+	Counted   bool      `json:"counted" gorm:"not null;default:false"`
+	RolledUp  bool      `json:"-" gorm:"not null;default:false"`
+	CreatedAt time.Time `json:"created_at" gorm:"index:idx_play_events_user_counted,priority:2,where:counted;index:idx_play_events_pending,where:NOT rolled_up"`
+
+	// No foreign key constraint: history is kept after a track is purged.
+	Track Track `json:"-" gorm:"foreignKey:TrackID;constraint:-"`
+}
+
+func (e *PlayEvent) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return nil
+}
@@ -1,38 +1,104 @@
 package models
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+const (
+	PlaylistPrivate  = "private"
+	PlaylistUnlisted = "unlisted" // readable by anyone with the slug
+	PlaylistPublic   = "public"   // unlisted, and also listed publicly
+)
+
+const (
+	CollaboratorViewer = "viewer"
+	CollaboratorEditor = "editor"
+)
+
+// Playlist is a hand-curated list of entries, or a smart playlist when Rule
+// is set. Smart playlists have no entries; their tracks are selected from
+// the owner's library by the rule each time the playlist is read.
 type Playlist struct {
 	ID          uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
 	Name        string         `json:"name" gorm:"not null"`
 	Description string         `json:"description"`
+	Visibility  string         `json:"visibility" gorm:"not null;default:private;index"`
+	Slug        string         `json:"slug" gorm:"uniqueIndex"`
+	Rule        string         `json:"rule,omitempty" gorm:"type:text"` // see package rules
 	UserID      uuid.UUID      `json:"user_id" gorm:"type:uuid;not null"`
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
 
-	User   User    `json:"user,omitempty" gorm:"foreignKey:UserID"`
-	Tracks []Track `json:"tracks,omitempty" gorm:"many2many:playlist_tracks;"`
+	User    User            `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Entries []PlaylistTrack `json:"entries,omitempty" gorm:"foreignKey:PlaylistID"`
 }
 
+// PlaylistTrack is an entry in a playlist. A track may appear in the same
+// playlist more than once, so entries have their own ID.
 type PlaylistTrack struct {
-	PlaylistID uuid.UUID `json:"playlist_id" gorm:"type:uuid;primary_key"`
-	TrackID    uuid.UUID `json:"track_id" gorm:"type:uuid;primary_key"`
-	Order      int       `json:"order" gorm:"not null;default:0"`
-	AddedAt    time.Time `json:"added_at" gorm:"default:CURRENT_TIMESTAMP"`
+	ID         uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	PlaylistID uuid.UUID      `json:"playlist_id" gorm:"type:uuid;not null;index"`
+	TrackID    uuid.UUID      `json:"track_id" gorm:"type:uuid;not null;index"`
+	Order      int            `json:"order" gorm:"not null;default:0"`
+	AddedAt    time.Time      `json:"added_at" gorm:"default:CURRENT_TIMESTAMP"`
+	DeletedAt  gorm.DeletedAt `json:"-" gorm:"index"` // set while the track is in the trash
 
 	Playlist Playlist `json:"playlist,omitempty" gorm:"foreignKey:PlaylistID"`
 	Track    Track    `json:"track,omitempty" gorm:"foreignKey:TrackID"`
 }
 
+// PlaylistCollaborator grants a user other than the owner access to a
+// playlist. Editors may add, remove and reorder tracks.
+type PlaylistCollaborator struct {
+	PlaylistID uuid.UUID `json:"playlist_id" gorm:"type:uuid;primary_key"`
+	UserID     uuid.UUID `json:"user_id" gorm:"type:uuid;primary_key;index"`
+	Role       string    `json:"role" gorm:"not null;default:viewer"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+
+	Playlist Playlist `json:"-" gorm:"foreignKey:PlaylistID"`
+	User     User     `json:"user,omitempty" gorm:"foreignKey:UserID"`
+}
+
+func (pt *PlaylistTrack) BeforeCreate(tx *gorm.DB) error {
+	if pt.ID == uuid.Nil {
+		pt.ID = uuid.New()
+	}
+	return nil
+}
+
+func (p *Playlist) IsSmart() bool {
+	return p.Rule != ""
+}
+
 func (p *Playlist) BeforeCreate(tx *gorm.DB) error {
 	if p.ID == uuid.Nil {
 		p.ID = uuid.New()
 	}
+	if p.Slug == "" {
+		slug, err := NewPlaylistSlug()
+		if err != nil {
+			return err
+		}
+		p.Slug = slug
+	}
+	if p.Visibility == "" {
+		p.Visibility = PlaylistPrivate
+	}
 	return nil
 }
+
+// NewPlaylistSlug returns a random identifier used in share URLs.
+func NewPlaylistSlug() (string, error) {
+	buf := make([]byte, 6)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
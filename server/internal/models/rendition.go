@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	RenditionPending    = "pending"
+	RenditionProcessing = "processing"
+	RenditionReady      = "ready"
+	RenditionFailed     = "failed"
+)
+
+// Rendition is a transcoded copy of a track at a lower bitrate.
+type Rendition struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	TrackID   uuid.UUID `json:"track_id" gorm:"type:uuid;not null;uniqueIndex:idx_renditions_track_profile"`
+	Profile   string    `json:"profile" gorm:"not null;uniqueIndex:idx_renditions_track_profile"`
+	Status    string    `json:"status" gorm:"not null;default:pending;index"`
+	Bitrate   int       `json:"bitrate"` // kbps
+	FilePath  string    `json:"-"`       // storage key
+	FileSize  int64     `json:"file_size"`
+	MimeType  string    `json:"mime_type"`
+	Segments  int       `json:"segments"` // HLS segments, 0 until packaged
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Track Track `json:"-" gorm:"foreignKey:TrackID"`
+}
+
+func (r *Rendition) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
@@ -8,20 +8,31 @@ import (
 )
 
 type Track struct {
-	ID        uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
-	Title     string         `json:"title" gorm:"not null"`
-	Artist    string         `json:"artist"`
-	Duration  int            `json:"duration" gorm:"not null"` // Duration in seconds
-	FilePath  string         `json:"file_path" gorm:"not null"`
-	FileSize  int64          `json:"file_size" gorm:"not null"`
-	MimeType  string         `json:"mime_type" gorm:"not null"`
-	UserID    uuid.UUID      `json:"user_id" gorm:"type:uuid;not null"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+	ID          uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Title       string         `json:"title" gorm:"not null"`
+	Artist      string         `json:"artist"`
+	ArtistID    *uuid.UUID     `json:"artist_id" gorm:"type:uuid;index"`
+	Album       string         `json:"album"`
+	AlbumID     *uuid.UUID     `json:"album_id" gorm:"type:uuid;index"`
+	Genre       string         `json:"genre"`
+	Year        int            `json:"year"`
+	TrackNumber int            `json:"track_number"`
+	DiscNumber  int            `json:"disc_number"`
+	Duration    int            `json:"duration" gorm:"not null"`  // Duration in seconds
+	FilePath    string         `json:"file_path" gorm:"not null"` // storage key
+	ContentHash *string        `json:"-" gorm:"size:64;index"`    // blob hash, nil for tracks stored before deduplication
+	FileSize    int64          `json:"file_size" gorm:"not null"`
+	MimeType    string         `json:"mime_type" gorm:"not null"`
+	PlayCount   int64          `json:"play_count" gorm:"not null;default:0"`
+	Broken      bool           `json:"broken" gorm:"not null;default:false"` // file missing or damaged, set by fsck
+	ArtworkHash *string        `json:"-" gorm:"size:64;index"`               // embedded cover art, nil if none
+	UserID      uuid.UUID      `json:"user_id" gorm:"type:uuid;not null"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
 
-	User      User       `json:"user,omitempty" gorm:"foreignKey:UserID"`
-	Playlists []Playlist `json:"playlists,omitempty" gorm:"many2many:playlist_tracks;"`
+	User            User            `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	PlaylistEntries []PlaylistTrack `json:"-" gorm:"foreignKey:TrackID"`
 }
 
 func (t *Track) BeforeCreate(tx *gorm.DB) error {
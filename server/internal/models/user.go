@@ -12,6 +12,10 @@ type User struct {
 	Username     string         `json:"username" gorm:"uniqueIndex;not null"`
 	Email        string         `json:"email" gorm:"uniqueIndex;not null"`
 	PasswordHash string         `json:"-" gorm:"not null"`
+	IsAdmin      bool           `json:"is_admin" gorm:"not null;default:false"`
+	Plan         string         `json:"plan" gorm:"size:32"` // empty is the default plan; set by admins only
+	StorageQuota *int64         `json:"-"`                   // overrides the plan's quota
+	TrackQuota   *int64         `json:"-"`
 	CreatedAt    time.Time      `json:"created_at"`
 	UpdatedAt    time.Time      `json:"updated_at"`
 	DeletedAt    gorm.DeletedAt `json:"-" gorm:"index"`
@@ -0,0 +1,90 @@
+package pagination
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// Request is a page request as sent by a client: a page size and the cursor
+// returned with the previous page, if any.
+type Request struct {
+	Limit  int
+	Cursor string
+}
+
+// Cursor marks the boundary row of a page. Keys holds the row's values for
+// the columns of the Keyset, in order. Prev cursors page backwards.
+type Cursor struct {
+	Prev  bool     `json:"p,omitempty"`
+	Keys  []string `json:"k"`
+	Scope string   `json:"s"`
+}
+
+// Signer encodes cursors as opaque tokens. Tokens are signed so clients
+// cannot forge keys, and carry a scope so a cursor issued for one listing is
+// rejected by another.
+type Signer struct {
+	key []byte
+}
+
+func NewSigner(secret string) *Signer {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("maxify pagination cursor"))
+	return &Signer{key: mac.Sum(nil)}
+}
+
+func (s *Signer) Encode(c *Cursor) string {
+	payload, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(s.sign(payload))
+}
+
+// Decode verifies a token produced by Encode for the same scope. An empty
+// token decodes to a nil cursor, meaning the first page.
+func (s *Signer) Decode(token, scope string) (*Cursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, s.sign(payload)) {
+		return nil, ErrInvalidCursor
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(payload, &c); err != nil || c.Scope != scope || len(c.Keys) == 0 {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+func (s *Signer) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(payload)
+	return mac.Sum(nil)[:16]
+}
+
+// Scope identifies a listing, such as one user's search for one query.
+func Scope(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:8])
+}
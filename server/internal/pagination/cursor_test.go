@@ -0,0 +1,86 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	signer := NewSigner("secret")
+	scope := Scope("tracks", "user")
+
+	for _, c := range []*Cursor{
+		{Keys: []string{"2024-01-02T03:04:05Z", "0b7e4a52-1c5e-4f0e-9a8b-4c3d2e1f0a9b"}, Scope: scope},
+		{Prev: true, Keys: []string{"0.0607927", "a,b.c=\"d\"", ""}, Scope: scope},
+	} {
+		token := signer.Encode(c)
+		if strings.ContainsAny(token, "+/=") {
+			t.Errorf("token %q is not URL safe", token)
+		}
+		got, err := signer.Decode(token, scope)
+		if err != nil {
+			t.Fatalf("Decode(%q): %v", token, err)
+		}
+		if !reflect.DeepEqual(got, c) {
+			t.Errorf("Decode = %+v, want %+v", got, c)
+		}
+	}
+
+	if got, err := signer.Decode("", scope); got != nil || err != nil {
+		t.Errorf("Decode of an empty token = %+v, %v; want the first page", got, err)
+	}
+}
+
+func TestCursorRejected(t *testing.T) {
+	signer := NewSigner("secret")
+	scope := Scope("tracks", "user")
+	token := signer.Encode(&Cursor{Keys: []string{"b", "2"}, Scope: scope})
+	payload, signature, _ := strings.Cut(token, ".")
+
+	// signed returns a correctly signed token for an arbitrary payload.
+	signed := func(payload string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+			base64.RawURLEncoding.EncodeToString(signer.sign([]byte(payload)))
+	}
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"k":["a","1"],"s":"` + scope + `"}`))
+	flipped := []byte(signature)
+	flipped[0] ^= 1
+
+	tests := []struct {
+		name  string
+		token string
+		scope string
+	}{
+		{"forged keys", forged + "." + signature, scope},
+		{"changed signature", payload + "." + string(flipped), scope},
+		{"truncated signature", payload + "." + signature[:len(signature)-2], scope},
+		{"no signature", payload, scope},
+		{"empty signature", payload + ".", scope},
+		{"invalid base64", payload + "!." + signature, scope},
+		{"other secret", NewSigner("other").Encode(&Cursor{Keys: []string{"b", "2"}, Scope: scope}), scope},
+		{"other scope", token, Scope("tracks", "other user")},
+		{"other listing", token, Scope("playlists", "user")},
+		{"no keys", signer.Encode(&Cursor{Scope: scope}), scope},
+		{"not json", signed("not json"), scope},
+	}
+	for _, tt := range tests {
+		if c, err := signer.Decode(tt.token, tt.scope); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%s: Decode = %+v, %v; want ErrInvalidCursor", tt.name, c, err)
+		}
+	}
+}
+
+func TestScope(t *testing.T) {
+	if Scope("search", "user", "q") == Scope("search", "user", "q", "") {
+		t.Error("scopes with different parts collide")
+	}
+	if Scope("search", "ab", "c") == Scope("search", "a", "bc") {
+		t.Error("scope parts are not separated")
+	}
+	if Scope("search", "user", "q") != Scope("search", "user", "q") {
+		t.Error("scope is not deterministic")
+	}
+}
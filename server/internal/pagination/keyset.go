@@ -0,0 +1,141 @@
+package pagination
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// exactCountLimit is the number of rows counted exactly. Larger totals are
+// estimated from the query plan.
+const exactCountLimit = 10000
+
+// Page describes where a page sits in the full result set. Next and Prev
+// are empty on the last and first page.
+type Page struct {
+	Limit          int    `json:"limit"`
+	Total          int64  `json:"total"`
+	TotalEstimated bool   `json:"total_estimated"`
+	Next           string `json:"next,omitempty"`
+	Prev           string `json:"prev,omitempty"`
+}
+
+// Keyset pages through a query ordered by Columns, all in the same direction.
+// The last column must be unique, typically the primary key, so that every
+// row has a distinct position.
+type Keyset struct {
+	Columns []string
+	Desc    bool
+}
+
+// Apply orders the query and restricts it to the rows after the cursor, or
+// before it for Prev cursors. One extra row is fetched so that Build can
+// tell whether another page follows.
+func (k Keyset) Apply(db *gorm.DB, cursor *Cursor, limit int) *gorm.DB {
+	desc := k.Desc
+	if cursor != nil && cursor.Prev {
+		desc = !desc
+	}
+
+	if cursor != nil && len(cursor.Keys) == len(k.Columns) {
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		args := make([]interface{}, len(cursor.Keys))
+		for i, key := range cursor.Keys {
+			args[i] = key
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+		db = db.Where(fmt.Sprintf("(%s) %s (%s)", strings.Join(k.Columns, ", "), op, placeholders), args...)
+	}
+
+	order := " ASC"
+	if desc {
+		order = " DESC"
+	}
+	for _, column := range k.Columns {
+		db = db.Order(column + order)
+	}
+
+	return db.Limit(limit + 1)
+}
+
+// Build trims the rows fetched with Keyset.Apply to the page, restores their
+// display order and returns the page with cursors to its neighbours. keys
+// returns a row's values for the keyset columns.
+func Build[T any](signer *Signer, scope string, rows []T, limit int, cursor *Cursor, keys func(*T) []string) ([]T, *Page) {
+	page := &Page{Limit: limit}
+
+	more := len(rows) > limit
+	if more {
+		rows = rows[:limit]
+	}
+
+	backward := cursor != nil && cursor.Prev
+	if backward {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+
+	// Moving forward there is a previous page whenever we started from a
+	// cursor, and moving backward there is always a next page.
+	hasNext := more
+	hasPrev := cursor != nil
+	if backward {
+		hasNext, hasPrev = true, more
+	}
+
+	if len(rows) > 0 {
+		if hasNext {
+			page.Next = signer.Encode(&Cursor{Keys: keys(&rows[len(rows)-1]), Scope: scope})
+		}
+		if hasPrev {
+			page.Prev = signer.Encode(&Cursor{Prev: true, Keys: keys(&rows[0]), Scope: scope})
+		}
+	}
+
+	return rows, page
+}
+
+// Count returns the number of rows matched by the query. Up to
+// exactCountLimit rows are counted exactly; beyond that the planner's
+// estimate is returned and estimated is true.
+func Count(db *gorm.DB) (total int64, estimated bool, err error) {
+	sub := db.Session(&gorm.Session{}).Select("1").Limit(exactCountLimit + 1)
+	if err := db.Session(&gorm.Session{NewDB: true}).Table("(?) AS counted", sub).Count(&total).Error; err != nil {
+		return 0, false, err
+	}
+	if total <= exactCountLimit {
+		return total, false, nil
+	}
+
+	if estimate, err := estimateRows(db); err == nil && estimate > total {
+		total = estimate
+	}
+	return total, true, nil
+}
+
+func estimateRows(db *gorm.DB) (int64, error) {
+	var rows []map[string]interface{}
+	stmt := db.Session(&gorm.Session{DryRun: true}).Select("1").Find(&rows).Statement
+
+	var plan string
+	if err := stmt.ConnPool.QueryRowContext(context.Background(), "EXPLAIN (FORMAT JSON) "+stmt.SQL.String(), stmt.Vars...).Scan(&plan); err != nil {
+		return 0, err
+	}
+
+	var explained []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal([]byte(plan), &explained); err != nil || len(explained) == 0 {
+		return 0, fmt.Errorf("unexpected query plan: %s", plan)
+	}
+	return int64(explained[0].Plan.Rows), nil
+}
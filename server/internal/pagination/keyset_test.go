@@ -0,0 +1,158 @@
+package pagination
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type item struct {
+	ID    string `gorm:"primaryKey"`
+	Genre string
+}
+
+func itemKeys(it *item) []string {
+	return []string{it.Genre, it.ID}
+}
+
+// newItemsDB stores items whose genres tie, inserted out of order.
+func newItemsDB(t *testing.T) (*gorm.DB, []item) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&item{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+
+	items := []item{
+		{"07", "b"}, {"03", "a"}, {"10", "c"}, {"01", "b"}, {"05", "a"},
+		{"02", "c"}, {"09", "a"}, {"04", "b"}, {"08", "b"}, {"06", "c"},
+	}
+	if err := db.Create(&items).Error; err != nil {
+		t.Fatalf("failed to create items: %v", err)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Genre != items[j].Genre {
+			return items[i].Genre < items[j].Genre
+		}
+		return items[i].ID < items[j].ID
+	})
+	return db, items
+}
+
+// fetchPage loads the page after or before token.
+func fetchPage(t *testing.T, db *gorm.DB, keyset Keyset, signer *Signer, scope, token string, limit int) ([]item, *Page) {
+	t.Helper()
+	cursor, err := signer.Decode(token, scope)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	var rows []item
+	if err := keyset.Apply(db.Model(&item{}), cursor, limit).Find(&rows).Error; err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	return Build(signer, scope, rows, limit, cursor, itemKeys)
+}
+
+func TestKeysetPaging(t *testing.T) {
+	db, sorted := newItemsDB(t)
+	signer := NewSigner("secret")
+	scope := Scope("items")
+
+	for _, desc := range []bool{false, true} {
+		want := append([]item(nil), sorted...)
+		if desc {
+			for i, j := 0, len(want)-1; i < j; i, j = i+1, j-1 {
+				want[i], want[j] = want[j], want[i]
+			}
+		}
+		keyset := Keyset{Columns: []string{"items.genre", "items.id"}, Desc: desc}
+
+		for _, limit := range []int{1, 3, 4, 10, 20} {
+			t.Run(fmt.Sprintf("desc=%v limit=%d", desc, limit), func(t *testing.T) {
+				var pages [][]item
+				var seen []item
+				token := ""
+				for {
+					rows, page := fetchPage(t, db, keyset, signer, scope, token, limit)
+					if (len(pages) == 0) != (page.Prev == "") {
+						t.Errorf("page %d: Prev = %q", len(pages), page.Prev)
+					}
+					pages = append(pages, rows)
+					seen = append(seen, rows...)
+					if page.Next == "" {
+						break
+					}
+					if len(pages) > len(want) {
+						t.Fatal("paging does not terminate")
+					}
+					token = page.Next
+				}
+				if !reflect.DeepEqual(seen, want) {
+					t.Fatalf("forward pages = %v, want %v", seen, want)
+				}
+
+				// Walk back from the last page with Prev cursors.
+				_, last := fetchPage(t, db, keyset, signer, scope, token, limit)
+				token = last.Prev
+				for i := len(pages) - 2; i >= 0; i-- {
+					rows, page := fetchPage(t, db, keyset, signer, scope, token, limit)
+					if !reflect.DeepEqual(rows, pages[i]) {
+						t.Errorf("backward page %d = %v, want %v", i, rows, pages[i])
+					}
+					if page.Next == "" {
+						t.Errorf("backward page %d has no Next", i)
+					}
+					if (i == 0) != (page.Prev == "") {
+						t.Errorf("backward page %d: Prev = %q", i, page.Prev)
+					}
+					token = page.Prev
+				}
+			})
+		}
+	}
+}
+
+// TestKeysetPagingInsert checks that a row tying with the cursor row and
+// sorting before it does not shift later pages.
+func TestKeysetPagingInsert(t *testing.T) {
+	db, sorted := newItemsDB(t)
+	signer := NewSigner("secret")
+	scope := Scope("items")
+	keyset := Keyset{Columns: []string{"items.genre", "items.id"}}
+
+	first, page := fetchPage(t, db, keyset, signer, scope, "", 4)
+	if !reflect.DeepEqual(first, sorted[:4]) {
+		t.Fatalf("first page = %v, want %v", first, sorted[:4])
+	}
+	// The first page ends with ("b", "01"); "00" ties on the genre.
+	if err := db.Create(&item{ID: "00", Genre: "b"}).Error; err != nil {
+		t.Fatalf("failed to create item: %v", err)
+	}
+
+	second, _ := fetchPage(t, db, keyset, signer, scope, page.Next, 4)
+	if !reflect.DeepEqual(second, sorted[4:8]) {
+		t.Errorf("second page = %v, want %v", second, sorted[4:8])
+	}
+}
+
+func TestCount(t *testing.T) {
+	db, _ := newItemsDB(t)
+	total, estimated, err := Count(db.Model(&item{}).Where("genre = ?", "b"))
+	if err != nil {
+		t.Fatalf("Count: %v", err)
+	}
+	if total != 4 || estimated {
+		t.Errorf("Count = %d, %v; want 4, false", total, estimated)
+	}
+}
@@ -16,9 +16,9 @@ func SetupRoutes(cfg *config.Config) *gin.Engine {
 	router := gin.New()
 
 	corsConfig := cors.Config{
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Requested-With", "Range", "Cache-Control", "Pragma"},
-		ExposeHeaders:    []string{"Content-Length", "Content-Range", "Content-Type"},
+		AllowMethods:     []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Requested-With", "Range", "If-Range", "If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "Upload-Offset", "Cache-Control", "Pragma"},
+		ExposeHeaders:    []string{"Content-Length", "Content-Range", "Content-Type", "Accept-Ranges", "ETag", "Last-Modified", "Upload-Offset"},
 		AllowCredentials: true,
 		MaxAge:           86400,
 	}
@@ -29,18 +29,34 @@ func SetupRoutes(cfg *config.Config) *gin.Engine {
 	router.Use(gin.Recovery())
 
 	authService := services.NewAuthService(cfg)
-	userService := services.NewUserService()
+	userService := services.NewUserService(cfg)
 	trackService := services.NewTrackService(cfg)
-	playlistService := services.NewPlaylistService()
-	searchService := services.NewSearchService()
+	playlistService := services.NewPlaylistService(cfg)
+	searchService := services.NewSearchService(cfg)
+	libraryService := services.NewLibraryService()
+	uploadService := services.NewUploadService(cfg)
+	playService := services.NewPlayService(cfg)
+	playerService := services.NewPlayerService()
+	fsckService := services.NewFsckService()
+	trashService := services.NewTrashService(cfg)
+	quotaService := services.NewQuotaService(cfg)
+	artworkService := services.NewArtworkService()
 
 	authController := controllers.NewAuthController(authService)
 	userController := controllers.NewUserController(userService)
 	trackController := controllers.NewTrackController(trackService)
 	playlistController := controllers.NewPlaylistController(playlistService)
 	searchController := controllers.NewSearchController(searchService)
+	libraryController := controllers.NewLibraryController(libraryService)
+	uploadController := controllers.NewUploadController(uploadService)
+	playController := controllers.NewPlayController(playService)
+	playerController := controllers.NewPlayerController(playerService)
+	adminController := controllers.NewAdminController(fsckService, quotaService)
+	trashController := controllers.NewTrashController(trashService)
+	artworkController := controllers.NewArtworkController(artworkService)
 
 	authMiddleware := middleware.AuthMiddleware(authService)
+	adminMiddleware := middleware.AdminMiddleware(userService)
 
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok"})
@@ -52,6 +68,7 @@ func SetupRoutes(cfg *config.Config) *gin.Engine {
 		{
 			auth.POST("/register", authController.Register)
 			auth.POST("/login", authController.Login)
+			auth.POST("/refresh", authController.Refresh)
 			auth.POST("/logout", authMiddleware, authController.Logout)
 		}
 
@@ -62,6 +79,10 @@ func SetupRoutes(cfg *config.Config) *gin.Engine {
 			users.PUT("/profile", userController.UpdateProfile)
 			users.DELETE("/profile", userController.DeleteAccount)
 			users.GET("/stats", userController.GetUserStats)
+			users.GET("/recently-played", playController.GetRecentlyPlayed)
+			users.GET("/wrapped/:year", userController.GetWrapped)
+			users.GET("/sessions", authController.GetSessions)
+			users.DELETE("/sessions/:id", authController.RevokeSession)
 		}
 
 		tracks := v1.Group("/tracks")
@@ -73,6 +94,43 @@ func SetupRoutes(cfg *config.Config) *gin.Engine {
 			tracks.GET("/:id", trackController.GetTrack)
 			tracks.DELETE("/:id", trackController.DeleteTrack)
 			tracks.GET("/:id/stream", trackController.StreamTrack)
+			tracks.HEAD("/:id/stream", trackController.StreamTrack)
+			tracks.GET("/:id/artwork", artworkController.GetTrackArtwork)
+			tracks.HEAD("/:id/artwork", artworkController.GetTrackArtwork)
+			tracks.POST("/:id/plays", playController.RecordPlay)
+			tracks.GET("/:id/hls/master.m3u8", trackController.GetHLSMaster)
+			tracks.GET("/:id/hls/:variant/:file", trackController.GetHLSFile)
+		}
+
+		uploads := v1.Group("/uploads")
+		uploads.Use(authMiddleware)
+		{
+			uploads.POST("", uploadController.CreateUpload)
+			uploads.POST("/", uploadController.CreateUpload)
+			uploads.GET("/:id", uploadController.GetUpload)
+			uploads.HEAD("/:id", uploadController.GetUpload)
+			uploads.PATCH("/:id", uploadController.AppendChunk)
+			uploads.POST("/:id/complete", uploadController.CompleteUpload)
+			uploads.DELETE("/:id", uploadController.CancelUpload)
+		}
+
+		artists := v1.Group("/artists")
+		artists.Use(authMiddleware)
+		{
+			artists.GET("", libraryController.GetArtists)
+			artists.GET("/", libraryController.GetArtists)
+		}
+
+		albums := v1.Group("/albums")
+		albums.Use(authMiddleware)
+		{
+			albums.GET("", libraryController.GetAlbums)
+			albums.GET("/", libraryController.GetAlbums)
+			albums.GET("/:id/tracks", libraryController.GetAlbumTracks)
+			albums.GET("/:id/artwork", artworkController.GetAlbumArtwork)
+			albums.HEAD("/:id/artwork", artworkController.GetAlbumArtwork)
+			albums.PUT("/:id/artwork", artworkController.SetAlbumArtwork)
+			albums.DELETE("/:id/artwork", artworkController.DeleteAlbumArtwork)
 		}
 
 		playlists := v1.Group("/playlists")
@@ -82,11 +140,59 @@ func SetupRoutes(cfg *config.Config) *gin.Engine {
 			playlists.POST("/", playlistController.CreatePlaylist)
 			playlists.GET("", playlistController.GetUserPlaylists)
 			playlists.GET("/", playlistController.GetUserPlaylists)
+			playlists.GET("/shared", playlistController.GetSharedPlaylists)
 			playlists.GET("/:id", playlistController.GetPlaylist)
 			playlists.PUT("/:id", playlistController.UpdatePlaylist)
 			playlists.DELETE("/:id", playlistController.DeletePlaylist)
 			playlists.POST("/:id/tracks", playlistController.AddTrackToPlaylist)
-			playlists.DELETE("/:id/tracks/:trackId", playlistController.RemoveTrackFromPlaylist)
+			playlists.PUT("/:id/tracks", playlistController.ReorderPlaylistTracks)
+			playlists.DELETE("/:id/tracks/:entryId", playlistController.RemoveTrackFromPlaylist)
+			playlists.POST("/:id/tracks/:entryId/move", playlistController.MoveEntry)
+			playlists.GET("/:id/collaborators", playlistController.GetCollaborators)
+			playlists.POST("/:id/collaborators", playlistController.AddCollaborator)
+			playlists.DELETE("/:id/collaborators/:userId", playlistController.RemoveCollaborator)
+		}
+
+		player := v1.Group("/player")
+		player.Use(authMiddleware)
+		{
+			player.GET("", playerController.GetState)
+			player.GET("/", playerController.GetState)
+			player.PUT("", playerController.UpdateState)
+			player.PUT("/", playerController.UpdateState)
+			player.GET("/events", playerController.Events)
+			player.GET("/devices", playerController.GetDevices)
+			player.POST("/queue", playerController.Enqueue)
+			player.PUT("/queue", playerController.ReorderQueue)
+			player.DELETE("/queue", playerController.ClearQueue)
+			player.DELETE("/queue/:itemId", playerController.Dequeue)
+			player.POST("/next", playerController.Next)
+			player.POST("/previous", playerController.Previous)
+		}
+
+		trash := v1.Group("/trash")
+		trash.Use(authMiddleware)
+		{
+			trash.GET("", trashController.GetTrash)
+			trash.GET("/", trashController.GetTrash)
+			trash.DELETE("", trashController.EmptyTrash)
+			trash.DELETE("/", trashController.EmptyTrash)
+			trash.POST("/tracks/:id/restore", trashController.RestoreTrack)
+			trash.POST("/playlists/:id/restore", trashController.RestorePlaylist)
+		}
+
+		admin := v1.Group("/admin")
+		admin.Use(authMiddleware, adminMiddleware)
+		{
+			admin.POST("/fsck", adminController.Fsck)
+			admin.GET("/users/:id/quota", adminController.GetUserQuota)
+			admin.PUT("/users/:id/quota", adminController.SetUserQuota)
+		}
+
+		public := v1.Group("/public")
+		{
+			public.GET("/playlists", playlistController.GetPublicPlaylists)
+			public.GET("/playlists/:slug", playlistController.GetPublicPlaylist)
 		}
 
 		search := v1.Group("/search")
@@ -0,0 +1,134 @@
+package rules
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Apply restricts a query on the tracks table to the rule's matches and
+// applies its order and limit. now anchors IN LAST conditions. Values are
+// always passed as query parameters; only whitelisted column names end up
+// in the SQL.
+func (r *Rule) Apply(db *gorm.DB, now time.Time) *gorm.DB {
+	if r.Where != nil {
+		var args []interface{}
+		db = db.Where(compile(r.Where, now, &args), args...)
+	}
+
+	if len(r.Order) == 0 {
+		db = db.Order("tracks.created_at DESC")
+	}
+	for _, order := range r.Order {
+		expr := "RANDOM()"
+		if f, ok := fields[order.Field]; ok {
+			expr = f.expr()
+		}
+		if order.Desc {
+			expr += " DESC"
+		}
+		db = db.Order(expr)
+	}
+
+	limit := r.Limit
+	if limit == 0 {
+		limit = MaxLimit
+	}
+	return db.Order("tracks.id").Limit(limit)
+}
+
+func compile(n Node, now time.Time, args *[]interface{}) string {
+	switch n := n.(type) {
+	case andNode:
+		return "(" + compile(n.left, now, args) + " AND " + compile(n.right, now, args) + ")"
+	case orNode:
+		return "(" + compile(n.left, now, args) + " OR " + compile(n.right, now, args) + ")"
+	case notNode:
+		return "(NOT " + compile(n.expr, now, args) + ")"
+	case condition:
+		return n.compile(now, args)
+	}
+	panic(fmt.Sprintf("rules: unexpected node %T", n))
+}
+
+func (c condition) compile(now time.Time, args *[]interface{}) string {
+	expr := c.field.expr()
+
+	switch c.op {
+	case "in":
+		*args = append(*args, c.values)
+		return "(" + expr + " IN ?)"
+	case "contains":
+		*args = append(*args, "%"+escapeLike(c.values[0].(string))+"%")
+		return "(" + expr + ` LIKE ? ESCAPE '\')`
+	case "last":
+		n, unit := c.values[0].(int), c.values[1].(string)
+		since := now
+		switch unit {
+		case "day":
+			since = now.AddDate(0, 0, -n)
+		case "week":
+			since = now.AddDate(0, 0, -7*n)
+		case "month":
+			since = now.AddDate(0, -n, 0)
+		case "year":
+			since = now.AddDate(-n, 0, 0)
+		}
+		*args = append(*args, since)
+		return "(" + expr + " >= ?)"
+	}
+
+	if c.field.kind == dateField {
+		return c.compileDate(expr, args)
+	}
+
+	op := c.op
+	if op == "!=" {
+		op = "<>"
+	}
+	*args = append(*args, c.values[0])
+	return "(" + expr + " " + op + " ?)"
+}
+
+// compileDate compares against whole UTC days: added = '2024-01-31' matches
+// any time on that day.
+func (c condition) compileDate(expr string, args *[]interface{}) string {
+	start := c.values[0].(time.Time)
+	end := start.AddDate(0, 0, 1)
+
+	switch c.op {
+	case "=":
+		*args = append(*args, start, end)
+		return "(" + expr + " >= ? AND " + expr + " < ?)"
+	case "!=":
+		*args = append(*args, start, end)
+		return "(" + expr + " < ? OR " + expr + " >= ?)"
+	case "<":
+		*args = append(*args, start)
+		return "(" + expr + " < ?)"
+	case "<=":
+		*args = append(*args, end)
+		return "(" + expr + " < ?)"
+	case ">":
+		*args = append(*args, end)
+		return "(" + expr + " >= ?)"
+	default: // >=
+		*args = append(*args, start)
+		return "(" + expr + " >= ?)"
+	}
+}
+
+// expr is the SQL expression a field is compared and ordered by. Text is
+// compared case-insensitively.
+func (f field) expr() string {
+	if f.kind == textField {
+		return "LOWER(COALESCE(" + f.column + ", ''))"
+	}
+	return f.column
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,125 @@
+package rules
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int // byte offset in the rule
+}
+
+// SyntaxError reports a rule that cannot be parsed or refers to unknown
+// fields. Pos is the byte offset of the offending token.
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos+1)
+}
+
+func lex(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokenLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokenRParen, ")", i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{tokenComma, ",", i})
+			i++
+		case c == '=':
+			tokens = append(tokens, token{tokenOperator, "=", i})
+			i++
+		case c == '!' || c == '<' || c == '>':
+			two := ""
+			if i+1 < len(src) {
+				two = src[i : i+2]
+			}
+			switch two {
+			case "!=", "<=", ">=":
+				tokens = append(tokens, token{tokenOperator, two, i})
+				i += 2
+			case "<>":
+				tokens = append(tokens, token{tokenOperator, "!=", i})
+				i += 2
+			default:
+				if c == '!' {
+					return nil, &SyntaxError{i, "unexpected '!'"}
+				}
+				tokens = append(tokens, token{tokenOperator, string(c), i})
+				i++
+			}
+		case c == '\'' || c == '"':
+			text, n, err := lexString(src[i:])
+			if err != nil {
+				return nil, &SyntaxError{i, err.Error()}
+			}
+			tokens = append(tokens, token{tokenString, text, i})
+			i += n
+		case c >= '0' && c <= '9' || c == '-' || c == '.':
+			start := i
+			i++
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokenNumber, src[start:i], start})
+		case isIdentStart(c):
+			start := i
+			for i < len(src) && (isIdentStart(src[i]) || src[i] >= '0' && src[i] <= '9') {
+				i++
+			}
+			tokens = append(tokens, token{tokenIdent, src[start:i], start})
+		default:
+			return nil, &SyntaxError{i, fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+	return append(tokens, token{tokenEOF, "", len(src)}), nil
+}
+
+// lexString reads a quoted string. The quote character is escaped by
+// doubling it, as in SQL.
+func lexString(src string) (string, int, error) {
+	quote := src[0]
+	var b strings.Builder
+	for i := 1; i < len(src); i++ {
+		if src[i] != quote {
+			b.WriteByte(src[i])
+			continue
+		}
+		if i+1 < len(src) && src[i+1] == quote {
+			b.WriteByte(quote)
+			i++
+			continue
+		}
+		return b.String(), i + 1, nil
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,406 @@
+// Package rules parses the rule expressions of smart playlists and compiles
+// them to parameterized track queries.
+//
+// A rule is a condition on tracks, optionally followed by an ordering and a
+// limit:
+//
+//	artist = 'Radiohead' AND duration < 300 AND added IN LAST 30 DAYS
+//	ORDER BY plays DESC, title LIMIT 50
+//
+// Conditions compare a field with a value using =, !=, <, <=, > or >=, or
+// use field IN (v1, v2), field CONTAINS 'text' and, for dates, field IN
+// LAST n DAYS|WEEKS|MONTHS|YEARS. NOT negates IN and CONTAINS and whole
+// conditions. Conditions are combined with AND, OR and parentheses. Text
+// comparisons ignore case. Keywords are case-insensitive.
+package rules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxLength bounds the length of a rule in bytes.
+	MaxLength = 2000
+	// MaxLimit bounds the number of tracks a rule can select. Rules without
+	// a LIMIT select at most this many.
+	MaxLimit = 1000
+
+	maxDepth   = 32
+	dateLayout = "2006-01-02"
+)
+
+type fieldKind int
+
+const (
+	textField fieldKind = iota
+	numberField
+	dateField
+)
+
+type field struct {
+	column string
+	kind   fieldKind
+}
+
+// lastUnits bound IN LAST per unit to about a century, so that the date
+// counted back stays in range whatever the unit.
+var lastUnits = map[string]int{
+	"day":   36500,
+	"week":  5200,
+	"month": 1200,
+	"year":  100,
+}
+
+// fields are the track fields rules can refer to.
+var fields = map[string]field{
+	"title":    {"tracks.title", textField},
+	"artist":   {"tracks.artist", textField},
+	"album":    {"tracks.album", textField},
+	"genre":    {"tracks.genre", textField},
+	"format":   {"tracks.mime_type", textField},
+	"year":     {"tracks.year", numberField},
+	"track":    {"tracks.track_number", numberField},
+	"disc":     {"tracks.disc_number", numberField},
+	"duration": {"tracks.duration", numberField},  // seconds
+	"size":     {"tracks.file_size", numberField}, // bytes
+	"plays":    {"tracks.play_count", numberField},
+	"added":    {"tracks.created_at", dateField},
+}
+
+// orderRandom shuffles the selection each time the rule is evaluated.
+const orderRandom = "random"
+
+// Rule is a parsed rule. A nil Where matches every track.
+type Rule struct {
+	Where Node
+	Order []Order
+	Limit int
+}
+
+type Order struct {
+	Field string
+	Desc  bool
+}
+
+// Node is a condition or a combination of conditions.
+type Node interface {
+	node()
+}
+
+type andNode struct{ left, right Node }
+type orNode struct{ left, right Node }
+type notNode struct{ expr Node }
+
+// condition compares a field with values that have been checked against
+// the field's kind: lower-cased strings, int64s or dates. For the "last" op
+// values holds the amount and the unit.
+type condition struct {
+	field  field
+	op     string
+	values []interface{}
+}
+
+func (andNode) node()   {}
+func (orNode) node()    {}
+func (notNode) node()   {}
+func (condition) node() {}
+
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+// Parse parses and validates a rule. Errors are *SyntaxError.
+func Parse(src string) (*Rule, error) {
+	if len(src) > MaxLength {
+		return nil, &SyntaxError{MaxLength, fmt.Sprintf("rule is longer than %d characters", MaxLength)}
+	}
+
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+
+	rule := &Rule{}
+	if p.peek().kind != tokenEOF && !p.atKeyword("ORDER") && !p.atKeyword("LIMIT") {
+		if rule.Where, err = p.parseOr(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.keyword("ORDER") {
+		if !p.keyword("BY") {
+			return nil, p.errorf("expected BY after ORDER")
+		}
+		for {
+			t := p.next()
+			name := strings.ToLower(t.text)
+			if _, ok := fields[name]; t.kind != tokenIdent || !ok && name != orderRandom {
+				return nil, &SyntaxError{t.pos, fmt.Sprintf("cannot order by %q", t.text)}
+			}
+			order := Order{Field: name}
+			if p.keyword("DESC") {
+				order.Desc = true
+			} else {
+				p.keyword("ASC")
+			}
+			rule.Order = append(rule.Order, order)
+			if p.peek().kind != tokenComma {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if p.keyword("LIMIT") {
+		t := p.next()
+		n, err := strconv.Atoi(t.text)
+		if t.kind != tokenNumber || err != nil || n < 1 || n > MaxLimit {
+			return nil, &SyntaxError{t.pos, fmt.Sprintf("LIMIT must be between 1 and %d", MaxLimit)}
+		}
+		rule.Limit = n
+	}
+
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, &SyntaxError{t.pos, fmt.Sprintf("unexpected %q", t.text)}
+	}
+	return rule, nil
+}
+
+func (p *parser) parseOr() (Node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (Node, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxDepth {
+		return nil, p.errorf("rule is nested too deeply")
+	}
+
+	if p.keyword("NOT") {
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{expr}, nil
+	}
+
+	if p.peek().kind == tokenLParen {
+		p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenRParen {
+			return nil, &SyntaxError{t.pos, "expected ')'"}
+		}
+		return expr, nil
+	}
+
+	return p.parseCondition()
+}
+
+func (p *parser) parseCondition() (Node, error) {
+	t := p.next()
+	if t.kind != tokenIdent {
+		return nil, &SyntaxError{t.pos, "expected a field name"}
+	}
+	f, ok := fields[strings.ToLower(t.text)]
+	if !ok {
+		return nil, &SyntaxError{t.pos, fmt.Sprintf("unknown field %q", t.text)}
+	}
+
+	negate := p.keyword("NOT")
+	var cond condition
+	var err error
+	switch {
+	case p.keyword("CONTAINS"):
+		cond, err = p.parseContains(f)
+	case p.keyword("IN"):
+		if p.keyword("LAST") {
+			cond, err = p.parseLast(f)
+		} else {
+			cond, err = p.parseIn(f)
+		}
+	case negate:
+		return nil, p.errorf("expected IN or CONTAINS after NOT")
+	default:
+		cond, err = p.parseComparison(f)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if negate {
+		return notNode{cond}, nil
+	}
+	return cond, nil
+}
+
+func (p *parser) parseComparison(f field) (condition, error) {
+	t := p.next()
+	if t.kind != tokenOperator {
+		return condition{}, &SyntaxError{t.pos, "expected a comparison operator"}
+	}
+	if f.kind == textField && t.text != "=" && t.text != "!=" {
+		return condition{}, &SyntaxError{t.pos, fmt.Sprintf("operator %s cannot be used with text", t.text)}
+	}
+
+	value, err := p.parseValue(f)
+	if err != nil {
+		return condition{}, err
+	}
+	return condition{field: f, op: t.text, values: []interface{}{value}}, nil
+}
+
+func (p *parser) parseContains(f field) (condition, error) {
+	if f.kind != textField {
+		return condition{}, p.errorAt(p.pos-1, "CONTAINS can only be used with text")
+	}
+	value, err := p.parseValue(f)
+	if err != nil {
+		return condition{}, err
+	}
+	return condition{field: f, op: "contains", values: []interface{}{value}}, nil
+}
+
+func (p *parser) parseIn(f field) (condition, error) {
+	if f.kind == dateField {
+		return condition{}, p.errorf("dates can only be used with IN LAST")
+	}
+	if t := p.next(); t.kind != tokenLParen {
+		return condition{}, &SyntaxError{t.pos, "expected '(' after IN"}
+	}
+
+	cond := condition{field: f, op: "in"}
+	for {
+		value, err := p.parseValue(f)
+		if err != nil {
+			return condition{}, err
+		}
+		cond.values = append(cond.values, value)
+
+		t := p.next()
+		if t.kind == tokenRParen {
+			return cond, nil
+		}
+		if t.kind != tokenComma {
+			return condition{}, &SyntaxError{t.pos, "expected ',' or ')'"}
+		}
+	}
+}
+
+func (p *parser) parseLast(f field) (condition, error) {
+	if f.kind != dateField {
+		return condition{}, p.errorAt(p.pos-1, "IN LAST can only be used with dates")
+	}
+
+	t := p.next()
+	n, err := strconv.Atoi(t.text)
+	if t.kind != tokenNumber || err != nil || n < 1 {
+		return condition{}, &SyntaxError{t.pos, "expected a number of days, weeks, months or years"}
+	}
+
+	unit := p.next()
+	name := strings.TrimSuffix(strings.ToLower(unit.text), "s")
+	max, ok := lastUnits[name]
+	if !ok {
+		return condition{}, &SyntaxError{unit.pos, "expected DAYS, WEEKS, MONTHS or YEARS"}
+	}
+	if n > max {
+		return condition{}, &SyntaxError{t.pos, fmt.Sprintf("IN LAST covers at most %d %ss", max, name)}
+	}
+	return condition{field: f, op: "last", values: []interface{}{n, name}}, nil
+}
+
+func (p *parser) parseValue(f field) (interface{}, error) {
+	t := p.next()
+	switch f.kind {
+	case textField:
+		if t.kind != tokenString {
+			return nil, &SyntaxError{t.pos, "expected a quoted string"}
+		}
+		return strings.ToLower(t.text), nil
+	case numberField:
+		n, err := strconv.ParseInt(t.text, 10, 64)
+		if t.kind != tokenNumber || err != nil {
+			return nil, &SyntaxError{t.pos, "expected a whole number"}
+		}
+		return n, nil
+	default:
+		day, err := time.Parse(dateLayout, t.text)
+		if t.kind != tokenString || err != nil {
+			return nil, &SyntaxError{t.pos, "expected a date such as '2024-01-31'"}
+		}
+		return day, nil
+	}
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) atKeyword(word string) bool {
+	t := p.peek()
+	return t.kind == tokenIdent && strings.EqualFold(t.text, word)
+}
+
+// keyword consumes the next token if it is the given keyword.
+func (p *parser) keyword(word string) bool {
+	if p.atKeyword(word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) errorf(msg string) error {
+	return p.errorAt(p.pos, msg)
+}
+
+func (p *parser) errorAt(i int, msg string) error {
+	if i >= len(p.tokens) {
+		i = len(p.tokens) - 1
+	}
+	return &SyntaxError{p.tokens[i].pos, msg}
+}
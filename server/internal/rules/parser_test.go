@@ -0,0 +1,248 @@
+package rules
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var testNow = time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+
+func day(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+const (
+	artist = "LOWER(COALESCE(tracks.artist, ''))"
+	title  = "LOWER(COALESCE(tracks.title, ''))"
+	genre  = "LOWER(COALESCE(tracks.genre, ''))"
+)
+
+func TestParseWhere(t *testing.T) {
+	tests := []struct {
+		rule string
+		sql  string
+		args []interface{}
+	}{
+		{"artist = 'Radiohead'", "(" + artist + " = ?)", []interface{}{"radiohead"}},
+		{`title = "It's"`, "(" + title + " = ?)", []interface{}{"it's"}},
+		{"title = 'It''s'", "(" + title + " = ?)", []interface{}{"it's"}},
+		{"artist != 'x'", "(" + artist + " <> ?)", []interface{}{"x"}},
+		{"artist <> 'x'", "(" + artist + " <> ?)", []interface{}{"x"}},
+		{"duration < 300", "(tracks.duration < ?)", []interface{}{int64(300)}},
+		{"year<=1999", "(tracks.year <= ?)", []interface{}{int64(1999)}},
+		{"plays > 0", "(tracks.play_count > ?)", []interface{}{int64(0)}},
+		{"track >= -1", "(tracks.track_number >= ?)", []interface{}{int64(-1)}},
+		{"format = 'audio/flac'", "(LOWER(COALESCE(tracks.mime_type, '')) = ?)", []interface{}{"audio/flac"}},
+		{"Genre in ('Rock', \"JAZZ\")", "(" + genre + " IN ?)", []interface{}{[]interface{}{"rock", "jazz"}}},
+		{"year IN (1999)", "(tracks.year IN ?)", []interface{}{[]interface{}{int64(1999)}}},
+		{"genre NOT IN ('pop')", "(NOT (" + genre + " IN ?))", []interface{}{[]interface{}{"pop"}}},
+		{"title contains '50%_off\\'", "(" + title + ` LIKE ? ESCAPE '\')`, []interface{}{`%50\%\_off\\%`}},
+		{"title not contains 'live'", "(NOT (" + title + ` LIKE ? ESCAPE '\'))`, []interface{}{"%live%"}},
+
+		// Precedence: NOT binds tighter than AND, which binds tighter than OR.
+		{
+			"artist = 'a' OR artist = 'b' AND year = 1",
+			"((" + artist + " = ?) OR ((" + artist + " = ?) AND (tracks.year = ?)))",
+			[]interface{}{"a", "b", int64(1)},
+		},
+		{
+			"artist = 'a' AND artist = 'b' OR year = 1",
+			"(((" + artist + " = ?) AND (" + artist + " = ?)) OR (tracks.year = ?))",
+			[]interface{}{"a", "b", int64(1)},
+		},
+		{
+			"(artist = 'a' OR artist = 'b') AND year = 1",
+			"(((" + artist + " = ?) OR (" + artist + " = ?)) AND (tracks.year = ?))",
+			[]interface{}{"a", "b", int64(1)},
+		},
+		{
+			"NOT artist = 'a' AND year = 1",
+			"((NOT (" + artist + " = ?)) AND (tracks.year = ?))",
+			[]interface{}{"a", int64(1)},
+		},
+		{
+			"NOT (artist = 'a' OR year = 1)",
+			"(NOT ((" + artist + " = ?) OR (tracks.year = ?)))",
+			[]interface{}{"a", int64(1)},
+		},
+		{"not not year = 1", "(NOT (NOT (tracks.year = ?)))", []interface{}{int64(1)}},
+		{
+			"year = 1 or year = 2 or year = 3",
+			"(((tracks.year = ?) OR (tracks.year = ?)) OR (tracks.year = ?))",
+			[]interface{}{int64(1), int64(2), int64(3)},
+		},
+
+		// Dates compare whole UTC days.
+		{"added = '2024-01-31'", "(tracks.created_at >= ? AND tracks.created_at < ?)", []interface{}{day(2024, 1, 31), day(2024, 2, 1)}},
+		{"added != '2024-01-31'", "(tracks.created_at < ? OR tracks.created_at >= ?)", []interface{}{day(2024, 1, 31), day(2024, 2, 1)}},
+		{"added < '2024-01-31'", "(tracks.created_at < ?)", []interface{}{day(2024, 1, 31)}},
+		{"added <= '2024-01-31'", "(tracks.created_at < ?)", []interface{}{day(2024, 2, 1)}},
+		{"added > '2024-12-31'", "(tracks.created_at >= ?)", []interface{}{day(2025, 1, 1)}},
+		{"added >= '2024-02-29'", "(tracks.created_at >= ?)", []interface{}{day(2024, 2, 29)}},
+
+		// IN LAST counts back from now.
+		{"added IN LAST 30 DAYS", "(tracks.created_at >= ?)", []interface{}{testNow.AddDate(0, 0, -30)}},
+		{"added in last 1 day", "(tracks.created_at >= ?)", []interface{}{time.Date(2024, 3, 30, 12, 0, 0, 0, time.UTC)}},
+		{"added IN LAST 2 WEEKS", "(tracks.created_at >= ?)", []interface{}{time.Date(2024, 3, 17, 12, 0, 0, 0, time.UTC)}},
+		{"added IN LAST 12 MONTHS", "(tracks.created_at >= ?)", []interface{}{time.Date(2023, 3, 31, 12, 0, 0, 0, time.UTC)}},
+		{"added IN LAST 1 YEAR", "(tracks.created_at >= ?)", []interface{}{time.Date(2023, 3, 31, 12, 0, 0, 0, time.UTC)}},
+		{"added IN LAST 100 years", "(tracks.created_at >= ?)", []interface{}{time.Date(1924, 3, 31, 12, 0, 0, 0, time.UTC)}},
+		{"added IN LAST 36500 DAYS", "(tracks.created_at >= ?)", []interface{}{testNow.AddDate(0, 0, -36500)}},
+		{"NOT added IN LAST 7 DAYS", "(NOT (tracks.created_at >= ?))", []interface{}{time.Date(2024, 3, 24, 12, 0, 0, 0, time.UTC)}},
+	}
+
+	for _, tt := range tests {
+		rule, err := Parse(tt.rule)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.rule, err)
+			continue
+		}
+		var args []interface{}
+		sql := compile(rule.Where, testNow, &args)
+		if sql != tt.sql {
+			t.Errorf("Parse(%q) compiles to\n%s\nwant\n%s", tt.rule, sql, tt.sql)
+		}
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("Parse(%q) args = %#v, want %#v", tt.rule, args, tt.args)
+		}
+	}
+}
+
+func TestParseOrderAndLimit(t *testing.T) {
+	tests := []struct {
+		rule string
+		want Rule
+	}{
+		{"", Rule{}},
+		{"ORDER BY plays DESC, title LIMIT 50", Rule{Order: []Order{{"plays", true}, {"title", false}}, Limit: 50}},
+		{"order by Random limit 1", Rule{Order: []Order{{"random", false}}, Limit: 1}},
+		{"LIMIT 1000", Rule{Limit: 1000}},
+		{"year = 1 ORDER BY added ASC", Rule{
+			Where: condition{field: fields["year"], op: "=", values: []interface{}{int64(1)}},
+			Order: []Order{{"added", false}},
+		}},
+	}
+	for _, tt := range tests {
+		rule, err := Parse(tt.rule)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.rule, err)
+			continue
+		}
+		if !reflect.DeepEqual(*rule, tt.want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.rule, *rule, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		rule string
+		pos  int
+		msg  string
+	}{
+		{"bpm = 120", 0, `unknown field "bpm"`},
+		{"year = 1 AND mood = 'sad'", 13, `unknown field "mood"`},
+		{"artist < 'a'", 7, "operator < cannot be used with text"},
+		{"artist >= 'a'", 7, "operator >= cannot be used with text"},
+		{"artist 'a'", 7, "expected a comparison operator"},
+		{"artist IS 'a'", 7, "expected a comparison operator"},
+		{"artist ! 'a'", 7, "unexpected '!'"},
+		{"artist = 5", 9, "expected a quoted string"},
+		{"year = '1999'", 7, "expected a whole number"},
+		{"year = 1.5", 7, "expected a whole number"},
+		{"year = 99999999999999999999", 7, "expected a whole number"},
+		{"added = 2024", 8, "expected a date"},
+		{"added = '2024-13-01'", 8, "expected a date"},
+		{"added = '31/01/2024'", 8, "expected a date"},
+		{"added IN ('2024-01-01')", 9, "dates can only be used with IN LAST"},
+		{"year IN LAST 3 DAYS", 8, "IN LAST can only be used with dates"},
+		{"added IN LAST 0 DAYS", 14, "expected a number of days"},
+		{"added IN LAST 36501 DAYS", 14, "IN LAST covers at most 36500 days"},
+		{"added IN LAST 5201 WEEKS", 14, "IN LAST covers at most 5200 weeks"},
+		{"added IN LAST 1201 MONTHS", 14, "IN LAST covers at most 1200 months"},
+		{"added IN LAST 101 YEARS", 14, "IN LAST covers at most 100 years"},
+		{"added IN LAST 99999999999999999999 DAYS", 14, "expected a number of days"},
+		{"added IN LAST DAYS", 14, "expected a number of days"},
+		{"added IN LAST 3 FORTNIGHTS", 16, "expected DAYS, WEEKS, MONTHS or YEARS"},
+		{"added IN LAST 3", 15, "expected DAYS, WEEKS, MONTHS or YEARS"},
+		{"year CONTAINS '1'", 5, "CONTAINS can only be used with text"},
+		{"artist NOT = 'a'", 11, "expected IN or CONTAINS after NOT"},
+		{"genre IN 'rock'", 9, "expected '(' after IN"},
+		{"genre IN", 8, "expected '(' after IN"},
+		{"genre IN ('rock' 'pop')", 17, "expected ',' or ')'"},
+		{"genre IN ()", 10, "expected a quoted string"},
+		{"artist = 'a' AND", 16, "expected a field name"},
+		{"AND artist = 'a'", 0, `unknown field "AND"`},
+		{"(artist = 'a'", 13, "expected ')'"},
+		{"artist = 'a')", 12, `unexpected ")"`},
+		{"artist = 'a' artist = 'b'", 13, `unexpected "artist"`},
+		{"artist = 'unterminated", 9, "unterminated string"},
+		{"artist = 'a'; DROP TABLE tracks", 12, "unexpected character ';'"},
+		{"ORDER plays", 6, "expected BY after ORDER"},
+		{"ORDER BY bpm", 9, `cannot order by "bpm"`},
+		{"ORDER BY 'title'", 9, `cannot order by "title"`},
+		{"ORDER BY plays,", 15, `cannot order by ""`},
+		{"LIMIT 0", 6, "LIMIT must be between 1 and 1000"},
+		{"LIMIT 1001", 6, "LIMIT must be between 1 and 1000"},
+		{"LIMIT ten", 6, "LIMIT must be between 1 and 1000"},
+		{"LIMIT 5 ORDER BY plays", 8, `unexpected "ORDER"`},
+		{strings.Repeat("NOT ", maxDepth) + "year = 1", 4 * maxDepth, "rule is nested too deeply"},
+		{strings.Repeat("(", maxDepth) + "year = 1" + strings.Repeat(")", maxDepth), maxDepth, "rule is nested too deeply"},
+		{strings.Repeat("a", MaxLength+1), MaxLength, "rule is longer than 2000 characters"},
+	}
+
+	for _, tt := range tests {
+		rule, err := Parse(tt.rule)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("Parse(%q) = %+v, %v; want a SyntaxError", tt.rule, rule, err)
+			continue
+		}
+		if syntaxErr.Pos != tt.pos || !strings.HasPrefix(syntaxErr.Msg, tt.msg) {
+			t.Errorf("Parse(%q) = %q at %d, want %q at %d", tt.rule, syntaxErr.Msg, syntaxErr.Pos, tt.msg, tt.pos)
+		}
+	}
+}
+
+func TestSyntaxErrorMessage(t *testing.T) {
+	_, err := Parse("bpm = 1")
+	if got, want := err.Error(), `unknown field "bpm" at position 1`; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
+
+func TestApply(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard, DryRun: true})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	tests := []struct {
+		rule string
+		sql  string
+	}{
+		{"", "SELECT * FROM `tracks` ORDER BY tracks.created_at DESC,tracks.id LIMIT 1000"},
+		{
+			"year = 1 ORDER BY plays DESC, title, random LIMIT 5",
+			"SELECT * FROM `tracks` WHERE (tracks.year = ?) ORDER BY tracks.play_count DESC," + title + ",RANDOM(),tracks.id LIMIT 5",
+		},
+	}
+	for _, tt := range tests {
+		rule, err := Parse(tt.rule)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.rule, err)
+		}
+		var rows []map[string]interface{}
+		stmt := rule.Apply(db.Table("tracks"), testNow).Find(&rows).Statement
+		if got := stmt.SQL.String(); got != tt.sql {
+			t.Errorf("Apply(%q) =\n%s\nwant\n%s", tt.rule, got, tt.sql)
+		}
+	}
+}
@@ -0,0 +1,458 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"mime/multipart"
+	"path"
+	"strconv"
+
+	"maxify/internal/database"
+	"maxify/internal/models"
+	"maxify/internal/storage"
+	"maxify/internal/streaming"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/google/uuid"
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrArtworkNotFound  = errors.New("artwork not found")
+	ErrArtworkTooLarge  = errors.New("image too large")
+	ErrUnsupportedImage = errors.New("file content is not a supported image format")
+	ErrAlbumNotFound    = errors.New("album not found")
+)
+
+const (
+	ArtworkJPEG = "jpeg"
+	ArtworkWebP = "webp"
+)
+
+const (
+	defaultArtworkSize = 300
+	artworkJPEGQuality = 85
+	// maxArtworkSize bounds uploaded album art.
+	maxArtworkSize = 10 << 20
+	// maxArtworkPixels bounds the images we decode, at 4 bytes a pixel.
+	maxArtworkPixels = 4096 * 4096
+)
+
+// artworkSizes are the square boxes variants are resized to fit.
+var artworkSizes = []int{64, 300, 640}
+
+type ArtworkService struct {
+	db      *gorm.DB
+	storage storage.Backend
+}
+
+func NewArtworkService() *ArtworkService {
+	return &ArtworkService{
+		db:      database.GetDB(),
+		storage: storage.GetBackend(),
+	}
+}
+
+// ArtworkRequest selects a variant: the largest side in pixels, 300 by
+// default, and the format, JPEG by default. WebP variants are lossless.
+type ArtworkRequest struct {
+	Size   int    `form:"size" binding:"omitempty,oneof=64 300 640"`
+	Format string `form:"format" binding:"omitempty,oneof=jpeg webp"`
+}
+
+// artworkKey is the content-addressed storage key of an original image,
+// fanned out like blobKey.
+func artworkKey(hash, format string) string {
+	return path.Join("artwork", hash[:2], hash+"."+format)
+}
+
+// artworkVariantKey is where a resized variant of an image is cached.
+func artworkVariantKey(hash string, size int, format string) string {
+	return path.Join("artwork", hash[:2], hash, strconv.Itoa(size)+"."+format)
+}
+
+// prepareArtwork checks that data is an image we can decode and describes
+// it for storage.
+func prepareArtwork(data []byte) (*models.Artwork, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxArtworkPixels {
+		return nil, fmt.Errorf("%w: %dx%d pixels", ErrArtworkTooLarge, config.Width, config.Height)
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	return &models.Artwork{
+		Hash:     hash,
+		Key:      artworkKey(hash, format),
+		Size:     int64(len(data)),
+		MimeType: "image/" + format,
+		Width:    config.Width,
+		Height:   config.Height,
+	}, nil
+}
+
+// acquireArtwork adds a reference to a stored image, creating its row if
+// needed. Like acquireBlob, the row stays locked until tx ends.
+func acquireArtwork(ctx context.Context, tx *gorm.DB, backend storage.Backend, art *models.Artwork) error {
+	art.RefCount = 1
+	if err := tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "hash"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"ref_count":  gorm.Expr("artworks.ref_count + 1"),
+			"updated_at": gorm.Expr("NOW()"),
+		}),
+	}).Create(art).Error; err != nil {
+		return err
+	}
+	return checkAcquired(ctx, tx, backend, &models.Artwork{}, art.Hash, art.Key)
+}
+
+// releaseArtwork drops a reference to an image and reports whether it was
+// the last one. Like releaseBlob, it keeps the row for collectArtwork to
+// delete once tx has committed.
+func releaseArtwork(tx *gorm.DB, hash string) (bool, error) {
+	var art models.Artwork
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&art, "hash = ?", hash).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get artwork: %w", err)
+	}
+
+	if err := tx.Model(&art).UpdateColumn("ref_count", gorm.Expr("ref_count - 1")).Error; err != nil {
+		return false, fmt.Errorf("failed to release artwork: %w", err)
+	}
+	return art.RefCount <= 1, nil
+}
+
+// collectArtwork deletes an image and its variants if nothing refers to
+// it, under the row lock like collectBlob.
+func collectArtwork(ctx context.Context, db *gorm.DB, backend storage.Backend, hash string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var art models.Artwork
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&art, "hash = ?", hash).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return fmt.Errorf("failed to get artwork: %w", err)
+		}
+		if art.RefCount > 0 {
+			return nil
+		}
+
+		keys := []string{art.Key}
+		for _, size := range artworkSizes {
+			for _, format := range []string{ArtworkJPEG, ArtworkWebP} {
+				keys = append(keys, artworkVariantKey(art.Hash, size, format))
+			}
+		}
+		for _, key := range keys {
+			if err := backend.Delete(ctx, key); err != nil {
+				return fmt.Errorf("failed to delete artwork: %w", err)
+			}
+		}
+		if err := tx.Delete(&art).Error; err != nil {
+			return fmt.Errorf("failed to delete artwork: %w", err)
+		}
+		return nil
+	})
+}
+
+// abandonArtwork collects an image stored for an upload that failed, like
+// abandonBlob.
+func abandonArtwork(ctx context.Context, db *gorm.DB, backend storage.Backend, art *models.Artwork) error {
+	row := models.Artwork{Hash: art.Hash, Key: art.Key, Size: art.Size, MimeType: art.MimeType, Width: art.Width, Height: art.Height}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error; err != nil {
+		return fmt.Errorf("failed to create artwork: %w", err)
+	}
+	return collectArtwork(ctx, db, backend, art.Hash)
+}
+
+// GetTrackArtwork returns the track's cover art. Tracks without embedded
+// art fall back to the art uploaded for their album.
+func (s *ArtworkService) GetTrackArtwork(trackID, userID uuid.UUID, req *ArtworkRequest) (*streaming.Content, error) {
+	var track models.Track
+	if err := s.db.Where("id = ? AND user_id = ?", trackID, userID).First(&track).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTrackNotFound
+		}
+		return nil, fmt.Errorf("failed to get track: %w", err)
+	}
+
+	hash := track.ArtworkHash
+	if hash == nil && track.AlbumID != nil {
+		var album models.Album
+		if err := s.db.Select("artwork_hash").First(&album, "id = ?", *track.AlbumID).Error; err != nil &&
+			!errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("failed to get album: %w", err)
+		}
+		hash = album.ArtworkHash
+	}
+	if hash == nil {
+		return nil, ErrArtworkNotFound
+	}
+	return s.variant(*hash, req)
+}
+
+// GetAlbumArtwork returns the art uploaded for an album.
+func (s *ArtworkService) GetAlbumArtwork(albumID, userID uuid.UUID, req *ArtworkRequest) (*streaming.Content, error) {
+	var album models.Album
+	if err := s.db.Where("id = ? AND user_id = ?", albumID, userID).First(&album).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAlbumNotFound
+		}
+		return nil, fmt.Errorf("failed to get album: %w", err)
+	}
+	if album.ArtworkHash == nil {
+		return nil, ErrArtworkNotFound
+	}
+	return s.variant(*album.ArtworkHash, req)
+}
+
+// SetAlbumArtwork replaces an album's cover art with an uploaded image.
+func (s *ArtworkService) SetAlbumArtwork(albumID, userID uuid.UUID, file *multipart.FileHeader) error {
+	if file.Size > maxArtworkSize {
+		return fmt.Errorf("%w: %d bytes (max: %d bytes)", ErrArtworkTooLarge, file.Size, maxArtworkSize)
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer src.Close()
+	data, err := io.ReadAll(io.LimitReader(src, maxArtworkSize+1))
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	if len(data) > maxArtworkSize {
+		return fmt.Errorf("%w: more than %d bytes", ErrArtworkTooLarge, maxArtworkSize)
+	}
+
+	art, err := prepareArtwork(data)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var released *string
+	for attempt := 1; ; attempt++ {
+		if err = storeObject(ctx, s.storage, art.Key, bytes.NewReader(data), art.Size, art.MimeType); err != nil {
+			return fmt.Errorf("failed to save artwork: %w", err)
+		}
+		err = s.db.Transaction(func(tx *gorm.DB) error {
+			var album models.Album
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("id = ? AND user_id = ?", albumID, userID).
+				First(&album).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return ErrAlbumNotFound
+				}
+				return err
+			}
+			if album.ArtworkHash != nil && *album.ArtworkHash == art.Hash {
+				return nil
+			}
+
+			if err := acquireArtwork(ctx, tx, s.storage, art); err != nil {
+				return err
+			}
+			if err := tx.Model(&album).UpdateColumn("artwork_hash", art.Hash).Error; err != nil {
+				return err
+			}
+			if album.ArtworkHash != nil {
+				last, err := releaseArtwork(tx, *album.ArtworkHash)
+				if last {
+					released = album.ArtworkHash
+				}
+				return err
+			}
+			return nil
+		})
+		// Retried when a purge collected the image after it was stored.
+		if !errors.Is(err, errObjectGone) || attempt == maxStoreAttempts {
+			break
+		}
+	}
+	if err != nil {
+		if err := abandonArtwork(ctx, s.db, s.storage, art); err != nil {
+			log.Printf("Failed to collect artwork %s: %v", art.Hash, err)
+		}
+		if errors.Is(err, ErrAlbumNotFound) {
+			return err
+		}
+		return fmt.Errorf("failed to set artwork: %w", err)
+	}
+
+	if released != nil {
+		if err := collectArtwork(ctx, s.db, s.storage, *released); err != nil {
+			log.Printf("Failed to delete replaced artwork %s: %v", *released, err)
+		}
+	}
+	return nil
+}
+
+// DeleteAlbumArtwork removes the art uploaded for an album.
+func (s *ArtworkService) DeleteAlbumArtwork(albumID, userID uuid.UUID) error {
+	var hash string
+	var last bool
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		var album models.Album
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ?", albumID, userID).
+			First(&album).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrAlbumNotFound
+			}
+			return fmt.Errorf("failed to get album: %w", err)
+		}
+		if album.ArtworkHash == nil {
+			return ErrArtworkNotFound
+		}
+
+		if err := tx.Model(&album).UpdateColumn("artwork_hash", nil).Error; err != nil {
+			return fmt.Errorf("failed to delete artwork: %w", err)
+		}
+		hash = *album.ArtworkHash
+		var err error
+		last, err = releaseArtwork(tx, hash)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	if last {
+		if err := collectArtwork(context.Background(), s.db, s.storage, hash); err != nil {
+			log.Printf("Failed to delete artwork %s: %v", hash, err)
+		}
+	}
+	return nil
+}
+
+// variant returns the image resized as requested. Variants are generated
+// on first use and cached in storage next to the original.
+func (s *ArtworkService) variant(hash string, req *ArtworkRequest) (*streaming.Content, error) {
+	size, format := req.Size, req.Format
+	if size == 0 {
+		size = defaultArtworkSize
+	}
+	if format == "" {
+		format = ArtworkJPEG
+	}
+
+	ctx := context.Background()
+	key := artworkVariantKey(hash, size, format)
+	info, err := s.storage.Stat(ctx, key)
+	if errors.Is(err, storage.ErrNotExist) {
+		info, err = s.render(ctx, hash, key, size, format)
+	} else if err != nil {
+		err = fmt.Errorf("failed to stat artwork: %w", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The ETag names the image content, but the image behind a URL changes
+	// when album art is replaced, so clients revalidate after an hour.
+	return &streaming.Content{
+		Size:         info.Size,
+		ModTime:      info.ModTime,
+		ETag:         fmt.Sprintf(`"%s-%d.%s"`, hash, size, format),
+		MimeType:     "image/" + format,
+		CacheControl: "private, max-age=3600",
+		Open: func(offset, length int64) (io.ReadCloser, error) {
+			return s.storage.Get(context.Background(), key, offset, length)
+		},
+	}, nil
+}
+
+// render generates and stores a variant. It holds a share lock on the
+// artwork row so that releaseArtwork cannot delete the image meanwhile and
+// leave the new variant behind.
+func (s *ArtworkService) render(ctx context.Context, hash, key string, size int, format string) (*storage.ObjectInfo, error) {
+	var info *storage.ObjectInfo
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var art models.Artwork
+		if err := tx.Clauses(clause.Locking{Strength: "SHARE"}).First(&art, "hash = ?", hash).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrArtworkNotFound
+			}
+			return fmt.Errorf("failed to get artwork: %w", err)
+		}
+
+		r, err := s.storage.Get(ctx, art.Key, 0, -1)
+		if err != nil {
+			return fmt.Errorf("failed to read artwork: %w", err)
+		}
+		defer r.Close()
+		img, _, err := image.Decode(r)
+		if err != nil {
+			return fmt.Errorf("failed to decode artwork: %w", err)
+		}
+
+		var buf bytes.Buffer
+		if err := encodeArtwork(&buf, resizeArtwork(img, size), format); err != nil {
+			return fmt.Errorf("failed to encode artwork: %w", err)
+		}
+		if err := s.storage.Put(ctx, key, &buf, int64(buf.Len()), "image/"+format); err != nil {
+			return fmt.Errorf("failed to save artwork: %w", err)
+		}
+		if info, err = s.storage.Stat(ctx, key); err != nil {
+			return fmt.Errorf("failed to stat artwork: %w", err)
+		}
+		return nil
+	})
+	return info, err
+}
+
+// resizeArtwork scales img to fit a size pixel square, keeping its aspect
+// ratio. Smaller images keep their size.
+func resizeArtwork(img image.Image, size int) *image.RGBA {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, height*size/width
+		} else {
+			width, height = width*size/height, size
+		}
+		if width < 1 {
+			width = 1
+		}
+		if height < 1 {
+			height = 1
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+func encodeArtwork(w io.Writer, img *image.RGBA, format string) error {
+	if format == ArtworkWebP {
+		return nativewebp.Encode(w, img, nil)
+	}
+
+	// JPEG has no transparency, so flatten the image onto white.
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+	return jpeg.Encode(w, flat, &jpeg.Options{Quality: artworkJPEGQuality})
+}
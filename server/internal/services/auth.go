@@ -2,6 +2,10 @@ package services
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"time"
@@ -15,6 +19,13 @@ import (
 	"github.com/redis/go-redis/v9"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected, session revoked")
+	ErrRevocationCheck     = errors.New("cannot check whether the token was revoked")
 )
 
 type AuthService struct {
@@ -32,25 +43,48 @@ func NewAuthService(cfg *config.Config) *AuthService {
 }
 
 type RegisterRequest struct {
-	Username string `json:"username" binding:"required,min=3,max=50"`
-	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=6"`
+	Username  string `json:"username" binding:"required,min=3,max=50"`
+	Email     string `json:"email" binding:"required,email"`
+	Password  string `json:"password" binding:"required,min=6"`
+	UserAgent string `json:"-"`
+	IPAddress string `json:"-"`
 }
 
 type LoginRequest struct {
-	Username string `json:"username" binding:"required"`
-	Password string `json:"password" binding:"required"`
+	Username  string `json:"username" binding:"required"`
+	Password  string `json:"password" binding:"required"`
+	UserAgent string `json:"-"`
+	IPAddress string `json:"-"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+	UserAgent    string `json:"-"`
+	IPAddress    string `json:"-"`
 }
 
 type AuthResponse struct {
-	Token     string       `json:"token"`
-	User      *models.User `json:"user"`
-	ExpiresAt time.Time    `json:"expires_at"`
+	Token            string       `json:"token"`
+	RefreshToken     string       `json:"refresh_token"`
+	User             *models.User `json:"user"`
+	ExpiresAt        time.Time    `json:"expires_at"`
+	RefreshExpiresAt time.Time    `json:"refresh_expires_at"`
+}
+
+type SessionResponse struct {
+	ID           uuid.UUID `json:"id"`
+	UserAgent    string    `json:"user_agent"`
+	IPAddress    string    `json:"ip_address"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastActiveAt time.Time `json:"last_active_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	Current      bool      `json:"current"`
 }
 
 type Claims struct {
-	UserID   uuid.UUID `json:"user_id"`
-	Username string    `json:"username"`
+	UserID    uuid.UUID `json:"user_id"`
+	Username  string    `json:"username"`
+	SessionID uuid.UUID `json:"sid"`
 	jwt.RegisteredClaims
 }
 
@@ -75,16 +109,7 @@ func (s *AuthService) Register(req *RegisterRequest) (*AuthResponse, error) {
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
 
-	token, expiresAt, err := s.generateJWT(user)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate token: %w", err)
-	}
-
-	return &AuthResponse{
-		Token:     token,
-		User:      user,
-		ExpiresAt: expiresAt,
-	}, nil
+	return s.startSession(user, req.UserAgent, req.IPAddress)
 }
 
 func (s *AuthService) Login(req *LoginRequest) (*AuthResponse, error) {
@@ -100,24 +125,78 @@ func (s *AuthService) Login(req *LoginRequest) (*AuthResponse, error) {
 		return nil, errors.New("invalid credentials")
 	}
 
-	token, expiresAt, err := s.generateJWT(&user)
+	// Old refresh tokens are useless once expired, so prune them here.
+	s.db.Where("user_id = ? AND expires_at < ?", user.ID, time.Now()).Delete(&models.AuthToken{})
+
+	return s.startSession(&user, req.UserAgent, req.IPAddress)
+}
+
+// Refresh exchanges a refresh token for a new access and refresh token pair.
+// Each refresh token can be used once; presenting one that was already
+// rotated means it leaked, so the whole session is revoked.
+func (s *AuthService) Refresh(req *RefreshRequest) (*AuthResponse, error) {
+	var response *AuthResponse
+	var reused *models.AuthToken
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var current models.AuthToken
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token = ?", hashRefreshToken(req.RefreshToken)).
+			First(&current).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidRefreshToken
+			}
+			return fmt.Errorf("failed to get refresh token: %w", err)
+		}
+
+		if current.RevokedAt != nil || time.Now().After(current.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+		if current.RotatedAt != nil {
+			reused = &current
+			return ErrRefreshTokenReused
+		}
+
+		var user models.User
+		if err := tx.First(&user, current.UserID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidRefreshToken
+			}
+			return fmt.Errorf("failed to get user: %w", err)
+		}
+
+		now := time.Now()
+		if err := tx.Model(&current).Update("rotated_at", now).Error; err != nil {
+			return fmt.Errorf("failed to rotate refresh token: %w", err)
+		}
+
+		var err error
+		response, err = s.issueTokens(tx, &user, current.FamilyID, current.LoginAt, req.UserAgent, req.IPAddress)
+		return err
+	})
+
+	if errors.Is(err, ErrRefreshTokenReused) && reused != nil {
+		if revokeErr := s.revokeFamily(reused.FamilyID); revokeErr != nil {
+			return nil, revokeErr
+		}
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate token: %w", err)
+		return nil, err
 	}
-
-	return &AuthResponse{
-		Token:     token,
-		User:      &user,
-		ExpiresAt: expiresAt,
-	}, nil
+	return response, nil
 }
 
 func (s *AuthService) ValidateToken(tokenString string) (*Claims, error) {
 	ctx := context.Background()
+	// Without Redis we cannot tell whether the token was revoked, so it is
+	// rejected rather than accepted.
 	_, err := s.redis.Get(ctx, fmt.Sprintf("blacklist:%s", tokenString)).Result()
 	if err == nil {
 		return nil, errors.New("token is blacklisted")
 	}
+	if !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("%w: %v", ErrRevocationCheck, err)
+	}
 
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -130,11 +209,22 @@ func (s *AuthService) ValidateToken(tokenString string) (*Claims, error) {
 		return nil, fmt.Errorf("failed to parse token: %w", err)
 	}
 
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return claims, nil
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	if claims.SessionID != uuid.Nil {
+		revoked, err := s.redis.Exists(ctx, revokedSessionKey(claims.SessionID)).Result()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrRevocationCheck, err)
+		}
+		if revoked > 0 {
+			return nil, errors.New("session has been revoked")
+		}
 	}
 
-	return nil, errors.New("invalid token")
+	return claims, nil
 }
 
 func (s *AuthService) Logout(tokenString string) error {
@@ -160,14 +250,130 @@ func (s *AuthService) Logout(tokenString string) error {
 		}
 	}
 
+	if claims.SessionID != uuid.Nil {
+		return s.revokeFamily(claims.SessionID)
+	}
+
 	return nil
 }
 
-func (s *AuthService) generateJWT(user *models.User) (string, time.Time, error) {
+// GetSessions lists the user's active logins, one per token family.
+func (s *AuthService) GetSessions(userID, currentSessionID uuid.UUID) ([]*SessionResponse, error) {
+	var tokens []models.AuthToken
+	if err := s.db.Where("user_id = ? AND rotated_at IS NULL AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("created_at DESC").
+		Find(&tokens).Error; err != nil {
+		return nil, fmt.Errorf("failed to get sessions: %w", err)
+	}
+
+	sessions := make([]*SessionResponse, 0, len(tokens))
+	for _, token := range tokens {
+		sessions = append(sessions, &SessionResponse{
+			ID:           token.FamilyID,
+			UserAgent:    token.UserAgent,
+			IPAddress:    token.IPAddress,
+			CreatedAt:    token.LoginAt,
+			LastActiveAt: token.CreatedAt,
+			ExpiresAt:    token.ExpiresAt,
+			Current:      token.FamilyID == currentSessionID,
+		})
+	}
+
+	return sessions, nil
+}
+
+func (s *AuthService) RevokeSession(sessionID, userID uuid.UUID) error {
+	var count int64
+	if err := s.db.Model(&models.AuthToken{}).
+		Where("family_id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to get session: %w", err)
+	}
+	if count == 0 {
+		return errors.New("session not found")
+	}
+
+	return s.revokeFamily(sessionID)
+}
+
+func (s *AuthService) startSession(user *models.User, userAgent, ipAddress string) (*AuthResponse, error) {
+	return s.issueTokens(s.db, user, uuid.New(), time.Now(), userAgent, ipAddress)
+}
+
+// issueTokens stores a new refresh token in the given family and signs a
+// matching access token.
+func (s *AuthService) issueTokens(tx *gorm.DB, user *models.User, familyID uuid.UUID, loginAt time.Time, userAgent, ipAddress string) (*AuthResponse, error) {
+	refreshToken, err := generateRefreshToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	record := &models.AuthToken{
+		Token:     hashRefreshToken(refreshToken),
+		FamilyID:  familyID,
+		UserID:    user.ID,
+		UserAgent: userAgent,
+		IPAddress: ipAddress,
+		LoginAt:   loginAt,
+		ExpiresAt: time.Now().Add(s.config.JWT.RefreshExpiry),
+	}
+	if err := tx.Create(record).Error; err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	token, expiresAt, err := s.generateJWT(user, familyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	return &AuthResponse{
+		Token:            token,
+		RefreshToken:     refreshToken,
+		User:             user,
+		ExpiresAt:        expiresAt,
+		RefreshExpiresAt: record.ExpiresAt,
+	}, nil
+}
+
+// revokeFamily ends a session: its refresh tokens stop working immediately
+// and its access tokens are rejected until they would have expired anyway.
+func (s *AuthService) revokeFamily(familyID uuid.UUID) error {
+	if err := s.db.Model(&models.AuthToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error; err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	ctx := context.Background()
+	if err := s.redis.Set(ctx, revokedSessionKey(familyID), "true", s.config.JWT.Expiry).Err(); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return nil
+}
+
+func revokedSessionKey(sessionID uuid.UUID) string {
+	return fmt.Sprintf("session:revoked:%s", sessionID)
+}
+
+func generateRefreshToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *AuthService) generateJWT(user *models.User, sessionID uuid.UUID) (string, time.Time, error) {
 	expiresAt := time.Now().Add(s.config.JWT.Expiry)
 	claims := &Claims{
-		UserID:   user.ID,
-		Username: user.Username,
+		UserID:    user.ID,
+		Username:  user.Username,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
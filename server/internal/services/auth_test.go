@@ -0,0 +1,128 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"maxify/internal/config"
+	"maxify/internal/models"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestAuthService(t *testing.T) (*AuthService, *miniredis.Miniredis) {
+	t.Helper()
+	db := newTestDB(t, &models.User{}, &models.AuthToken{})
+	client, server := newTestRedis(t)
+	cfg := &config.Config{JWT: config.JWTConfig{Secret: "secret", Expiry: time.Hour, RefreshExpiry: 24 * time.Hour}}
+	return &AuthService{config: cfg, db: db, redis: client}, server
+}
+
+func register(t *testing.T, auth *AuthService, username string) *AuthResponse {
+	t.Helper()
+	session, err := auth.Register(&RegisterRequest{Username: username, Email: username + "@example.com", Password: "password"})
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	return session
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	auth, _ := newTestAuthService(t)
+	session := register(t, auth, "alice")
+
+	refreshed, err := auth.Refresh(&RefreshRequest{RefreshToken: session.RefreshToken})
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if refreshed.RefreshToken == session.RefreshToken {
+		t.Error("Refresh returned the same refresh token")
+	}
+	before, err := auth.ValidateToken(session.Token)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	after, err := auth.ValidateToken(refreshed.Token)
+	if err != nil {
+		t.Fatalf("ValidateToken of the refreshed token: %v", err)
+	}
+	if after.SessionID != before.SessionID {
+		t.Errorf("session changed on refresh: %s, want %s", after.SessionID, before.SessionID)
+	}
+
+	if _, err := auth.Refresh(&RefreshRequest{RefreshToken: "unknown"}); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Refresh of an unknown token = %v, want ErrInvalidRefreshToken", err)
+	}
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	auth, _ := newTestAuthService(t)
+	session := register(t, auth, "alice")
+	other, err := auth.Login(&LoginRequest{Username: "alice", Password: "password"})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	refreshed, err := auth.Refresh(&RefreshRequest{RefreshToken: session.RefreshToken})
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	// The rotated token was stolen and is presented again.
+	if _, err := auth.Refresh(&RefreshRequest{RefreshToken: session.RefreshToken}); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("Refresh of a rotated token = %v, want ErrRefreshTokenReused", err)
+	}
+
+	if _, err := auth.Refresh(&RefreshRequest{RefreshToken: refreshed.RefreshToken}); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Refresh with the latest token of the family = %v, want ErrInvalidRefreshToken", err)
+	}
+	for _, token := range []string{session.Token, refreshed.Token} {
+		if _, err := auth.ValidateToken(token); err == nil {
+			t.Error("access token of the revoked family still accepted")
+		}
+	}
+
+	var active int64
+	auth.db.Model(&models.AuthToken{}).Where("revoked_at IS NULL").Count(&active)
+	if active != 1 {
+		t.Errorf("%d refresh tokens not revoked, want only the other login's", active)
+	}
+	if _, err := auth.ValidateToken(other.Token); err != nil {
+		t.Errorf("ValidateToken of another session: %v", err)
+	}
+	if _, err := auth.Refresh(&RefreshRequest{RefreshToken: other.RefreshToken}); err != nil {
+		t.Errorf("Refresh of another session: %v", err)
+	}
+}
+
+func TestRevokedSessionRejectsAccessToken(t *testing.T) {
+	auth, _ := newTestAuthService(t)
+	session := register(t, auth, "alice")
+	claims, err := auth.ValidateToken(session.Token)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+
+	if err := auth.RevokeSession(claims.SessionID, claims.UserID); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	if _, err := auth.ValidateToken(session.Token); err == nil {
+		t.Error("access token of a revoked session accepted")
+	}
+	if _, err := auth.Refresh(&RefreshRequest{RefreshToken: session.RefreshToken}); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Refresh of a revoked session = %v, want ErrInvalidRefreshToken", err)
+	}
+	if sessions, err := auth.GetSessions(claims.UserID, claims.SessionID); err != nil || len(sessions) != 0 {
+		t.Errorf("GetSessions = %v, %v; want none", sessions, err)
+	}
+}
+
+func TestValidateTokenWithoutRedis(t *testing.T) {
+	auth, server := newTestAuthService(t)
+	session := register(t, auth, "alice")
+
+	server.Close()
+	if _, err := auth.ValidateToken(session.Token); !errors.Is(err, ErrRevocationCheck) {
+		t.Errorf("ValidateToken without Redis = %v, want ErrRevocationCheck", err)
+	}
+}
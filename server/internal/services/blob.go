@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+
+	"maxify/internal/metadata"
+	"maxify/internal/models"
+	"maxify/internal/storage"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// errObjectGone is returned when a row is acquired whose object was
+// deleted after the caller stored it, by a collection of the last
+// reference. The caller stores the object again and retries.
+var errObjectGone = errors.New("object was deleted after it was stored")
+
+// maxStoreAttempts bounds those retries.
+const maxStoreAttempts = 3
+
+// blobKey is the content-addressed storage key of audio with the given
+// hash, fanned out by the first byte to keep directories small.
+func blobKey(hash string, format metadata.Format) string {
+	return path.Join("blobs", hash[:2], hash+"."+string(format))
+}
+
+// incomingKey is a temporary key for an upload whose hash is not known
+// yet. fsck sweeps those left behind along with other blobs.
+func incomingKey() string {
+	return path.Join("blobs", "incoming", uuid.New().String())
+}
+
+// putHashed uploads src to a temporary key, hashing it on the way, and
+// returns the key and the hex SHA-256 of the content.
+func putHashed(ctx context.Context, backend storage.Backend, src io.ReadSeeker, size int64, mimeType string) (string, string, error) {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return "", "", err
+	}
+	key := incomingKey()
+	h := sha256.New()
+	if err := backend.Put(ctx, key, io.TeeReader(src, h), size, mimeType); err != nil {
+		backend.Delete(ctx, key)
+		return "", "", err
+	}
+	return key, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// moveObject moves a temporary object to key, or deletes it if key
+// already holds the same content.
+func moveObject(ctx context.Context, backend storage.Backend, tmp, key string) error {
+	if _, err := backend.Stat(ctx, key); err == nil {
+		return backend.Delete(ctx, tmp)
+	} else if !errors.Is(err, storage.ErrNotExist) {
+		return err
+	}
+	return backend.Move(ctx, tmp, key)
+}
+
+// storeObject uploads src to key unless the object already exists.
+func storeObject(ctx context.Context, backend storage.Backend, key string, src io.ReadSeeker, size int64, mimeType string) error {
+	if _, err := backend.Stat(ctx, key); err == nil {
+		return nil
+	} else if !errors.Is(err, storage.ErrNotExist) {
+		return err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return backend.Put(ctx, key, src, size, mimeType)
+}
+
+// acquireBlob adds a reference to a blob, creating its row if needed. The
+// object must have been stored already. The row stays locked until tx
+// ends, which serializes it with collectBlob.
+func acquireBlob(ctx context.Context, tx *gorm.DB, backend storage.Backend, blob *models.Blob) error {
+	blob.RefCount = 1
+	if err := tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "hash"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"ref_count":  gorm.Expr("blobs.ref_count + 1"),
+			"updated_at": gorm.Expr("NOW()"),
+		}),
+	}).Create(blob).Error; err != nil {
+		return err
+	}
+	return checkAcquired(ctx, tx, backend, &models.Blob{}, blob.Hash, blob.Key)
+}
+
+// checkAcquired re-checks a row just acquired. If it holds the only
+// reference, the last one may have been released and collected after the
+// object was stored, so the object must still exist.
+func checkAcquired(ctx context.Context, tx *gorm.DB, backend storage.Backend, model interface{}, hash, key string) error {
+	var refs int64
+	if err := tx.Model(model).Select("ref_count").Where("hash = ?", hash).Scan(&refs).Error; err != nil {
+		return err
+	}
+	if refs > 1 {
+		return nil
+	}
+	if _, err := backend.Stat(ctx, key); errors.Is(err, storage.ErrNotExist) {
+		return errObjectGone
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// releaseBlob drops a reference to a blob and reports whether it was the
+// last one. The row is kept, and the caller runs collectBlob once tx has
+// committed: deleting the object here would lose it if tx rolled back.
+func releaseBlob(tx *gorm.DB, hash string) (bool, error) {
+	var blob models.Blob
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&blob, "hash = ?", hash).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get blob: %w", err)
+	}
+
+	if err := tx.Model(&blob).UpdateColumn("ref_count", gorm.Expr("ref_count - 1")).Error; err != nil {
+		return false, fmt.Errorf("failed to release blob: %w", err)
+	}
+	return blob.RefCount <= 1, nil
+}
+
+// collectBlob deletes a blob and its object if nothing refers to it. It
+// holds the row lock throughout, so an upload that acquired the blob in
+// the meantime keeps it.
+func collectBlob(ctx context.Context, db *gorm.DB, backend storage.Backend, hash string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var blob models.Blob
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&blob, "hash = ?", hash).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return fmt.Errorf("failed to get blob: %w", err)
+		}
+		if blob.RefCount > 0 {
+			return nil
+		}
+
+		if err := backend.Delete(ctx, blob.Key); err != nil {
+			return fmt.Errorf("failed to delete file: %w", err)
+		}
+		if err := tx.Delete(&blob).Error; err != nil {
+			return fmt.Errorf("failed to delete blob: %w", err)
+		}
+		return nil
+	})
+}
+
+// abandonBlob collects a blob stored for an upload that failed. A row
+// without references is created first if there is none, so that the
+// object is deleted unless another upload has acquired it meanwhile; one
+// about to acquire it finds it gone and stores it again.
+func abandonBlob(ctx context.Context, db *gorm.DB, backend storage.Backend, blob *models.Blob) error {
+	row := models.Blob{Hash: blob.Hash, Key: blob.Key, Size: blob.Size, MimeType: blob.MimeType}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error; err != nil {
+		return fmt.Errorf("failed to create blob: %w", err)
+	}
+	return collectBlob(ctx, db, backend, blob.Hash)
+}
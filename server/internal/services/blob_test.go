@@ -0,0 +1,58 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"maxify/internal/models"
+	"maxify/internal/storage"
+)
+
+func TestAbandonBlob(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.Blob{})
+	backend, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open storage: %v", err)
+	}
+	store := func(blob *models.Blob) {
+		t.Helper()
+		if err := backend.Put(ctx, blob.Key, strings.NewReader("audio"), 5, blob.MimeType); err != nil {
+			t.Fatalf("failed to store %s: %v", blob.Key, err)
+		}
+	}
+
+	// An upload whose transaction rolled back left no row.
+	orphan := &models.Blob{Hash: strings.Repeat("a", 64), Key: "blobs/aa/orphan.mp3", Size: 5, MimeType: "audio/mpeg"}
+	store(orphan)
+	if err := abandonBlob(ctx, db, backend, orphan); err != nil {
+		t.Fatalf("abandonBlob: %v", err)
+	}
+	if _, err := backend.Stat(ctx, orphan.Key); !errors.Is(err, storage.ErrNotExist) {
+		t.Errorf("orphaned object: Stat = %v, want ErrNotExist", err)
+	}
+	var rows int64
+	db.Model(&models.Blob{}).Where("hash = ?", orphan.Hash).Count(&rows)
+	if rows != 0 {
+		t.Errorf("orphaned blob left %d rows", rows)
+	}
+
+	// A duplicate upload stored nothing new: the blob belongs to a track.
+	shared := &models.Blob{Hash: strings.Repeat("b", 64), Key: "blobs/bb/shared.mp3", Size: 5, MimeType: "audio/mpeg", RefCount: 1}
+	store(shared)
+	if err := db.Create(shared).Error; err != nil {
+		t.Fatalf("failed to create blob: %v", err)
+	}
+	if err := abandonBlob(ctx, db, backend, shared); err != nil {
+		t.Fatalf("abandonBlob: %v", err)
+	}
+	if _, err := backend.Stat(ctx, shared.Key); err != nil {
+		t.Errorf("referenced object: Stat = %v", err)
+	}
+	var kept models.Blob
+	if err := db.First(&kept, "hash = ?", shared.Hash).Error; err != nil || kept.RefCount != 1 {
+		t.Errorf("referenced blob = %+v, %v; want it kept with one reference", kept, err)
+	}
+}
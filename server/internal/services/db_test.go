@@ -0,0 +1,85 @@
+package services
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"maxify/internal/models"
+
+	"github.com/alicebob/miniredis/v2"
+	gosqlite "github.com/glebarez/go-sqlite"
+	"github.com/glebarez/sqlite"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// NOW() is what the Postgres queries use for the current time.
+func init() {
+	gosqlite.MustRegisterScalarFunction("now", 0, func(*gosqlite.FunctionContext, []driver.Value) (driver.Value, error) {
+		return time.Now().UTC(), nil
+	})
+}
+
+// newTestDB opens an in-memory SQLite database with the tables of the
+// given models. Postgres generates missing IDs, which SQLite cannot, so
+// those defaults are dropped and the models' BeforeCreate hooks set IDs.
+func newTestDB(t *testing.T, tables ...interface{}) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	// Every connection to :memory: is a separate database.
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	for _, table := range tables {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(table); err != nil {
+			t.Fatalf("failed to parse %T: %v", table, err)
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DefaultValue == "gen_random_uuid()" {
+				field.DefaultValue = ""
+				field.HasDefaultValue = false
+				field.DefaultValueInterface = nil
+			}
+		}
+	}
+	if err := db.AutoMigrate(tables...); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return db
+}
+
+// newTestRedis starts an in-memory Redis server.
+func newTestRedis(t *testing.T) (*redis.Client, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return client, server
+}
+
+// newTestTrack creates a track owned by a new user.
+func newTestTrack(t *testing.T, db *gorm.DB, track *models.Track) *models.Track {
+	t.Helper()
+	user := &models.User{Username: "user-" + t.Name(), Email: t.Name() + "@example.com", PasswordHash: "x"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	track.UserID = user.ID
+	if track.Title == "" {
+		track.Title = "Track"
+	}
+	if err := db.Create(track).Error; err != nil {
+		t.Fatalf("failed to create track: %v", err)
+	}
+	return track
+}
@@ -0,0 +1,439 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"maxify/internal/database"
+	"maxify/internal/models"
+	"maxify/internal/storage"
+	"maxify/internal/transcode"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	FsckOrphan           = "orphan"
+	FsckMissing          = "missing"
+	FsckSizeMismatch     = "size_mismatch"
+	FsckHashMismatch     = "hash_mismatch"
+	FsckRefCountMismatch = "ref_count_mismatch"
+)
+
+// quarantinePrefix is where repair moves orphaned objects. They are kept
+// for inspection rather than deleted.
+const quarantinePrefix = "quarantine/"
+
+// orphanGracePeriod skips recent objects: uploads store the file before the
+// track row is committed.
+const orphanGracePeriod = time.Hour
+
+// audioPrefixes are the storage prefixes holding track audio: blobs, and
+// tracks uploaded before content addressing.
+var audioPrefixes = []string{"blobs/", "tracks/"}
+
+// artworkPrefix holds cover art and its cached variants.
+const artworkPrefix = "artwork/"
+
+// renditionPrefixes hold transcoded renditions: progressive files and
+// their HLS playlists and segments.
+var renditionPrefixes = []string{"renditions/", "hls/"}
+
+type FsckService struct {
+	db      *gorm.DB
+	storage storage.Backend
+}
+
+func NewFsckService() *FsckService {
+	return &FsckService{
+		db:      database.GetDB(),
+		storage: storage.GetBackend(),
+	}
+}
+
+// FsckRequest selects what Check does. Hashes re-reads every blob to
+// verify its content, which is slow on large libraries.
+type FsckRequest struct {
+	Repair bool `json:"repair"`
+	Hashes bool `json:"hashes"`
+}
+
+type FsckIssue struct {
+	Kind     string      `json:"kind"`
+	Key      string      `json:"key"`
+	TrackIDs []uuid.UUID `json:"track_ids,omitempty"`
+	Expected string      `json:"expected,omitempty"`
+	Actual   string      `json:"actual,omitempty"`
+}
+
+type FsckReport struct {
+	Tracks      int         `json:"tracks"`
+	Blobs       int         `json:"blobs"`
+	Objects     int         `json:"objects"`
+	Issues      []FsckIssue `json:"issues"`
+	Repaired    bool        `json:"repaired"`
+	Quarantined int         `json:"quarantined"`
+	Flagged     int         `json:"flagged"`
+	Unflagged   int         `json:"unflagged"`
+	StartedAt   time.Time   `json:"started_at"`
+	Duration    float64     `json:"duration"` // seconds
+}
+
+// fsckTrack is the part of a track the checker needs.
+type fsckTrack struct {
+	ID          uuid.UUID
+	FilePath    string
+	FileSize    int64
+	ContentHash *string
+	Broken      bool
+}
+
+// Check compares the database with storage. It reports objects no track or
+// blob refers to, tracks and blobs whose object is missing or has the
+// wrong size or content, and blobs with a wrong reference count. With
+// Repair, orphans are moved to quarantine, reference counts are corrected,
+// artwork nothing refers to is deleted and tracks are flagged as broken,
+// or unflagged once their file is intact again.
+func (s *FsckService) Check(req *FsckRequest) (*FsckReport, error) {
+	ctx := context.Background()
+	report := &FsckReport{Issues: []FsckIssue{}, Repaired: req.Repair, StartedAt: time.Now()}
+
+	// Tracks in the trash keep their files until they are purged.
+	var tracks []fsckTrack
+	if err := s.db.Unscoped().Model(&models.Track{}).
+		Select("id, file_path, file_size, content_hash, broken").
+		Find(&tracks).Error; err != nil {
+		return nil, fmt.Errorf("failed to get tracks: %w", err)
+	}
+	var blobs []models.Blob
+	if err := s.db.Find(&blobs).Error; err != nil {
+		return nil, fmt.Errorf("failed to get blobs: %w", err)
+	}
+	report.Tracks, report.Blobs = len(tracks), len(blobs)
+
+	byHash := make(map[string][]uuid.UUID)
+	for _, track := range tracks {
+		if track.ContentHash != nil {
+			byHash[*track.ContentHash] = append(byHash[*track.ContentHash], track.ID)
+		}
+	}
+
+	broken := make(map[uuid.UUID]bool)
+	addIssue := func(issue FsckIssue) {
+		report.Issues = append(report.Issues, issue)
+		if issue.Kind != FsckRefCountMismatch {
+			for _, id := range issue.TrackIDs {
+				broken[id] = true
+			}
+		}
+	}
+
+	for i := range blobs {
+		blob := &blobs[i]
+		// The last reference was released but the blob was not collected,
+		// for example because storage was unavailable.
+		if blob.RefCount == 0 && len(byHash[blob.Hash]) == 0 {
+			addIssue(FsckIssue{Kind: FsckOrphan, Key: blob.Key, Actual: strconv.FormatInt(blob.Size, 10)})
+			if req.Repair {
+				if err := s.repairRefCount(ctx, blob.Hash); err != nil {
+					return nil, err
+				}
+				report.Quarantined++
+			}
+			continue
+		}
+
+		issue, err := s.checkObject(ctx, blob.Key, blob.Size, blob.Hash, req.Hashes)
+		if err != nil {
+			return nil, err
+		}
+		if issue != nil {
+			issue.TrackIDs = byHash[blob.Hash]
+			addIssue(*issue)
+		}
+
+		if refs := int64(len(byHash[blob.Hash])); refs != blob.RefCount {
+			addIssue(FsckIssue{
+				Kind:     FsckRefCountMismatch,
+				Key:      blob.Key,
+				TrackIDs: byHash[blob.Hash],
+				Expected: strconv.FormatInt(refs, 10),
+				Actual:   strconv.FormatInt(blob.RefCount, 10),
+			})
+			if req.Repair {
+				if err := s.repairRefCount(ctx, blob.Hash); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	referenced := make(map[string]bool, len(blobs))
+	for _, blob := range blobs {
+		referenced[blob.Key] = true
+	}
+	for _, track := range tracks {
+		if track.ContentHash != nil {
+			continue
+		}
+		referenced[track.FilePath] = true
+		issue, err := s.checkObject(ctx, track.FilePath, track.FileSize, "", false)
+		if err != nil {
+			return nil, err
+		}
+		if issue != nil {
+			issue.TrackIDs = []uuid.UUID{track.ID}
+			addIssue(*issue)
+		}
+	}
+
+	var orphans []*storage.ObjectInfo
+	for _, prefix := range audioPrefixes {
+		if err := s.storage.List(ctx, prefix, func(object *storage.ObjectInfo) error {
+			report.Objects++
+			if !referenced[object.Key] && time.Since(object.ModTime) > orphanGracePeriod {
+				orphans = append(orphans, object)
+			}
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("failed to list storage: %w", err)
+		}
+	}
+
+	artOrphans, err := s.checkArtwork(ctx, report, req.Repair)
+	if err != nil {
+		return nil, err
+	}
+	orphans = append(orphans, artOrphans...)
+	renditionOrphans, err := s.checkRenditions(ctx, report)
+	if err != nil {
+		return nil, err
+	}
+	orphans = append(orphans, renditionOrphans...)
+	for _, object := range orphans {
+		addIssue(FsckIssue{Kind: FsckOrphan, Key: object.Key, Actual: strconv.FormatInt(object.Size, 10)})
+		if req.Repair {
+			if err := s.quarantine(ctx, object); err != nil {
+				return nil, err
+			}
+			report.Quarantined++
+		}
+	}
+
+	if req.Repair {
+		for _, track := range tracks {
+			if broken[track.ID] == track.Broken {
+				continue
+			}
+			if err := s.db.Unscoped().Model(&models.Track{}).Where("id = ?", track.ID).
+				UpdateColumn("broken", broken[track.ID]).Error; err != nil {
+				return nil, fmt.Errorf("failed to flag track: %w", err)
+			}
+			if broken[track.ID] {
+				report.Flagged++
+			} else {
+				report.Unflagged++
+			}
+		}
+	}
+
+	report.Duration = time.Since(report.StartedAt).Seconds()
+	return report, nil
+}
+
+// checkArtwork collects artwork whose last reference was released but that
+// was not deleted, and returns the objects under artworkPrefix that
+// belong to no artwork: originals without a row, and variants of them.
+func (s *FsckService) checkArtwork(ctx context.Context, report *FsckReport, repair bool) ([]*storage.ObjectInfo, error) {
+	var artworks []models.Artwork
+	if err := s.db.Find(&artworks).Error; err != nil {
+		return nil, fmt.Errorf("failed to get artwork: %w", err)
+	}
+
+	// Unreferenced rows stay known until they are collected, as an upload
+	// of the same image may be reusing them.
+	known := make(map[string]bool, len(artworks))
+	for _, art := range artworks {
+		known[art.Hash] = true
+		if art.RefCount > 0 {
+			continue
+		}
+		report.Issues = append(report.Issues, FsckIssue{Kind: FsckOrphan, Key: art.Key, Actual: strconv.FormatInt(art.Size, 10)})
+		if repair {
+			if err := collectArtwork(ctx, s.db, s.storage, art.Hash); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var orphans []*storage.ObjectInfo
+	if err := s.storage.List(ctx, artworkPrefix, func(object *storage.ObjectInfo) error {
+		report.Objects++
+		// Originals are artwork/<h2>/<hash>.<format>, and their variants
+		// artwork/<h2>/<hash>/<size>.<format>.
+		hash := ""
+		if parts := strings.Split(object.Key, "/"); len(parts) > 2 {
+			hash = strings.TrimSuffix(parts[2], path.Ext(parts[2]))
+		}
+		if !known[hash] && time.Since(object.ModTime) > orphanGracePeriod {
+			orphans = append(orphans, object)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to list storage: %w", err)
+	}
+	return orphans, nil
+}
+
+// checkRenditions returns the objects under renditionPrefixes that belong
+// to no rendition. Renditions still being transcoded own everything under
+// their track and profile, as they store files before recording them.
+func (s *FsckService) checkRenditions(ctx context.Context, report *FsckReport) ([]*storage.ObjectInfo, error) {
+	var renditions []models.Rendition
+	if err := s.db.Select("track_id, profile, status, file_path, segments").Find(&renditions).Error; err != nil {
+		return nil, fmt.Errorf("failed to get renditions: %w", err)
+	}
+
+	referenced := make(map[string]bool)
+	var inProgress []string
+	for _, rendition := range renditions {
+		if rendition.Status == models.RenditionPending || rendition.Status == models.RenditionProcessing {
+			inProgress = append(inProgress,
+				path.Join("renditions", rendition.TrackID.String(), rendition.Profile)+".",
+				hlsKey(rendition.TrackID, rendition.Profile, "")+"/")
+		}
+		if rendition.FilePath != "" {
+			referenced[rendition.FilePath] = true
+		}
+		if rendition.Segments > 0 {
+			referenced[hlsKey(rendition.TrackID, rendition.Profile, transcode.HLSPlaylist)] = true
+			for i := 0; i < rendition.Segments; i++ {
+				referenced[hlsKey(rendition.TrackID, rendition.Profile, transcode.HLSSegment(i))] = true
+			}
+		}
+	}
+	owned := func(key string) bool {
+		if referenced[key] {
+			return true
+		}
+		for _, prefix := range inProgress {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var orphans []*storage.ObjectInfo
+	for _, prefix := range renditionPrefixes {
+		if err := s.storage.List(ctx, prefix, func(object *storage.ObjectInfo) error {
+			report.Objects++
+			if !owned(object.Key) && time.Since(object.ModTime) > orphanGracePeriod {
+				orphans = append(orphans, object)
+			}
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("failed to list storage: %w", err)
+		}
+	}
+	return orphans, nil
+}
+
+// checkObject returns an issue if the object at key is missing, has the
+// wrong size or, when verify is set, content that does not hash to hash.
+func (s *FsckService) checkObject(ctx context.Context, key string, size int64, hash string, verify bool) (*FsckIssue, error) {
+	info, err := s.storage.Stat(ctx, key)
+	if errors.Is(err, storage.ErrNotExist) || errors.Is(err, storage.ErrInvalidKey) {
+		return &FsckIssue{Kind: FsckMissing, Key: key}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", key, err)
+	}
+	if info.Size != size {
+		return &FsckIssue{
+			Kind:     FsckSizeMismatch,
+			Key:      key,
+			Expected: strconv.FormatInt(size, 10),
+			Actual:   strconv.FormatInt(info.Size, 10),
+		}, nil
+	}
+	if !verify || hash == "" {
+		return nil, nil
+	}
+
+	r, err := s.storage.Get(ctx, key, 0, -1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != hash {
+		return &FsckIssue{Kind: FsckHashMismatch, Key: key, Expected: hash, Actual: actual}, nil
+	}
+	return nil, nil
+}
+
+// repairRefCount recounts a blob's references under its row lock, so it
+// cannot race with uploads and deletes of the same content.
+func (s *FsckService) repairRefCount(ctx context.Context, hash string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var blob models.Blob
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&blob, "hash = ?", hash).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return fmt.Errorf("failed to get blob: %w", err)
+		}
+
+		var refs int64
+		if err := tx.Unscoped().Model(&models.Track{}).Where("content_hash = ?", hash).Count(&refs).Error; err != nil {
+			return fmt.Errorf("failed to count blob references: %w", err)
+		}
+		if refs > 0 {
+			return tx.Model(&blob).UpdateColumn("ref_count", refs).Error
+		}
+
+		// Nothing uses the blob any more: drop it and quarantine its object.
+		if err := tx.Delete(&blob).Error; err != nil {
+			return fmt.Errorf("failed to delete blob: %w", err)
+		}
+		info, err := s.storage.Stat(ctx, blob.Key)
+		if errors.Is(err, storage.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", blob.Key, err)
+		}
+		return s.quarantine(ctx, info)
+	})
+}
+
+// quarantine moves an object under quarantinePrefix, keeping its key.
+func (s *FsckService) quarantine(ctx context.Context, object *storage.ObjectInfo) error {
+	r, err := s.storage.Get(ctx, object.Key, 0, -1)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", object.Key, err)
+	}
+	defer r.Close()
+
+	if err := s.storage.Put(ctx, path.Join(quarantinePrefix, object.Key), r, object.Size, object.ContentType); err != nil {
+		return fmt.Errorf("failed to quarantine %s: %w", object.Key, err)
+	}
+	if err := s.storage.Delete(ctx, object.Key); err != nil {
+		return fmt.Errorf("failed to quarantine %s: %w", object.Key, err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"maxify/internal/models"
+	"maxify/internal/storage"
+	"maxify/internal/transcode"
+)
+
+func TestFsckRenditionOrphans(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.User{}, &models.Track{}, &models.Blob{}, &models.Artwork{}, &models.Rendition{})
+	root := t.TempDir()
+	backend, err := storage.NewLocal(root)
+	if err != nil {
+		t.Fatalf("failed to open storage: %v", err)
+	}
+	fsck := &FsckService{db: db, storage: backend}
+
+	// Objects are stored as if written before the grace period.
+	old := time.Now().Add(-2 * orphanGracePeriod)
+	put := func(key string) {
+		t.Helper()
+		if err := backend.Put(ctx, key, strings.NewReader("data"), 4, "application/octet-stream"); err != nil {
+			t.Fatalf("failed to store %s: %v", key, err)
+		}
+		if err := os.Chtimes(filepath.Join(root, filepath.FromSlash(key)), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	track := newTestTrack(t, db, &models.Track{FilePath: "tracks/legacy.mp3", FileSize: 4, MimeType: "audio/mpeg"})
+	put(track.FilePath)
+
+	ready := &models.Rendition{
+		TrackID:  track.ID,
+		Profile:  "high",
+		Status:   models.RenditionReady,
+		FilePath: path.Join("renditions", track.ID.String(), "high.m4a"),
+		Segments: 2,
+	}
+	processing := &models.Rendition{TrackID: track.ID, Profile: "low", Status: models.RenditionProcessing}
+	for _, rendition := range []*models.Rendition{ready, processing} {
+		if err := db.Create(rendition).Error; err != nil {
+			t.Fatalf("failed to create rendition: %v", err)
+		}
+	}
+	put(ready.FilePath)
+	put(hlsKey(track.ID, "high", transcode.HLSPlaylist))
+	put(hlsKey(track.ID, "high", transcode.HLSSegment(0)))
+	put(hlsKey(track.ID, "high", transcode.HLSSegment(1)))
+	// Output of the rendition being transcoded, not recorded yet.
+	put(path.Join("renditions", track.ID.String(), "low.m4a"))
+	put(hlsKey(track.ID, "low", transcode.HLSSegment(0)))
+
+	// A segment left from an earlier, longer transcode, and the output of
+	// a profile whose rendition is gone.
+	orphans := []string{
+		hlsKey(track.ID, "high", transcode.HLSSegment(2)),
+		hlsKey(track.ID, "medium", transcode.HLSPlaylist),
+		hlsKey(track.ID, "medium", transcode.HLSSegment(0)),
+		path.Join("renditions", track.ID.String(), "medium.m4a"),
+	}
+	for _, key := range orphans {
+		put(key)
+	}
+	sort.Strings(orphans)
+
+	report, err := fsck.Check(&FsckRequest{Repair: true})
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	var reported []string
+	for _, issue := range report.Issues {
+		if issue.Kind != FsckOrphan {
+			t.Errorf("unexpected issue %+v", issue)
+			continue
+		}
+		reported = append(reported, issue.Key)
+	}
+	sort.Strings(reported)
+	if !reflect.DeepEqual(reported, orphans) {
+		t.Errorf("orphans = %v, want %v", reported, orphans)
+	}
+	if report.Objects != 11 || report.Quarantined != len(orphans) {
+		t.Errorf("objects = %d, quarantined = %d; want 11, %d", report.Objects, report.Quarantined, len(orphans))
+	}
+
+	for _, key := range orphans {
+		if _, err := backend.Stat(ctx, key); !errors.Is(err, storage.ErrNotExist) {
+			t.Errorf("%s: Stat = %v, want it moved", key, err)
+		}
+		if _, err := backend.Stat(ctx, path.Join(quarantinePrefix, key)); err != nil {
+			t.Errorf("%s was not quarantined: %v", key, err)
+		}
+	}
+	if _, err := backend.Stat(ctx, ready.FilePath); err != nil {
+		t.Errorf("rendition file: %v", err)
+	}
+}
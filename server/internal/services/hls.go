@@ -0,0 +1,75 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"maxify/internal/models"
+	"maxify/internal/streaming"
+	"maxify/internal/transcode"
+
+	"github.com/google/uuid"
+)
+
+var ErrHLSNotReady = errors.New("HLS stream is not ready yet")
+
+var hlsFilePattern = regexp.MustCompile(`^(index\.m3u8|seg_\d{5}\.ts)$`)
+
+// GetHLSMaster builds the master playlist from the renditions that have been
+// packaged so far. Missing renditions are requested, so the first call for an
+// older track starts its transcode.
+func (s *TrackService) GetHLSMaster(trackID, userID uuid.UUID) (string, error) {
+	track, err := s.getOwnedTrack(trackID, userID)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+
+	variants := 0
+	for _, profile := range transcode.Profiles {
+		rendition := s.transcodes.ReadyRendition(track, profile)
+		if rendition == nil || rendition.Segments == 0 {
+			continue
+		}
+
+		// Peak bandwidth allows for MPEG-TS and ADTS framing overhead.
+		average := profile.Bitrate * 1000
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d,CODECS=\"%s\"\n%s/%s\n",
+			average*115/100, average, profile.Codecs, profile.Name, transcode.HLSPlaylist)
+		variants++
+	}
+
+	if variants == 0 {
+		return "", ErrHLSNotReady
+	}
+	return b.String(), nil
+}
+
+// GetHLSFile returns a media playlist or segment of one variant.
+func (s *TrackService) GetHLSFile(trackID, userID uuid.UUID, variant, name string) (*streaming.Content, error) {
+	track, err := s.getOwnedTrack(trackID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	profile, err := transcode.Lookup(variant)
+	if err != nil || !hlsFilePattern.MatchString(name) {
+		return nil, errors.New("HLS file not found")
+	}
+
+	var rendition models.Rendition
+	if err := s.db.Where("track_id = ? AND profile = ? AND status = ? AND segments > 0", track.ID, profile.Name, models.RenditionReady).
+		First(&rendition).Error; err != nil {
+		return nil, errors.New("HLS file not found")
+	}
+
+	mimeType := "video/mp2t"
+	if name == transcode.HLSPlaylist {
+		mimeType = "application/vnd.apple.mpegurl"
+	}
+	return s.objectContent(rendition.ID, hlsKey(track.ID, profile.Name, name), mimeType, "private, max-age=86400")
+}
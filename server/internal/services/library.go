@@ -0,0 +1,187 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"maxify/internal/database"
+	"maxify/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type LibraryService struct {
+	db *gorm.DB
+}
+
+func NewLibraryService() *LibraryService {
+	return &LibraryService{
+		db: database.GetDB(),
+	}
+}
+
+type ArtistResponse struct {
+	ID         uuid.UUID `json:"id"`
+	Name       string    `json:"name"`
+	AlbumCount int       `json:"album_count"`
+	TrackCount int       `json:"track_count"`
+}
+
+type AlbumResponse struct {
+	ID         uuid.UUID `json:"id"`
+	Title      string    `json:"title"`
+	ArtistID   uuid.UUID `json:"artist_id"`
+	ArtistName string    `json:"artist_name"`
+	Year       int       `json:"year"`
+	TrackCount int       `json:"track_count"`
+	Duration   int       `json:"duration"`
+}
+
+func (s *LibraryService) GetUserArtists(userID uuid.UUID, limit, offset int) ([]*ArtistResponse, error) {
+	var artists []*ArtistResponse
+	if err := s.db.Model(&models.Artist{}).
+		Select(`artists.id, artists.name,
+			(SELECT COUNT(*) FROM albums WHERE albums.artist_id = artists.id) AS album_count,
+			(SELECT COUNT(*) FROM tracks WHERE tracks.artist_id = artists.id AND tracks.deleted_at IS NULL) AS track_count`).
+		Where("artists.user_id = ?", userID).
+		Where("EXISTS (SELECT 1 FROM tracks WHERE tracks.artist_id = artists.id AND tracks.deleted_at IS NULL)").
+		Order("artists.name_key ASC").
+		Limit(limit).
+		Offset(offset).
+		Scan(&artists).Error; err != nil {
+		return nil, fmt.Errorf("failed to get artists: %w", err)
+	}
+
+	return artists, nil
+}
+
+func (s *LibraryService) GetUserAlbums(userID uuid.UUID, artistID *uuid.UUID, limit, offset int) ([]*AlbumResponse, error) {
+	query := s.db.Model(&models.Album{}).
+		Select(`albums.id, albums.title, albums.artist_id, artists.name AS artist_name, albums.year,
+			COUNT(tracks.id) AS track_count, COALESCE(SUM(tracks.duration), 0) AS duration`).
+		Joins("JOIN artists ON artists.id = albums.artist_id").
+		Joins("JOIN tracks ON tracks.album_id = albums.id AND tracks.deleted_at IS NULL").
+		Where("albums.user_id = ?", userID)
+
+	if artistID != nil {
+		query = query.Where("albums.artist_id = ?", *artistID)
+	}
+
+	var albums []*AlbumResponse
+	if err := query.
+		Group("albums.id, artists.id").
+		Order("artists.name_key ASC, albums.year ASC, albums.title_key ASC").
+		Limit(limit).
+		Offset(offset).
+		Scan(&albums).Error; err != nil {
+		return nil, fmt.Errorf("failed to get albums: %w", err)
+	}
+
+	return albums, nil
+}
+
+func (s *LibraryService) GetAlbumTracks(albumID, userID uuid.UUID) ([]*TrackResponse, error) {
+	var album models.Album
+	if err := s.db.Where("id = ? AND user_id = ?", albumID, userID).First(&album).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("album not found")
+		}
+		return nil, fmt.Errorf("failed to get album: %w", err)
+	}
+
+	var tracks []models.Track
+	if err := s.db.Where("album_id = ?", album.ID).
+		Order("disc_number ASC, track_number ASC, title ASC").
+		Find(&tracks).Error; err != nil {
+		return nil, fmt.Errorf("failed to get album tracks: %w", err)
+	}
+
+	responses := make([]*TrackResponse, 0, len(tracks))
+	for i := range tracks {
+		responses = append(responses, newTrackResponse(&tracks[i]))
+	}
+
+	return responses, nil
+}
+
+// libraryKey is the case-insensitive form artists and albums are
+// deduplicated on. It must stay in sync with the backfill in database.
+func libraryKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// resolveArtist returns the user's artist with the given name, creating it
+// if needed. Concurrent uploads race on the unique index, so the insert
+// ignores conflicts and the row is read back afterwards.
+func resolveArtist(tx *gorm.DB, userID uuid.UUID, name string) (*models.Artist, error) {
+	artist := &models.Artist{
+		Name:    strings.TrimSpace(name),
+		NameKey: libraryKey(name),
+		UserID:  userID,
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(artist).Error; err != nil {
+		return nil, fmt.Errorf("failed to create artist: %w", err)
+	}
+
+	var existing models.Artist
+	if err := tx.Where("user_id = ? AND name_key = ?", userID, artist.NameKey).First(&existing).Error; err != nil {
+		return nil, fmt.Errorf("failed to get artist: %w", err)
+	}
+	return &existing, nil
+}
+
+func resolveAlbum(tx *gorm.DB, userID, artistID uuid.UUID, title string, year int) (*models.Album, error) {
+	album := &models.Album{
+		Title:    strings.TrimSpace(title),
+		TitleKey: libraryKey(title),
+		ArtistID: artistID,
+		UserID:   userID,
+		Year:     year,
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(album).Error; err != nil {
+		return nil, fmt.Errorf("failed to create album: %w", err)
+	}
+
+	var existing models.Album
+	if err := tx.Where("user_id = ? AND artist_id = ? AND title_key = ?", userID, artistID, album.TitleKey).
+		First(&existing).Error; err != nil {
+		return nil, fmt.Errorf("failed to get album: %w", err)
+	}
+	return &existing, nil
+}
+
+// linkLibrary attaches a new track to its artist and album entities. The
+// album belongs to the album artist when the tags name one, so compilations
+// stay together.
+func linkLibrary(tx *gorm.DB, track *models.Track, albumArtist string) error {
+	if libraryKey(track.Artist) == "" {
+		return nil
+	}
+
+	artist, err := resolveArtist(tx, track.UserID, track.Artist)
+	if err != nil {
+		return err
+	}
+	track.ArtistID = &artist.ID
+
+	if libraryKey(track.Album) == "" {
+		return nil
+	}
+
+	owner := artist
+	if libraryKey(albumArtist) != "" && libraryKey(albumArtist) != artist.NameKey {
+		if owner, err = resolveArtist(tx, track.UserID, albumArtist); err != nil {
+			return err
+		}
+	}
+
+	album, err := resolveAlbum(tx, track.UserID, owner.ID, track.Album, track.Year)
+	if err != nil {
+		return err
+	}
+	track.AlbumID = &album.ID
+	return nil
+}
@@ -0,0 +1,293 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"maxify/internal/config"
+	"maxify/internal/database"
+	"maxify/internal/models"
+	"maxify/internal/pagination"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// playThreshold is how far into a track a listen counts as a play.
+	// Tracks shorter than twice this count at their halfway point.
+	playThreshold = 30
+
+	playEventsKey   = "plays:events"
+	playStateTTL    = 12 * time.Hour
+	playFlushBatch  = 500
+	playPushTimeout = 5 * time.Second
+)
+
+var ErrPlayMismatch = errors.New("play belongs to another track")
+
+// recordPlayScript tracks the state of one listen. The first event ties the
+// play ID to a user and track; later events must match. Listening time is
+// how far the position advanced since the furthest point reached, bounded
+// by the wall-clock time since the previous event so that seeking ahead
+// does not count. Returns {-1, 0} on a mismatch, otherwise {counted,
+// seconds} where counted is 1 if this event is the first to count the play.
+var recordPlayScript = redis.NewScript(`
+local owner = redis.call('HGET', KEYS[1], 'owner')
+if owner and owner ~= ARGV[1] then
+	return {-1, 0}
+end
+redis.call('HSET', KEYS[1], 'owner', ARGV[1])
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+
+local position = tonumber(ARGV[4])
+local now = tonumber(ARGV[5])
+local reached = tonumber(redis.call('HGET', KEYS[1], 'position') or '0')
+local last = tonumber(redis.call('HGET', KEYS[1], 'at') or ARGV[5])
+local seconds = 0
+if position > reached then
+	seconds = math.min(position - reached, now - last)
+	redis.call('HSET', KEYS[1], 'position', position)
+end
+redis.call('HSET', KEYS[1], 'at', now)
+
+local counted = 0
+if ARGV[2] == '1' and redis.call('HSETNX', KEYS[1], 'counted', 1) == 1 then
+	counted = 1
+end
+return {counted, seconds}
+`)
+
+type PlayService struct {
+	db      *gorm.DB
+	redis   *redis.Client
+	cursors *pagination.Signer
+}
+
+func NewPlayService(cfg *config.Config) *PlayService {
+	return &PlayService{
+		db:      database.GetDB(),
+		redis:   database.GetRedis(),
+		cursors: pagination.NewSigner(cfg.JWT.Secret),
+	}
+}
+
+// PlayEventRequest is a playback report. Clients generate a PlayID when a
+// track starts and send it with every event of that listen.
+type PlayEventRequest struct {
+	PlayID   string `json:"play_id" binding:"required,uuid"`
+	Event    string `json:"event" binding:"required,oneof=start progress complete"`
+	Position int    `json:"position" binding:"min=0"` // seconds
+}
+
+type PlayEventResponse struct {
+	PlayID  string `json:"play_id"`
+	Counted bool   `json:"counted"`
+}
+
+type RecentPlayResponse struct {
+	PlayID   uuid.UUID      `json:"play_id"`
+	PlayedAt time.Time      `json:"played_at"`
+	Track    *TrackResponse `json:"track"`
+}
+
+func playStateKey(playID uuid.UUID) string {
+	return "plays:state:" + playID.String()
+}
+
+// RecordEvent buffers a playback event. Counted is true for the event that
+// made the listen count as a play.
+func (s *PlayService) RecordEvent(trackID, userID uuid.UUID, req *PlayEventRequest) (*PlayEventResponse, error) {
+	playID, err := uuid.Parse(req.PlayID)
+	if err != nil {
+		return nil, err
+	}
+
+	var track models.Track
+	if err := s.db.Select("id", "duration").Where("id = ? AND user_id = ?", trackID, userID).First(&track).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTrackNotFound
+		}
+		return nil, fmt.Errorf("failed to get track: %w", err)
+	}
+
+	position := req.Position
+	if track.Duration > 0 && position > track.Duration {
+		position = track.Duration
+	}
+
+	threshold := playThreshold
+	if half := track.Duration / 2; half > 0 && half < threshold {
+		threshold = half
+	}
+	counts := "0"
+	if req.Event == models.PlayEventComplete || position >= threshold {
+		counts = "1"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), playPushTimeout)
+	defer cancel()
+
+	now := time.Now()
+	result, err := recordPlayScript.Run(ctx, s.redis, []string{playStateKey(playID)},
+		userID.String()+":"+trackID.String(), counts, int(playStateTTL.Seconds()), position, now.Unix()).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to record play: %w", err)
+	}
+	if result[0] < 0 {
+		return nil, ErrPlayMismatch
+	}
+
+	event := models.PlayEvent{
+		ID:        uuid.New(),
+		PlayID:    playID,
+		UserID:    userID,
+		TrackID:   trackID,
+		Type:      req.Event,
+		Position:  position,
+		Seconds:   int(result[1]),
+		Counted:   result[0] == 1,
+		CreatedAt: now,
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.redis.RPush(ctx, playEventsKey, data).Err(); err != nil {
+		return nil, fmt.Errorf("failed to buffer play event: %w", err)
+	}
+
+	return &PlayEventResponse{PlayID: playID.String(), Counted: event.Counted}, nil
+}
+
+// Flush moves up to one batch of buffered events into the database and adds
+// the counted plays to the tracks' play counts. It returns the number of
+// events taken from the buffer.
+func (s *PlayService) Flush() (int, error) {
+	ctx := context.Background()
+
+	var batch *redis.StringSliceCmd
+	if _, err := s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		batch = pipe.LRange(ctx, playEventsKey, 0, playFlushBatch-1)
+		pipe.LTrim(ctx, playEventsKey, playFlushBatch, -1)
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("failed to read play events: %w", err)
+	}
+
+	raw := batch.Val()
+	if len(raw) == 0 {
+		return 0, nil
+	}
+
+	events := make([]models.PlayEvent, 0, len(raw))
+	plays := make(map[uuid.UUID]int64)
+	for _, data := range raw {
+		var event models.PlayEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			log.Printf("Dropping invalid play event: %v", err)
+			continue
+		}
+		events = append(events, event)
+		if event.Counted {
+			plays[event.TrackID]++
+		}
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if len(events) == 0 {
+			return nil
+		}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(events, 100).Error; err != nil {
+			return err
+		}
+		for trackID, n := range plays {
+			if err := tx.Model(&models.Track{}).Where("id = ?", trackID).
+				UpdateColumn("play_count", gorm.Expr("play_count + ?", n)).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// Put the batch back so it is retried on the next flush.
+		values := make([]interface{}, len(raw))
+		for i, data := range raw {
+			values[i] = data
+		}
+		if pushErr := s.redis.RPush(ctx, playEventsKey, values...).Err(); pushErr != nil {
+			log.Printf("Lost %d play events: %v", len(raw), pushErr)
+		}
+		return 0, fmt.Errorf("failed to save play events: %w", err)
+	}
+
+	return len(raw), nil
+}
+
+// StartFlusher periodically writes buffered play events to the database,
+// draining the buffer a batch at a time.
+func (s *PlayService) StartFlusher(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			for {
+				n, err := s.Flush()
+				if err != nil {
+					log.Printf("Play event flush failed: %v", err)
+					break
+				}
+				if n < playFlushBatch {
+					break
+				}
+			}
+		}
+	}()
+}
+
+// GetRecentlyPlayed lists the user's counted plays, most recent first. A
+// track appears once per play. Plays show up once their events are flushed.
+func (s *PlayService) GetRecentlyPlayed(userID uuid.UUID, req *pagination.Request) ([]*RecentPlayResponse, *pagination.Page, error) {
+	scope := pagination.Scope("recently-played", userID.String())
+	cursor, err := s.cursors.Decode(req.Cursor, scope)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filter := func(db *gorm.DB) *gorm.DB {
+		return db.Model(&models.PlayEvent{}).InnerJoins("Track").
+			Where("play_events.user_id = ? AND play_events.counted", userID)
+	}
+
+	keyset := pagination.Keyset{Columns: []string{"play_events.created_at", "play_events.id"}, Desc: true}
+
+	var events []models.PlayEvent
+	if err := keyset.Apply(s.db.Scopes(filter), cursor, req.Limit).Find(&events).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to get recently played: %w", err)
+	}
+
+	events, page := pagination.Build(s.cursors, scope, events, req.Limit, cursor, func(event *models.PlayEvent) []string {
+		return []string{event.CreatedAt.Format(time.RFC3339Nano), event.ID.String()}
+	})
+	if page.Total, page.TotalEstimated, err = pagination.Count(s.db.Scopes(filter)); err != nil {
+		return nil, nil, fmt.Errorf("failed to count recently played: %w", err)
+	}
+
+	responses := make([]*RecentPlayResponse, 0, len(events))
+	for i := range events {
+		responses = append(responses, &RecentPlayResponse{
+			PlayID:   events[i].PlayID,
+			PlayedAt: events[i].CreatedAt,
+			Track:    newTrackResponse(&events[i].Track),
+		})
+	}
+
+	return responses, page, nil
+}
@@ -0,0 +1,620 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"time"
+
+	"maxify/internal/database"
+	"maxify/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+const (
+	RepeatOff = "off"
+	RepeatAll = "all"
+	RepeatOne = "one"
+)
+
+const (
+	maxQueueLength = 1000
+	// playerTxRetries bounds the optimistic transaction retries when
+	// devices change the player at the same time.
+	playerTxRetries = 10
+	// restartAfter is how far into a track Previous restarts it instead of
+	// going back, in seconds.
+	restartAfter = 3
+	// deviceTTL is how long a device stays listed after its event stream
+	// was last seen.
+	deviceTTL      = 90 * time.Second
+	playerStateTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrQueueItemNotFound = errors.New("queue item not found")
+	ErrQueueFull         = errors.New("queue is full")
+	ErrInvalidQueueOrder = errors.New("item_ids must list every queue item exactly once")
+	ErrPlayerConflict    = errors.New("player state changed concurrently, try again")
+)
+
+// playerState is what a user is listening to, shared by all their devices.
+// Position is the playback position at UpdatedAt; while Playing it advances
+// with the clock. Unshuffled remembers the queue order from before shuffle
+// was turned on.
+type playerState struct {
+	Queue      []queueItem `json:"queue"`
+	Current    int         `json:"current"` // index into Queue, -1 when nothing is loaded
+	Position   float64     `json:"position"`
+	Playing    bool        `json:"playing"`
+	Shuffle    bool        `json:"shuffle"`
+	Repeat     string      `json:"repeat"`
+	DeviceID   string      `json:"device_id"`
+	Unshuffled []uuid.UUID `json:"unshuffled,omitempty"`
+	Version    int64       `json:"version"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+}
+
+// queueItem is an occurrence of a track in the queue. Items have their own
+// ID so a track can be queued more than once.
+type queueItem struct {
+	ID      uuid.UUID `json:"id"`
+	TrackID uuid.UUID `json:"track_id"`
+}
+
+func (p *playerState) position(now time.Time) float64 {
+	if !p.Playing {
+		return p.Position
+	}
+	return p.Position + now.Sub(p.UpdatedAt).Seconds()
+}
+
+func (p *playerState) indexOf(itemID uuid.UUID) int {
+	for i := range p.Queue {
+		if p.Queue[i].ID == itemID {
+			return i
+		}
+	}
+	return -1
+}
+
+// load makes the item at index current, from the start.
+func (p *playerState) load(index int) {
+	p.Current = index
+	p.Position = 0
+}
+
+type PlayerService struct {
+	db    *gorm.DB
+	redis *redis.Client
+}
+
+func NewPlayerService() *PlayerService {
+	return &PlayerService{
+		db:    database.GetDB(),
+		redis: database.GetRedis(),
+	}
+}
+
+// PlayerUpdateRequest changes the playback state. Omitted fields are left
+// unchanged. Setting DeviceID transfers playback to that device.
+type PlayerUpdateRequest struct {
+	ItemID   *uuid.UUID `json:"item_id"`
+	Playing  *bool      `json:"playing"`
+	Position *float64   `json:"position" binding:"omitempty,min=0"`
+	Shuffle  *bool      `json:"shuffle"`
+	Repeat   *string    `json:"repeat" binding:"omitempty,oneof=off all one"`
+	DeviceID *string    `json:"device_id" binding:"omitempty,max=64"`
+}
+
+// EnqueueRequest adds tracks to the end of the queue, or right after the
+// current item when Next is set.
+type EnqueueRequest struct {
+	TrackIDs []uuid.UUID `json:"track_ids" binding:"required,min=1,max=100"`
+	Next     bool        `json:"next"`
+}
+
+type QueueItemResponse struct {
+	ID    uuid.UUID      `json:"id"`
+	Track *TrackResponse `json:"track"`
+}
+
+type PlayerStateResponse struct {
+	Queue        []*QueueItemResponse `json:"queue"`
+	Current      *QueueItemResponse   `json:"current"`
+	CurrentIndex int                  `json:"current_index"`
+	Position     float64              `json:"position"`
+	Playing      bool                 `json:"playing"`
+	Shuffle      bool                 `json:"shuffle"`
+	Repeat       string               `json:"repeat"`
+	DeviceID     string               `json:"device_id,omitempty"`
+	Version      int64                `json:"version"`
+	UpdatedAt    time.Time            `json:"updated_at"`
+}
+
+// Device is a client connected to the player's event stream.
+type Device struct {
+	ID     string    `json:"id"`
+	Name   string    `json:"name"`
+	SeenAt time.Time `json:"seen_at"`
+}
+
+func playerStateKey(userID uuid.UUID) string {
+	return "player:state:" + userID.String()
+}
+
+func playerDevicesKey(userID uuid.UUID) string {
+	return "player:devices:" + userID.String()
+}
+
+func playerChannel(userID uuid.UUID) string {
+	return "player:events:" + userID.String()
+}
+
+func (s *PlayerService) GetState(userID uuid.UUID) (*PlayerStateResponse, error) {
+	state, err := loadPlayerState(context.Background(), s.redis, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.response(state)
+}
+
+func (s *PlayerService) UpdateState(userID uuid.UUID, req *PlayerUpdateRequest) (*PlayerStateResponse, error) {
+	return s.update(userID, func(state *playerState) error {
+		if req.ItemID != nil {
+			index := state.indexOf(*req.ItemID)
+			if index < 0 {
+				return ErrQueueItemNotFound
+			}
+			state.load(index)
+		}
+		if req.Position != nil {
+			state.Position = *req.Position
+		}
+		if req.Playing != nil {
+			state.Playing = *req.Playing && state.Current >= 0
+		}
+		if req.Repeat != nil {
+			state.Repeat = *req.Repeat
+		}
+		if req.DeviceID != nil {
+			state.DeviceID = *req.DeviceID
+		}
+		if req.Shuffle != nil && *req.Shuffle != state.Shuffle {
+			if *req.Shuffle {
+				state.shuffle()
+			} else {
+				state.unshuffle()
+			}
+		}
+		return nil
+	})
+}
+
+// shuffle randomizes the items after the current one.
+func (p *playerState) shuffle() {
+	p.Unshuffled = make([]uuid.UUID, len(p.Queue))
+	for i := range p.Queue {
+		p.Unshuffled[i] = p.Queue[i].ID
+	}
+	upcoming := p.Queue[p.Current+1:]
+	rand.Shuffle(len(upcoming), func(i, j int) {
+		upcoming[i], upcoming[j] = upcoming[j], upcoming[i]
+	})
+	p.Shuffle = true
+}
+
+// unshuffle restores the order from before shuffling. Items queued since
+// then keep their relative order after the others.
+func (p *playerState) unshuffle() {
+	rank := make(map[uuid.UUID]int, len(p.Unshuffled))
+	for i, id := range p.Unshuffled {
+		rank[id] = i
+	}
+	order := func(id uuid.UUID) int {
+		if r, ok := rank[id]; ok {
+			return r
+		}
+		return len(rank)
+	}
+
+	var current uuid.UUID
+	if p.Current >= 0 {
+		current = p.Queue[p.Current].ID
+	}
+	sort.SliceStable(p.Queue, func(i, j int) bool {
+		return order(p.Queue[i].ID) < order(p.Queue[j].ID)
+	})
+	if p.Current >= 0 {
+		p.Current = p.indexOf(current)
+	}
+	p.Unshuffled = nil
+	p.Shuffle = false
+}
+
+func (s *PlayerService) Enqueue(userID uuid.UUID, req *EnqueueRequest) (*PlayerStateResponse, error) {
+	unique := make(map[uuid.UUID]bool, len(req.TrackIDs))
+	for _, id := range req.TrackIDs {
+		unique[id] = true
+	}
+	var found int64
+	if err := s.db.Model(&models.Track{}).
+		Where("id IN ? AND user_id = ?", req.TrackIDs, userID).
+		Count(&found).Error; err != nil {
+		return nil, fmt.Errorf("failed to get tracks: %w", err)
+	}
+	if found != int64(len(unique)) {
+		return nil, ErrTrackNotFound
+	}
+
+	return s.update(userID, func(state *playerState) error {
+		if len(state.Queue)+len(req.TrackIDs) > maxQueueLength {
+			return ErrQueueFull
+		}
+
+		items := make([]queueItem, len(req.TrackIDs))
+		for i, trackID := range req.TrackIDs {
+			items[i] = queueItem{ID: uuid.New(), TrackID: trackID}
+			if state.Shuffle {
+				state.Unshuffled = append(state.Unshuffled, items[i].ID)
+			}
+		}
+
+		at := len(state.Queue)
+		if req.Next {
+			at = state.Current + 1
+		}
+		state.Queue = append(state.Queue[:at], append(items, state.Queue[at:]...)...)
+
+		if state.Current < 0 {
+			state.load(0)
+		}
+		return nil
+	})
+}
+
+// Dequeue removes an item. Removing the current item loads the one after
+// it, or stops playback at the end of the queue.
+func (s *PlayerService) Dequeue(userID, itemID uuid.UUID) (*PlayerStateResponse, error) {
+	return s.update(userID, func(state *playerState) error {
+		index := state.indexOf(itemID)
+		if index < 0 {
+			return ErrQueueItemNotFound
+		}
+		state.Queue = append(state.Queue[:index], state.Queue[index+1:]...)
+
+		switch {
+		case index < state.Current:
+			state.Current--
+		case index == state.Current:
+			if state.Current >= len(state.Queue) {
+				state.Current = len(state.Queue) - 1
+				state.Playing = false
+			}
+			state.Position = 0
+		}
+		if state.Current < 0 {
+			state.Playing = false
+		}
+		return nil
+	})
+}
+
+// ReorderQueue puts the queue in the order of itemIDs, which must list
+// every item exactly once. The current item keeps playing.
+func (s *PlayerService) ReorderQueue(userID uuid.UUID, itemIDs []uuid.UUID) (*PlayerStateResponse, error) {
+	return s.update(userID, func(state *playerState) error {
+		if len(itemIDs) != len(state.Queue) {
+			return ErrInvalidQueueOrder
+		}
+		byID := make(map[uuid.UUID]queueItem, len(state.Queue))
+		for _, item := range state.Queue {
+			byID[item.ID] = item
+		}
+
+		var current uuid.UUID
+		if state.Current >= 0 {
+			current = state.Queue[state.Current].ID
+		}
+		queue := make([]queueItem, 0, len(itemIDs))
+		for _, id := range itemIDs {
+			item, ok := byID[id]
+			if !ok {
+				return ErrInvalidQueueOrder
+			}
+			delete(byID, id)
+			queue = append(queue, item)
+		}
+		state.Queue = queue
+		if state.Current >= 0 {
+			state.Current = state.indexOf(current)
+		}
+		return nil
+	})
+}
+
+func (s *PlayerService) ClearQueue(userID uuid.UUID) (*PlayerStateResponse, error) {
+	return s.update(userID, func(state *playerState) error {
+		state.Queue = nil
+		state.Unshuffled = nil
+		state.load(-1)
+		state.Playing = false
+		return nil
+	})
+}
+
+// Next skips to the next item. ended is set by the playing device when the
+// track finished by itself, in which case repeat one replays it. Past the
+// end of the queue playback wraps around with repeat all, and stops
+// otherwise.
+func (s *PlayerService) Next(userID uuid.UUID, ended bool) (*PlayerStateResponse, error) {
+	return s.update(userID, func(state *playerState) error {
+		if state.Current < 0 {
+			return nil
+		}
+		switch {
+		case ended && state.Repeat == RepeatOne:
+			state.load(state.Current)
+		case state.Current+1 < len(state.Queue):
+			state.load(state.Current + 1)
+		case state.Repeat == RepeatAll:
+			state.load(0)
+		default:
+			state.Position = 0
+			state.Playing = false
+		}
+		return nil
+	})
+}
+
+// Previous restarts the current track, or goes back to the previous item
+// near the start of the track.
+func (s *PlayerService) Previous(userID uuid.UUID) (*PlayerStateResponse, error) {
+	return s.update(userID, func(state *playerState) error {
+		if state.Current < 0 {
+			return nil
+		}
+		switch {
+		case state.Position > restartAfter:
+			state.load(state.Current)
+		case state.Current > 0:
+			state.load(state.Current - 1)
+		case state.Repeat == RepeatAll:
+			state.load(len(state.Queue) - 1)
+		default:
+			state.load(state.Current)
+		}
+		return nil
+	})
+}
+
+// update applies fn to the user's player state in an optimistic
+// transaction and publishes the result to the user's devices. The
+// transaction is retried if another device changed the state meanwhile.
+func (s *PlayerService) update(userID uuid.UUID, fn func(*playerState) error) (*PlayerStateResponse, error) {
+	ctx := context.Background()
+	key := playerStateKey(userID)
+
+	for i := 0; i < playerTxRetries; i++ {
+		var state *playerState
+		err := s.redis.Watch(ctx, func(tx *redis.Tx) error {
+			var err error
+			if state, err = loadPlayerState(ctx, tx, userID); err != nil {
+				return err
+			}
+
+			now := time.Now()
+			state.Position = state.position(now)
+			if err := fn(state); err != nil {
+				return err
+			}
+			state.Version++
+			state.UpdatedAt = now
+
+			data, err := json.Marshal(state)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, key, data, playerStateTTL)
+				pipe.Publish(ctx, playerChannel(userID), data)
+				return nil
+			})
+			return err
+		}, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return s.response(state)
+	}
+
+	return nil, ErrPlayerConflict
+}
+
+func loadPlayerState(ctx context.Context, client redis.Cmdable, userID uuid.UUID) (*playerState, error) {
+	state := &playerState{Current: -1, Repeat: RepeatOff}
+
+	data, err := client.Get(ctx, playerStateKey(userID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get player state: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("invalid player state: %w", err)
+	}
+	return state, nil
+}
+
+// response resolves the queued tracks. Items whose track has been deleted
+// are left out.
+func (s *PlayerService) response(state *playerState) (*PlayerStateResponse, error) {
+	ids := make([]uuid.UUID, len(state.Queue))
+	for i, item := range state.Queue {
+		ids[i] = item.TrackID
+	}
+	tracks := make(map[uuid.UUID]*models.Track, len(ids))
+	if len(ids) > 0 {
+		var found []models.Track
+		if err := s.db.Where("id IN ?", ids).Find(&found).Error; err != nil {
+			return nil, fmt.Errorf("failed to get queued tracks: %w", err)
+		}
+		for i := range found {
+			tracks[found[i].ID] = &found[i]
+		}
+	}
+
+	response := &PlayerStateResponse{
+		Queue:        make([]*QueueItemResponse, 0, len(state.Queue)),
+		CurrentIndex: -1,
+		Position:     state.position(time.Now()),
+		Playing:      state.Playing,
+		Shuffle:      state.Shuffle,
+		Repeat:       state.Repeat,
+		DeviceID:     state.DeviceID,
+		Version:      state.Version,
+		UpdatedAt:    state.UpdatedAt,
+	}
+	for i, item := range state.Queue {
+		track, ok := tracks[item.TrackID]
+		if !ok {
+			continue
+		}
+		itemResponse := &QueueItemResponse{ID: item.ID, Track: newTrackResponse(track)}
+		if i == state.Current {
+			response.Current = itemResponse
+			response.CurrentIndex = len(response.Queue)
+			if track.Duration > 0 && response.Position > float64(track.Duration) {
+				response.Position = float64(track.Duration)
+			}
+		}
+		response.Queue = append(response.Queue, itemResponse)
+	}
+
+	return response, nil
+}
+
+// Subscribe registers a device and streams the user's player state to it:
+// the current state first, then every change. The channel is closed and
+// the device unregistered when ctx is done.
+func (s *PlayerService) Subscribe(ctx context.Context, userID uuid.UUID, device *Device) (<-chan *PlayerStateResponse, error) {
+	pubsub := s.redis.Subscribe(ctx, playerChannel(userID))
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("failed to subscribe to player events: %w", err)
+	}
+
+	initial, err := s.GetState(userID)
+	if err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	if device != nil {
+		if err := s.touchDevice(ctx, userID, device); err != nil {
+			pubsub.Close()
+			return nil, err
+		}
+	}
+
+	states := make(chan *PlayerStateResponse, 1)
+	states <- initial
+
+	go func() {
+		defer close(states)
+		defer pubsub.Close()
+		if device != nil {
+			defer s.redis.HDel(context.Background(), playerDevicesKey(userID), device.ID)
+		}
+
+		touch := time.NewTicker(deviceTTL / 3)
+		defer touch.Stop()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-touch.C:
+				if device != nil {
+					if err := s.touchDevice(ctx, userID, device); err != nil {
+						log.Printf("Failed to refresh player device: %v", err)
+					}
+				}
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+				var state playerState
+				if err := json.Unmarshal([]byte(message.Payload), &state); err != nil {
+					log.Printf("Invalid player event: %v", err)
+					continue
+				}
+				response, err := s.response(&state)
+				if err != nil {
+					log.Printf("Failed to build player state: %v", err)
+					continue
+				}
+				select {
+				case states <- response:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return states, nil
+}
+
+func (s *PlayerService) touchDevice(ctx context.Context, userID uuid.UUID, device *Device) error {
+	device.SeenAt = time.Now()
+	data, err := json.Marshal(device)
+	if err != nil {
+		return err
+	}
+	key := playerDevicesKey(userID)
+	if _, err := s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, device.ID, data)
+		pipe.Expire(ctx, key, deviceTTL)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to register player device: %w", err)
+	}
+	return nil
+}
+
+// GetDevices lists the devices with an open event stream.
+func (s *PlayerService) GetDevices(userID uuid.UUID) ([]*Device, error) {
+	ctx := context.Background()
+	key := playerDevicesKey(userID)
+
+	fields, err := s.redis.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get player devices: %w", err)
+	}
+
+	devices := make([]*Device, 0, len(fields))
+	for id, data := range fields {
+		var device Device
+		if err := json.Unmarshal([]byte(data), &device); err != nil || time.Since(device.SeenAt) > deviceTTL {
+			s.redis.HDel(ctx, key, id)
+			continue
+		}
+		devices = append(devices, &device)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Name < devices[j].Name })
+
+	return devices, nil
+}
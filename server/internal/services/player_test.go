@@ -0,0 +1,361 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"maxify/internal/models"
+
+	"github.com/google/uuid"
+)
+
+type playerFixture struct {
+	player *PlayerService
+	userID uuid.UUID
+	tracks []uuid.UUID
+}
+
+// newPlayerFixture creates a user with n tracks.
+func newPlayerFixture(t *testing.T, n int) *playerFixture {
+	t.Helper()
+	db := newTestDB(t, &models.User{}, &models.Track{})
+	client, _ := newTestRedis(t)
+
+	user := &models.User{Username: "listener", Email: "listener@example.com", PasswordHash: "x"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	f := &playerFixture{player: &PlayerService{db: db, redis: client}, userID: user.ID}
+	for i := 0; i < n; i++ {
+		track := &models.Track{Title: fmt.Sprintf("Track %d", i), Duration: 180, UserID: user.ID}
+		if err := db.Create(track).Error; err != nil {
+			t.Fatalf("failed to create track: %v", err)
+		}
+		f.tracks = append(f.tracks, track.ID)
+	}
+	return f
+}
+
+// enqueue queues every track in order and returns the item IDs.
+func (f *playerFixture) enqueue(t *testing.T) []uuid.UUID {
+	t.Helper()
+	state, err := f.player.Enqueue(f.userID, &EnqueueRequest{TrackIDs: f.tracks})
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	return queueIDs(state)
+}
+
+func (f *playerFixture) set(t *testing.T, req *PlayerUpdateRequest) *PlayerStateResponse {
+	t.Helper()
+	state, err := f.player.UpdateState(f.userID, req)
+	if err != nil {
+		t.Fatalf("UpdateState: %v", err)
+	}
+	return state
+}
+
+func queueIDs(state *PlayerStateResponse) []uuid.UUID {
+	ids := make([]uuid.UUID, len(state.Queue))
+	for i, item := range state.Queue {
+		ids[i] = item.ID
+	}
+	return ids
+}
+
+func sortedIDs(ids []uuid.UUID) []uuid.UUID {
+	sorted := append([]uuid.UUID(nil), ids...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].String() < sorted[j].String() })
+	return sorted
+}
+
+func TestPlayerShuffle(t *testing.T) {
+	f := newPlayerFixture(t, 6)
+	items := f.enqueue(t)
+	f.set(t, &PlayerUpdateRequest{ItemID: &items[2]})
+
+	on, off := true, false
+	state := f.set(t, &PlayerUpdateRequest{Shuffle: &on})
+	got := queueIDs(state)
+	if !state.Shuffle || state.CurrentIndex != 2 || state.Current.ID != items[2] {
+		t.Fatalf("shuffle moved the current item: index %d, shuffle %v", state.CurrentIndex, state.Shuffle)
+	}
+	if !reflect.DeepEqual(got[:3], items[:3]) {
+		t.Errorf("shuffle moved played items: %v, want %v", got[:3], items[:3])
+	}
+	if !reflect.DeepEqual(sortedIDs(got[3:]), sortedIDs(items[3:])) {
+		t.Errorf("shuffled items %v are not the upcoming items %v", got[3:], items[3:])
+	}
+
+	// Changes made while shuffled are kept by unshuffle.
+	state, err := f.player.Enqueue(f.userID, &EnqueueRequest{TrackIDs: f.tracks[:1]})
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	added := state.Queue[len(state.Queue)-1].ID
+	if _, err := f.player.Dequeue(f.userID, items[4]); err != nil {
+		t.Fatalf("Dequeue: %v", err)
+	}
+
+	state = f.set(t, &PlayerUpdateRequest{Shuffle: &off})
+	want := []uuid.UUID{items[0], items[1], items[2], items[3], items[5], added}
+	if got := queueIDs(state); !reflect.DeepEqual(got, want) {
+		t.Errorf("unshuffled queue = %v, want %v", got, want)
+	}
+	if state.Shuffle || state.CurrentIndex != 2 || state.Current.ID != items[2] {
+		t.Errorf("unshuffle: index %d, shuffle %v; want the current item at 2", state.CurrentIndex, state.Shuffle)
+	}
+}
+
+func TestPlayerDequeue(t *testing.T) {
+	tests := []struct {
+		name        string
+		current     int
+		remove      int // -1 for an unknown item
+		wantCurrent int // index into the original queue
+		wantPlaying bool
+		wantErr     error
+	}{
+		{name: "before current", current: 2, remove: 0, wantCurrent: 2, wantPlaying: true},
+		{name: "after current", current: 2, remove: 3, wantCurrent: 2, wantPlaying: true},
+		{name: "current", current: 1, remove: 1, wantCurrent: 2, wantPlaying: true},
+		{name: "current at the end", current: 3, remove: 3, wantCurrent: 2, wantPlaying: false},
+		{name: "unknown item", current: 1, remove: -1, wantErr: ErrQueueItemNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newPlayerFixture(t, 4)
+			items := f.enqueue(t)
+			playing, position := true, 42.0
+			f.set(t, &PlayerUpdateRequest{ItemID: &items[tt.current], Playing: &playing, Position: &position})
+
+			remove := uuid.New()
+			if tt.remove >= 0 {
+				remove = items[tt.remove]
+			}
+			state, err := f.player.Dequeue(f.userID, remove)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Dequeue = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Dequeue: %v", err)
+			}
+
+			if len(state.Queue) != len(items)-1 {
+				t.Fatalf("queue has %d items, want %d", len(state.Queue), len(items)-1)
+			}
+			if state.Current == nil || state.Current.ID != items[tt.wantCurrent] {
+				t.Fatalf("current = %+v, want item %d", state.Current, tt.wantCurrent)
+			}
+			if state.Playing != tt.wantPlaying {
+				t.Errorf("playing = %v, want %v", state.Playing, tt.wantPlaying)
+			}
+			restarted := state.Position < 1
+			if restarted != (tt.remove == tt.current) {
+				t.Errorf("position = %v after removing item %d", state.Position, tt.remove)
+			}
+		})
+	}
+}
+
+func TestPlayerDequeueLastItem(t *testing.T) {
+	f := newPlayerFixture(t, 1)
+	items := f.enqueue(t)
+	playing := true
+	f.set(t, &PlayerUpdateRequest{Playing: &playing})
+
+	state, err := f.player.Dequeue(f.userID, items[0])
+	if err != nil {
+		t.Fatalf("Dequeue: %v", err)
+	}
+	if len(state.Queue) != 0 || state.Current != nil || state.CurrentIndex != -1 || state.Playing {
+		t.Errorf("state after removing the last item = %+v", state)
+	}
+
+	// The next item queued is loaded.
+	state, err = f.player.Enqueue(f.userID, &EnqueueRequest{TrackIDs: f.tracks})
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if state.CurrentIndex != 0 || state.Playing {
+		t.Errorf("after enqueueing: index %d, playing %v; want 0, paused", state.CurrentIndex, state.Playing)
+	}
+}
+
+func TestPlayerNext(t *testing.T) {
+	tests := []struct {
+		name        string
+		current     int
+		repeat      string
+		ended       bool
+		wantCurrent int
+		wantPlaying bool
+	}{
+		{"skip", 0, RepeatOff, false, 1, true},
+		{"ended", 0, RepeatOff, true, 1, true},
+		{"skip with repeat all", 1, RepeatAll, false, 2, true},
+		{"skip with repeat one", 0, RepeatOne, false, 1, true},
+		{"ended with repeat one", 0, RepeatOne, true, 0, true},
+		{"end of queue", 2, RepeatOff, true, 2, false},
+		{"end of queue with repeat all", 2, RepeatAll, true, 0, true},
+		{"skip at end with repeat all", 2, RepeatAll, false, 0, true},
+		{"ended at end with repeat one", 2, RepeatOne, true, 2, true},
+		{"skip at end with repeat one", 2, RepeatOne, false, 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newPlayerFixture(t, 3)
+			items := f.enqueue(t)
+			playing, position, repeat := true, 100.0, tt.repeat
+			f.set(t, &PlayerUpdateRequest{ItemID: &items[tt.current], Playing: &playing, Position: &position, Repeat: &repeat})
+
+			state, err := f.player.Next(f.userID, tt.ended)
+			if err != nil {
+				t.Fatalf("Next: %v", err)
+			}
+			if state.CurrentIndex != tt.wantCurrent || state.Playing != tt.wantPlaying {
+				t.Errorf("Next: index %d, playing %v; want %d, %v", state.CurrentIndex, state.Playing, tt.wantCurrent, tt.wantPlaying)
+			}
+			if state.Position >= 1 {
+				t.Errorf("Next: position = %v, want the start of the track", state.Position)
+			}
+		})
+	}
+}
+
+func TestPlayerPrevious(t *testing.T) {
+	tests := []struct {
+		name        string
+		current     int
+		position    float64
+		repeat      string
+		wantCurrent int
+	}{
+		{"restart", 1, 10, RepeatOff, 1},
+		{"go back", 1, 1, RepeatOff, 0},
+		{"go back with repeat one", 1, 1, RepeatOne, 0},
+		{"start of queue", 0, 1, RepeatOff, 0},
+		{"start of queue with repeat all", 0, 1, RepeatAll, 2},
+		{"restart with repeat all", 0, 10, RepeatAll, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newPlayerFixture(t, 3)
+			items := f.enqueue(t)
+			position, repeat := tt.position, tt.repeat
+			f.set(t, &PlayerUpdateRequest{ItemID: &items[tt.current], Position: &position, Repeat: &repeat})
+
+			state, err := f.player.Previous(f.userID)
+			if err != nil {
+				t.Fatalf("Previous: %v", err)
+			}
+			if state.CurrentIndex != tt.wantCurrent || state.Position != 0 {
+				t.Errorf("Previous: index %d at %v; want %d at 0", state.CurrentIndex, state.Position, tt.wantCurrent)
+			}
+		})
+	}
+}
+
+func TestPlayerReorderQueue(t *testing.T) {
+	f := newPlayerFixture(t, 4)
+	items := f.enqueue(t)
+	f.set(t, &PlayerUpdateRequest{ItemID: &items[1]})
+
+	for _, order := range [][]uuid.UUID{
+		{items[0], items[1], items[2]},
+		{items[0], items[1], items[2], items[3], items[3]},
+		{items[0], items[1], items[2], items[2]},
+		{items[0], items[1], items[2], uuid.New()},
+		nil,
+	} {
+		if _, err := f.player.ReorderQueue(f.userID, order); !errors.Is(err, ErrInvalidQueueOrder) {
+			t.Errorf("ReorderQueue(%v) = %v, want ErrInvalidQueueOrder", order, err)
+		}
+	}
+
+	want := []uuid.UUID{items[3], items[2], items[1], items[0]}
+	state, err := f.player.ReorderQueue(f.userID, want)
+	if err != nil {
+		t.Fatalf("ReorderQueue: %v", err)
+	}
+	if got := queueIDs(state); !reflect.DeepEqual(got, want) {
+		t.Errorf("queue = %v, want %v", got, want)
+	}
+	if state.CurrentIndex != 2 || state.Current.ID != items[1] {
+		t.Errorf("current index = %d, want the current item at 2", state.CurrentIndex)
+	}
+	// Rejected orders did not change the state.
+	if state.Version != 3 {
+		t.Errorf("version = %d, want 3", state.Version)
+	}
+}
+
+func TestPlayerEmptyQueue(t *testing.T) {
+	f := newPlayerFixture(t, 0)
+
+	state, err := f.player.GetState(f.userID)
+	if err != nil {
+		t.Fatalf("GetState: %v", err)
+	}
+	if len(state.Queue) != 0 || state.Current != nil || state.CurrentIndex != -1 || state.Repeat != RepeatOff {
+		t.Errorf("initial state = %+v", state)
+	}
+
+	if _, err := f.player.Next(f.userID, true); err != nil {
+		t.Errorf("Next: %v", err)
+	}
+	if _, err := f.player.Previous(f.userID); err != nil {
+		t.Errorf("Previous: %v", err)
+	}
+	if _, err := f.player.ReorderQueue(f.userID, nil); err != nil {
+		t.Errorf("ReorderQueue: %v", err)
+	}
+	if _, err := f.player.Dequeue(f.userID, uuid.New()); !errors.Is(err, ErrQueueItemNotFound) {
+		t.Errorf("Dequeue = %v, want ErrQueueItemNotFound", err)
+	}
+	playing := true
+	if state := f.set(t, &PlayerUpdateRequest{Playing: &playing}); state.Playing {
+		t.Error("playing with nothing loaded")
+	}
+}
+
+func TestPlayerUpdateRetriesConflicts(t *testing.T) {
+	f := newPlayerFixture(t, 0)
+	repeat := RepeatAll
+
+	// Another device changes the state while the first one is updating it.
+	calls := 0
+	state, err := f.player.update(f.userID, func(state *playerState) error {
+		calls++
+		if calls == 1 {
+			f.set(t, &PlayerUpdateRequest{Repeat: &repeat})
+		}
+		state.Position = 7
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("update applied the change %d times, want 2", calls)
+	}
+	if state.Repeat != RepeatAll || state.Position != 7 || state.Version != 2 {
+		t.Errorf("state = %+v; want both changes", state)
+	}
+
+	calls = 0
+	_, err = f.player.update(f.userID, func(state *playerState) error {
+		calls++
+		f.set(t, &PlayerUpdateRequest{Repeat: &repeat})
+		return nil
+	})
+	if !errors.Is(err, ErrPlayerConflict) || calls != playerTxRetries {
+		t.Errorf("update = %v after %d attempts, want ErrPlayerConflict after %d", err, calls, playerTxRetries)
+	}
+}
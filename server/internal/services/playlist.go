@@ -3,44 +3,137 @@ package services
 import (
 	"errors"
 	"fmt"
+	"time"
 
+	"maxify/internal/config"
 	"maxify/internal/database"
 	"maxify/internal/models"
+	"maxify/internal/pagination"
+	"maxify/internal/rules"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrPlaylistNotFound      = errors.New("playlist not found")
+	ErrPlaylistForbidden     = errors.New("insufficient permissions for playlist")
+	ErrInvalidVisibility     = errors.New("visibility must be private, unlisted or public")
+	ErrCollaboratorNotFound  = errors.New("collaborator not found")
+	ErrPlaylistEntryNotFound = errors.New("playlist entry not found")
+	ErrInvalidRule           = errors.New("invalid smart playlist rule")
+	ErrSmartPlaylist         = errors.New("smart playlist tracks are defined by its rule")
+	ErrPlaylistKind          = errors.New("cannot convert between smart and manual playlists")
+)
+
+// playlistAccess is the level of access an operation needs on a playlist.
+type playlistAccess int
+
+const (
+	accessView playlistAccess = iota
+	accessEdit
+	accessOwner
 )
 
 type PlaylistService struct {
-	db *gorm.DB
+	db      *gorm.DB
+	cursors *pagination.Signer
 }
 
-func NewPlaylistService() *PlaylistService {
+func NewPlaylistService(cfg *config.Config) *PlaylistService {
 	return &PlaylistService{
-		db: database.GetDB(),
+		db:      database.GetDB(),
+		cursors: pagination.NewSigner(cfg.JWT.Secret),
 	}
 }
 
 type CreatePlaylistRequest struct {
 	Name        string `json:"name" binding:"required,min=1,max=100"`
 	Description string `json:"description" binding:"max=500"`
+	Visibility  string `json:"visibility" binding:"omitempty,oneof=private unlisted public"`
+	Rule        string `json:"rule"` // makes a smart playlist
 	UserID      uuid.UUID
 }
 
+// UpdatePlaylistRequest holds the playlist fields the owner can change.
+// Omitted fields are left as they are.
+type UpdatePlaylistRequest struct {
+	Name        *string `json:"name" binding:"omitempty,min=1,max=100"`
+	Description *string `json:"description" binding:"omitempty,max=500"`
+	Visibility  *string `json:"visibility"`
+	Rule        *string `json:"rule"` // smart playlists only
+}
+
+type EntryOrder struct {
+	EntryID uuid.UUID `json:"entry_id" binding:"required"`
+	Order   int       `json:"order"`
+}
+
+type AddCollaboratorRequest struct {
+	Username string `json:"username" binding:"required"`
+	Role     string `json:"role" binding:"required,oneof=viewer editor"`
+}
+
 type PlaylistResponse struct {
-	ID          uuid.UUID        `json:"id"`
-	Name        string           `json:"name"`
-	Description string           `json:"description"`
-	UserID      uuid.UUID        `json:"user_id"`
-	Tracks      []*TrackResponse `json:"tracks,omitempty"`
-	CreatedAt   string           `json:"created_at"`
-	UpdatedAt   string           `json:"updated_at"`
+	ID          uuid.UUID                `json:"id"`
+	Name        string                   `json:"name"`
+	Description string                   `json:"description"`
+	Visibility  string                   `json:"visibility"`
+	Slug        string                   `json:"slug"`
+	Smart       bool                     `json:"smart"`
+	Rule        string                   `json:"rule,omitempty"`
+	UserID      uuid.UUID                `json:"user_id"`
+	Role        string                   `json:"role,omitempty"`
+	Tracks      []*PlaylistTrackResponse `json:"tracks,omitempty"`
+	CreatedAt   string                   `json:"created_at"`
+	UpdatedAt   string                   `json:"updated_at"`
+}
+
+// PlaylistTrackResponse is a track as it appears in a playlist. EntryID
+// tells apart repeated occurrences of the same track. Tracks of smart
+// playlists have no entry, and AddedAt is when they joined the library.
+type PlaylistTrackResponse struct {
+	*TrackResponse
+	EntryID  *uuid.UUID `json:"entry_id,omitempty"`
+	Position int        `json:"position"`
+	AddedAt  time.Time  `json:"added_at"`
+}
+
+type CollaboratorResponse struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	CreatedAt string    `json:"created_at"`
+}
+
+func newPlaylistResponse(playlist *models.Playlist) *PlaylistResponse {
+	return &PlaylistResponse{
+		ID:          playlist.ID,
+		Name:        playlist.Name,
+		Description: playlist.Description,
+		Visibility:  playlist.Visibility,
+		Slug:        playlist.Slug,
+		Smart:       playlist.IsSmart(),
+		Rule:        playlist.Rule,
+		UserID:      playlist.UserID,
+		CreatedAt:   playlist.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+		UpdatedAt:   playlist.UpdatedAt.Format("2006-01-02T15:04:05Z07:00"),
+	}
 }
 
 func (s *PlaylistService) CreatePlaylist(req *CreatePlaylistRequest) (*PlaylistResponse, error) {
+	if req.Rule != "" {
+		if _, err := parseRule(req.Rule); err != nil {
+			return nil, err
+		}
+	}
+
 	playlist := &models.Playlist{
 		Name:        req.Name,
 		Description: req.Description,
+		Visibility:  req.Visibility,
+		Rule:        req.Rule,
 		UserID:      req.UserID,
 	}
 
@@ -48,188 +141,567 @@ func (s *PlaylistService) CreatePlaylist(req *CreatePlaylistRequest) (*PlaylistR
 		return nil, fmt.Errorf("failed to create playlist: %w", err)
 	}
 
-	return &PlaylistResponse{
-		ID:          playlist.ID,
-		Name:        playlist.Name,
-		Description: playlist.Description,
-		UserID:      playlist.UserID,
-		CreatedAt:   playlist.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
-		UpdatedAt:   playlist.UpdatedAt.Format("2006-01-02T15:04:05Z07:00"),
-	}, nil
+	return newPlaylistResponse(playlist), nil
 }
 
-func (s *PlaylistService) GetUserPlaylists(userID uuid.UUID, limit, offset int) ([]*PlaylistResponse, error) {
+// playlistKeyset orders playlist listings newest first.
+var playlistKeyset = pagination.Keyset{Columns: []string{"playlists.created_at", "playlists.id"}, Desc: true}
+
+func playlistKeys(playlist *models.Playlist) []string {
+	return []string{playlist.CreatedAt.Format(time.RFC3339Nano), playlist.ID.String()}
+}
+
+func (s *PlaylistService) GetUserPlaylists(userID uuid.UUID, req *pagination.Request) ([]*PlaylistResponse, *pagination.Page, error) {
+	scope := pagination.Scope("playlists", userID.String())
+	cursor, err := s.cursors.Decode(req.Cursor, scope)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filter := func(db *gorm.DB) *gorm.DB {
+		return db.Model(&models.Playlist{}).Where("user_id = ?", userID)
+	}
+
 	var playlists []models.Playlist
-	if err := s.db.Where("user_id = ?", userID).
+	if err := playlistKeyset.Apply(s.db.Scopes(filter), cursor, req.Limit).Find(&playlists).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to get user playlists: %w", err)
+	}
+
+	playlists, page := pagination.Build(s.cursors, scope, playlists, req.Limit, cursor, playlistKeys)
+	if page.Total, page.TotalEstimated, err = pagination.Count(s.db.Scopes(filter)); err != nil {
+		return nil, nil, fmt.Errorf("failed to count user playlists: %w", err)
+	}
+
+	responses := make([]*PlaylistResponse, 0, len(playlists))
+	for i := range playlists {
+		responses = append(responses, newPlaylistResponse(&playlists[i]))
+	}
+
+	return responses, page, nil
+}
+
+// GetSharedPlaylists lists playlists owned by others on which the user is a
+// collaborator.
+func (s *PlaylistService) GetSharedPlaylists(userID uuid.UUID, limit, offset int) ([]*PlaylistResponse, error) {
+	var collaborations []models.PlaylistCollaborator
+	if err := s.db.Joins("JOIN playlists ON playlists.id = playlist_collaborators.playlist_id AND playlists.deleted_at IS NULL").
+		Where("playlist_collaborators.user_id = ?", userID).
+		Preload("Playlist").
+		Order("playlist_collaborators.created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&collaborations).Error; err != nil {
+		return nil, fmt.Errorf("failed to get shared playlists: %w", err)
+	}
+
+	responses := make([]*PlaylistResponse, 0, len(collaborations))
+	for i := range collaborations {
+		response := newPlaylistResponse(&collaborations[i].Playlist)
+		response.Role = collaborations[i].Role
+		responses = append(responses, response)
+	}
+
+	return responses, nil
+}
+
+// GetPublicPlaylists lists playlists whose owners made them public, newest
+// first.
+func (s *PlaylistService) GetPublicPlaylists(limit, offset int) ([]*PlaylistResponse, error) {
+	var playlists []models.Playlist
+	if err := s.db.Where("visibility = ?", models.PlaylistPublic).
 		Order("created_at DESC").
 		Limit(limit).
 		Offset(offset).
 		Find(&playlists).Error; err != nil {
-		return nil, fmt.Errorf("failed to get user playlists: %w", err)
-	}
-
-	var responses []*PlaylistResponse
-	for _, playlist := range playlists {
-		responses = append(responses, &PlaylistResponse{
-			ID:          playlist.ID,
-			Name:        playlist.Name,
-			Description: playlist.Description,
-			UserID:      playlist.UserID,
-			CreatedAt:   playlist.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
-			UpdatedAt:   playlist.UpdatedAt.Format("2006-01-02T15:04:05Z07:00"),
-		})
+		return nil, fmt.Errorf("failed to get public playlists: %w", err)
+	}
+
+	responses := make([]*PlaylistResponse, 0, len(playlists))
+	for i := range playlists {
+		responses = append(responses, newPlaylistResponse(&playlists[i]))
 	}
 
 	return responses, nil
 }
 
 func (s *PlaylistService) GetPlaylistByID(playlistID, userID uuid.UUID) (*PlaylistResponse, error) {
+	playlist, role, err := s.authorize(playlistID, userID, accessView)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := s.playlistWithTracks(playlist)
+	if err != nil {
+		return nil, err
+	}
+	response.Role = role
+
+	return response, nil
+}
+
+// GetPlaylistBySlug returns a shared playlist without authentication. Only
+// unlisted and public playlists can be read this way.
+func (s *PlaylistService) GetPlaylistBySlug(slug string) (*PlaylistResponse, error) {
 	var playlist models.Playlist
-	if err := s.db.Where("id = ? AND user_id = ?", playlistID, userID).
-		Preload("Tracks").
+	if err := s.db.Where("slug = ? AND visibility IN ?", slug, []string{models.PlaylistUnlisted, models.PlaylistPublic}).
 		First(&playlist).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("playlist not found")
+			return nil, ErrPlaylistNotFound
 		}
 		return nil, fmt.Errorf("failed to get playlist: %w", err)
 	}
 
-	var tracks []*TrackResponse
-	for _, track := range playlist.Tracks {
-		tracks = append(tracks, &TrackResponse{
-			ID:        track.ID,
-			Title:     track.Title,
-			Artist:    track.Artist,
-			Duration:  track.Duration,
-			FileSize:  track.FileSize,
-			MimeType:  track.MimeType,
-			CreatedAt: track.CreatedAt,
+	return s.playlistWithTracks(&playlist)
+}
+
+func (s *PlaylistService) playlistWithTracks(playlist *models.Playlist) (*PlaylistResponse, error) {
+	if playlist.IsSmart() {
+		return s.smartPlaylistWithTracks(playlist)
+	}
+
+	var entries []models.PlaylistTrack
+	if err := s.db.Joins("JOIN tracks ON tracks.id = playlist_tracks.track_id AND tracks.deleted_at IS NULL").
+		Where("playlist_tracks.playlist_id = ?", playlist.ID).
+		Preload("Track").
+		Order(`playlist_tracks."order" ASC, playlist_tracks.added_at ASC, playlist_tracks.id ASC`).
+		Find(&entries).Error; err != nil {
+		return nil, fmt.Errorf("failed to get playlist tracks: %w", err)
+	}
+
+	response := newPlaylistResponse(playlist)
+	for i := range entries {
+		response.Tracks = append(response.Tracks, &PlaylistTrackResponse{
+			TrackResponse: newTrackResponse(&entries[i].Track),
+			EntryID:       &entries[i].ID,
+			Position:      i + 1,
+			AddedAt:       entries[i].AddedAt,
 		})
 	}
 
-	return &PlaylistResponse{
-		ID:          playlist.ID,
-		Name:        playlist.Name,
-		Description: playlist.Description,
-		UserID:      playlist.UserID,
-		Tracks:      tracks,
-		CreatedAt:   playlist.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
-		UpdatedAt:   playlist.UpdatedAt.Format("2006-01-02T15:04:05Z07:00"),
-	}, nil
+	return response, nil
 }
 
-func (s *PlaylistService) UpdatePlaylist(playlistID, userID uuid.UUID, updates map[string]interface{}) (*PlaylistResponse, error) {
-	var playlist models.Playlist
-	if err := s.db.Where("id = ? AND user_id = ?", playlistID, userID).First(&playlist).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("playlist not found")
+// smartPlaylistWithTracks evaluates the rule of a smart playlist against
+// the owner's library, so the result always reflects the current tracks.
+func (s *PlaylistService) smartPlaylistWithTracks(playlist *models.Playlist) (*PlaylistResponse, error) {
+	rule, err := parseRule(playlist.Rule)
+	if err != nil {
+		return nil, err
+	}
+
+	var tracks []models.Track
+	if err := rule.Apply(s.db.Where("tracks.user_id = ?", playlist.UserID), time.Now()).
+		Find(&tracks).Error; err != nil {
+		return nil, fmt.Errorf("failed to evaluate smart playlist: %w", err)
+	}
+
+	response := newPlaylistResponse(playlist)
+	for i := range tracks {
+		response.Tracks = append(response.Tracks, &PlaylistTrackResponse{
+			TrackResponse: newTrackResponse(&tracks[i]),
+			Position:      i + 1,
+			AddedAt:       tracks[i].CreatedAt,
+		})
+	}
+
+	return response, nil
+}
+
+func parseRule(src string) (*rules.Rule, error) {
+	rule, err := rules.Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRule, err)
+	}
+	return rule, nil
+}
+
+func (s *PlaylistService) UpdatePlaylist(playlistID, userID uuid.UUID, req *UpdatePlaylistRequest) (*PlaylistResponse, error) {
+	playlist, _, err := s.authorize(playlistID, userID, accessOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	var columns []string
+	if req.Name != nil {
+		playlist.Name = *req.Name
+		columns = append(columns, "name")
+	}
+	if req.Description != nil {
+		playlist.Description = *req.Description
+		columns = append(columns, "description")
+	}
+	if req.Visibility != nil {
+		switch *req.Visibility {
+		case models.PlaylistPrivate, models.PlaylistUnlisted, models.PlaylistPublic:
+		default:
+			return nil, ErrInvalidVisibility
 		}
-		return nil, fmt.Errorf("failed to get playlist: %w", err)
+		playlist.Visibility = *req.Visibility
+		columns = append(columns, "visibility")
+	}
+	if req.Rule != nil {
+		if !playlist.IsSmart() || *req.Rule == "" {
+			return nil, ErrPlaylistKind
+		}
+		if _, err := parseRule(*req.Rule); err != nil {
+			return nil, err
+		}
+		playlist.Rule = *req.Rule
+		columns = append(columns, "rule")
+	}
+	if len(columns) == 0 {
+		return newPlaylistResponse(playlist), nil
 	}
 
-	if err := s.db.Model(&playlist).Updates(updates).Error; err != nil {
+	if err := s.db.Model(playlist).Select(columns).Updates(playlist).Error; err != nil {
 		return nil, fmt.Errorf("failed to update playlist: %w", err)
 	}
 
-	return &PlaylistResponse{
-		ID:          playlist.ID,
-		Name:        playlist.Name,
-		Description: playlist.Description,
-		UserID:      playlist.UserID,
-		CreatedAt:   playlist.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
-		UpdatedAt:   playlist.UpdatedAt.Format("2006-01-02T15:04:05Z07:00"),
-	}, nil
+	return newPlaylistResponse(playlist), nil
 }
 
 func (s *PlaylistService) DeletePlaylist(playlistID, userID uuid.UUID) error {
-	var playlist models.Playlist
-	if err := s.db.Where("id = ? AND user_id = ?", playlistID, userID).First(&playlist).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return errors.New("playlist not found")
-		}
-		return fmt.Errorf("failed to get playlist: %w", err)
+	playlist, _, err := s.authorize(playlistID, userID, accessOwner)
+	if err != nil {
+		return err
 	}
 
-	if err := s.db.Delete(&playlist).Error; err != nil {
+	if err := s.db.Delete(playlist).Error; err != nil {
 		return fmt.Errorf("failed to delete playlist: %w", err)
 	}
 
 	return nil
 }
 
-func (s *PlaylistService) AddTrackToPlaylist(playlistID, trackID, userID uuid.UUID) error {
-	var playlist models.Playlist
-	if err := s.db.Where("id = ? AND user_id = ?", playlistID, userID).First(&playlist).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return errors.New("playlist not found")
-		}
-		return fmt.Errorf("failed to get playlist: %w", err)
+// AddTrackToPlaylist appends one of the user's own tracks to the playlist.
+// Collaborators with the editor role may add tracks to playlists they do not
+// own. The same track may be added more than once.
+func (s *PlaylistService) AddTrackToPlaylist(playlistID, trackID, userID uuid.UUID) (*PlaylistTrackResponse, error) {
+	if err := s.authorizeEntryEdit(playlistID, userID); err != nil {
+		return nil, err
 	}
 
 	var track models.Track
 	if err := s.db.Where("id = ? AND user_id = ?", trackID, userID).First(&track).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return errors.New("track not found")
+			return nil, ErrTrackNotFound
 		}
-		return fmt.Errorf("failed to get track: %w", err)
+		return nil, fmt.Errorf("failed to get track: %w", err)
 	}
 
-	var existingPlaylistTrack models.PlaylistTrack
-	if err := s.db.Where("playlist_id = ? AND track_id = ?", playlistID, trackID).First(&existingPlaylistTrack).Error; err == nil {
-		return errors.New("track already in playlist")
-	}
-
-	var maxOrder int
-	s.db.Model(&models.PlaylistTrack{}).Where("playlist_id = ?", playlistID).Select("COALESCE(MAX(\"order\"), 0)").Scan(&maxOrder)
-
 	playlistTrack := &models.PlaylistTrack{
 		PlaylistID: playlistID,
 		TrackID:    trackID,
-		Order:      maxOrder + 1,
 	}
 
-	if err := s.db.Create(playlistTrack).Error; err != nil {
-		return fmt.Errorf("failed to add track to playlist: %w", err)
+	var position int
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		entries, err := lockPlaylistTracks(tx, playlistID)
+		if err != nil {
+			return err
+		}
+
+		// Entries of tracks in the trash keep their order, so the new one
+		// goes after them too and stays last when they are restored.
+		var last int
+		if err := tx.Unscoped().Model(&models.PlaylistTrack{}).
+			Where("playlist_id = ?", playlistID).
+			Select(`COALESCE(MAX("order"), 0)`).
+			Scan(&last).Error; err != nil {
+			return fmt.Errorf("failed to get playlist order: %w", err)
+		}
+		playlistTrack.Order = last + 1
+		position = len(entries) + 1
+
+		if err := tx.Omit("Playlist", "Track").Create(playlistTrack).Error; err != nil {
+			return fmt.Errorf("failed to add track to playlist: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &PlaylistTrackResponse{
+		TrackResponse: newTrackResponse(&track),
+		EntryID:       &playlistTrack.ID,
+		Position:      position,
+		AddedAt:       playlistTrack.AddedAt,
+	}, nil
+}
+
+// RemoveTrackFromPlaylist removes a single entry, leaving other occurrences
+// of the same track in place.
+func (s *PlaylistService) RemoveTrackFromPlaylist(playlistID, entryID, userID uuid.UUID) error {
+	if err := s.authorizeEntryEdit(playlistID, userID); err != nil {
+		return err
+	}
+
+	result := s.db.Unscoped().Where("id = ? AND playlist_id = ?", entryID, playlistID).Delete(&models.PlaylistTrack{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to remove track from playlist: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrPlaylistEntryNotFound
+	}
+
+	return nil
+}
+
+// MoveEntry moves an entry to a 1-based position in the playlist, shifting
+// the entries in between, and renumbers the whole playlist in one
+// transaction. Positions past the end move the entry to the end.
+func (s *PlaylistService) MoveEntry(playlistID, entryID, userID uuid.UUID, position int) error {
+	if err := s.authorizeEntryEdit(playlistID, userID); err != nil {
+		return err
 	}
 
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		entries, err := lockPlaylistTracks(tx, playlistID)
+		if err != nil {
+			return err
+		}
+
+		from := -1
+		for i := range entries {
+			if entries[i].ID == entryID {
+				from = i
+				break
+			}
+		}
+		if from < 0 {
+			return ErrPlaylistEntryNotFound
+		}
+
+		to := position - 1
+		if to < 0 {
+			to = 0
+		}
+		if to >= len(entries) {
+			to = len(entries) - 1
+		}
+
+		moved := entries[from]
+		entries = append(entries[:from], entries[from+1:]...)
+		entries = append(entries[:to], append([]models.PlaylistTrack{moved}, entries[to:]...)...)
+
+		return renumberPlaylistTracks(tx, entries)
+	})
+}
+
+// ReorderPlaylistTracks assigns new order values to the given entries, then
+// renumbers the playlist so positions stay contiguous.
+func (s *PlaylistService) ReorderPlaylistTracks(playlistID, userID uuid.UUID, entryOrders []EntryOrder) error {
+	if err := s.authorizeEntryEdit(playlistID, userID); err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockPlaylist(tx, playlistID); err != nil {
+			return err
+		}
+
+		for _, entryOrder := range entryOrders {
+			if err := tx.Model(&models.PlaylistTrack{}).
+				Where("id = ? AND playlist_id = ?", entryOrder.EntryID, playlistID).
+				Update("order", entryOrder.Order).Error; err != nil {
+				return fmt.Errorf("failed to update track order: %w", err)
+			}
+		}
+
+		entries, err := lockPlaylistTracks(tx, playlistID)
+		if err != nil {
+			return err
+		}
+		return renumberPlaylistTracks(tx, entries)
+	})
+}
+
+// authorizeEntryEdit checks that the user may add, remove or reorder the
+// playlist's entries. Smart playlists have no entries to edit.
+func (s *PlaylistService) authorizeEntryEdit(playlistID, userID uuid.UUID) error {
+	playlist, _, err := s.authorize(playlistID, userID, accessEdit)
+	if err != nil {
+		return err
+	}
+	if playlist.IsSmart() {
+		return ErrSmartPlaylist
+	}
 	return nil
 }
 
-func (s *PlaylistService) RemoveTrackFromPlaylist(playlistID, trackID, userID uuid.UUID) error {
+// lockPlaylist takes a row lock on the playlist so that concurrent edits of
+// its track order are serialized.
+func lockPlaylist(tx *gorm.DB, playlistID uuid.UUID) error {
 	var playlist models.Playlist
-	if err := s.db.Where("id = ? AND user_id = ?", playlistID, userID).First(&playlist).Error; err != nil {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", playlistID).
+		First(&playlist).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return errors.New("playlist not found")
+			return ErrPlaylistNotFound
 		}
-		return fmt.Errorf("failed to get playlist: %w", err)
+		return fmt.Errorf("failed to lock playlist: %w", err)
+	}
+	return nil
+}
+
+// lockPlaylistTracks locks the playlist and returns its entries in stored
+// order, skipping deleted tracks just like playlist reads do.
+func lockPlaylistTracks(tx *gorm.DB, playlistID uuid.UUID) ([]models.PlaylistTrack, error) {
+	if err := lockPlaylist(tx, playlistID); err != nil {
+		return nil, err
 	}
 
-	if err := s.db.Where("playlist_id = ? AND track_id = ?", playlistID, trackID).Delete(&models.PlaylistTrack{}).Error; err != nil {
-		return fmt.Errorf("failed to remove track from playlist: %w", err)
+	var entries []models.PlaylistTrack
+	if err := tx.Joins("JOIN tracks ON tracks.id = playlist_tracks.track_id AND tracks.deleted_at IS NULL").
+		Where("playlist_tracks.playlist_id = ?", playlistID).
+		Order(`playlist_tracks."order" ASC, playlist_tracks.added_at ASC, playlist_tracks.id ASC`).
+		Find(&entries).Error; err != nil {
+		return nil, fmt.Errorf("failed to get playlist tracks: %w", err)
 	}
+	return entries, nil
+}
 
+func renumberPlaylistTracks(tx *gorm.DB, entries []models.PlaylistTrack) error {
+	for i := range entries {
+		if entries[i].Order == i+1 {
+			continue
+		}
+		if err := tx.Model(&models.PlaylistTrack{}).
+			Where("id = ?", entries[i].ID).
+			Update("order", i+1).Error; err != nil {
+			return fmt.Errorf("failed to update track order: %w", err)
+		}
+	}
 	return nil
 }
 
-func (s *PlaylistService) ReorderPlaylistTracks(playlistID, userID uuid.UUID, trackOrders []struct {
-	TrackID uuid.UUID `json:"track_id"`
-	Order   int       `json:"order"`
-}) error {
+func (s *PlaylistService) GetCollaborators(playlistID, userID uuid.UUID) ([]*CollaboratorResponse, error) {
+	if _, _, err := s.authorize(playlistID, userID, accessView); err != nil {
+		return nil, err
+	}
+
+	var collaborators []models.PlaylistCollaborator
+	if err := s.db.Where("playlist_id = ?", playlistID).
+		Preload("User").
+		Order("created_at ASC").
+		Find(&collaborators).Error; err != nil {
+		return nil, fmt.Errorf("failed to get collaborators: %w", err)
+	}
+
+	responses := make([]*CollaboratorResponse, 0, len(collaborators))
+	for i := range collaborators {
+		responses = append(responses, newCollaboratorResponse(&collaborators[i]))
+	}
+
+	return responses, nil
+}
+
+// AddCollaborator shares the playlist with another user, or changes the role
+// of an existing collaborator.
+func (s *PlaylistService) AddCollaborator(playlistID, userID uuid.UUID, req *AddCollaboratorRequest) (*CollaboratorResponse, error) {
+	playlist, _, err := s.authorize(playlistID, userID, accessOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	if err := s.db.Where("username = ?", req.Username).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("user not found")
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if user.ID == playlist.UserID {
+		return nil, errors.New("playlist owner cannot be a collaborator")
+	}
+
+	collaborator := &models.PlaylistCollaborator{
+		PlaylistID: playlistID,
+		UserID:     user.ID,
+		Role:       req.Role,
+		User:       user,
+	}
+	if err := s.db.Omit("User", "Playlist").Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "playlist_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"role", "updated_at"}),
+	}).Create(collaborator).Error; err != nil {
+		return nil, fmt.Errorf("failed to add collaborator: %w", err)
+	}
+
+	return newCollaboratorResponse(collaborator), nil
+}
+
+// RemoveCollaborator revokes a collaborator's access. Owners can remove
+// anyone; collaborators can remove themselves to leave a playlist.
+func (s *PlaylistService) RemoveCollaborator(playlistID, collaboratorID, userID uuid.UUID) error {
+	need := accessOwner
+	if collaboratorID == userID {
+		need = accessView
+	}
+	if _, _, err := s.authorize(playlistID, userID, need); err != nil {
+		return err
+	}
+
+	result := s.db.Where("playlist_id = ? AND user_id = ?", playlistID, collaboratorID).Delete(&models.PlaylistCollaborator{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to remove collaborator: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrCollaboratorNotFound
+	}
+
+	return nil
+}
+
+func newCollaboratorResponse(collaborator *models.PlaylistCollaborator) *CollaboratorResponse {
+	return &CollaboratorResponse{
+		UserID:    collaborator.UserID,
+		Username:  collaborator.User.Username,
+		Role:      collaborator.Role,
+		CreatedAt: collaborator.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+	}
+}
+
+// authorize loads a playlist and checks that the user has at least the
+// requested access, returning the user's role on it ("owner", "editor" or
+// "viewer", or "" for non-collaborators reading a shared playlist). Private
+// playlists the user cannot see are reported as not found.
+func (s *PlaylistService) authorize(playlistID, userID uuid.UUID, need playlistAccess) (*models.Playlist, string, error) {
 	var playlist models.Playlist
-	if err := s.db.Where("id = ? AND user_id = ?", playlistID, userID).First(&playlist).Error; err != nil {
+	if err := s.db.Where("id = ?", playlistID).First(&playlist).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return errors.New("playlist not found")
+			return nil, "", ErrPlaylistNotFound
 		}
-		return fmt.Errorf("failed to get playlist: %w", err)
+		return nil, "", fmt.Errorf("failed to get playlist: %w", err)
 	}
 
-	for _, trackOrder := range trackOrders {
-		if err := s.db.Model(&models.PlaylistTrack{}).
-			Where("playlist_id = ? AND track_id = ?", playlistID, trackOrder.TrackID).
-			Update("order", trackOrder.Order).Error; err != nil {
-			return fmt.Errorf("failed to update track order: %w", err)
+	if playlist.UserID == userID {
+		return &playlist, "owner", nil
+	}
+
+	var role string
+	var collaborator models.PlaylistCollaborator
+	err := s.db.Where("playlist_id = ? AND user_id = ?", playlistID, userID).First(&collaborator).Error
+	switch {
+	case err == nil:
+		role = collaborator.Role
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, "", fmt.Errorf("failed to get collaborator: %w", err)
+	case playlist.Visibility == models.PlaylistPrivate:
+		return nil, "", ErrPlaylistNotFound
+	}
+
+	switch need {
+	case accessView:
+	case accessEdit:
+		if role != models.CollaboratorEditor {
+			return nil, "", ErrPlaylistForbidden
 		}
+	default:
+		return nil, "", ErrPlaylistForbidden
 	}
 
-	return nil
+	return &playlist, role, nil
 }
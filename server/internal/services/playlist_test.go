@@ -0,0 +1,77 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"maxify/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// TestAddTrackAfterTrashedEntries adds a track while an earlier entry is
+// in the trash, which must not take the new one's place on restore.
+func TestAddTrackAfterTrashedEntries(t *testing.T) {
+	db := newTestDB(t, &models.User{}, &models.Track{}, &models.Playlist{}, &models.PlaylistTrack{}, &models.PlaylistCollaborator{})
+	playlists := &PlaylistService{db: db}
+	tracks := &TrackService{db: db}
+	trash := &TrashService{db: db}
+
+	user := &models.User{Username: "curator", Email: "curator@example.com", PasswordHash: "x"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	playlist := &models.Playlist{Name: "Mix", UserID: user.ID}
+	if err := db.Create(playlist).Error; err != nil {
+		t.Fatalf("failed to create playlist: %v", err)
+	}
+	var ids []uuid.UUID
+	for _, title := range []string{"A", "B", "C", "D"} {
+		track := &models.Track{Title: title, UserID: user.ID}
+		if err := db.Create(track).Error; err != nil {
+			t.Fatalf("failed to create track: %v", err)
+		}
+		ids = append(ids, track.ID)
+	}
+	add := func(trackID uuid.UUID) {
+		t.Helper()
+		if _, err := playlists.AddTrackToPlaylist(playlist.ID, trackID, user.ID); err != nil {
+			t.Fatalf("AddTrackToPlaylist: %v", err)
+		}
+	}
+
+	add(ids[0])
+	add(ids[1])
+	add(ids[2])
+	if err := tracks.DeleteTrack(ids[2], user.ID); err != nil {
+		t.Fatalf("DeleteTrack: %v", err)
+	}
+	add(ids[3])
+	if _, err := trash.RestoreTrack(ids[2], user.ID); err != nil {
+		t.Fatalf("RestoreTrack: %v", err)
+	}
+
+	var entries []models.PlaylistTrack
+	if err := db.Where("playlist_id = ?", playlist.ID).Find(&entries).Error; err != nil {
+		t.Fatalf("failed to get entries: %v", err)
+	}
+	orders := make(map[uuid.UUID]int)
+	for _, entry := range entries {
+		orders[entry.TrackID] = entry.Order
+	}
+	if orders[ids[3]] <= orders[ids[2]] {
+		t.Errorf("entry added while C was in the trash has order %d, C has %d", orders[ids[3]], orders[ids[2]])
+	}
+
+	response, err := playlists.GetPlaylistByID(playlist.ID, user.ID)
+	if err != nil {
+		t.Fatalf("GetPlaylistByID: %v", err)
+	}
+	var got []string
+	for _, entry := range response.Tracks {
+		got = append(got, entry.Title)
+	}
+	if want := []string{"A", "B", "C", "D"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("playlist = %v, want %v", got, want)
+	}
+}
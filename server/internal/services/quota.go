@@ -0,0 +1,295 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"maxify/internal/config"
+	"maxify/internal/database"
+	"maxify/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+	ErrSizeRequired  = errors.New("upload size must be known in advance")
+	ErrUnknownPlan   = errors.New("unknown plan")
+	ErrUserNotFound  = errors.New("user not found")
+)
+
+// reserveUploadScript reserves space for a resumable upload if the user's
+// stored bytes and tracks, plus those of their other uploads in progress,
+// leave room for it. Returns 1 on success, 0 if the upload does not fit
+// in the byte quota and -1 if it does not fit in the track quota.
+var reserveUploadScript = redis.NewScript(`
+local bytes, tracks = tonumber(ARGV[3]), tonumber(ARGV[5])
+for _, size in ipairs(redis.call('HVALS', KEYS[1])) do
+	bytes = bytes + tonumber(size)
+	tracks = tracks + 1
+end
+if tonumber(ARGV[4]) > 0 and bytes + tonumber(ARGV[2]) > tonumber(ARGV[4]) then
+	return 0
+end
+if tonumber(ARGV[6]) > 0 and tracks + 1 > tonumber(ARGV[6]) then
+	return -1
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+redis.call('EXPIRE', KEYS[1], ARGV[7])
+return 1
+`)
+
+type QuotaService struct {
+	config *config.Config
+	db     *gorm.DB
+	redis  *redis.Client
+}
+
+func NewQuotaService(cfg *config.Config) *QuotaService {
+	return &QuotaService{
+		config: cfg,
+		db:     database.GetDB(),
+		redis:  database.GetRedis(),
+	}
+}
+
+// StorageUsage is what a user stores against their quota. Tracks in the
+// trash count until they are purged, and resumable uploads in progress
+// count as pending. Limits and remaining amounts are null when unlimited.
+type StorageUsage struct {
+	Plan            string `json:"plan"`
+	BytesUsed       int64  `json:"bytes_used"`
+	BytesPending    int64  `json:"bytes_pending"`
+	BytesLimit      *int64 `json:"bytes_limit"`
+	BytesRemaining  *int64 `json:"bytes_remaining"`
+	TracksUsed      int64  `json:"tracks_used"`
+	TracksLimit     *int64 `json:"tracks_limit"`
+	TracksRemaining *int64 `json:"tracks_remaining"`
+}
+
+// QuotaUpdateRequest replaces a user's plan and quota overrides. An empty
+// plan is the default plan, and a null quota uses the plan's.
+type QuotaUpdateRequest struct {
+	Plan         string `json:"plan" binding:"max=32"`
+	StorageQuota *int64 `json:"storage_quota" binding:"omitempty,min=0"`
+	TrackQuota   *int64 `json:"track_quota" binding:"omitempty,min=0"`
+}
+
+func uploadReservationsKey(userID uuid.UUID) string {
+	return fmt.Sprintf("uploads:reserved:%s", userID)
+}
+
+// limits returns the user's plan and quota, with per-user overrides
+// applied.
+func (s *QuotaService) limits(user *models.User) (string, config.PlanQuota) {
+	plan := user.Plan
+	if plan == "" {
+		plan = s.config.Quota.DefaultPlan
+	}
+	quota := s.config.Quota.Plans[plan]
+	if user.StorageQuota != nil {
+		quota.StorageBytes = *user.StorageQuota
+	}
+	if user.TrackQuota != nil {
+		quota.Tracks = *user.TrackQuota
+	}
+	return plan, quota
+}
+
+// used returns the bytes and tracks the user stores, including the trash.
+func used(db *gorm.DB, userID uuid.UUID) (bytes, tracks int64, err error) {
+	var usage struct {
+		Bytes  int64
+		Tracks int64
+	}
+	if err := db.Unscoped().Model(&models.Track{}).
+		Select("COALESCE(SUM(file_size), 0) AS bytes, COUNT(*) AS tracks").
+		Where("user_id = ?", userID).
+		Scan(&usage).Error; err != nil {
+		return 0, 0, fmt.Errorf("failed to get storage usage: %w", err)
+	}
+	return usage.Bytes, usage.Tracks, nil
+}
+
+// pending returns the bytes and tracks reserved by the user's resumable
+// uploads in progress, except the upload exclude.
+func (s *QuotaService) pending(ctx context.Context, userID, exclude uuid.UUID) (bytes, tracks int64, err error) {
+	reserved, err := s.redis.HGetAll(ctx, uploadReservationsKey(userID)).Result()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get pending uploads: %w", err)
+	}
+	for uploadID, size := range reserved {
+		if uploadID == exclude.String() {
+			continue
+		}
+		n, _ := strconv.ParseInt(size, 10, 64)
+		bytes += n
+		tracks++
+	}
+	return bytes, tracks, nil
+}
+
+func (s *QuotaService) getUser(db *gorm.DB, userID uuid.UUID) (*models.User, error) {
+	var user models.User
+	if err := db.First(&user, "id = ?", userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	return &user, nil
+}
+
+func (s *QuotaService) GetUsage(userID uuid.UUID) (*StorageUsage, error) {
+	user, err := s.getUser(s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	plan, quota := s.limits(user)
+
+	usage := &StorageUsage{Plan: plan}
+	if usage.BytesUsed, usage.TracksUsed, err = used(s.db, userID); err != nil {
+		return nil, err
+	}
+	var tracksPending int64
+	if usage.BytesPending, tracksPending, err = s.pending(context.Background(), userID, uuid.Nil); err != nil {
+		return nil, err
+	}
+
+	if quota.StorageBytes > 0 {
+		remaining := quota.StorageBytes - usage.BytesUsed - usage.BytesPending
+		if remaining < 0 {
+			remaining = 0
+		}
+		usage.BytesLimit, usage.BytesRemaining = &quota.StorageBytes, &remaining
+	}
+	if quota.Tracks > 0 {
+		remaining := quota.Tracks - usage.TracksUsed - tracksPending
+		if remaining < 0 {
+			remaining = 0
+		}
+		usage.TracksLimit, usage.TracksRemaining = &quota.Tracks, &remaining
+	}
+	return usage, nil
+}
+
+// Check returns an error wrapping ErrQuotaExceeded if the user has no room
+// for another track of size bytes. It is a quick check made before an
+// upload is read; enforce is the one that counts. It returns the bytes
+// remaining in the quota, or -1 if there is no byte quota. A negative size
+// is unknown, which is ErrSizeRequired under a byte quota.
+func (s *QuotaService) Check(userID uuid.UUID, size int64) (int64, error) {
+	usage, err := s.GetUsage(userID)
+	if err != nil {
+		return 0, err
+	}
+	if usage.TracksRemaining != nil && *usage.TracksRemaining < 1 {
+		return 0, fmt.Errorf("%w: %d of %d tracks used", ErrQuotaExceeded, usage.TracksUsed, *usage.TracksLimit)
+	}
+	if usage.BytesRemaining == nil {
+		return -1, nil
+	}
+	if size < 0 {
+		return 0, ErrSizeRequired
+	}
+	if size > *usage.BytesRemaining {
+		return 0, fmt.Errorf("%w: %d bytes remaining", ErrQuotaExceeded, *usage.BytesRemaining)
+	}
+	return *usage.BytesRemaining, nil
+}
+
+// enforce returns an error wrapping ErrQuotaExceeded if a track of size
+// bytes would take the user over quota, counting the space reserved by
+// resumable uploads other than uploadID, which is uuid.Nil for uploads in
+// a single request. In a transaction it locks the user's row, so
+// concurrent uploads by the same user are checked one at a time against
+// the tracks they create.
+func (s *QuotaService) enforce(db *gorm.DB, userID, uploadID uuid.UUID, size int64) error {
+	user, err := s.getUser(db.Clauses(clause.Locking{Strength: "UPDATE"}), userID)
+	if err != nil {
+		return err
+	}
+	_, quota := s.limits(user)
+	if quota.StorageBytes == 0 && quota.Tracks == 0 {
+		return nil
+	}
+
+	bytes, tracks, err := used(db, userID)
+	if err != nil {
+		return err
+	}
+	pendingBytes, pendingTracks, err := s.pending(context.Background(), userID, uploadID)
+	if err != nil {
+		return err
+	}
+	bytes, tracks = bytes+pendingBytes, tracks+pendingTracks
+	if quota.StorageBytes > 0 && bytes+size > quota.StorageBytes {
+		return fmt.Errorf("%w: %d of %d bytes used", ErrQuotaExceeded, bytes, quota.StorageBytes)
+	}
+	if quota.Tracks > 0 && tracks+1 > quota.Tracks {
+		return fmt.Errorf("%w: %d of %d tracks used", ErrQuotaExceeded, tracks, quota.Tracks)
+	}
+	return nil
+}
+
+// reserve holds size bytes and one track of the user's quota for a
+// resumable upload, so that uploads started at the same time cannot
+// together go over quota. The reservation lasts until release, or ttl as
+// a safety net.
+func (s *QuotaService) reserve(ctx context.Context, uploadID, userID uuid.UUID, size int64, ttl int) error {
+	user, err := s.getUser(s.db, userID)
+	if err != nil {
+		return err
+	}
+	_, quota := s.limits(user)
+	bytes, tracks, err := used(s.db, userID)
+	if err != nil {
+		return err
+	}
+
+	result, err := reserveUploadScript.Run(ctx, s.redis,
+		[]string{uploadReservationsKey(userID)},
+		uploadID.String(), size, bytes, quota.StorageBytes, tracks, quota.Tracks, ttl,
+	).Int()
+	if err != nil {
+		return fmt.Errorf("failed to reserve quota: %w", err)
+	}
+	switch result {
+	case 0:
+		return fmt.Errorf("%w: %d of %d bytes used", ErrQuotaExceeded, bytes, quota.StorageBytes)
+	case -1:
+		return fmt.Errorf("%w: %d of %d tracks used", ErrQuotaExceeded, tracks, quota.Tracks)
+	}
+	return nil
+}
+
+// release drops the reservation of a resumable upload.
+func (s *QuotaService) release(ctx context.Context, uploadID, userID uuid.UUID) error {
+	return s.redis.HDel(ctx, uploadReservationsKey(userID), uploadID.String()).Err()
+}
+
+// SetUserQuota changes a user's plan and quota overrides.
+func (s *QuotaService) SetUserQuota(userID uuid.UUID, req *QuotaUpdateRequest) (*StorageUsage, error) {
+	if _, ok := s.config.Quota.Plans[req.Plan]; !ok && req.Plan != "" && req.Plan != s.config.Quota.DefaultPlan {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPlan, req.Plan)
+	}
+
+	user, err := s.getUser(s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.Model(user).Select("plan", "storage_quota", "track_quota").Updates(&models.User{
+		Plan:         req.Plan,
+		StorageQuota: req.StorageQuota,
+		TrackQuota:   req.TrackQuota,
+	}).Error; err != nil {
+		return nil, fmt.Errorf("failed to update quota: %w", err)
+	}
+
+	return s.GetUsage(userID)
+}
@@ -1,23 +1,36 @@
 package services
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
+	"maxify/internal/config"
 	"maxify/internal/database"
 	"maxify/internal/models"
+	"maxify/internal/pagination"
 
 	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 )
 
 type SearchService struct {
-	db *gorm.DB
+	db      *gorm.DB
+	redis   *redis.Client
+	cursors *pagination.Signer
 }
 
-func NewSearchService() *SearchService {
+func NewSearchService(cfg *config.Config) *SearchService {
 	return &SearchService{
-		db: database.GetDB(),
+		db:      database.GetDB(),
+		redis:   database.GetRedis(),
+		cursors: pagination.NewSigner(cfg.JWT.Secret),
 	}
 }
 
@@ -25,149 +38,379 @@ type SearchRequest struct {
 	Query  string `json:"query" binding:"required,min=1"`
 	UserID uuid.UUID
 	Limit  int
-	Offset int
 }
 
+// searchConfig is the text search configuration of the search_vector
+// columns created in database.createSearchIndexes.
+const searchConfig = "english"
+
+// ts_headline options. Matched words are wrapped in <mark> tags; short fields
+// are returned whole, long ones are cut down to the fragments around matches.
+const (
+	headlineShort = "StartSel=<mark>, StopSel=</mark>, HighlightAll=true"
+	headlineLong  = "StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=20, MinWords=5"
+)
+
+// Fuzzy matching. Terms whose pg_trgm word similarity to the query is below
+// fuzzyThreshold are not suggested. Each past search for a term adds
+// frequencyWeight*ln(1+n) to its score, so common searches rank higher.
+const (
+	fuzzyThreshold     = 0.3
+	frequencyWeight    = 0.1
+	maxTrackedSearches = 500
+	searchHistoryTTL   = 90 * 24 * time.Hour
+)
+
+// SearchResponse holds the first page of each result type. Their Next
+// cursors continue on /search/tracks and /search/playlists.
 type SearchResponse struct {
-	Tracks    []*TrackResponse    `json:"tracks"`
-	Playlists []*PlaylistResponse `json:"playlists"`
-	Total     int                 `json:"total"`
+	Tracks        []*TrackSearchResult    `json:"tracks"`
+	Playlists     []*PlaylistSearchResult `json:"playlists"`
+	TracksPage    *pagination.Page        `json:"tracks_page"`
+	PlaylistsPage *pagination.Page        `json:"playlists_page"`
+	Total         int64                   `json:"total"`
+	DidYouMean    string                  `json:"did_you_mean,omitempty"`
+}
+
+// TrackSearchResult is a matching track with its relevance and the matched
+// fields, keyed by field name, with matches wrapped in <mark> tags.
+type TrackSearchResult struct {
+	*TrackResponse
+	Rank       float64           `json:"rank"`
+	Highlights map[string]string `json:"highlights,omitempty"`
+}
+
+type PlaylistSearchResult struct {
+	*PlaylistResponse
+	Rank       float64           `json:"rank"`
+	Highlights map[string]string `json:"highlights,omitempty"`
+}
+
+type trackHit struct {
+	models.Track
+	Rank           float64
+	TitleHeadline  string
+	ArtistHeadline string
+	AlbumHeadline  string
+	GenreHeadline  string
+}
+
+type playlistHit struct {
+	models.Playlist
+	Rank                float64
+	NameHeadline        string
+	DescriptionHeadline string
 }
 
 func (s *SearchService) Search(req *SearchRequest) (*SearchResponse, error) {
 	query := strings.TrimSpace(req.Query)
 	if query == "" {
 		return &SearchResponse{
-			Tracks:    []*TrackResponse{},
-			Playlists: []*PlaylistResponse{},
-			Total:     0,
+			Tracks:        []*TrackSearchResult{},
+			Playlists:     []*PlaylistSearchResult{},
+			TracksPage:    &pagination.Page{Limit: req.Limit},
+			PlaylistsPage: &pagination.Page{Limit: req.Limit},
+			Total:         0,
 		}, nil
 	}
 
-	tracks, err := s.searchTracks(query, req.UserID, req.Limit, req.Offset)
+	page := &pagination.Request{Limit: req.Limit}
+
+	tracks, tracksPage, err := s.searchTracks(query, req.UserID, page)
 	if err != nil {
 		return nil, fmt.Errorf("failed to search tracks: %w", err)
 	}
 
-	playlists, err := s.searchPlaylists(query, req.UserID, req.Limit, req.Offset)
+	playlists, playlistsPage, err := s.searchPlaylists(query, req.UserID, page)
 	if err != nil {
 		return nil, fmt.Errorf("failed to search playlists: %w", err)
 	}
 
-	total := len(tracks) + len(playlists)
+	total := tracksPage.Total + playlistsPage.Total
+
+	response := &SearchResponse{
+		Tracks:        tracks,
+		Playlists:     playlists,
+		TracksPage:    tracksPage,
+		PlaylistsPage: playlistsPage,
+		Total:         total,
+	}
+
+	if total > 0 {
+		s.recordSearch(req.UserID, query)
+	} else {
+		suggestions, err := s.suggest(query, req.UserID, 1)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get suggestions: %w", err)
+		}
+		if len(suggestions) > 0 && !strings.EqualFold(suggestions[0], query) {
+			response.DidYouMean = suggestions[0]
+		}
+	}
+
+	return response, nil
+}
 
-	return &SearchResponse{
-		Tracks:    tracks,
-		Playlists: playlists,
-		Total:     total,
-	}, nil
+// Search results are ordered by rank, then newest first.
+var (
+	trackSearchKeyset    = pagination.Keyset{Columns: []string{"ts_rank(tracks.search_vector, q)", "tracks.created_at", "tracks.id"}, Desc: true}
+	playlistSearchKeyset = pagination.Keyset{Columns: []string{"ts_rank(playlists.search_vector, q)", "playlists.created_at", "playlists.id"}, Desc: true}
+)
+
+// formatRank formats a ts_rank value, which is a real, so that it parses
+// back to exactly the same value in a cursor.
+func formatRank(rank float64) string {
+	return strconv.FormatFloat(rank, 'g', -1, 32)
 }
 
-func (s *SearchService) searchTracks(query string, userID uuid.UUID, limit, offset int) ([]*TrackResponse, error) {
-	var tracks []models.Track
+// searchTracks matches the query against the tracks' search_vector. The
+// query uses web search syntax: "quoted phrases", OR, and -negation.
+func (s *SearchService) searchTracks(query string, userID uuid.UUID, req *pagination.Request) ([]*TrackSearchResult, *pagination.Page, error) {
+	scope := pagination.Scope("search:tracks", userID.String(), query)
+	cursor, err := s.cursors.Decode(req.Cursor, scope)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filter := func(db *gorm.DB) *gorm.DB {
+		return db.Model(&models.Track{}).
+			Joins("CROSS JOIN websearch_to_tsquery(?, ?) AS q", searchConfig, query).
+			Where("tracks.user_id = ? AND tracks.search_vector @@ q", userID)
+	}
 
-	searchQuery := fmt.Sprintf("%%%s%%", query)
+	var hits []trackHit
+	if err := trackSearchKeyset.Apply(s.db.Scopes(filter), cursor, req.Limit).
+		Select(`tracks.*, ts_rank(tracks.search_vector, q) AS rank,
+			ts_headline(?, COALESCE(tracks.title, ''), q, ?) AS title_headline,
+			ts_headline(?, COALESCE(tracks.artist, ''), q, ?) AS artist_headline,
+			ts_headline(?, COALESCE(tracks.album, ''), q, ?) AS album_headline,
+			ts_headline(?, COALESCE(tracks.genre, ''), q, ?) AS genre_headline`,
+			searchConfig, headlineShort,
+			searchConfig, headlineShort,
+			searchConfig, headlineShort,
+			searchConfig, headlineShort).
+		Find(&hits).Error; err != nil {
+		return nil, nil, err
+	}
 
-	if err := s.db.Where("user_id = ? AND (title ILIKE ? OR artist ILIKE ?)",
-		userID, searchQuery, searchQuery).
-		Order("created_at DESC").
-		Limit(limit).
-		Offset(offset).
-		Find(&tracks).Error; err != nil {
-		return nil, err
+	hits, page := pagination.Build(s.cursors, scope, hits, req.Limit, cursor, func(hit *trackHit) []string {
+		return []string{formatRank(hit.Rank), hit.CreatedAt.Format(time.RFC3339Nano), hit.ID.String()}
+	})
+	if page.Total, page.TotalEstimated, err = pagination.Count(s.db.Scopes(filter)); err != nil {
+		return nil, nil, err
 	}
 
-	var responses []*TrackResponse
-	for _, track := range tracks {
-		responses = append(responses, &TrackResponse{
-			ID:        track.ID,
-			Title:     track.Title,
-			Artist:    track.Artist,
-			Duration:  track.Duration,
-			FileSize:  track.FileSize,
-			MimeType:  track.MimeType,
-			CreatedAt: track.CreatedAt,
+	results := make([]*TrackSearchResult, 0, len(hits))
+	for i := range hits {
+		hit := &hits[i]
+		results = append(results, &TrackSearchResult{
+			TrackResponse: newTrackResponse(&hit.Track),
+			Rank:          hit.Rank,
+			Highlights: highlights(map[string]string{
+				"title":  hit.TitleHeadline,
+				"artist": hit.ArtistHeadline,
+				"album":  hit.AlbumHeadline,
+				"genre":  hit.GenreHeadline,
+			}),
 		})
 	}
 
-	return responses, nil
+	return results, page, nil
 }
 
-func (s *SearchService) searchPlaylists(query string, userID uuid.UUID, limit, offset int) ([]*PlaylistResponse, error) {
-	var playlists []models.Playlist
+func (s *SearchService) searchPlaylists(query string, userID uuid.UUID, req *pagination.Request) ([]*PlaylistSearchResult, *pagination.Page, error) {
+	scope := pagination.Scope("search:playlists", userID.String(), query)
+	cursor, err := s.cursors.Decode(req.Cursor, scope)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	searchQuery := fmt.Sprintf("%%%s%%", query)
+	filter := func(db *gorm.DB) *gorm.DB {
+		return db.Model(&models.Playlist{}).
+			Joins("CROSS JOIN websearch_to_tsquery(?, ?) AS q", searchConfig, query).
+			Where("playlists.user_id = ? AND playlists.search_vector @@ q", userID)
+	}
 
-	if err := s.db.Where("user_id = ? AND (name ILIKE ? OR description ILIKE ?)",
-		userID, searchQuery, searchQuery).
-		Order("created_at DESC").
-		Limit(limit).
-		Offset(offset).
-		Find(&playlists).Error; err != nil {
-		return nil, err
+	var hits []playlistHit
+	if err := playlistSearchKeyset.Apply(s.db.Scopes(filter), cursor, req.Limit).
+		Select(`playlists.*, ts_rank(playlists.search_vector, q) AS rank,
+			ts_headline(?, COALESCE(playlists.name, ''), q, ?) AS name_headline,
+			ts_headline(?, COALESCE(playlists.description, ''), q, ?) AS description_headline`,
+			searchConfig, headlineShort,
+			searchConfig, headlineLong).
+		Find(&hits).Error; err != nil {
+		return nil, nil, err
+	}
+
+	hits, page := pagination.Build(s.cursors, scope, hits, req.Limit, cursor, func(hit *playlistHit) []string {
+		return []string{formatRank(hit.Rank), hit.CreatedAt.Format(time.RFC3339Nano), hit.ID.String()}
+	})
+	if page.Total, page.TotalEstimated, err = pagination.Count(s.db.Scopes(filter)); err != nil {
+		return nil, nil, err
 	}
 
-	var responses []*PlaylistResponse
-	for _, playlist := range playlists {
-		responses = append(responses, &PlaylistResponse{
-			ID:          playlist.ID,
-			Name:        playlist.Name,
-			Description: playlist.Description,
-			UserID:      playlist.UserID,
+	results := make([]*PlaylistSearchResult, 0, len(hits))
+	for i := range hits {
+		hit := &hits[i]
+		results = append(results, &PlaylistSearchResult{
+			PlaylistResponse: newPlaylistResponse(&hit.Playlist),
+			Rank:             hit.Rank,
+			Highlights: highlights(map[string]string{
+				"name":        hit.NameHeadline,
+				"description": hit.DescriptionHeadline,
+			}),
 		})
 	}
 
-	return responses, nil
+	return results, page, nil
 }
 
-func (s *SearchService) SearchTracksOnly(query string, userID uuid.UUID, limit, offset int) ([]*TrackResponse, error) {
-	return s.searchTracks(query, userID, limit, offset)
+// highlights drops the fields in which nothing matched.
+func highlights(headlines map[string]string) map[string]string {
+	for field, headline := range headlines {
+		if !strings.Contains(headline, "<mark>") {
+			delete(headlines, field)
+		}
+	}
+	if len(headlines) == 0 {
+		return nil
+	}
+	return headlines
+}
+
+func (s *SearchService) SearchTracksOnly(query string, userID uuid.UUID, req *pagination.Request) ([]*TrackSearchResult, *pagination.Page, error) {
+	return s.searchTracks(strings.TrimSpace(query), userID, req)
 }
 
-func (s *SearchService) SearchPlaylistsOnly(query string, userID uuid.UUID, limit, offset int) ([]*PlaylistResponse, error) {
-	return s.searchPlaylists(query, userID, limit, offset)
+func (s *SearchService) SearchPlaylistsOnly(query string, userID uuid.UUID, req *pagination.Request) ([]*PlaylistSearchResult, *pagination.Page, error) {
+	return s.searchPlaylists(strings.TrimSpace(query), userID, req)
 }
 
+// GetSearchSuggestions returns titles, artists and playlist names that
+// resemble the query, tolerating typos, ranked by similarity and by how often
+// the user searched for them.
 func (s *SearchService) GetSearchSuggestions(query string, userID uuid.UUID, limit int) ([]string, error) {
-	if len(query) < 2 {
+	query = strings.TrimSpace(query)
+	if utf8.RuneCountInString(query) < 2 {
 		return []string{}, nil
 	}
 
-	var suggestions []string
-	searchQuery := fmt.Sprintf("%%%s%%", query)
-
-	var trackTitles []string
-	s.db.Model(&models.Track{}).
-		Where("user_id = ? AND title ILIKE ?", userID, searchQuery).
-		Select("DISTINCT title").
-		Limit(limit/2).
-		Pluck("title", &trackTitles)
-	suggestions = append(suggestions, trackTitles...)
-
-	var artists []string
-	s.db.Model(&models.Track{}).
-		Where("user_id = ? AND artist ILIKE ?", userID, searchQuery).
-		Select("DISTINCT artist").
-		Limit(limit/2).
-		Pluck("artist", &artists)
-	suggestions = append(suggestions, artists...)
-
-	var playlistNames []string
-	s.db.Model(&models.Playlist{}).
-		Where("user_id = ? AND name ILIKE ?", userID, searchQuery).
-		Select("DISTINCT name").
-		Limit(limit/2).
-		Pluck("name", &playlistNames)
-	suggestions = append(suggestions, playlistNames...)
-
-	uniqueSuggestions := make(map[string]bool)
-	var result []string
-	for _, suggestion := range suggestions {
-		if !uniqueSuggestions[suggestion] && len(result) < limit {
-			uniqueSuggestions[suggestion] = true
-			result = append(result, suggestion)
+	suggestions, err := s.suggest(query, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get suggestions: %w", err)
+	}
+	return suggestions, nil
+}
+
+type searchCandidate struct {
+	Term       string
+	Similarity float64
+	score      float64
+}
+
+func (s *SearchService) suggest(query string, userID uuid.UUID, limit int) ([]string, error) {
+	var candidates []searchCandidate
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT set_config('pg_trgm.word_similarity_threshold', ?, true)",
+			fmt.Sprint(fuzzyThreshold)).Error; err != nil {
+			return err
+		}
+
+		// <% is the word similarity operator; it is served by the trigram
+		// indexes created in database.createSearchIndexes.
+		return tx.Raw(`
+			SELECT term, MAX(similarity) AS similarity FROM (
+				SELECT title AS term, word_similarity(@query, title) AS similarity
+				FROM tracks
+				WHERE user_id = @user AND deleted_at IS NULL AND @query <% title
+				UNION ALL
+				SELECT artist, word_similarity(@query, artist)
+				FROM tracks
+				WHERE user_id = @user AND deleted_at IS NULL AND @query <% artist
+				UNION ALL
+				SELECT name, word_similarity(@query, name)
+				FROM playlists
+				WHERE user_id = @user AND deleted_at IS NULL AND @query <% name
+			) AS candidates
+			GROUP BY term
+			ORDER BY similarity DESC
+			LIMIT @limit`,
+			map[string]interface{}{"query": query, "user": userID, "limit": limit * 3}).
+			Scan(&candidates).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	unique := candidates[:0]
+	for _, candidate := range candidates {
+		key := searchTerm(candidate.Term)
+		if key == "" || seen[key] {
+			continue
 		}
+		seen[key] = true
+		unique = append(unique, candidate)
+	}
+	candidates = unique
+
+	frequencies := s.searchFrequencies(userID, candidates)
+	for i := range candidates {
+		candidates[i].score = candidates[i].Similarity + frequencyWeight*math.Log1p(frequencies[i])
 	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
 
+	result := make([]string, 0, limit)
+	for _, candidate := range candidates {
+		if len(result) == limit {
+			break
+		}
+		result = append(result, candidate.Term)
+	}
 	return result, nil
 }
+
+// recordSearch counts a query that returned results. Only the most frequent
+// maxTrackedSearches terms per user are kept.
+func (s *SearchService) recordSearch(userID uuid.UUID, query string) {
+	ctx := context.Background()
+	key := searchHistoryKey(userID)
+
+	pipe := s.redis.TxPipeline()
+	pipe.ZIncrBy(ctx, key, 1, searchTerm(query))
+	pipe.ZRemRangeByRank(ctx, key, 0, -maxTrackedSearches-1)
+	pipe.Expire(ctx, key, searchHistoryTTL)
+	pipe.Exec(ctx)
+}
+
+// searchFrequencies returns how often the user searched for each candidate.
+// Frequency only affects ranking, so Redis errors are ignored.
+func (s *SearchService) searchFrequencies(userID uuid.UUID, candidates []searchCandidate) []float64 {
+	frequencies := make([]float64, len(candidates))
+	if len(candidates) == 0 {
+		return frequencies
+	}
+
+	terms := make([]string, len(candidates))
+	for i, candidate := range candidates {
+		terms[i] = searchTerm(candidate.Term)
+	}
+
+	scores, err := s.redis.ZMScore(context.Background(), searchHistoryKey(userID), terms...).Result()
+	if err != nil {
+		return frequencies
+	}
+	copy(frequencies, scores)
+	return frequencies
+}
+
+func searchHistoryKey(userID uuid.UUID) string {
+	return fmt.Sprintf("search:history:%s", userID)
+}
+
+// searchTerm normalizes a query or suggestion for frequency counting.
+func searchTerm(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
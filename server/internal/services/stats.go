@@ -0,0 +1,445 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"maxify/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// rollupBatch is the number of play events summed per rollup statement.
+	rollupBatch = 5000
+
+	defaultTopLimit = 10
+	wrappedTopLimit = 5
+	dateFormat      = "2006-01-02"
+)
+
+// statsWindows maps the window parameter of /users/stats to a number of
+// days. Zero means all time.
+var statsWindows = map[string]int{
+	"7d":   7,
+	"30d":  30,
+	"90d":  90,
+	"365d": 365,
+	"all":  0,
+}
+
+var ErrInvalidYear = errors.New("invalid year")
+
+type StatsRequest struct {
+	Window string `form:"window" binding:"omitempty,oneof=7d 30d 90d 365d all"`
+	Top    int    `form:"top" binding:"omitempty,min=1,max=50"`
+}
+
+// TopTrack is a track with its listening. Track is null and Deleted set
+// for tracks that have been deleted since.
+type TopTrack struct {
+	Track   *TrackResponse `json:"track"`
+	Deleted bool           `json:"deleted,omitempty"`
+	Plays   int64          `json:"plays"`
+	Seconds int64          `json:"seconds"`
+}
+
+type TopItem struct {
+	ID      string `json:"id,omitempty"`
+	Name    string `json:"name"`
+	Plays   int64  `json:"plays"`
+	Seconds int64  `json:"seconds"`
+}
+
+// Streaks counts consecutive UTC days with listening. The current streak
+// is still running if there was listening today or yesterday.
+type Streaks struct {
+	Current     int    `json:"current"`
+	Longest     int    `json:"longest"`
+	LongestFrom string `json:"longest_from,omitempty"`
+	LongestTo   string `json:"longest_to,omitempty"`
+}
+
+type UserStatsResponse struct {
+	TracksCount      int64         `json:"tracks_count"`
+	PlaylistsCount   int64         `json:"playlists_count"`
+	Storage          *StorageUsage `json:"storage"`
+	Window           string        `json:"window"`
+	From             string        `json:"from,omitempty"`
+	ListeningSeconds int64         `json:"listening_seconds"`
+	Plays            int64         `json:"plays"`
+	TopTracks        []TopTrack    `json:"top_tracks"`
+	TopArtists       []TopItem     `json:"top_artists"`
+	TopGenres        []TopItem     `json:"top_genres"`
+	Streaks          Streaks       `json:"streaks"`
+	Heatmap          [7][24]int64  `json:"heatmap"` // seconds by UTC weekday (0 is Sunday) and hour
+}
+
+type DayTotal struct {
+	Day     string `json:"day"`
+	Seconds int64  `json:"seconds"`
+}
+
+type WrappedResponse struct {
+	Year             int        `json:"year"`
+	ListeningSeconds int64      `json:"listening_seconds"`
+	Plays            int64      `json:"plays"`
+	DistinctTracks   int64      `json:"distinct_tracks"`
+	DistinctArtists  int64      `json:"distinct_artists"`
+	TopTracks        []TopTrack `json:"top_tracks"`
+	TopArtists       []TopItem  `json:"top_artists"`
+	TopGenres        []TopItem  `json:"top_genres"`
+	Months           [12]int64  `json:"months"`    // seconds per month
+	TopMonth         int        `json:"top_month"` // 1-12, 0 without listening
+	BusiestDay       *DayTotal  `json:"busiest_day"`
+	LongestStreak    Streaks    `json:"longest_streak"`
+}
+
+func (s *UserService) GetUserStats(userID uuid.UUID, req *StatsRequest) (*UserStatsResponse, error) {
+	if req.Window == "" {
+		req.Window = "30d"
+	}
+	if req.Top == 0 {
+		req.Top = defaultTopLimit
+	}
+
+	stats := &UserStatsResponse{Window: req.Window}
+
+	if err := s.db.Model(&models.Track{}).Where("user_id = ?", userID).Count(&stats.TracksCount).Error; err != nil {
+		return nil, fmt.Errorf("failed to count tracks: %w", err)
+	}
+
+	if err := s.db.Model(&models.Playlist{}).Where("user_id = ?", userID).Count(&stats.PlaylistsCount).Error; err != nil {
+		return nil, fmt.Errorf("failed to count playlists: %w", err)
+	}
+
+	var err error
+	if stats.Storage, err = s.quotas.GetUsage(userID); err != nil {
+		return nil, err
+	}
+
+	var from time.Time
+	if days := statsWindows[req.Window]; days > 0 {
+		from = today().AddDate(0, 0, 1-days)
+		stats.From = from.Format(dateFormat)
+	}
+	rollups := func(db *gorm.DB) *gorm.DB {
+		return s.rollups(db, userID, from, time.Time{})
+	}
+
+	var totals struct {
+		Plays   int64
+		Seconds int64
+	}
+	if err := s.db.Scopes(rollups).
+		Select("COALESCE(SUM(listening_rollups.plays), 0) AS plays, COALESCE(SUM(listening_rollups.seconds), 0) AS seconds").
+		Scan(&totals).Error; err != nil {
+		return nil, fmt.Errorf("failed to get listening totals: %w", err)
+	}
+	stats.Plays, stats.ListeningSeconds = totals.Plays, totals.Seconds
+
+	if stats.TopTracks, err = s.topTracks(s.db.Scopes(rollups), req.Top); err != nil {
+		return nil, err
+	}
+	if stats.TopArtists, err = s.topArtists(s.db.Scopes(rollups), req.Top); err != nil {
+		return nil, err
+	}
+	if stats.TopGenres, err = s.topGenres(s.db.Scopes(rollups), req.Top); err != nil {
+		return nil, err
+	}
+
+	var cells []struct {
+		Weekday int
+		Hour    int
+		Seconds int64
+	}
+	if err := s.db.Scopes(rollups).
+		Select("EXTRACT(DOW FROM listening_rollups.day)::int AS weekday, listening_rollups.hour, SUM(listening_rollups.seconds) AS seconds").
+		Group("EXTRACT(DOW FROM listening_rollups.day), listening_rollups.hour").
+		Scan(&cells).Error; err != nil {
+		return nil, fmt.Errorf("failed to get listening heatmap: %w", err)
+	}
+	for _, cell := range cells {
+		stats.Heatmap[cell.Weekday][cell.Hour] = cell.Seconds
+	}
+
+	// Streaks always look at the whole history.
+	if stats.Streaks, err = s.streaks(userID, time.Time{}, time.Time{}); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// GetWrapped summarises a user's listening in one calendar year (UTC).
+func (s *UserService) GetWrapped(userID uuid.UUID, year int) (*WrappedResponse, error) {
+	if year < 2000 || year > today().Year() {
+		return nil, ErrInvalidYear
+	}
+
+	from := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(1, 0, 0)
+	rollups := func(db *gorm.DB) *gorm.DB {
+		return s.rollups(db, userID, from, to)
+	}
+
+	wrapped := &WrappedResponse{Year: year}
+
+	var totals struct {
+		Plays   int64
+		Seconds int64
+		Tracks  int64
+		Artists int64
+	}
+	if err := s.db.Scopes(rollups).
+		Joins("LEFT JOIN tracks ON tracks.id = listening_rollups.track_id").
+		Select(`COALESCE(SUM(listening_rollups.plays), 0) AS plays,
+			COALESCE(SUM(listening_rollups.seconds), 0) AS seconds,
+			COUNT(DISTINCT listening_rollups.track_id) FILTER (WHERE listening_rollups.plays > 0) AS tracks,
+			COUNT(DISTINCT tracks.artist_id) FILTER (WHERE listening_rollups.plays > 0) AS artists`).
+		Scan(&totals).Error; err != nil {
+		return nil, fmt.Errorf("failed to get listening totals: %w", err)
+	}
+	wrapped.Plays, wrapped.ListeningSeconds = totals.Plays, totals.Seconds
+	wrapped.DistinctTracks, wrapped.DistinctArtists = totals.Tracks, totals.Artists
+
+	var err error
+	if wrapped.TopTracks, err = s.topTracks(s.db.Scopes(rollups), wrappedTopLimit); err != nil {
+		return nil, err
+	}
+	if wrapped.TopArtists, err = s.topArtists(s.db.Scopes(rollups), wrappedTopLimit); err != nil {
+		return nil, err
+	}
+	if wrapped.TopGenres, err = s.topGenres(s.db.Scopes(rollups), wrappedTopLimit); err != nil {
+		return nil, err
+	}
+
+	var months []struct {
+		Month   int
+		Seconds int64
+	}
+	if err := s.db.Scopes(rollups).
+		Select("EXTRACT(MONTH FROM listening_rollups.day)::int AS month, SUM(listening_rollups.seconds) AS seconds").
+		Group("EXTRACT(MONTH FROM listening_rollups.day)").
+		Scan(&months).Error; err != nil {
+		return nil, fmt.Errorf("failed to get monthly listening: %w", err)
+	}
+	for _, month := range months {
+		wrapped.Months[month.Month-1] = month.Seconds
+		if month.Seconds > 0 && (wrapped.TopMonth == 0 || month.Seconds > wrapped.Months[wrapped.TopMonth-1]) {
+			wrapped.TopMonth = month.Month
+		}
+	}
+
+	var busiest []struct {
+		Day     time.Time
+		Seconds int64
+	}
+	if err := s.db.Scopes(rollups).
+		Select("listening_rollups.day, SUM(listening_rollups.seconds) AS seconds").
+		Group("listening_rollups.day").
+		Order("seconds DESC, listening_rollups.day ASC").
+		Limit(1).
+		Scan(&busiest).Error; err != nil {
+		return nil, fmt.Errorf("failed to get busiest day: %w", err)
+	}
+	if len(busiest) > 0 && busiest[0].Seconds > 0 {
+		wrapped.BusiestDay = &DayTotal{Day: busiest[0].Day.Format(dateFormat), Seconds: busiest[0].Seconds}
+	}
+
+	if wrapped.LongestStreak, err = s.streaks(userID, from, to); err != nil {
+		return nil, err
+	}
+	// A past year has no running streak.
+	wrapped.LongestStreak.Current = 0
+
+	return wrapped, nil
+}
+
+// rollups selects a user's rollups for days in [from, to). Zero times leave
+// that side open.
+func (s *UserService) rollups(db *gorm.DB, userID uuid.UUID, from, to time.Time) *gorm.DB {
+	db = db.Model(&models.ListeningRollup{}).Where("listening_rollups.user_id = ?", userID)
+	if !from.IsZero() {
+		db = db.Where("listening_rollups.day >= ?", from.Format(dateFormat))
+	}
+	if !to.IsZero() {
+		db = db.Where("listening_rollups.day < ?", to.Format(dateFormat))
+	}
+	return db
+}
+
+func (s *UserService) topTracks(db *gorm.DB, limit int) ([]TopTrack, error) {
+	var rows []struct {
+		TrackID uuid.UUID
+		Plays   int64
+		Seconds int64
+	}
+	if err := db.
+		Select("listening_rollups.track_id, SUM(listening_rollups.plays) AS plays, SUM(listening_rollups.seconds) AS seconds").
+		Group("listening_rollups.track_id").
+		Having("SUM(listening_rollups.plays) > 0").
+		Order("plays DESC, seconds DESC").
+		Limit(limit).
+		Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to get top tracks: %w", err)
+	}
+
+	ids := make([]uuid.UUID, len(rows))
+	for i, row := range rows {
+		ids[i] = row.TrackID
+	}
+	var tracks []models.Track
+	if len(ids) > 0 {
+		if err := s.db.Where("id IN ?", ids).Find(&tracks).Error; err != nil {
+			return nil, fmt.Errorf("failed to get top tracks: %w", err)
+		}
+	}
+	byID := make(map[uuid.UUID]*models.Track, len(tracks))
+	for i := range tracks {
+		byID[tracks[i].ID] = &tracks[i]
+	}
+
+	top := make([]TopTrack, 0, len(rows))
+	for _, row := range rows {
+		item := TopTrack{Plays: row.Plays, Seconds: row.Seconds}
+		if track, ok := byID[row.TrackID]; ok {
+			item.Track = newTrackResponse(track)
+		} else {
+			item.Deleted = true
+		}
+		top = append(top, item)
+	}
+	return top, nil
+}
+
+func (s *UserService) topArtists(db *gorm.DB, limit int) ([]TopItem, error) {
+	top := []TopItem{}
+	err := db.
+		Joins("JOIN tracks ON tracks.id = listening_rollups.track_id").
+		Where("tracks.artist_id IS NOT NULL").
+		Select("tracks.artist_id::text AS id, MIN(tracks.artist) AS name, SUM(listening_rollups.plays) AS plays, SUM(listening_rollups.seconds) AS seconds").
+		Group("tracks.artist_id").
+		Having("SUM(listening_rollups.plays) > 0").
+		Order("plays DESC, seconds DESC").
+		Limit(limit).
+		Scan(&top).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get top artists: %w", err)
+	}
+	return top, nil
+}
+
+func (s *UserService) topGenres(db *gorm.DB, limit int) ([]TopItem, error) {
+	top := []TopItem{}
+	err := db.
+		Joins("JOIN tracks ON tracks.id = listening_rollups.track_id").
+		Where("BTRIM(COALESCE(tracks.genre, '')) <> ''").
+		Select("MIN(BTRIM(tracks.genre)) AS name, SUM(listening_rollups.plays) AS plays, SUM(listening_rollups.seconds) AS seconds").
+		Group("LOWER(BTRIM(tracks.genre))").
+		Having("SUM(listening_rollups.plays) > 0").
+		Order("plays DESC, seconds DESC").
+		Limit(limit).
+		Scan(&top).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get top genres: %w", err)
+	}
+	return top, nil
+}
+
+// streaks finds runs of consecutive days with listening in [from, to). Days
+// minus their row number are equal within a run, which groups them.
+func (s *UserService) streaks(userID uuid.UUID, from, to time.Time) (Streaks, error) {
+	var result Streaks
+
+	days := s.rollups(s.db, userID, from, to).
+		Where("listening_rollups.seconds > 0 OR listening_rollups.plays > 0").
+		Distinct("listening_rollups.day")
+
+	var runs []struct {
+		FirstDay time.Time
+		LastDay  time.Time
+		Days     int
+	}
+	if err := s.db.Raw(`
+		SELECT MIN(day) AS first_day, MAX(day) AS last_day, COUNT(*) AS days
+		FROM (
+			SELECT day, day - (ROW_NUMBER() OVER (ORDER BY day))::int AS run
+			FROM (?) AS listened
+		) AS numbered
+		GROUP BY run
+		ORDER BY last_day DESC`, days).
+		Scan(&runs).Error; err != nil {
+		return result, fmt.Errorf("failed to get listening streaks: %w", err)
+	}
+
+	if len(runs) > 0 && !runs[0].LastDay.Before(today().AddDate(0, 0, -1)) {
+		result.Current = runs[0].Days
+	}
+	for _, run := range runs {
+		if run.Days > result.Longest {
+			result.Longest = run.Days
+			result.LongestFrom = run.FirstDay.Format(dateFormat)
+			result.LongestTo = run.LastDay.Format(dateFormat)
+		}
+	}
+	return result, nil
+}
+
+// RollUpPlays sums play events that have not been rolled up yet into
+// listening rollups and marks them, in one statement so that an event is
+// never counted twice. It returns the number of rollup rows touched.
+func (s *UserService) RollUpPlays() (int64, error) {
+	result := s.db.Exec(`
+		WITH batch AS (
+			UPDATE play_events SET rolled_up = true
+			WHERE id IN (
+				SELECT id FROM play_events
+				WHERE NOT rolled_up
+				ORDER BY created_at
+				LIMIT ?
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING user_id, track_id, created_at AT TIME ZONE 'UTC' AS played_at, seconds, counted
+		)
+		INSERT INTO listening_rollups (user_id, day, hour, track_id, plays, seconds)
+		SELECT user_id, played_at::date, EXTRACT(HOUR FROM played_at)::int, track_id,
+			COUNT(*) FILTER (WHERE counted), SUM(seconds)
+		FROM batch
+		GROUP BY 1, 2, 3, 4
+		ON CONFLICT (user_id, day, hour, track_id) DO UPDATE SET
+			plays = listening_rollups.plays + EXCLUDED.plays,
+			seconds = listening_rollups.seconds + EXCLUDED.seconds`, rollupBatch)
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to roll up plays: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// StartRollups periodically rolls up new play events until none are left.
+func (s *UserService) StartRollups(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			for {
+				n, err := s.RollUpPlays()
+				if err != nil {
+					log.Printf("Play rollup failed: %v", err)
+					break
+				}
+				if n == 0 {
+					break
+				}
+			}
+		}
+	}()
+}
+
+func today() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour)
+}
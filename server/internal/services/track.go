@@ -1,213 +1,446 @@
 package services
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"log"
+	"math"
+	"mime"
 	"mime/multipart"
-	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"maxify/internal/config"
 	"maxify/internal/database"
+	"maxify/internal/metadata"
 	"maxify/internal/models"
+	"maxify/internal/pagination"
+	"maxify/internal/storage"
+	"maxify/internal/streaming"
+	"maxify/internal/transcode"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type TrackService struct {
-	config *config.Config
-	db     *gorm.DB
+	config     *config.Config
+	db         *gorm.DB
+	storage    storage.Backend
+	transcodes *TranscodeService
+	quotas     *QuotaService
+	cursors    *pagination.Signer
 }
 
 func NewTrackService(cfg *config.Config) *TrackService {
 	return &TrackService{
-		config: cfg,
-		db:     database.GetDB(),
+		config:     cfg,
+		db:         database.GetDB(),
+		storage:    storage.GetBackend(),
+		transcodes: NewTranscodeService(cfg),
+		quotas:     NewQuotaService(cfg),
+		cursors:    pagination.NewSigner(cfg.JWT.Secret),
 	}
 }
 
+var (
+	ErrTrackNotFound    = errors.New("track not found")
+	ErrUnsupportedAudio = errors.New("file content is not a supported audio format")
+)
+
+type ContentTypeMismatchError struct {
+	Declared string
+	Detected string
+}
+
+func (e *ContentTypeMismatchError) Error() string {
+	return fmt.Sprintf("declared content type %s does not match file content (%s)", e.Declared, e.Detected)
+}
+
+// DuplicateTrackError is returned for an upload of content the user
+// already has. Track is the existing track, which may be in the trash.
+type DuplicateTrackError struct {
+	Track   *TrackResponse
+	InTrash bool
+}
+
+func (e *DuplicateTrackError) Error() string {
+	if e.InTrash {
+		return "track already uploaded and in the trash; restore it instead"
+	}
+	return "track already uploaded"
+}
+
 type UploadTrackRequest struct {
 	File   *multipart.FileHeader
 	UserID uuid.UUID
 }
 
 type TrackResponse struct {
-	ID        uuid.UUID `json:"id"`
-	Title     string    `json:"title"`
-	Artist    string    `json:"artist"`
-	Duration  int       `json:"duration"`
-	FileSize  int64     `json:"file_size"`
-	MimeType  string    `json:"mime_type"`
-	CreatedAt time.Time `json:"created_at"`
+	ID          uuid.UUID  `json:"id"`
+	Title       string     `json:"title"`
+	Artist      string     `json:"artist"`
+	ArtistID    *uuid.UUID `json:"artist_id,omitempty"`
+	Album       string     `json:"album"`
+	AlbumID     *uuid.UUID `json:"album_id,omitempty"`
+	Genre       string     `json:"genre"`
+	Year        int        `json:"year"`
+	TrackNumber int        `json:"track_number"`
+	DiscNumber  int        `json:"disc_number"`
+	Duration    int        `json:"duration"`
+	FileSize    int64      `json:"file_size"`
+	MimeType    string     `json:"mime_type"`
+	PlayCount   int64      `json:"play_count"`
+	Broken      bool       `json:"broken,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func newTrackResponse(track *models.Track) *TrackResponse {
+	return &TrackResponse{
+		ID:          track.ID,
+		Title:       track.Title,
+		Artist:      track.Artist,
+		ArtistID:    track.ArtistID,
+		Album:       track.Album,
+		AlbumID:     track.AlbumID,
+		Genre:       track.Genre,
+		Year:        track.Year,
+		TrackNumber: track.TrackNumber,
+		DiscNumber:  track.DiscNumber,
+		Duration:    track.Duration,
+		FileSize:    track.FileSize,
+		MimeType:    track.MimeType,
+		PlayCount:   track.PlayCount,
+		Broken:      track.Broken,
+		CreatedAt:   track.CreatedAt,
+	}
 }
 
 func (s *TrackService) UploadTrack(req *UploadTrackRequest) (*TrackResponse, error) {
-	if err := s.validateFile(req.File); err != nil {
+	if req.File.Size > s.config.Storage.MaxFileSize {
+		return nil, fmt.Errorf("file too large: %d bytes (max: %d bytes)", req.File.Size, s.config.Storage.MaxFileSize)
+	}
+
+	src, err := req.File.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer src.Close()
+
+	return s.ingest(src, "", req.File.Filename, req.File.Header.Get("Content-Type"), req.File.Size, req.UserID, uuid.Nil)
+}
+
+// CheckQuota rejects an upload of size bytes that would not fit in the
+// user's quota, before its body is read. It returns the bytes remaining in
+// the quota, or -1 if there is no byte quota. A negative size is unknown.
+func (s *TrackService) CheckQuota(userID uuid.UUID, size int64) (int64, error) {
+	return s.quotas.Check(userID, size)
+}
+
+// ingest validates an uploaded file, copies it to storage and creates the
+// track record. Both single-request and resumable uploads end up here.
+// hash is the SHA-256 of the content if the caller computed it while
+// writing the file, or empty. uploadID is the resumable upload, whose own
+// reservation does not count against the quota, or uuid.Nil.
+func (s *TrackService) ingest(src io.ReadSeeker, hash, filename, contentType string, size int64, userID, uploadID uuid.UUID) (*TrackResponse, error) {
+	format, err := s.validateFile(src, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := s.readTags(src)
+
+	if err := s.quotas.enforce(s.db, userID, uploadID, size); err != nil {
 		return nil, err
 	}
 
-	if err := os.MkdirAll(s.config.Storage.UploadDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	// Content not hashed yet is hashed as it is stored, so that the
+	// upload is read only once.
+	ctx := context.Background()
+	tmp := ""
+	if hash == "" {
+		if tmp, hash, err = putHashed(ctx, s.storage, src, size, format.MimeType()); err != nil {
+			return nil, fmt.Errorf("failed to save file: %w", err)
+		}
 	}
 
-	fileExt := filepath.Ext(req.File.Filename)
-	filename := fmt.Sprintf("%s%s", uuid.New().String(), fileExt)
-	filePath := filepath.Join(s.config.Storage.UploadDir, filename)
+	if err := checkDuplicate(s.db, userID, hash, uuid.Nil); err != nil {
+		if tmp != "" {
+			s.storage.Delete(ctx, tmp)
+		}
+		return nil, err
+	}
 
-	if err := s.saveFile(req.File, filePath); err != nil {
+	blob := &models.Blob{
+		Hash:     hash,
+		Key:      blobKey(hash, format),
+		Size:     size,
+		MimeType: format.MimeType(),
+	}
+	if tmp != "" {
+		err = moveObject(ctx, s.storage, tmp, blob.Key)
+	} else {
+		err = storeObject(ctx, s.storage, blob.Key, src, size, blob.MimeType)
+	}
+	if err != nil {
 		return nil, fmt.Errorf("failed to save file: %w", err)
 	}
 
-	title, artist := s.extractMetadata(req.File.Filename)
+	// Cover art is optional: a picture we cannot use does not fail the upload.
+	var art *models.Artwork
+	if tags.Picture != nil {
+		if art, err = prepareArtwork(tags.Picture.Data); err == nil {
+			err = storeObject(ctx, s.storage, art.Key, bytes.NewReader(tags.Picture.Data), art.Size, art.MimeType)
+		}
+		if err != nil {
+			log.Printf("Skipping embedded artwork of %s: %v", filename, err)
+			art = nil
+		}
+	}
+
+	title, artist := s.extractMetadata(filename)
+	if tags.Title != "" {
+		title = tags.Title
+	}
+	if tags.Artist != "" {
+		artist = tags.Artist
+	}
 
 	track := &models.Track{
-		Title:    title,
-		Artist:   artist,
-		Duration: 0, // Would be extracted from audio file in real implementation
-		FilePath: filePath,
-		FileSize: req.File.Size,
-		MimeType: req.File.Header.Get("Content-Type"),
-		UserID:   req.UserID,
+		Title:       title,
+		Artist:      artist,
+		Album:       tags.Album,
+		Genre:       tags.Genre,
+		Year:        tags.Year,
+		TrackNumber: tags.TrackNumber,
+		DiscNumber:  tags.DiscNumber,
+		Duration:    int(math.Round(tags.Duration.Seconds())),
+		FilePath:    blob.Key,
+		ContentHash: &hash,
+		FileSize:    size,
+		MimeType:    format.MimeType(),
+		UserID:      userID,
 	}
 
-	if err := s.db.Create(track).Error; err != nil {
-		os.Remove(filePath)
+	for attempt := 1; ; attempt++ {
+		err = s.db.Transaction(func(tx *gorm.DB) error {
+			// Checked again under the user's row lock for concurrent uploads.
+			if err := s.quotas.enforce(tx, userID, uploadID, size); err != nil {
+				return err
+			}
+			if err := acquireBlob(ctx, tx, s.storage, blob); err != nil {
+				return err
+			}
+			// Checked again under the blob lock for concurrent uploads of
+			// the same file.
+			if err := checkDuplicate(tx, userID, hash, uuid.Nil); err != nil {
+				return err
+			}
+			if err := linkLibrary(tx, track, tags.AlbumArtist); err != nil {
+				return err
+			}
+			if art != nil {
+				if err := acquireArtwork(ctx, tx, s.storage, art); err != nil {
+					return err
+				}
+				track.ArtworkHash = &art.Hash
+			}
+			return tx.Create(track).Error
+		})
+		if !errors.Is(err, errObjectGone) || attempt == maxStoreAttempts {
+			break
+		}
+
+		// A purge collected the content after it was stored above.
+		if err = storeObject(ctx, s.storage, blob.Key, src, size, blob.MimeType); err != nil {
+			break
+		}
+		if art != nil {
+			if err = storeObject(ctx, s.storage, art.Key, bytes.NewReader(tags.Picture.Data), art.Size, art.MimeType); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		// The stored objects go unless another track refers to them.
+		if err := abandonBlob(ctx, s.db, s.storage, blob); err != nil {
+			log.Printf("Failed to collect blob %s: %v", blob.Hash, err)
+		}
+		if art != nil {
+			if err := abandonArtwork(ctx, s.db, s.storage, art); err != nil {
+				log.Printf("Failed to collect artwork %s: %v", art.Hash, err)
+			}
+		}
+		var duplicateErr *DuplicateTrackError
+		if errors.As(err, &duplicateErr) || errors.Is(err, ErrQuotaExceeded) {
+			return nil, err
+		}
 		return nil, fmt.Errorf("failed to create track record: %w", err)
 	}
 
-	return &TrackResponse{
-		ID:        track.ID,
-		Title:     track.Title,
-		Artist:    track.Artist,
-		Duration:  track.Duration,
-		FileSize:  track.FileSize,
-		MimeType:  track.MimeType,
-		CreatedAt: track.CreatedAt,
-	}, nil
+	s.transcodes.EnqueueTrack(track)
+
+	return newTrackResponse(track), nil
 }
 
-func (s *TrackService) GetUserTracks(userID uuid.UUID, limit, offset int) ([]*TrackResponse, error) {
-	var tracks []models.Track
-	if err := s.db.Where("user_id = ?", userID).
-		Order("created_at DESC").
-		Limit(limit).
-		Offset(offset).
-		Find(&tracks).Error; err != nil {
-		return nil, fmt.Errorf("failed to get user tracks: %w", err)
-	}
-
-	var responses []*TrackResponse
-	for _, track := range tracks {
-		responses = append(responses, &TrackResponse{
-			ID:        track.ID,
-			Title:     track.Title,
-			Artist:    track.Artist,
-			Duration:  track.Duration,
-			FileSize:  track.FileSize,
-			MimeType:  track.MimeType,
-			CreatedAt: track.CreatedAt,
-		})
+// checkDuplicate returns a *DuplicateTrackError if the user already has a
+// track other than exclude with the given content, in the library or in
+// the trash.
+func checkDuplicate(db *gorm.DB, userID uuid.UUID, hash string, exclude uuid.UUID) error {
+	var track models.Track
+	err := db.Unscoped().
+		Where("user_id = ? AND content_hash = ? AND id <> ?", userID, hash, exclude).
+		Order("deleted_at IS NOT NULL").
+		First(&track).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
 	}
-
-	return responses, nil
+	if err != nil {
+		return fmt.Errorf("failed to check for duplicates: %w", err)
+	}
+	return &DuplicateTrackError{Track: newTrackResponse(&track), InTrash: track.DeletedAt.Valid}
 }
 
 func (s *TrackService) GetTrackByID(trackID uuid.UUID) (*models.Track, error) {
 	var track models.Track
 	if err := s.db.First(&track, trackID).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("track not found")
+			return nil, ErrTrackNotFound
 		}
 		return nil, fmt.Errorf("failed to get track: %w", err)
 	}
 	return &track, nil
 }
 
+// DeleteTrack moves a track to the trash, together with its playlist
+// entries so that restoring the track puts them back. Its file is kept
+// until the track is purged.
 func (s *TrackService) DeleteTrack(trackID, userID uuid.UUID) error {
 	var track models.Track
 	if err := s.db.Where("id = ? AND user_id = ?", trackID, userID).First(&track).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return errors.New("track not found")
+			return ErrTrackNotFound
 		}
 		return fmt.Errorf("failed to get track: %w", err)
 	}
 
-	if err := s.db.Delete(&track).Error; err != nil {
+	// Both get the same timestamp, which is how restore finds the entries.
+	now := time.Now()
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.PlaylistTrack{}).
+			Where("track_id = ?", track.ID).
+			UpdateColumn("deleted_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&track).UpdateColumn("deleted_at", now).Error
+	}); err != nil {
 		return fmt.Errorf("failed to delete track: %w", err)
 	}
 
-	if err := os.Remove(track.FilePath); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to delete file: %w", err)
+	return nil
+}
+
+// GetTrackFile returns the track's audio for streaming. A quality other
+// than "original" selects a transcoded rendition; until it is ready the
+// original is served without long-lived caching.
+func (s *TrackService) GetTrackFile(trackID, userID uuid.UUID, quality string) (*streaming.Content, error) {
+	track, err := s.getOwnedTrack(trackID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheControl := "private, max-age=86400"
+	if quality != "" && quality != "original" {
+		profile, err := transcode.Lookup(quality)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidQuality, quality)
+		}
+		if rendition := s.transcodes.ReadyRendition(track, profile); rendition != nil {
+			return s.objectContent(rendition.ID, rendition.FilePath, rendition.MimeType, cacheControl)
+		}
+		cacheControl = "no-cache"
 	}
 
-	return nil
+	return s.objectContent(track.ID, track.FilePath, trackMimeType(track), cacheControl)
 }
 
-func (s *TrackService) GetTrackFile(trackID, userID uuid.UUID) (string, error) {
+// getOwnedTrack loads a track for playback, checking that it belongs to the
+// requesting user.
+func (s *TrackService) getOwnedTrack(trackID, userID uuid.UUID) (*models.Track, error) {
 	var track models.Track
 	if err := s.db.Where("id = ? AND user_id = ?", trackID, userID).First(&track).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return "", errors.New("track not found")
+			return nil, ErrTrackNotFound
 		}
-		return "", fmt.Errorf("failed to get track: %w", err)
+		return nil, fmt.Errorf("failed to get track: %w", err)
 	}
+	return &track, nil
+}
 
-	if _, err := os.Stat(track.FilePath); os.IsNotExist(err) {
-		return "", errors.New("track file not found")
+func (s *TrackService) objectContent(id uuid.UUID, key, mimeType, cacheControl string) (*streaming.Content, error) {
+	info, err := s.storage.Stat(context.Background(), key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotExist) {
+			return nil, errors.New("track file not found")
+		}
+		return nil, fmt.Errorf("failed to stat track file: %w", err)
 	}
 
-	return track.FilePath, nil
+	return &streaming.Content{
+		Size:         info.Size,
+		ModTime:      info.ModTime,
+		ETag:         fmt.Sprintf(`"%s-%x-%x"`, id, info.Size, info.ModTime.UnixNano()),
+		MimeType:     mimeType,
+		CacheControl: cacheControl,
+		Open: func(offset, length int64) (io.ReadCloser, error) {
+			return s.storage.Get(context.Background(), key, offset, length)
+		},
+	}, nil
 }
 
-func (s *TrackService) validateFile(file *multipart.FileHeader) error {
-	if file.Size > s.config.Storage.MaxFileSize {
-		return fmt.Errorf("file too large: %d bytes (max: %d bytes)", file.Size, s.config.Storage.MaxFileSize)
+// trackMimeType returns the stored type, falling back to the file extension
+// for tracks uploaded before the type was detected from content.
+func trackMimeType(track *models.Track) string {
+	if strings.HasPrefix(track.MimeType, "audio/") {
+		return track.MimeType
 	}
-
-	allowedTypes := []string{
-		"audio/mpeg",
-		"audio/mp3",
-		"audio/wav",
-		"audio/flac",
-		"audio/aac",
-		"audio/ogg",
+	if byExt := mime.TypeByExtension(filepath.Ext(track.FilePath)); byExt != "" {
+		return byExt
 	}
+	return "application/octet-stream"
+}
 
-	contentType := file.Header.Get("Content-Type")
-	isValidType := false
-	for _, allowedType := range allowedTypes {
-		if contentType == allowedType {
-			isValidType = true
-			break
-		}
+// validateFile detects the real audio format from the file content. The
+// client's Content-Type is only checked for consistency, with the generic
+// octet-stream type accepted for any audio.
+func (s *TrackService) validateFile(src io.ReadSeeker, contentType string) (metadata.Format, error) {
+	format, err := metadata.Sniff(src)
+	if err != nil {
+		return "", ErrUnsupportedAudio
 	}
 
-	if !isValidType {
-		return fmt.Errorf("unsupported file type: %s", contentType)
+	if contentType != "" && contentType != "application/octet-stream" && !format.MatchesMimeType(contentType) {
+		return "", &ContentTypeMismatchError{
+			Declared: contentType,
+			Detected: format.MimeType(),
+		}
 	}
 
-	return nil
+	return format, nil
 }
 
-func (s *TrackService) saveFile(file *multipart.FileHeader, filePath string) error {
-	src, err := file.Open()
+// readTags parses embedded tags and stream headers. Unreadable files yield
+// empty metadata so that the filename heuristic applies.
+func (s *TrackService) readTags(src io.ReadSeeker) *metadata.Metadata {
+	tags, err := metadata.Read(src)
 	if err != nil {
-		return err
+		return &metadata.Metadata{}
 	}
-	defer src.Close()
-
-	dst, err := os.Create(filePath)
-	if err != nil {
-		return err
-	}
-	defer dst.Close()
-
-	_, err = io.Copy(dst, src)
-	return err
+	return tags
 }
 
 func (s *TrackService) extractMetadata(filename string) (title, artist string) {
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"maxify/internal/models"
+	"maxify/internal/pagination"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// trackSorts maps the sort parameter of track listings to the SQL
+// expression tracks are ordered by.
+var trackSorts = map[string]string{
+	"title":      "LOWER(tracks.title)",
+	"artist":     "LOWER(COALESCE(tracks.artist, ''))",
+	"album":      "LOWER(COALESCE(tracks.album, ''))",
+	"duration":   "tracks.duration",
+	"size":       "tracks.file_size",
+	"created_at": "tracks.created_at",
+	"play_count": "tracks.play_count",
+}
+
+const maxFacetValues = 50
+
+// TrackListRequest holds the sort and filter parameters of a track listing.
+// Repeating genre or mime_type matches any of the given values.
+type TrackListRequest struct {
+	Sort          string   `form:"sort" binding:"omitempty,oneof=title artist album duration size created_at play_count"`
+	Order         string   `form:"order" binding:"omitempty,oneof=asc desc"`
+	ArtistID      string   `form:"artist_id" binding:"omitempty,uuid"`
+	AlbumID       string   `form:"album_id" binding:"omitempty,uuid"`
+	Genres        []string `form:"genre"`
+	MimeTypes     []string `form:"mime_type"`
+	YearFrom      int      `form:"year_from" binding:"omitempty,min=0"`
+	YearTo        int      `form:"year_to" binding:"omitempty,min=0"`
+	MinDuration   int      `form:"min_duration" binding:"omitempty,min=0"` // seconds
+	MaxDuration   int      `form:"max_duration" binding:"omitempty,min=0"` // seconds
+	NotInPlaylist bool     `form:"not_in_playlist"`
+}
+
+type FacetValue struct {
+	Value string `json:"value"`
+	Label string `json:"label,omitempty"`
+	Count int64  `json:"count"`
+}
+
+// TrackFacets counts the tracks matching each value of a filter. The counts
+// for one filter ignore that filter's own selection, so a client can offer
+// the other values alongside the selected ones.
+type TrackFacets struct {
+	Genres    []FacetValue `json:"genre"`
+	MimeTypes []FacetValue `json:"mime_type"`
+	Artists   []FacetValue `json:"artist"`
+	Years     []FacetValue `json:"year"`
+}
+
+type TrackListResponse struct {
+	Tracks []*TrackResponse `json:"tracks"`
+	Page   *pagination.Page `json:"page"`
+	Facets *TrackFacets     `json:"facets,omitempty"`
+}
+
+type trackRow struct {
+	models.Track
+	SortKey string
+}
+
+// GetUserTracks lists the user's tracks, newest first unless another sort is
+// requested. Facets are only computed for the first page.
+func (s *TrackService) GetUserTracks(userID uuid.UUID, list *TrackListRequest, req *pagination.Request) (*TrackListResponse, error) {
+	sort, ok := trackSorts[list.Sort]
+	if !ok {
+		list.Sort = "created_at"
+		sort = trackSorts[list.Sort]
+	}
+	if list.Order == "" {
+		list.Order = "asc"
+		switch list.Sort {
+		case "created_at", "play_count", "size", "duration":
+			list.Order = "desc"
+		}
+	}
+
+	scope := pagination.Scope("tracks", userID.String(), fmt.Sprintf("%+v", *list))
+	cursor, err := s.cursors.Decode(req.Cursor, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := func(db *gorm.DB) *gorm.DB {
+		return list.apply(db.Model(&models.Track{}).Where("tracks.user_id = ?", userID), "")
+	}
+
+	keyset := pagination.Keyset{Columns: []string{sort, "tracks.id"}, Desc: list.Order == "desc"}
+
+	var rows []trackRow
+	if err := keyset.Apply(s.db.Scopes(filter), cursor, req.Limit).
+		Select(fmt.Sprintf("tracks.*, (%s)::text AS sort_key", sort)).
+		Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user tracks: %w", err)
+	}
+
+	rows, page := pagination.Build(s.cursors, scope, rows, req.Limit, cursor, func(row *trackRow) []string {
+		return []string{row.SortKey, row.ID.String()}
+	})
+	if page.Total, page.TotalEstimated, err = pagination.Count(s.db.Scopes(filter)); err != nil {
+		return nil, fmt.Errorf("failed to count user tracks: %w", err)
+	}
+
+	response := &TrackListResponse{
+		Tracks: make([]*TrackResponse, 0, len(rows)),
+		Page:   page,
+	}
+	for i := range rows {
+		response.Tracks = append(response.Tracks, newTrackResponse(&rows[i].Track))
+	}
+
+	if cursor == nil {
+		if response.Facets, err = s.trackFacets(userID, list); err != nil {
+			return nil, fmt.Errorf("failed to get track facets: %w", err)
+		}
+	}
+
+	return response, nil
+}
+
+func (s *TrackService) trackFacets(userID uuid.UUID, list *TrackListRequest) (*TrackFacets, error) {
+	facet := func(skip, value, label, where string) ([]FacetValue, error) {
+		values := []FacetValue{}
+		query := list.apply(s.db.Model(&models.Track{}).Where("tracks.user_id = ?", userID), skip)
+		if where != "" {
+			query = query.Where(where)
+		}
+		err := query.
+			Select(fmt.Sprintf("(%s)::text AS value, %s AS label, COUNT(*) AS count", value, label)).
+			Group(value).
+			Order("count DESC, value ASC").
+			Limit(maxFacetValues).
+			Scan(&values).Error
+		return values, err
+	}
+
+	var facets TrackFacets
+	var err error
+	if facets.Genres, err = facet("genre", "tracks.genre", "''", "COALESCE(tracks.genre, '') <> ''"); err != nil {
+		return nil, err
+	}
+	if facets.MimeTypes, err = facet("mime_type", "tracks.mime_type", "''", ""); err != nil {
+		return nil, err
+	}
+	if facets.Artists, err = facet("artist", "tracks.artist_id", "MIN(tracks.artist)", "tracks.artist_id IS NOT NULL"); err != nil {
+		return nil, err
+	}
+	if facets.Years, err = facet("year", "tracks.year", "''", "tracks.year > 0"); err != nil {
+		return nil, err
+	}
+
+	return &facets, nil
+}
+
+// apply adds the request's filters to a track query. The filter named by
+// skip is left out, for computing its facet.
+func (r *TrackListRequest) apply(db *gorm.DB, skip string) *gorm.DB {
+	if r.ArtistID != "" && skip != "artist" {
+		db = db.Where("tracks.artist_id = ?", r.ArtistID)
+	}
+	if r.AlbumID != "" {
+		db = db.Where("tracks.album_id = ?", r.AlbumID)
+	}
+	if genres := nonEmpty(r.Genres); len(genres) > 0 && skip != "genre" {
+		db = db.Where("LOWER(tracks.genre) IN ?", lower(genres))
+	}
+	if mimeTypes := nonEmpty(r.MimeTypes); len(mimeTypes) > 0 && skip != "mime_type" {
+		db = db.Where("tracks.mime_type IN ?", mimeTypes)
+	}
+	if skip != "year" {
+		if r.YearFrom > 0 {
+			db = db.Where("tracks.year >= ?", r.YearFrom)
+		}
+		if r.YearTo > 0 {
+			db = db.Where("tracks.year <= ?", r.YearTo)
+		}
+	}
+	if r.MinDuration > 0 {
+		db = db.Where("tracks.duration >= ?", r.MinDuration)
+	}
+	if r.MaxDuration > 0 {
+		db = db.Where("tracks.duration <= ?", r.MaxDuration)
+	}
+	if r.NotInPlaylist {
+		db = db.Where(`NOT EXISTS (
+			SELECT 1 FROM playlist_tracks
+			JOIN playlists ON playlists.id = playlist_tracks.playlist_id AND playlists.deleted_at IS NULL
+			WHERE playlist_tracks.track_id = tracks.id)`)
+	}
+	return db
+}
+
+func nonEmpty(values []string) []string {
+	var result []string
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+func lower(values []string) []string {
+	result := make([]string, len(values))
+	for i, value := range values {
+		result[i] = strings.ToLower(value)
+	}
+	return result
+}
@@ -0,0 +1,390 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"maxify/internal/config"
+	"maxify/internal/database"
+	"maxify/internal/models"
+	"maxify/internal/storage"
+	"maxify/internal/transcode"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// transcodeTimeout bounds a single ffmpeg run.
+	transcodeTimeout = 30 * time.Minute
+	// staleTranscodeAfter is when a rendition stuck in processing, for
+	// example after a crash, is queued again.
+	staleTranscodeAfter = time.Hour
+)
+
+var ErrInvalidQuality = errors.New("invalid quality")
+
+type TranscodeService struct {
+	config     *config.Config
+	db         *gorm.DB
+	storage    storage.Backend
+	queue      *transcode.Queue
+	transcoder transcode.Transcoder
+}
+
+func NewTranscodeService(cfg *config.Config) *TranscodeService {
+	return &TranscodeService{
+		config:     cfg,
+		db:         database.GetDB(),
+		storage:    storage.GetBackend(),
+		queue:      transcode.NewQueue(database.GetRedis()),
+		transcoder: transcode.NewFFmpeg(cfg.Transcode.FFmpegPath),
+	}
+}
+
+// EnqueueTrack schedules every rendition that makes sense for the track.
+// Failures are logged rather than returned so uploads never fail because
+// of the transcoding queue; pending renditions are requeued on startup.
+func (s *TranscodeService) EnqueueTrack(track *models.Track) {
+	for _, profile := range transcode.Profiles {
+		if _, err := s.requestRendition(track, profile); err != nil {
+			log.Printf("Failed to queue %s rendition of track %s: %v", profile.Name, track.ID, err)
+		}
+	}
+}
+
+// ReadyRendition returns the finished rendition of track for profile, or nil
+// if it is not available yet. Missing renditions are requested on demand,
+// which also covers tracks uploaded before transcoding existed.
+func (s *TranscodeService) ReadyRendition(track *models.Track, profile transcode.Profile) *models.Rendition {
+	rendition, err := s.requestRendition(track, profile)
+	if err != nil {
+		log.Printf("Failed to request %s rendition of track %s: %v", profile.Name, track.ID, err)
+		return nil
+	}
+	if rendition == nil || rendition.Status != models.RenditionReady {
+		return nil
+	}
+	return rendition
+}
+
+func (s *TranscodeService) requestRendition(track *models.Track, profile transcode.Profile) (*models.Rendition, error) {
+	var rendition models.Rendition
+	err := s.db.Where("track_id = ? AND profile = ?", track.ID, profile.Name).First(&rendition).Error
+	if err == nil {
+		return &rendition, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if !s.config.Transcode.Enabled || !needsRendition(track, profile) {
+		return nil, nil
+	}
+
+	rendition = models.Rendition{
+		TrackID:  track.ID,
+		Profile:  profile.Name,
+		Status:   models.RenditionPending,
+		Bitrate:  profile.Bitrate,
+		MimeType: profile.MimeType,
+	}
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&rendition)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		// Another request created it first.
+		return nil, nil
+	}
+
+	if err := s.queue.Push(context.Background(), transcode.Job{RenditionID: rendition.ID}); err != nil {
+		return &rendition, fmt.Errorf("failed to queue transcode: %w", err)
+	}
+	return &rendition, nil
+}
+
+// needsRendition skips profiles that would not be smaller than the original.
+// The lowest profile is always produced so every track can be played over
+// HLS.
+func needsRendition(track *models.Track, profile transcode.Profile) bool {
+	if profile.Name == transcode.Profiles[0].Name {
+		return true
+	}
+	if track.MimeType == "audio/flac" || track.MimeType == "audio/wav" || track.Duration <= 0 {
+		return true
+	}
+	sourceKbps := track.FileSize * 8 / int64(track.Duration) / 1000
+	return int64(profile.Bitrate) < sourceKbps
+}
+
+// DeleteRenditions removes the renditions of a track and their files.
+func (s *TranscodeService) DeleteRenditions(trackID uuid.UUID) error {
+	var renditions []models.Rendition
+	if err := s.db.Where("track_id = ?", trackID).Find(&renditions).Error; err != nil {
+		return fmt.Errorf("failed to get renditions: %w", err)
+	}
+
+	ctx := context.Background()
+	for i := range renditions {
+		if err := s.deleteFiles(ctx, &renditions[i]); err != nil {
+			return fmt.Errorf("failed to delete rendition file: %w", err)
+		}
+	}
+
+	if err := s.db.Where("track_id = ?", trackID).Delete(&models.Rendition{}).Error; err != nil {
+		return fmt.Errorf("failed to delete renditions: %w", err)
+	}
+	return nil
+}
+
+// StartWorkers requeues interrupted work and starts n workers consuming the
+// transcode queue.
+func (s *TranscodeService) StartWorkers(n int) {
+	if err := s.requeuePending(); err != nil {
+		log.Printf("Failed to requeue pending transcodes: %v", err)
+	}
+
+	for i := 0; i < n; i++ {
+		go s.work()
+	}
+}
+
+func (s *TranscodeService) requeuePending() error {
+	if err := s.db.Model(&models.Rendition{}).
+		Where("status = ? AND updated_at < ?", models.RenditionProcessing, time.Now().Add(-staleTranscodeAfter)).
+		Update("status", models.RenditionPending).Error; err != nil {
+		return err
+	}
+
+	var ids []uuid.UUID
+	if err := s.db.Model(&models.Rendition{}).
+		Where("status = ?", models.RenditionPending).
+		Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+
+	// Jobs that are still queued get a duplicate, which process skips.
+	ctx := context.Background()
+	for _, id := range ids {
+		if err := s.queue.Push(ctx, transcode.Job{RenditionID: id}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *TranscodeService) work() {
+	ctx := context.Background()
+	for {
+		job, err := s.queue.Pop(ctx, 5*time.Second)
+		if err != nil {
+			log.Printf("Failed to read transcode queue: %v", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		if job == nil {
+			continue
+		}
+
+		if err := s.process(ctx, job.RenditionID); err != nil {
+			log.Printf("Transcode of rendition %s failed: %v", job.RenditionID, err)
+		}
+	}
+}
+
+func (s *TranscodeService) process(ctx context.Context, renditionID uuid.UUID) error {
+	// Claim the job; duplicates and finished renditions are skipped.
+	claim := s.db.Model(&models.Rendition{}).
+		Where("id = ? AND status = ?", renditionID, models.RenditionPending).
+		Update("status", models.RenditionProcessing)
+	if claim.Error != nil {
+		return claim.Error
+	}
+	if claim.RowsAffected == 0 {
+		return nil
+	}
+
+	var rendition models.Rendition
+	if err := s.db.First(&rendition, renditionID).Error; err != nil {
+		return err
+	}
+
+	var track models.Track
+	if err := s.db.First(&track, rendition.TrackID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return s.db.Delete(&rendition).Error
+		}
+		return err
+	}
+
+	if err := s.transcodeRendition(ctx, &track, &rendition); err != nil {
+		s.db.Model(&rendition).Updates(map[string]interface{}{
+			"status": models.RenditionFailed,
+			"error":  err.Error(),
+		})
+		return err
+	}
+	return nil
+}
+
+func (s *TranscodeService) transcodeRendition(ctx context.Context, track *models.Track, rendition *models.Rendition) error {
+	profile, err := transcode.Lookup(rendition.Profile)
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "maxify-transcode-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "source"+filepath.Ext(track.FilePath))
+	if err := s.download(ctx, track.FilePath, input); err != nil {
+		return fmt.Errorf("failed to read original: %w", err)
+	}
+
+	output := filepath.Join(dir, "rendition"+profile.Extension)
+	runCtx, cancel := context.WithTimeout(ctx, transcodeTimeout)
+	defer cancel()
+	if err := s.transcoder.Transcode(runCtx, input, output, profile); err != nil {
+		return err
+	}
+
+	f, err := os.Open(output)
+	if err != nil {
+		return fmt.Errorf("failed to open rendition: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to open rendition: %w", err)
+	}
+
+	key := path.Join("renditions", track.ID.String(), profile.Name+profile.Extension)
+	if err := s.storage.Put(ctx, key, f, info.Size(), profile.MimeType); err != nil {
+		return fmt.Errorf("failed to store rendition: %w", err)
+	}
+
+	// A packaging failure only affects HLS playback, so the progressive
+	// rendition is still published.
+	segments, err := s.packageHLS(runCtx, track.ID, profile, output, filepath.Join(dir, "hls"))
+	if err != nil {
+		log.Printf("HLS packaging of rendition %s failed: %v", rendition.ID, err)
+	}
+
+	result := s.db.Model(&models.Rendition{}).
+		Where("id = ? AND status = ?", rendition.ID, models.RenditionProcessing).
+		Updates(map[string]interface{}{
+			"status":    models.RenditionReady,
+			"file_path": key,
+			"file_size": info.Size(),
+			"mime_type": profile.MimeType,
+			"segments":  segments,
+			"error":     "",
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		// The track was deleted while transcoding.
+		return s.deleteFiles(ctx, &models.Rendition{TrackID: track.ID, Profile: profile.Name, FilePath: key, Segments: segments})
+	}
+	return nil
+}
+
+// packageHLS segments a rendition and uploads the media playlist and its
+// segments. The playlist is stored last so it never references a missing
+// segment. It returns the number of segments.
+func (s *TranscodeService) packageHLS(ctx context.Context, trackID uuid.UUID, profile transcode.Profile, input, dir string) (int, error) {
+	if err := s.transcoder.Segment(ctx, input, dir); err != nil {
+		return 0, err
+	}
+
+	segments := 0
+	for {
+		name := transcode.HLSSegment(segments)
+		if err := s.uploadFile(ctx, filepath.Join(dir, name), hlsKey(trackID, profile.Name, name), "video/mp2t"); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				break
+			}
+			return 0, err
+		}
+		segments++
+	}
+	if segments == 0 {
+		return 0, errors.New("no HLS segments were produced")
+	}
+
+	playlist := hlsKey(trackID, profile.Name, transcode.HLSPlaylist)
+	if err := s.uploadFile(ctx, filepath.Join(dir, transcode.HLSPlaylist), playlist, "application/vnd.apple.mpegurl"); err != nil {
+		return 0, err
+	}
+	return segments, nil
+}
+
+func (s *TranscodeService) uploadFile(ctx context.Context, src, key, contentType string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return s.storage.Put(ctx, key, f, info.Size(), contentType)
+}
+
+// deleteFiles removes the progressive file and HLS output of a rendition.
+func (s *TranscodeService) deleteFiles(ctx context.Context, rendition *models.Rendition) error {
+	var keys []string
+	if rendition.FilePath != "" {
+		keys = append(keys, rendition.FilePath)
+	}
+	if rendition.Segments > 0 {
+		keys = append(keys, hlsKey(rendition.TrackID, rendition.Profile, transcode.HLSPlaylist))
+		for i := 0; i < rendition.Segments; i++ {
+			keys = append(keys, hlsKey(rendition.TrackID, rendition.Profile, transcode.HLSSegment(i)))
+		}
+	}
+
+	for _, key := range keys {
+		if err := s.storage.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hlsKey(trackID uuid.UUID, profile, name string) string {
+	return path.Join("hls", trackID.String(), profile, name)
+}
+
+func (s *TranscodeService) download(ctx context.Context, key, dst string) error {
+	src, err := s.storage.Get(ctx, key, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, src); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,372 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"maxify/internal/config"
+	"maxify/internal/models"
+	"maxify/internal/storage"
+	"maxify/internal/transcode"
+
+	"gorm.io/gorm"
+)
+
+// fakeTranscoder stands in for ffmpeg. It writes the profile name after
+// the input's content, and splits the output into two HLS segments.
+type fakeTranscoder struct {
+	err        error
+	segmentErr error
+	calls      int
+}
+
+func (f *fakeTranscoder) Transcode(ctx context.Context, input, output string, profile transcode.Profile) error {
+	f.calls++
+	if f.err != nil {
+		return f.err
+	}
+	data, err := os.ReadFile(input)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(output, append(data, ":"+profile.Name...), 0644)
+}
+
+func (f *fakeTranscoder) Segment(ctx context.Context, input, dir string) error {
+	if f.segmentErr != nil {
+		return f.segmentErr
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for i := 0; i < 2; i++ {
+		if err := os.WriteFile(filepath.Join(dir, transcode.HLSSegment(i)), []byte("segment"), 0644); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(filepath.Join(dir, transcode.HLSPlaylist), []byte("#EXTM3U\n"), 0644)
+}
+
+type transcodeFixture struct {
+	db         *gorm.DB
+	storage    storage.Backend
+	queue      *transcode.Queue
+	transcoder *fakeTranscoder
+	transcodes *TranscodeService
+	tracks     *TrackService
+}
+
+func newTranscodeFixture(t *testing.T) *transcodeFixture {
+	t.Helper()
+	db := newTestDB(t, &models.User{}, &models.Track{}, &models.Rendition{})
+	backend, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, _ := newTestRedis(t)
+
+	cfg := &config.Config{Transcode: config.TranscodeConfig{Enabled: true}}
+	f := &transcodeFixture{
+		db:         db,
+		storage:    backend,
+		queue:      transcode.NewQueue(client),
+		transcoder: &fakeTranscoder{},
+	}
+	f.transcodes = &TranscodeService{
+		config:     cfg,
+		db:         db,
+		storage:    backend,
+		queue:      f.queue,
+		transcoder: f.transcoder,
+	}
+	f.tracks = &TrackService{
+		config:     cfg,
+		db:         db,
+		storage:    backend,
+		transcodes: f.transcodes,
+	}
+	return f
+}
+
+// addTrack stores a FLAC track, which gets every rendition.
+func (f *transcodeFixture) addTrack(t *testing.T) *models.Track {
+	t.Helper()
+	track := newTestTrack(t, f.db, &models.Track{
+		FilePath: "blobs/ab/original.flac",
+		FileSize: 8,
+		MimeType: "audio/flac",
+		Duration: 180,
+	})
+	if err := f.storage.Put(context.Background(), track.FilePath, strings.NewReader("original"), 8, track.MimeType); err != nil {
+		t.Fatal(err)
+	}
+	return track
+}
+
+func (f *transcodeFixture) addRendition(t *testing.T, track *models.Track, profile, status string) *models.Rendition {
+	t.Helper()
+	rendition := &models.Rendition{TrackID: track.ID, Profile: profile, Status: status}
+	if err := f.db.Create(rendition).Error; err != nil {
+		t.Fatal(err)
+	}
+	return rendition
+}
+
+func (f *transcodeFixture) reload(t *testing.T, rendition *models.Rendition) *models.Rendition {
+	t.Helper()
+	var reloaded models.Rendition
+	if err := f.db.First(&reloaded, "id = ?", rendition.ID).Error; err != nil {
+		t.Fatalf("failed to reload rendition: %v", err)
+	}
+	return &reloaded
+}
+
+func (f *transcodeFixture) read(t *testing.T, key string) string {
+	t.Helper()
+	r, err := f.storage.Get(context.Background(), key, 0, -1)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", key, err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", key, err)
+	}
+	return string(data)
+}
+
+func TestProcessMakesRenditionReady(t *testing.T) {
+	f := newTranscodeFixture(t)
+	track := f.addTrack(t)
+	rendition := f.addRendition(t, track, "high", models.RenditionPending)
+
+	if err := f.transcodes.process(context.Background(), rendition.ID); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+
+	got := f.reload(t, rendition)
+	if got.Status != models.RenditionReady || got.Segments != 2 || got.Error != "" {
+		t.Fatalf("rendition = %+v, want ready with 2 segments", got)
+	}
+	if content := f.read(t, got.FilePath); content != "original:high" {
+		t.Errorf("rendition content = %q", content)
+	}
+	if _, err := f.storage.Stat(context.Background(), hlsKey(track.ID, "high", transcode.HLSPlaylist)); err != nil {
+		t.Errorf("HLS playlist: %v", err)
+	}
+}
+
+func TestProcessRecordsFailure(t *testing.T) {
+	f := newTranscodeFixture(t)
+	f.transcoder.err = errors.New("unsupported codec")
+	rendition := f.addRendition(t, f.addTrack(t), "low", models.RenditionPending)
+
+	if err := f.transcodes.process(context.Background(), rendition.ID); err == nil {
+		t.Fatal("process succeeded, want the transcoder's error")
+	}
+
+	got := f.reload(t, rendition)
+	if got.Status != models.RenditionFailed || !strings.Contains(got.Error, "unsupported codec") {
+		t.Errorf("rendition = %+v, want failed with the error", got)
+	}
+}
+
+func TestProcessPublishesRenditionWithoutHLS(t *testing.T) {
+	f := newTranscodeFixture(t)
+	f.transcoder.segmentErr = errors.New("segmenter crashed")
+	rendition := f.addRendition(t, f.addTrack(t), "low", models.RenditionPending)
+
+	if err := f.transcodes.process(context.Background(), rendition.ID); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+
+	if got := f.reload(t, rendition); got.Status != models.RenditionReady || got.Segments != 0 {
+		t.Errorf("rendition = %+v, want ready without segments", got)
+	}
+}
+
+func TestProcessSkipsClaimedJobs(t *testing.T) {
+	for _, status := range []string{models.RenditionProcessing, models.RenditionReady, models.RenditionFailed} {
+		t.Run(status, func(t *testing.T) {
+			f := newTranscodeFixture(t)
+			rendition := f.addRendition(t, f.addTrack(t), "low", status)
+
+			if err := f.transcodes.process(context.Background(), rendition.ID); err != nil {
+				t.Fatalf("process: %v", err)
+			}
+			if f.transcoder.calls != 0 {
+				t.Errorf("transcoder ran %d times", f.transcoder.calls)
+			}
+			if got := f.reload(t, rendition); got.Status != status {
+				t.Errorf("status = %s, want %s", got.Status, status)
+			}
+		})
+	}
+}
+
+func TestProcessDropsRenditionOfDeletedTrack(t *testing.T) {
+	f := newTranscodeFixture(t)
+	track := f.addTrack(t)
+	rendition := f.addRendition(t, track, "low", models.RenditionPending)
+	if err := f.db.Delete(track).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.transcodes.process(context.Background(), rendition.ID); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+	if err := f.db.First(&models.Rendition{}, "id = ?", rendition.ID).Error; !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("rendition still exists: %v", err)
+	}
+}
+
+func TestEnqueueTrackQueuesPendingRenditions(t *testing.T) {
+	f := newTranscodeFixture(t)
+	track := f.addTrack(t)
+
+	f.transcodes.EnqueueTrack(track)
+
+	var renditions []models.Rendition
+	if err := f.db.Where("track_id = ?", track.ID).Find(&renditions).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(renditions) != len(transcode.Profiles) {
+		t.Fatalf("got %d renditions, want %d", len(renditions), len(transcode.Profiles))
+	}
+	queued := make(map[string]bool)
+	for range renditions {
+		job, err := f.queue.Pop(context.Background(), time.Second)
+		if err != nil || job == nil {
+			t.Fatalf("Pop = %v, %v", job, err)
+		}
+		queued[job.RenditionID.String()] = true
+	}
+	for _, rendition := range renditions {
+		if rendition.Status != models.RenditionPending || !queued[rendition.ID.String()] {
+			t.Errorf("rendition %s is %s, queued %v", rendition.Profile, rendition.Status, queued[rendition.ID.String()])
+		}
+	}
+}
+
+func TestNeedsRendition(t *testing.T) {
+	tests := []struct {
+		name     string
+		track    models.Track
+		profile  string
+		expected bool
+	}{
+		{"lowest profile always", models.Track{MimeType: "audio/mpeg", FileSize: 1_440_000, Duration: 180}, "low", true},
+		{"lossless source", models.Track{MimeType: "audio/flac", FileSize: 1, Duration: 180}, "high", true},
+		{"unknown duration", models.Track{MimeType: "audio/mpeg", FileSize: 1}, "high", true},
+		{"64 kbps source", models.Track{MimeType: "audio/mpeg", FileSize: 1_440_000, Duration: 180}, "medium", false},
+		{"320 kbps source", models.Track{MimeType: "audio/mpeg", FileSize: 7_200_000, Duration: 180}, "high", false},
+		{"256 kbps source", models.Track{MimeType: "audio/mpeg", FileSize: 5_760_000, Duration: 180}, "medium", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, err := transcode.Lookup(tt.profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := needsRendition(&tt.track, profile); got != tt.expected {
+				t.Errorf("needsRendition = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRequeuePendingRestartsStaleJobs(t *testing.T) {
+	f := newTranscodeFixture(t)
+	track := f.addTrack(t)
+	stale := f.addRendition(t, track, "low", models.RenditionProcessing)
+	running := f.addRendition(t, track, "medium", models.RenditionProcessing)
+	if err := f.db.Model(stale).UpdateColumn("updated_at", time.Now().Add(-2*staleTranscodeAfter)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.transcodes.requeuePending(); err != nil {
+		t.Fatalf("requeuePending: %v", err)
+	}
+
+	if got := f.reload(t, stale); got.Status != models.RenditionPending {
+		t.Errorf("stale rendition is %s, want pending", got.Status)
+	}
+	if got := f.reload(t, running); got.Status != models.RenditionProcessing {
+		t.Errorf("running rendition is %s, want processing", got.Status)
+	}
+	job, err := f.queue.Pop(context.Background(), time.Second)
+	if err != nil || job == nil || job.RenditionID != stale.ID {
+		t.Errorf("queued job = %v, %v, want the stale rendition", job, err)
+	}
+}
+
+func TestGetTrackFileFallsBackToOriginal(t *testing.T) {
+	f := newTranscodeFixture(t)
+	track := f.addTrack(t)
+	rendition := f.addRendition(t, track, "high", models.RenditionPending)
+
+	content, err := f.tracks.GetTrackFile(track.ID, track.UserID, "high")
+	if err != nil {
+		t.Fatalf("GetTrackFile: %v", err)
+	}
+	if content.MimeType != "audio/flac" || content.CacheControl != "no-cache" {
+		t.Errorf("pending rendition served %s with %q, want the original uncached", content.MimeType, content.CacheControl)
+	}
+
+	if err := f.transcodes.process(context.Background(), rendition.ID); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+	content, err = f.tracks.GetTrackFile(track.ID, track.UserID, "high")
+	if err != nil {
+		t.Fatalf("GetTrackFile: %v", err)
+	}
+	if content.MimeType != "audio/mp4" || content.CacheControl != "private, max-age=86400" {
+		t.Errorf("ready rendition served %s with %q", content.MimeType, content.CacheControl)
+	}
+	r, err := content.Open(0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "original:high" {
+		t.Errorf("served %q, want the rendition", data)
+	}
+
+	content, err = f.tracks.GetTrackFile(track.ID, track.UserID, "original")
+	if err != nil {
+		t.Fatalf("GetTrackFile: %v", err)
+	}
+	if content.MimeType != "audio/flac" {
+		t.Errorf("original quality served %s", content.MimeType)
+	}
+
+	if _, err := f.tracks.GetTrackFile(track.ID, track.UserID, "lossless"); !errors.Is(err, ErrInvalidQuality) {
+		t.Errorf("unknown quality = %v, want ErrInvalidQuality", err)
+	}
+}
+
+func TestGetTrackFileWithoutTranscoding(t *testing.T) {
+	f := newTranscodeFixture(t)
+	f.transcodes.config.Transcode.Enabled = false
+	track := f.addTrack(t)
+
+	content, err := f.tracks.GetTrackFile(track.ID, track.UserID, "low")
+	if err != nil {
+		t.Fatalf("GetTrackFile: %v", err)
+	}
+	if content.MimeType != "audio/flac" || content.CacheControl != "no-cache" {
+		t.Errorf("served %s with %q, want the original uncached", content.MimeType, content.CacheControl)
+	}
+	var count int64
+	f.db.Model(&models.Rendition{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d renditions requested with transcoding disabled", count)
+	}
+}
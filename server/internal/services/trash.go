@@ -0,0 +1,367 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"maxify/internal/config"
+	"maxify/internal/database"
+	"maxify/internal/models"
+	"maxify/internal/pagination"
+	"maxify/internal/storage"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	TrashTrack    = "track"
+	TrashPlaylist = "playlist"
+)
+
+// purgeBatchSize bounds how many items a purge pass loads at once.
+const purgeBatchSize = 100
+
+type TrashService struct {
+	config     *config.Config
+	db         *gorm.DB
+	storage    storage.Backend
+	transcodes *TranscodeService
+	cursors    *pagination.Signer
+}
+
+func NewTrashService(cfg *config.Config) *TrashService {
+	return &TrashService{
+		config:     cfg,
+		db:         database.GetDB(),
+		storage:    storage.GetBackend(),
+		transcodes: NewTranscodeService(cfg),
+		cursors:    pagination.NewSigner(cfg.JWT.Secret),
+	}
+}
+
+type TrashRequest struct {
+	Type string `form:"type" binding:"omitempty,oneof=track playlist"`
+}
+
+// TrashItemResponse is a deleted track or playlist. PurgeAt is when it
+// will be deleted for good.
+type TrashItemResponse struct {
+	Type      string            `json:"type"`
+	ID        uuid.UUID         `json:"id"`
+	Name      string            `json:"name"`
+	DeletedAt time.Time         `json:"deleted_at"`
+	PurgeAt   time.Time         `json:"purge_at"`
+	Track     *TrackResponse    `json:"track,omitempty"`
+	Playlist  *PlaylistResponse `json:"playlist,omitempty"`
+}
+
+type trashRow struct {
+	Type      string
+	ID        uuid.UUID
+	Name      string
+	DeletedAt time.Time
+}
+
+// GetTrash lists the user's deleted tracks and playlists, most recently
+// deleted first.
+func (s *TrashService) GetTrash(userID uuid.UUID, list *TrashRequest, req *pagination.Request) ([]*TrashItemResponse, *pagination.Page, error) {
+	scope := pagination.Scope("trash", userID.String(), list.Type)
+	cursor, err := s.cursors.Decode(req.Cursor, scope)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tracks := s.db.Unscoped().Model(&models.Track{}).
+		Select("'track' AS type, id, title AS name, deleted_at").
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID)
+	playlists := s.db.Unscoped().Model(&models.Playlist{}).
+		Select("'playlist' AS type, id, name, deleted_at").
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID)
+	filter := func(db *gorm.DB) *gorm.DB {
+		switch list.Type {
+		case TrashTrack:
+			return db.Table("(?) AS trash", tracks)
+		case TrashPlaylist:
+			return db.Table("(?) AS trash", playlists)
+		}
+		return db.Table("(? UNION ALL ?) AS trash", tracks, playlists)
+	}
+
+	keyset := pagination.Keyset{Columns: []string{"trash.deleted_at", "trash.id"}, Desc: true}
+
+	var rows []trashRow
+	if err := keyset.Apply(s.db.Scopes(filter), cursor, req.Limit).Find(&rows).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to get trash: %w", err)
+	}
+
+	rows, page := pagination.Build(s.cursors, scope, rows, req.Limit, cursor, func(row *trashRow) []string {
+		return []string{row.DeletedAt.Format(time.RFC3339Nano), row.ID.String()}
+	})
+	if page.Total, page.TotalEstimated, err = pagination.Count(s.db.Scopes(filter)); err != nil {
+		return nil, nil, fmt.Errorf("failed to count trash: %w", err)
+	}
+
+	items, err := s.trashItems(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+	return items, page, nil
+}
+
+// trashItems loads the tracks and playlists of a page of trash rows.
+func (s *TrashService) trashItems(rows []trashRow) ([]*TrashItemResponse, error) {
+	var trackIDs, playlistIDs []uuid.UUID
+	for _, row := range rows {
+		if row.Type == TrashTrack {
+			trackIDs = append(trackIDs, row.ID)
+		} else {
+			playlistIDs = append(playlistIDs, row.ID)
+		}
+	}
+
+	tracks := make(map[uuid.UUID]*models.Track, len(trackIDs))
+	if len(trackIDs) > 0 {
+		var found []models.Track
+		if err := s.db.Unscoped().Where("id IN ?", trackIDs).Find(&found).Error; err != nil {
+			return nil, fmt.Errorf("failed to get tracks: %w", err)
+		}
+		for i := range found {
+			tracks[found[i].ID] = &found[i]
+		}
+	}
+	playlists := make(map[uuid.UUID]*models.Playlist, len(playlistIDs))
+	if len(playlistIDs) > 0 {
+		var found []models.Playlist
+		if err := s.db.Unscoped().Where("id IN ?", playlistIDs).Find(&found).Error; err != nil {
+			return nil, fmt.Errorf("failed to get playlists: %w", err)
+		}
+		for i := range found {
+			playlists[found[i].ID] = &found[i]
+		}
+	}
+
+	items := make([]*TrashItemResponse, 0, len(rows))
+	for _, row := range rows {
+		item := &TrashItemResponse{
+			Type:      row.Type,
+			ID:        row.ID,
+			Name:      row.Name,
+			DeletedAt: row.DeletedAt,
+			PurgeAt:   row.DeletedAt.Add(s.config.Storage.TrashRetention),
+		}
+		if track, ok := tracks[row.ID]; ok {
+			item.Track = newTrackResponse(track)
+		}
+		if playlist, ok := playlists[row.ID]; ok {
+			item.Playlist = newPlaylistResponse(playlist)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// RestoreTrack takes a track out of the trash along with the playlist
+// entries that were deleted with it. It fails with a *DuplicateTrackError
+// if the user has another track with the same file.
+func (s *TrashService) RestoreTrack(trackID, userID uuid.UUID) (*TrackResponse, error) {
+	var track models.Track
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", trackID, userID).
+			First(&track).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrTrackNotFound
+			}
+			return fmt.Errorf("failed to get track: %w", err)
+		}
+
+		if track.ContentHash != nil {
+			if err := checkDuplicate(tx, userID, *track.ContentHash, track.ID); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Unscoped().Model(&models.PlaylistTrack{}).
+			Where("track_id = ? AND deleted_at = ?", track.ID, track.DeletedAt.Time).
+			UpdateColumn("deleted_at", nil).Error; err != nil {
+			return fmt.Errorf("failed to restore playlist entries: %w", err)
+		}
+		if err := tx.Unscoped().Model(&track).UpdateColumn("deleted_at", nil).Error; err != nil {
+			return fmt.Errorf("failed to restore track: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	track.DeletedAt = gorm.DeletedAt{}
+	return newTrackResponse(&track), nil
+}
+
+// RestorePlaylist takes a playlist out of the trash. Only the owner can
+// restore it.
+func (s *TrashService) RestorePlaylist(playlistID, userID uuid.UUID) (*PlaylistResponse, error) {
+	var playlist models.Playlist
+	if err := s.db.Unscoped().
+		Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", playlistID, userID).
+		First(&playlist).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPlaylistNotFound
+		}
+		return nil, fmt.Errorf("failed to get playlist: %w", err)
+	}
+
+	if err := s.db.Unscoped().Model(&playlist).UpdateColumn("deleted_at", nil).Error; err != nil {
+		return nil, fmt.Errorf("failed to restore playlist: %w", err)
+	}
+
+	playlist.DeletedAt = gorm.DeletedAt{}
+	return newPlaylistResponse(&playlist), nil
+}
+
+// EmptyTrash purges all of the user's deleted tracks and playlists now.
+func (s *TrashService) EmptyTrash(userID uuid.UUID) (int, error) {
+	return s.purge(func(db *gorm.DB) *gorm.DB {
+		return db.Where("user_id = ? AND deleted_at IS NOT NULL", userID)
+	})
+}
+
+// Purge permanently deletes tracks and playlists that have been in the
+// trash for longer than the retention period.
+func (s *TrashService) Purge() (int, error) {
+	cutoff := time.Now().Add(-s.config.Storage.TrashRetention)
+	return s.purge(func(db *gorm.DB) *gorm.DB {
+		return db.Where("deleted_at < ?", cutoff)
+	})
+}
+
+func (s *TrashService) purge(filter func(*gorm.DB) *gorm.DB) (int, error) {
+	purged := 0
+
+	for {
+		var tracks []models.Track
+		if err := s.db.Unscoped().Scopes(filter).Limit(purgeBatchSize).Find(&tracks).Error; err != nil {
+			return purged, fmt.Errorf("failed to get deleted tracks: %w", err)
+		}
+		for i := range tracks {
+			if err := s.purgeTrack(&tracks[i]); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+		if len(tracks) < purgeBatchSize {
+			break
+		}
+	}
+
+	for {
+		var playlists []models.Playlist
+		if err := s.db.Unscoped().Scopes(filter).Limit(purgeBatchSize).Find(&playlists).Error; err != nil {
+			return purged, fmt.Errorf("failed to get deleted playlists: %w", err)
+		}
+		for i := range playlists {
+			if err := s.purgePlaylist(&playlists[i]); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+		if len(playlists) < purgeBatchSize {
+			break
+		}
+	}
+
+	return purged, nil
+}
+
+// purgeTrack deletes a track with its playlist entries, renditions and
+// references to the audio file and cover art. Play history is kept, and
+// stats show the track as deleted.
+func (s *TrashService) purgeTrack(track *models.Track) error {
+	if err := s.transcodes.DeleteRenditions(track.ID); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var lastBlobRef, lastArtworkRef bool
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("track_id = ?", track.ID).Delete(&models.PlaylistTrack{}).Error; err != nil {
+			return fmt.Errorf("failed to purge track: %w", err)
+		}
+		if err := tx.Unscoped().Delete(track).Error; err != nil {
+			return fmt.Errorf("failed to purge track: %w", err)
+		}
+
+		if track.ContentHash != nil {
+			var err error
+			if lastBlobRef, err = releaseBlob(tx, *track.ContentHash); err != nil {
+				return err
+			}
+		}
+
+		// Released after the blob, in the order uploads acquire them.
+		if track.ArtworkHash != nil {
+			var err error
+			lastArtworkRef, err = releaseArtwork(tx, *track.ArtworkHash)
+			return err
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// The track is gone either way; fsck finds files left behind.
+	var err error
+	if track.ContentHash == nil {
+		err = s.storage.Delete(ctx, track.FilePath)
+	} else if lastBlobRef {
+		err = collectBlob(ctx, s.db, s.storage, *track.ContentHash)
+	}
+	if err != nil {
+		log.Printf("Failed to delete file of purged track %s: %v", track.ID, err)
+	}
+	if lastArtworkRef {
+		if err := collectArtwork(ctx, s.db, s.storage, *track.ArtworkHash); err != nil {
+			log.Printf("Failed to delete artwork of purged track %s: %v", track.ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *TrashService) purgePlaylist(playlist *models.Playlist) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&models.PlaylistTrack{}, &models.PlaylistCollaborator{}} {
+			if err := tx.Unscoped().Where("playlist_id = ?", playlist.ID).Delete(model).Error; err != nil {
+				return fmt.Errorf("failed to purge playlist: %w", err)
+			}
+		}
+		if err := tx.Unscoped().Delete(playlist).Error; err != nil {
+			return fmt.Errorf("failed to purge playlist: %w", err)
+		}
+		return nil
+	})
+}
+
+// StartPurger periodically purges expired trash.
+func (s *TrashService) StartPurger(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			n, err := s.Purge()
+			if err != nil {
+				log.Printf("Trash purge failed: %v", err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("Purged %d items from the trash", n)
+			}
+		}
+	}()
+}
@@ -0,0 +1,485 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"maxify/internal/config"
+	"maxify/internal/database"
+	"maxify/internal/storage"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// maxChunkSize bounds a single PATCH request of a resumable upload.
+const maxChunkSize = 32 << 20
+
+const (
+	uploadStateUploading  = "uploading"
+	uploadStateFinalizing = "finalizing"
+	uploadStateExpired    = "expired"
+
+	uploadExpiryKey = "uploads:expiry"
+)
+
+var (
+	ErrUploadNotFound   = errors.New("upload session not found")
+	ErrUploadTooLarge   = errors.New("upload is too large")
+	ErrChunkTooLarge    = errors.New("chunk is too large")
+	ErrUploadIncomplete = errors.New("upload is not complete")
+	ErrUploadBusy       = errors.New("upload is being finalized")
+)
+
+// UploadOffsetError is returned when a chunk does not start where the
+// server's copy of the upload ends. Clients resume from Offset.
+type UploadOffsetError struct {
+	Offset int64
+}
+
+func (e *UploadOffsetError) Error() string {
+	return fmt.Sprintf("upload offset mismatch: server has %d bytes", e.Offset)
+}
+
+// appendChunkScript records a stored chunk if the session is still at the
+// expected offset. Returns 1 on success, 0 on an offset mismatch and -1 if
+// the session is gone or no longer accepting data.
+var appendChunkScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'state') ~= ARGV[1] then
+	return -1
+end
+if redis.call('HGET', KEYS[1], 'offset') ~= ARGV[2] then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'offset', ARGV[3], 'expires_at', ARGV[5])
+redis.call('RPUSH', KEYS[2], ARGV[4])
+redis.call('ZADD', KEYS[3], ARGV[5], ARGV[6])
+redis.call('EXPIRE', KEYS[1], ARGV[7])
+redis.call('EXPIRE', KEYS[2], ARGV[7])
+return 1
+`)
+
+// beginFinalizeScript moves a fully uploaded session into the finalizing
+// state so it cannot be completed twice. Returns 1 on success, 0 if data is
+// missing and -1 if the session is not accepting a finalize.
+var beginFinalizeScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'state') ~= ARGV[1] then
+	return -1
+end
+if redis.call('HGET', KEYS[1], 'offset') ~= redis.call('HGET', KEYS[1], 'size') then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'state', ARGV[2], 'expires_at', ARGV[3])
+redis.call('ZADD', KEYS[2], ARGV[3], ARGV[4])
+return 1
+`)
+
+// claimExpiredScript lets exactly one server instance collect an expired
+// session, and only if no chunk has extended it in the meantime.
+var claimExpiredScript = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[2], ARGV[1])
+if not score or tonumber(score) > tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('ZREM', KEYS[2], ARGV[1])
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	redis.call('HSET', KEYS[1], 'state', ARGV[3])
+end
+return 1
+`)
+
+type UploadService struct {
+	config  *config.Config
+	redis   *redis.Client
+	storage storage.Backend
+	tracks  *TrackService
+	quotas  *QuotaService
+}
+
+func NewUploadService(cfg *config.Config) *UploadService {
+	return &UploadService{
+		config:  cfg,
+		redis:   database.GetRedis(),
+		storage: storage.GetBackend(),
+		tracks:  NewTrackService(cfg),
+		quotas:  NewQuotaService(cfg),
+	}
+}
+
+type CreateUploadRequest struct {
+	Filename    string    `json:"filename" binding:"required,max=255"`
+	Size        int64     `json:"size" binding:"required,min=1"`
+	ContentType string    `json:"content_type"`
+	UserID      uuid.UUID `json:"-"`
+}
+
+type UploadSessionResponse struct {
+	ID           uuid.UUID `json:"id"`
+	Filename     string    `json:"filename"`
+	Size         int64     `json:"size"`
+	Offset       int64     `json:"offset"`
+	MaxChunkSize int64     `json:"max_chunk_size"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+type uploadSession struct {
+	ID          uuid.UUID
+	UserID      uuid.UUID
+	Filename    string
+	ContentType string
+	Size        int64
+	Offset      int64
+	State       string
+	ExpiresAt   time.Time
+}
+
+func (u *uploadSession) response() *UploadSessionResponse {
+	return &UploadSessionResponse{
+		ID:           u.ID,
+		Filename:     u.Filename,
+		Size:         u.Size,
+		Offset:       u.Offset,
+		MaxChunkSize: maxChunkSize,
+		ExpiresAt:    u.ExpiresAt,
+	}
+}
+
+func uploadKey(id uuid.UUID) string {
+	return fmt.Sprintf("upload:%s", id)
+}
+
+func uploadChunksKey(id uuid.UUID) string {
+	return fmt.Sprintf("upload:%s:chunks", id)
+}
+
+// keyTTL is a safety net so Redis never keeps session state forever. The
+// sweeper normally removes sessions well before this.
+func (s *UploadService) keyTTL() time.Duration {
+	return 2 * s.config.Storage.UploadSessionTTL
+}
+
+func (s *UploadService) CreateUpload(req *CreateUploadRequest) (*UploadSessionResponse, error) {
+	if req.Size > s.config.Storage.MaxUploadSize {
+		return nil, fmt.Errorf("%w: %d bytes (max: %d bytes)", ErrUploadTooLarge, req.Size, s.config.Storage.MaxUploadSize)
+	}
+
+	session := &uploadSession{
+		ID:          uuid.New(),
+		UserID:      req.UserID,
+		Filename:    req.Filename,
+		ContentType: req.ContentType,
+		Size:        req.Size,
+		State:       uploadStateUploading,
+		ExpiresAt:   time.Now().Add(s.config.Storage.UploadSessionTTL),
+	}
+
+	// The whole upload is counted against the quota from the start, so it
+	// is rejected before any data is sent.
+	ctx := context.Background()
+	if err := s.quotas.reserve(ctx, session.ID, session.UserID, session.Size, int(s.keyTTL().Seconds())); err != nil {
+		return nil, err
+	}
+
+	pipe := s.redis.TxPipeline()
+	pipe.HSet(ctx, uploadKey(session.ID), map[string]interface{}{
+		"user_id":      session.UserID.String(),
+		"filename":     session.Filename,
+		"content_type": session.ContentType,
+		"size":         session.Size,
+		"offset":       0,
+		"state":        session.State,
+		"expires_at":   session.ExpiresAt.Unix(),
+	})
+	pipe.Expire(ctx, uploadKey(session.ID), s.keyTTL())
+	pipe.ZAdd(ctx, uploadExpiryKey, redis.Z{Score: float64(session.ExpiresAt.Unix()), Member: session.ID.String()})
+	if _, err := pipe.Exec(ctx); err != nil {
+		s.quotas.release(ctx, session.ID, session.UserID)
+		return nil, fmt.Errorf("failed to create upload session: %w", err)
+	}
+
+	return session.response(), nil
+}
+
+func (s *UploadService) GetUpload(uploadID, userID uuid.UUID) (*UploadSessionResponse, error) {
+	session, err := s.getSession(context.Background(), uploadID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return session.response(), nil
+}
+
+// AppendChunk stores length bytes read from r as the next chunk of the
+// upload. offset must equal the number of bytes the server already has.
+func (s *UploadService) AppendChunk(uploadID, userID uuid.UUID, offset int64, r io.Reader, length int64) (*UploadSessionResponse, error) {
+	ctx := context.Background()
+	session, err := s.getSession(ctx, uploadID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if session.State != uploadStateUploading {
+		return nil, ErrUploadBusy
+	}
+	if offset != session.Offset {
+		return nil, &UploadOffsetError{Offset: session.Offset}
+	}
+	if length <= 0 {
+		return nil, errors.New("chunk is empty")
+	}
+	if length > maxChunkSize {
+		return nil, fmt.Errorf("%w: %d bytes (max: %d bytes)", ErrChunkTooLarge, length, maxChunkSize)
+	}
+	if offset+length > session.Size {
+		return nil, fmt.Errorf("%w: chunk ends at %d bytes but upload size is %d bytes", ErrChunkTooLarge, offset+length, session.Size)
+	}
+
+	// Chunk keys are unique so a request that loses a race cannot clobber
+	// the chunk that won.
+	chunkKey := fmt.Sprintf("tmp/uploads/%s/%016d-%s", uploadID, offset, uuid.New().String()[:8])
+	if err := s.storage.Put(ctx, chunkKey, r, length, "application/octet-stream"); err != nil {
+		return nil, fmt.Errorf("failed to store chunk: %w", err)
+	}
+
+	expiresAt := time.Now().Add(s.config.Storage.UploadSessionTTL)
+	result, err := appendChunkScript.Run(ctx, s.redis,
+		[]string{uploadKey(uploadID), uploadChunksKey(uploadID), uploadExpiryKey},
+		uploadStateUploading, offset, offset+length, chunkKey, expiresAt.Unix(), uploadID.String(), int(s.keyTTL().Seconds()),
+	).Int()
+	if err != nil || result != 1 {
+		s.storage.Delete(ctx, chunkKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to record chunk: %w", err)
+	}
+
+	switch result {
+	case 0:
+		current, err := s.getSession(ctx, uploadID, userID)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &UploadOffsetError{Offset: current.Offset}
+	case -1:
+		return nil, ErrUploadBusy
+	}
+
+	session.Offset = offset + length
+	session.ExpiresAt = expiresAt
+	return session.response(), nil
+}
+
+// CompleteUpload assembles the chunks and runs the result through the same
+// validation and track creation as a single-request upload.
+func (s *UploadService) CompleteUpload(uploadID, userID uuid.UUID) (*TrackResponse, error) {
+	ctx := context.Background()
+	session, err := s.getSession(ctx, uploadID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := beginFinalizeScript.Run(ctx, s.redis,
+		[]string{uploadKey(uploadID), uploadExpiryKey},
+		uploadStateUploading, uploadStateFinalizing, time.Now().Add(s.config.Storage.UploadSessionTTL).Unix(), uploadID.String(),
+	).Int()
+	if err != nil {
+		return nil, fmt.Errorf("failed to finalize upload: %w", err)
+	}
+	switch result {
+	case 0:
+		return nil, fmt.Errorf("%w: received %d of %d bytes", ErrUploadIncomplete, session.Offset, session.Size)
+	case -1:
+		return nil, ErrUploadBusy
+	}
+
+	track, err := s.assemble(ctx, session)
+	if err != nil {
+		var mismatchErr *ContentTypeMismatchError
+		var duplicateErr *DuplicateTrackError
+		if errors.Is(err, ErrUnsupportedAudio) || errors.As(err, &mismatchErr) || errors.As(err, &duplicateErr) {
+			// The content will never be accepted, so don't keep it around.
+			s.discard(ctx, uploadID)
+			return nil, err
+		}
+		s.redis.HSet(ctx, uploadKey(uploadID), "state", uploadStateUploading)
+		return nil, err
+	}
+
+	s.discard(ctx, uploadID)
+	return track, nil
+}
+
+func (s *UploadService) CancelUpload(uploadID, userID uuid.UUID) error {
+	ctx := context.Background()
+	if _, err := s.getSession(ctx, uploadID, userID); err != nil {
+		return err
+	}
+	return s.discard(ctx, uploadID)
+}
+
+// CollectExpired removes sessions that have not received data within the
+// session TTL, including their stored chunks.
+func (s *UploadService) CollectExpired() (int, error) {
+	ctx := context.Background()
+	now := time.Now().Unix()
+	ids, err := s.redis.ZRangeByScore(ctx, uploadExpiryKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now, 10),
+	}).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list expired uploads: %w", err)
+	}
+
+	collected := 0
+	for _, raw := range ids {
+		uploadID, err := uuid.Parse(raw)
+		if err != nil {
+			s.redis.ZRem(ctx, uploadExpiryKey, raw)
+			continue
+		}
+
+		claimed, err := claimExpiredScript.Run(ctx, s.redis,
+			[]string{uploadKey(uploadID), uploadExpiryKey},
+			raw, now, uploadStateExpired,
+		).Int()
+		if err != nil {
+			return collected, fmt.Errorf("failed to claim expired upload: %w", err)
+		}
+		if claimed != 1 {
+			continue
+		}
+
+		if err := s.discard(ctx, uploadID); err != nil {
+			log.Printf("Failed to remove expired upload %s: %v", uploadID, err)
+			continue
+		}
+		collected++
+	}
+
+	return collected, nil
+}
+
+// StartSweeper periodically collects abandoned upload sessions.
+func (s *UploadService) StartSweeper(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			n, err := s.CollectExpired()
+			if err != nil {
+				log.Printf("Upload sweeper failed: %v", err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("Removed %d expired upload sessions", n)
+			}
+		}
+	}()
+}
+
+func (s *UploadService) getSession(ctx context.Context, uploadID, userID uuid.UUID) (*uploadSession, error) {
+	fields, err := s.redis.HGetAll(ctx, uploadKey(uploadID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upload session: %w", err)
+	}
+	if len(fields) == 0 || fields["user_id"] != userID.String() || fields["state"] == uploadStateExpired {
+		return nil, ErrUploadNotFound
+	}
+
+	session := &uploadSession{
+		ID:          uploadID,
+		UserID:      userID,
+		Filename:    fields["filename"],
+		ContentType: fields["content_type"],
+		State:       fields["state"],
+	}
+	session.Size, _ = strconv.ParseInt(fields["size"], 10, 64)
+	session.Offset, _ = strconv.ParseInt(fields["offset"], 10, 64)
+	expiresAt, _ := strconv.ParseInt(fields["expires_at"], 10, 64)
+	session.ExpiresAt = time.Unix(expiresAt, 0)
+
+	if time.Now().After(session.ExpiresAt) {
+		return nil, ErrUploadNotFound
+	}
+	return session, nil
+}
+
+// assemble concatenates the chunks into a local temporary file, which gives
+// the validators the seekable reader they need. The content is hashed as
+// it is written.
+func (s *UploadService) assemble(ctx context.Context, session *uploadSession) (*TrackResponse, error) {
+	chunks, err := s.redis.LRange(ctx, uploadChunksKey(session.ID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upload chunks: %w", err)
+	}
+
+	tmp, err := os.CreateTemp("", "maxify-upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	dst := io.MultiWriter(tmp, hash)
+	for _, key := range chunks {
+		if err := s.copyChunk(ctx, dst, key); err != nil {
+			return nil, fmt.Errorf("failed to assemble upload: %w", err)
+		}
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to assemble upload: %w", err)
+	}
+	if size != session.Size {
+		return nil, fmt.Errorf("failed to assemble upload: got %d of %d bytes", size, session.Size)
+	}
+
+	return s.tracks.ingest(tmp, hex.EncodeToString(hash.Sum(nil)), session.Filename, session.ContentType, session.Size, session.UserID, session.ID)
+}
+
+func (s *UploadService) copyChunk(ctx context.Context, dst io.Writer, key string) error {
+	chunk, err := s.storage.Get(ctx, key, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer chunk.Close()
+
+	_, err = io.Copy(dst, chunk)
+	return err
+}
+
+// discard deletes a session's chunks and state, and releases its quota
+// reservation.
+func (s *UploadService) discard(ctx context.Context, uploadID uuid.UUID) error {
+	chunks, err := s.redis.LRange(ctx, uploadChunksKey(uploadID), 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("failed to get upload chunks: %w", err)
+	}
+	for _, key := range chunks {
+		if err := s.storage.Delete(ctx, key); err != nil {
+			return fmt.Errorf("failed to delete chunk: %w", err)
+		}
+	}
+
+	if owner, err := s.redis.HGet(ctx, uploadKey(uploadID), "user_id").Result(); err == nil {
+		if userID, err := uuid.Parse(owner); err == nil {
+			if err := s.quotas.release(ctx, uploadID, userID); err != nil {
+				return fmt.Errorf("failed to release quota: %w", err)
+			}
+		}
+	}
+
+	if err := s.redis.Del(ctx, uploadKey(uploadID), uploadChunksKey(uploadID)).Err(); err != nil {
+		return fmt.Errorf("failed to delete upload session: %w", err)
+	}
+	return s.redis.ZRem(ctx, uploadExpiryKey, uploadID.String()).Err()
+}
@@ -0,0 +1,447 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"maxify/internal/config"
+	"maxify/internal/models"
+	"maxify/internal/storage"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// testMP3 returns n 417 byte MPEG-1 Layer III frames at 128 kbps, 44.1 kHz.
+func testMP3(n int) []byte {
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xFF, 0xFB, 0x90, 0x00})
+	return bytes.Repeat(frame, n)
+}
+
+type uploadFixture struct {
+	db      *gorm.DB
+	redis   *redis.Client
+	storage storage.Backend
+	uploads *UploadService
+	userID  uuid.UUID
+}
+
+// newUploadFixture creates a user whose plan allows storageBytes, or any
+// amount if it is 0.
+func newUploadFixture(t *testing.T, storageBytes int64) *uploadFixture {
+	t.Helper()
+	db := newTestDB(t, &models.User{}, &models.Track{}, &models.Blob{}, &models.Artwork{},
+		&models.Artist{}, &models.Album{}, &models.Rendition{})
+	client, _ := newTestRedis(t)
+	backend, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open storage: %v", err)
+	}
+
+	cfg := &config.Config{
+		Storage: config.StorageConfig{MaxUploadSize: 1 << 20, UploadSessionTTL: time.Hour},
+		Quota: config.QuotaConfig{
+			DefaultPlan: "free",
+			Plans:       map[string]config.PlanQuota{"free": {StorageBytes: storageBytes}},
+		},
+	}
+	quotas := &QuotaService{config: cfg, db: db, redis: client}
+	tracks := &TrackService{
+		config:     cfg,
+		db:         db,
+		storage:    backend,
+		transcodes: &TranscodeService{config: cfg, db: db, storage: backend},
+		quotas:     quotas,
+	}
+
+	user := &models.User{Username: "uploader", Email: "uploader@example.com", PasswordHash: "x"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	return &uploadFixture{
+		db:      db,
+		redis:   client,
+		storage: backend,
+		uploads: &UploadService{config: cfg, redis: client, storage: backend, tracks: tracks, quotas: quotas},
+		userID:  user.ID,
+	}
+}
+
+func (f *uploadFixture) create(t *testing.T, size int64) uuid.UUID {
+	t.Helper()
+	session, err := f.uploads.CreateUpload(&CreateUploadRequest{Filename: "song.mp3", Size: size, ContentType: "audio/mpeg", UserID: f.userID})
+	if err != nil {
+		t.Fatalf("CreateUpload: %v", err)
+	}
+	return session.ID
+}
+
+func (f *uploadFixture) append(uploadID uuid.UUID, offset int64, chunk []byte) (*UploadSessionResponse, error) {
+	return f.uploads.AppendChunk(uploadID, f.userID, offset, bytes.NewReader(chunk), int64(len(chunk)))
+}
+
+// upload sends data in two chunks.
+func (f *uploadFixture) upload(t *testing.T, uploadID uuid.UUID, data []byte) {
+	t.Helper()
+	half := len(data) / 2
+	if _, err := f.append(uploadID, 0, data[:half]); err != nil {
+		t.Fatalf("AppendChunk: %v", err)
+	}
+	if _, err := f.append(uploadID, int64(half), data[half:]); err != nil {
+		t.Fatalf("AppendChunk: %v", err)
+	}
+}
+
+// chunkObjects returns the keys of the stored chunks of an upload.
+func (f *uploadFixture) chunkObjects(t *testing.T, uploadID uuid.UUID) []string {
+	t.Helper()
+	var keys []string
+	if err := f.storage.List(context.Background(), "tmp/uploads/"+uploadID.String()+"/", func(object *storage.ObjectInfo) error {
+		keys = append(keys, object.Key)
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to list chunks: %v", err)
+	}
+	return keys
+}
+
+func (f *uploadFixture) reserved(t *testing.T) map[string]string {
+	t.Helper()
+	reserved, err := f.redis.HGetAll(context.Background(), uploadReservationsKey(f.userID)).Result()
+	if err != nil {
+		t.Fatalf("failed to get reservations: %v", err)
+	}
+	return reserved
+}
+
+func (f *uploadFixture) state(t *testing.T, uploadID uuid.UUID) string {
+	t.Helper()
+	return f.redis.HGet(context.Background(), uploadKey(uploadID), "state").Val()
+}
+
+func TestAppendChunk(t *testing.T) {
+	f := newUploadFixture(t, 0)
+	uploadID := f.create(t, 1000)
+
+	session, err := f.append(uploadID, 0, make([]byte, 400))
+	if err != nil {
+		t.Fatalf("AppendChunk: %v", err)
+	}
+	if session.Offset != 400 {
+		t.Errorf("offset = %d, want 400", session.Offset)
+	}
+
+	tests := []struct {
+		name   string
+		offset int64
+		length int
+		want   error
+	}{
+		{"duplicate chunk", 0, 400, &UploadOffsetError{Offset: 400}},
+		{"overlapping chunk", 200, 400, &UploadOffsetError{Offset: 400}},
+		{"chunk out of order", 800, 200, &UploadOffsetError{Offset: 400}},
+		{"past the end", 400, 601, ErrChunkTooLarge},
+	}
+	for _, tt := range tests {
+		_, err := f.append(uploadID, tt.offset, make([]byte, tt.length))
+		var offsetErr *UploadOffsetError
+		if want, ok := tt.want.(*UploadOffsetError); ok {
+			if !errors.As(err, &offsetErr) || offsetErr.Offset != want.Offset {
+				t.Errorf("%s: AppendChunk = %v, want %v", tt.name, err, want)
+			}
+		} else if !errors.Is(err, tt.want) {
+			t.Errorf("%s: AppendChunk = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	if _, err := f.append(uploadID, 400, make([]byte, 600)); err != nil {
+		t.Fatalf("AppendChunk: %v", err)
+	}
+	// Rejected chunks were not kept.
+	if keys := f.chunkObjects(t, uploadID); len(keys) != 2 {
+		t.Errorf("stored chunks = %v, want 2", keys)
+	}
+	if got, _ := f.uploads.GetUpload(uploadID, f.userID); got == nil || got.Offset != 1000 {
+		t.Errorf("GetUpload = %+v, want offset 1000", got)
+	}
+	if _, err := f.uploads.GetUpload(uploadID, uuid.New()); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("GetUpload by another user = %v, want ErrUploadNotFound", err)
+	}
+}
+
+func TestUploadScripts(t *testing.T) {
+	ctx := context.Background()
+	f := newUploadFixture(t, 0)
+	uploadID := f.create(t, 1000)
+	keys := []string{uploadKey(uploadID), uploadChunksKey(uploadID), uploadExpiryKey}
+	expiresAt := time.Now().Add(time.Hour).Unix()
+
+	appendChunk := func(state string, offset, end int64) int {
+		t.Helper()
+		result, err := appendChunkScript.Run(ctx, f.redis, keys,
+			state, offset, end, "chunk-"+strconv.FormatInt(offset, 10), expiresAt, uploadID.String(), 3600).Int()
+		if err != nil {
+			t.Fatalf("append script: %v", err)
+		}
+		return result
+	}
+	beginFinalize := func() int {
+		t.Helper()
+		result, err := beginFinalizeScript.Run(ctx, f.redis, []string{uploadKey(uploadID), uploadExpiryKey},
+			uploadStateUploading, uploadStateFinalizing, expiresAt, uploadID.String()).Int()
+		if err != nil {
+			t.Fatalf("finalize script: %v", err)
+		}
+		return result
+	}
+
+	if got := appendChunk(uploadStateUploading, 0, 600); got != 1 {
+		t.Errorf("append at the offset = %d, want 1", got)
+	}
+	// A request that read the session before the first chunk landed.
+	if got := appendChunk(uploadStateUploading, 0, 600); got != 0 {
+		t.Errorf("append at a stale offset = %d, want 0", got)
+	}
+	if got := beginFinalize(); got != 0 {
+		t.Errorf("finalize of a partial upload = %d, want 0", got)
+	}
+	if got := appendChunk(uploadStateUploading, 600, 1000); got != 1 {
+		t.Errorf("append at the offset = %d, want 1", got)
+	}
+	if chunks := f.redis.LRange(ctx, uploadChunksKey(uploadID), 0, -1).Val(); len(chunks) != 2 {
+		t.Errorf("recorded chunks = %v, want 2", chunks)
+	}
+	if score := f.redis.ZScore(ctx, uploadExpiryKey, uploadID.String()).Val(); int64(score) != expiresAt {
+		t.Errorf("expiry = %v, want %d", score, expiresAt)
+	}
+
+	if got := beginFinalize(); got != 1 {
+		t.Errorf("finalize = %d, want 1", got)
+	}
+	if got := beginFinalize(); got != -1 {
+		t.Errorf("second finalize = %d, want -1", got)
+	}
+	if got := appendChunk(uploadStateUploading, 1000, 1000); got != -1 {
+		t.Errorf("append while finalizing = %d, want -1", got)
+	}
+
+	claim := func(now int64) int {
+		t.Helper()
+		result, err := claimExpiredScript.Run(ctx, f.redis, []string{uploadKey(uploadID), uploadExpiryKey},
+			uploadID.String(), now, uploadStateExpired).Int()
+		if err != nil {
+			t.Fatalf("claim script: %v", err)
+		}
+		return result
+	}
+	// A chunk extended the session after the sweeper listed it.
+	if got := claim(expiresAt - 1); got != 0 {
+		t.Errorf("claim of an extended session = %d, want 0", got)
+	}
+	if got := claim(expiresAt); got != 1 {
+		t.Errorf("claim = %d, want 1", got)
+	}
+	if got := claim(expiresAt); got != 0 {
+		t.Errorf("second claim = %d, want 0", got)
+	}
+	if got := f.state(t, uploadID); got != uploadStateExpired {
+		t.Errorf("state = %q, want %q", got, uploadStateExpired)
+	}
+}
+
+func TestCompleteUpload(t *testing.T) {
+	f := newUploadFixture(t, 0)
+	data := testMP3(10)
+	uploadID := f.create(t, int64(len(data)))
+
+	if _, err := f.append(uploadID, 0, data[:1000]); err != nil {
+		t.Fatalf("AppendChunk: %v", err)
+	}
+	if _, err := f.uploads.CompleteUpload(uploadID, f.userID); !errors.Is(err, ErrUploadIncomplete) {
+		t.Fatalf("CompleteUpload of a partial upload = %v, want ErrUploadIncomplete", err)
+	}
+	// The upload can be resumed.
+	if _, err := f.append(uploadID, 1000, data[1000:]); err != nil {
+		t.Fatalf("AppendChunk after an early complete: %v", err)
+	}
+
+	track, err := f.uploads.CompleteUpload(uploadID, f.userID)
+	if err != nil {
+		t.Fatalf("CompleteUpload: %v", err)
+	}
+	if track.Title != "song" || track.FileSize != int64(len(data)) {
+		t.Errorf("track = %+v", track)
+	}
+
+	if _, err := f.uploads.GetUpload(uploadID, f.userID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("GetUpload after completion = %v, want ErrUploadNotFound", err)
+	}
+	if _, err := f.uploads.CompleteUpload(uploadID, f.userID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("second CompleteUpload = %v, want ErrUploadNotFound", err)
+	}
+	if keys := f.chunkObjects(t, uploadID); len(keys) != 0 {
+		t.Errorf("chunks left after completion: %v", keys)
+	}
+	if reserved := f.reserved(t); len(reserved) != 0 {
+		t.Errorf("reservations left after completion: %v", reserved)
+	}
+}
+
+func TestCompleteUploadRejectsContent(t *testing.T) {
+	f := newUploadFixture(t, 0)
+	data := []byte(strings.Repeat("not audio ", 100))
+	uploadID := f.create(t, int64(len(data)))
+	f.upload(t, uploadID, data)
+
+	if _, err := f.uploads.CompleteUpload(uploadID, f.userID); !errors.Is(err, ErrUnsupportedAudio) {
+		t.Fatalf("CompleteUpload = %v, want ErrUnsupportedAudio", err)
+	}
+	// Content that will never be accepted is dropped with its session.
+	if _, err := f.uploads.GetUpload(uploadID, f.userID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("GetUpload = %v, want ErrUploadNotFound", err)
+	}
+	if keys := f.chunkObjects(t, uploadID); len(keys) != 0 {
+		t.Errorf("chunks left: %v", keys)
+	}
+	if reserved := f.reserved(t); len(reserved) != 0 {
+		t.Errorf("reservations left: %v", reserved)
+	}
+}
+
+func TestCompleteUploadWrongTotalSize(t *testing.T) {
+	f := newUploadFixture(t, 0)
+	data := testMP3(10)
+	uploadID := f.create(t, int64(len(data)))
+	f.upload(t, uploadID, data)
+
+	// A chunk lost data in storage after it was recorded.
+	ctx := context.Background()
+	chunks := f.redis.LRange(ctx, uploadChunksKey(uploadID), 0, -1).Val()
+	if err := f.storage.Put(ctx, chunks[1], bytes.NewReader(data[:10]), 10, "application/octet-stream"); err != nil {
+		t.Fatalf("failed to replace chunk: %v", err)
+	}
+
+	_, err := f.uploads.CompleteUpload(uploadID, f.userID)
+	if err == nil || !strings.Contains(err.Error(), "got 2095 of 4170 bytes") {
+		t.Fatalf("CompleteUpload = %v, want a size error", err)
+	}
+	var count int64
+	f.db.Model(&models.Track{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d tracks created", count)
+	}
+	// The session is kept for another attempt.
+	if got := f.state(t, uploadID); got != uploadStateUploading {
+		t.Errorf("state = %q, want %q", got, uploadStateUploading)
+	}
+}
+
+func TestCompleteUploadOverQuota(t *testing.T) {
+	data := testMP3(10)
+	size := int64(len(data))
+	f := newUploadFixture(t, 2*size)
+	uploadID := f.create(t, size)
+	f.upload(t, uploadID, data)
+
+	// A track uploaded in a single request meanwhile takes the space left.
+	other := &models.Track{Title: "Other", FileSize: size + 1, UserID: f.userID}
+	if err := f.db.Create(other).Error; err != nil {
+		t.Fatalf("failed to create track: %v", err)
+	}
+
+	if _, err := f.uploads.CompleteUpload(uploadID, f.userID); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("CompleteUpload = %v, want ErrQuotaExceeded", err)
+	}
+	// The user can free space and complete the upload again.
+	if got := f.state(t, uploadID); got != uploadStateUploading {
+		t.Errorf("state = %q, want %q", got, uploadStateUploading)
+	}
+	if _, ok := f.reserved(t)[uploadID.String()]; !ok {
+		t.Error("reservation released after ErrQuotaExceeded")
+	}
+	if keys := f.chunkObjects(t, uploadID); len(keys) != 2 {
+		t.Errorf("stored chunks = %v, want 2", keys)
+	}
+
+	if err := f.db.Unscoped().Delete(other).Error; err != nil {
+		t.Fatalf("failed to delete track: %v", err)
+	}
+	if _, err := f.uploads.CompleteUpload(uploadID, f.userID); err != nil {
+		t.Fatalf("CompleteUpload after freeing space: %v", err)
+	}
+}
+
+func TestUploadQuotaReservation(t *testing.T) {
+	f := newUploadFixture(t, 1500)
+	first := f.create(t, 1000)
+
+	_, err := f.uploads.CreateUpload(&CreateUploadRequest{Filename: "b.mp3", Size: 1000, UserID: f.userID})
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("CreateUpload over the reserved quota = %v, want ErrQuotaExceeded", err)
+	}
+
+	if _, err := f.append(first, 0, make([]byte, 100)); err != nil {
+		t.Fatalf("AppendChunk: %v", err)
+	}
+	if err := f.uploads.CancelUpload(first, f.userID); err != nil {
+		t.Fatalf("CancelUpload: %v", err)
+	}
+	if keys := f.chunkObjects(t, first); len(keys) != 0 {
+		t.Errorf("chunks left after cancel: %v", keys)
+	}
+	if reserved := f.reserved(t); len(reserved) != 0 {
+		t.Errorf("reservations left after cancel: %v", reserved)
+	}
+	f.create(t, 1000)
+}
+
+func TestCollectExpired(t *testing.T) {
+	ctx := context.Background()
+	f := newUploadFixture(t, 0)
+	expired := f.create(t, 1000)
+	active := f.create(t, 1000)
+	for _, id := range []uuid.UUID{expired, active} {
+		if _, err := f.append(id, 0, make([]byte, 100)); err != nil {
+			t.Fatalf("AppendChunk: %v", err)
+		}
+	}
+
+	past := time.Now().Add(-time.Minute).Unix()
+	f.redis.HSet(ctx, uploadKey(expired), "expires_at", past)
+	f.redis.ZAdd(ctx, uploadExpiryKey, redis.Z{Score: float64(past), Member: expired.String()})
+
+	if _, err := f.uploads.GetUpload(expired, f.userID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("GetUpload of an expired upload = %v, want ErrUploadNotFound", err)
+	}
+
+	n, err := f.uploads.CollectExpired()
+	if err != nil {
+		t.Fatalf("CollectExpired: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("collected %d uploads, want 1", n)
+	}
+	if exists := f.redis.Exists(ctx, uploadKey(expired), uploadChunksKey(expired)).Val(); exists != 0 {
+		t.Errorf("%d keys of the expired upload left", exists)
+	}
+	if keys := f.chunkObjects(t, expired); len(keys) != 0 {
+		t.Errorf("chunks of the expired upload left: %v", keys)
+	}
+	if reserved := f.reserved(t); len(reserved) != 1 || reserved[active.String()] == "" {
+		t.Errorf("reservations = %v, want only the active upload's", reserved)
+	}
+
+	if _, err := f.uploads.GetUpload(active, f.userID); err != nil {
+		t.Errorf("GetUpload of the active upload: %v", err)
+	}
+	if n, err := f.uploads.CollectExpired(); n != 0 || err != nil {
+		t.Errorf("second CollectExpired = %d, %v; want 0", n, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 
+	"maxify/internal/config"
 	"maxify/internal/database"
 	"maxify/internal/models"
 
@@ -12,12 +13,14 @@ import (
 )
 
 type UserService struct {
-	db *gorm.DB
+	db     *gorm.DB
+	quotas *QuotaService
 }
 
-func NewUserService() *UserService {
+func NewUserService(cfg *config.Config) *UserService {
 	return &UserService{
-		db: database.GetDB(),
+		db:     database.GetDB(),
+		quotas: NewQuotaService(cfg),
 	}
 }
 
@@ -54,7 +57,14 @@ func (s *UserService) GetUserByEmail(email string) (*models.User, error) {
 	return &user, nil
 }
 
-func (s *UserService) UpdateUser(userID uuid.UUID, updates map[string]interface{}) (*models.User, error) {
+// UpdateProfileRequest holds the profile fields users can change
+// themselves. Omitted fields are left as they are.
+type UpdateProfileRequest struct {
+	Username *string `json:"username" binding:"omitempty,min=3,max=50"`
+	Email    *string `json:"email" binding:"omitempty,email"`
+}
+
+func (s *UserService) UpdateUser(userID uuid.UUID, req *UpdateProfileRequest) (*models.User, error) {
 	var user models.User
 	if err := s.db.First(&user, userID).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -63,7 +73,20 @@ func (s *UserService) UpdateUser(userID uuid.UUID, updates map[string]interface{
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
-	if err := s.db.Model(&user).Updates(updates).Error; err != nil {
+	var columns []string
+	if req.Username != nil {
+		user.Username = *req.Username
+		columns = append(columns, "username")
+	}
+	if req.Email != nil {
+		user.Email = *req.Email
+		columns = append(columns, "email")
+	}
+	if len(columns) == 0 {
+		return &user, nil
+	}
+
+	if err := s.db.Model(&user).Select(columns).Updates(&user).Error; err != nil {
 		return nil, fmt.Errorf("failed to update user: %w", err)
 	}
 
@@ -76,21 +99,3 @@ func (s *UserService) DeleteUser(userID uuid.UUID) error {
 	}
 	return nil
 }
-
-func (s *UserService) GetUserStats(userID uuid.UUID) (map[string]interface{}, error) {
-	var trackCount int64
-	var playlistCount int64
-
-	if err := s.db.Model(&models.Track{}).Where("user_id = ?", userID).Count(&trackCount).Error; err != nil {
-		return nil, fmt.Errorf("failed to count tracks: %w", err)
-	}
-
-	if err := s.db.Model(&models.Playlist{}).Where("user_id = ?", userID).Count(&playlistCount).Error; err != nil {
-		return nil, fmt.Errorf("failed to count playlists: %w", err)
-	}
-
-	return map[string]interface{}{
-		"tracks_count":    trackCount,
-		"playlists_count": playlistCount,
-	}, nil
-}
@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type Local struct {
+	root string
+}
+
+func NewLocal(root string) (*Local, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &Local{root: root}, nil
+}
+
+func (l *Local) path(key string) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, filepath.FromSlash(key)), nil
+}
+
+// Put writes to a temporary file next to the destination and renames it into
+// place, so readers never observe a partially written object.
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	dst, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if size >= 0 && written != size {
+		return fmt.Errorf("short write: %d of %d bytes", written, size)
+	}
+
+	return os.Rename(tmp.Name(), dst)
+}
+
+func (l *Local) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotExist
+		}
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+func (l *Local) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotExist
+		}
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, ErrNotExist
+	}
+	return &ObjectInfo{
+		Key:     key,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		ETag:    fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano()),
+	}, nil
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l.removeEmptyDirs(p)
+	return nil
+}
+
+func (l *Local) Move(ctx context.Context, src, dst string) error {
+	from, err := l.path(src)
+	if err != nil {
+		return err
+	}
+	to, err := l.path(dst)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotExist
+		}
+		return err
+	}
+	l.removeEmptyDirs(from)
+	return nil
+}
+
+// removeEmptyDirs removes the directories above p that are left empty,
+// such as those of finished uploads.
+func (l *Local) removeEmptyDirs(p string) {
+	root := filepath.Clean(l.root)
+	for dir := filepath.Dir(p); dir != root && dir != "."; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+}
+
+// List walks the directory holding prefix. Temporary files of writes in
+// progress are skipped.
+func (l *Local) List(ctx context.Context, prefix string, fn func(*ObjectInfo) error) error {
+	dir := l.root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		p, err := l.path(prefix[:i])
+		if err != nil {
+			return err
+		}
+		dir = p
+	}
+
+	err := filepath.WalkDir(dir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".upload-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		return fn(&ObjectInfo{
+			Key:     key,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			ETag:    fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano()),
+		})
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// PresignGet is not available for local storage; files are always served
+// through the API.
+func (l *Local) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return "", ErrPresignUnsupported
+}
@@ -0,0 +1,333 @@
+package storage
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"maxify/internal/config"
+)
+
+// S3 talks to Amazon S3 or any S3-compatible service such as MinIO using
+// plain HTTP requests signed with AWS Signature Version 4.
+type S3 struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+	client    *http.Client
+}
+
+func NewS3(cfg *config.S3Config) (*S3, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("S3 bucket is not configured")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("S3 credentials are not configured")
+	}
+
+	endpoint := cfg.Endpoint
+	if !strings.Contains(endpoint, "://") {
+		scheme := "https"
+		if !cfg.UseSSL {
+			scheme = "http"
+		}
+		endpoint = scheme + "://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", cfg.Endpoint)
+	}
+
+	s := &S3{
+		endpoint:  &url.URL{Scheme: u.Scheme, Host: u.Host},
+		region:    cfg.Region,
+		bucket:    cfg.Bucket,
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+		pathStyle: cfg.PathStyle,
+		client:    &http.Client{},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := s.ensureBucket(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return err
+	}
+	if size < 0 {
+		return errors.New("S3 uploads require a known size")
+	}
+
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(ctx, http.MethodPut, u, header, r, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	header := http.Header{}
+	switch {
+	case length > 0:
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := s.do(ctx, http.MethodGet, u, header, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(ctx, http.MethodHead, u, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &ObjectInfo{
+		Key:         key,
+		Size:        resp.ContentLength,
+		ModTime:     modTime,
+		ETag:        resp.Header.Get("ETag"),
+		ContentType: resp.Header.Get("Content-Type"),
+	}, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, u, nil, nil, 0)
+	if err != nil {
+		if errors.Is(err, ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Move copies the object with CopyObject and deletes the source. S3 copies
+// objects of up to 5 GiB this way.
+func (s *S3) Move(ctx context.Context, src, dst string) error {
+	from, err := cleanKey(src)
+	if err != nil {
+		return err
+	}
+	u, err := s.objectURL(dst)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("X-Amz-Copy-Source", uriEncode("/"+s.bucket+"/"+from, false))
+	resp, err := s.do(ctx, http.MethodPut, u, header, nil, 0)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return s.Delete(ctx, from)
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		ETag         string    `xml:"ETag"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List pages through ListObjectsV2 results.
+func (s *S3) List(ctx context.Context, prefix string, fn func(*ObjectInfo) error) error {
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u := s.bucketURL()
+		u.RawQuery = query.Encode()
+
+		resp, err := s.do(ctx, http.MethodGet, u, nil, nil, 0)
+		if err != nil {
+			return err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("invalid S3 list response: %w", err)
+		}
+
+		for _, object := range result.Contents {
+			if err := fn(&ObjectInfo{
+				Key:     object.Key,
+				Size:    object.Size,
+				ModTime: object.LastModified,
+				ETag:    object.ETag,
+			}); err != nil {
+				return err
+			}
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return "", err
+	}
+	return presignURL(u, s.credentials(), time.Now(), expiry), nil
+}
+
+func (s *S3) ensureBucket(ctx context.Context) error {
+	u := s.bucketURL()
+	resp, err := s.do(ctx, http.MethodHead, u, nil, nil, 0)
+	if err == nil {
+		resp.Body.Close()
+		return nil
+	}
+	if !errors.Is(err, ErrNotExist) {
+		return fmt.Errorf("failed to check S3 bucket: %w", err)
+	}
+
+	var body string
+	if s.region != "" && s.region != "us-east-1" {
+		body = `<CreateBucketConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><LocationConstraint>` +
+			s.region + `</LocationConstraint></CreateBucketConfiguration>`
+	}
+	resp, err = s.do(ctx, http.MethodPut, u, nil, strings.NewReader(body), int64(len(body)))
+	if err != nil {
+		return fmt.Errorf("failed to create S3 bucket: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) bucketURL() *url.URL {
+	u := *s.endpoint
+	if s.pathStyle {
+		u.Path = "/" + s.bucket + "/"
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = "/"
+	}
+	return &u
+}
+
+func (s *S3) objectURL(key string) (*url.URL, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	u := s.bucketURL()
+	u.Path += key
+	u.RawPath = uriEncode(u.Path, false)
+	return u, nil
+}
+
+func (s *S3) credentials() *credentials {
+	return &credentials{
+		accessKey: s.accessKey,
+		secretKey: s.secretKey,
+		region:    s.region,
+		service:   "s3",
+	}
+}
+
+// do signs and sends a request. Non-2xx responses are turned into errors,
+// with 404 reported as ErrNotExist.
+func (s *S3) do(ctx context.Context, method string, u *url.URL, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+		payloadHash = unsignedPayload
+	}
+	signRequest(req, s.credentials(), payloadHash, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 request failed: %w", err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotExist
+	}
+	return nil, parseS3Error(resp)
+}
+
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func parseS3Error(resp *http.Response) error {
+	var e s3Error
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if xml.Unmarshal(data, &e) != nil || e.Code == "" {
+		return fmt.Errorf("S3 request failed with status %d", resp.StatusCode)
+	}
+	return fmt.Errorf("S3 request failed with status %d: %s: %s", resp.StatusCode, e.Code, e.Message)
+}
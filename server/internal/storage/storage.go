@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"maxify/internal/config"
+)
+
+var (
+	ErrNotExist           = errors.New("object does not exist")
+	ErrInvalidKey         = errors.New("invalid object key")
+	ErrPresignUnsupported = errors.New("backend does not support presigned URLs")
+)
+
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ModTime     time.Time
+	ETag        string
+	ContentType string
+}
+
+// Backend stores objects under slash separated keys such as
+// "tracks/<id>.flac". Implementations must be safe for concurrent use.
+type Backend interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get returns length bytes starting at offset. A negative length reads
+	// to the end of the object.
+	Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	// Move renames an object, replacing any object at dst.
+	Move(ctx context.Context, src, dst string) error
+	// List calls fn for every object whose key starts with prefix, in key
+	// order, and stops at the first error fn returns.
+	List(ctx context.Context, prefix string, fn func(*ObjectInfo) error) error
+	PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
+
+var backend Backend
+
+func Init(cfg *config.Config) error {
+	b, err := New(&cfg.Storage)
+	if err != nil {
+		return err
+	}
+	backend = b
+
+	log.Printf("Using %s storage backend", cfg.Storage.Backend)
+	return nil
+}
+
+func GetBackend() Backend {
+	return backend
+}
+
+func New(cfg *config.StorageConfig) (Backend, error) {
+	switch cfg.Backend {
+	case "", "local":
+		return NewLocal(cfg.UploadDir)
+	case "s3":
+		return NewS3(&cfg.S3)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+}
+
+// cleanKey rejects keys that could escape the storage root.
+func cleanKey(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+	cleaned := path.Clean(key)
+	if cleaned != key || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", ErrInvalidKey
+	}
+	return cleaned, nil
+}
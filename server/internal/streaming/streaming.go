@@ -0,0 +1,342 @@
+package streaming
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRanges caps how many ranges a single request may ask for before the
+// Range header is ignored and the full body is served instead.
+const maxRanges = 32
+
+var (
+	errInvalidRange       = errors.New("invalid range")
+	errUnsatisfiableRange = errors.New("unsatisfiable range")
+)
+
+// Content describes a stored object that can be read in byte ranges.
+type Content struct {
+	Size         int64
+	ModTime      time.Time
+	ETag         string // strong validator, including quotes
+	MimeType     string
+	CacheControl string
+	Open         func(offset, length int64) (io.ReadCloser, error)
+}
+
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// Serve writes c honoring Range, If-Range, If-Match, If-None-Match,
+// If-Modified-Since and If-Unmodified-Since as described in RFC 9110.
+func Serve(w http.ResponseWriter, r *http.Request, c *Content) {
+	header := w.Header()
+	header.Set("Accept-Ranges", "bytes")
+	if c.ETag != "" {
+		header.Set("ETag", c.ETag)
+	}
+	if !c.ModTime.IsZero() {
+		header.Set("Last-Modified", c.ModTime.UTC().Format(http.TimeFormat))
+	}
+	if c.CacheControl != "" {
+		header.Set("Cache-Control", c.CacheControl)
+	}
+
+	switch checkPreconditions(r, c) {
+	case http.StatusPreconditionFailed:
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	case http.StatusNotModified:
+		header.Del("Content-Type")
+		header.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	ranges, err := parseRange(r.Header.Get("Range"), c.Size)
+	if err == nil && len(ranges) > 0 && !ifRangeMatches(r, c) {
+		ranges = nil
+	}
+	switch {
+	case errors.Is(err, errUnsatisfiableRange) && ifRangeMatches(r, c):
+		header.Set("Content-Range", fmt.Sprintf("bytes */%d", c.Size))
+		http.Error(w, "requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return
+	case err != nil:
+		// Syntactically invalid ranges are ignored and the full body is sent.
+		ranges = nil
+	}
+
+	switch len(ranges) {
+	case 0:
+		serveFull(w, r, c)
+	case 1:
+		serveSingle(w, r, c, ranges[0])
+	default:
+		serveMultipart(w, r, c, ranges)
+	}
+}
+
+func serveFull(w http.ResponseWriter, r *http.Request, c *Content) {
+	w.Header().Set("Content-Type", c.MimeType)
+	w.Header().Set("Content-Length", strconv.FormatInt(c.Size, 10))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	copyRange(w, c, byteRange{start: 0, length: c.Size})
+}
+
+func serveSingle(w http.ResponseWriter, r *http.Request, c *Content, rng byteRange) {
+	w.Header().Set("Content-Type", c.MimeType)
+	w.Header().Set("Content-Range", rng.contentRange(c.Size))
+	w.Header().Set("Content-Length", strconv.FormatInt(rng.length, 10))
+	w.WriteHeader(http.StatusPartialContent)
+	if r.Method == http.MethodHead {
+		return
+	}
+	copyRange(w, c, rng)
+}
+
+func serveMultipart(w http.ResponseWriter, r *http.Request, c *Content, ranges []byteRange) {
+	boundary := randomBoundary()
+
+	// The body length is known up front, so compute it by rendering the
+	// part headers into a counting writer.
+	counter := &countingWriter{}
+	mw := multipart.NewWriter(counter)
+	_ = mw.SetBoundary(boundary)
+	for _, rng := range ranges {
+		_, _ = mw.CreatePart(partHeader(c, rng))
+		counter.n += rng.length
+	}
+	_ = mw.Close()
+
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+	w.Header().Set("Content-Length", strconv.FormatInt(counter.n, 10))
+	w.WriteHeader(http.StatusPartialContent)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	mw = multipart.NewWriter(w)
+	_ = mw.SetBoundary(boundary)
+	for _, rng := range ranges {
+		part, err := mw.CreatePart(partHeader(c, rng))
+		if err != nil {
+			return
+		}
+		if !copyRange(part, c, rng) {
+			return
+		}
+	}
+	_ = mw.Close()
+}
+
+func partHeader(c *Content, rng byteRange) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Type":  {c.MimeType},
+		"Content-Range": {rng.contentRange(c.Size)},
+	}
+}
+
+func copyRange(w io.Writer, c *Content, rng byteRange) bool {
+	if rng.length == 0 {
+		return true
+	}
+	body, err := c.Open(rng.start, rng.length)
+	if err != nil {
+		return false
+	}
+	defer body.Close()
+
+	_, err = io.CopyN(w, body, rng.length)
+	return err == nil
+}
+
+// checkPreconditions evaluates conditional headers in the order required by
+// RFC 9110 section 13.2.2 and returns the status to short-circuit with, or 0.
+func checkPreconditions(r *http.Request, c *Content) int {
+	if im := r.Header.Get("If-Match"); im != "" {
+		if !etagListMatches(im, c.ETag, true) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ius := r.Header.Get("If-Unmodified-Since"); ius != "" && !c.ModTime.IsZero() {
+		if t, err := http.ParseTime(ius); err == nil && c.ModTime.Truncate(time.Second).After(t) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return 0
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etagListMatches(inm, c.ETag, false) {
+			return http.StatusNotModified
+		}
+		return 0
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !c.ModTime.IsZero() {
+		if t, err := http.ParseTime(ims); err == nil && !c.ModTime.Truncate(time.Second).After(t) {
+			return http.StatusNotModified
+		}
+	}
+	return 0
+}
+
+// ifRangeMatches reports whether a Range request may be honored. An entity
+// tag must match strongly; a date must equal Last-Modified exactly.
+func ifRangeMatches(r *http.Request, c *Content) bool {
+	ir := strings.TrimSpace(r.Header.Get("If-Range"))
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		return !strings.HasPrefix(ir, "W/") && c.ETag != "" && ir == c.ETag
+	}
+	t, err := http.ParseTime(ir)
+	if err != nil || c.ModTime.IsZero() {
+		return false
+	}
+	return c.ModTime.Truncate(time.Second).Equal(t)
+}
+
+func etagListMatches(list, etag string, strong bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strings.HasPrefix(candidate, "W/") {
+			if strong {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRange parses a bytes Range header. It returns errInvalidRange for
+// malformed headers, which callers ignore, and errUnsatisfiableRange when no
+// range overlaps the content.
+func parseRange(header string, size int64) ([]byteRange, error) {
+	if header == "" {
+		return nil, nil
+	}
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return nil, errInvalidRange
+	}
+	specs := strings.Split(header[len(prefix):], ",")
+	if len(specs) > maxRanges {
+		return nil, errInvalidRange
+	}
+
+	var ranges []byteRange
+	var total int64
+	var parsed int
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		parsed++
+		startStr, endStr, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, errInvalidRange
+		}
+		startStr, endStr = strings.TrimSpace(startStr), strings.TrimSpace(endStr)
+
+		var rng byteRange
+		if startStr == "" {
+			// Suffix range: the last n bytes.
+			n, err := strconv.ParseInt(endStr, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errInvalidRange
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			rng = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(startStr, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errInvalidRange
+			}
+			end := size - 1
+			if endStr != "" {
+				e, err := strconv.ParseInt(endStr, 10, 64)
+				if err != nil || e < start {
+					return nil, errInvalidRange
+				}
+				if e < end {
+					end = e
+				}
+			}
+			if start >= size {
+				continue
+			}
+			rng = byteRange{start: start, length: end - start + 1}
+		}
+
+		ranges = append(ranges, rng)
+		total += rng.length
+	}
+
+	if parsed == 0 {
+		return nil, errInvalidRange
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	if total > size {
+		// Overlapping ranges that add up to more than the whole object are
+		// a known amplification trick; answer with the full body instead.
+		return nil, errInvalidRange
+	}
+	return ranges, nil
+}
+
+func randomBoundary() string {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "maxify-byteranges"
+	}
+	return hex.EncodeToString(buf[:])
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
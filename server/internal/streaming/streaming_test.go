@@ -0,0 +1,198 @@
+package streaming
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testData    = []byte("abcdefghijklmnopqrstuvwxyz")
+	testModTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+)
+
+func testContent() *Content {
+	return &Content{
+		Size:         int64(len(testData)),
+		ModTime:      testModTime.Add(500 * time.Millisecond),
+		ETag:         `"v1"`,
+		MimeType:     "audio/mpeg",
+		CacheControl: "private, max-age=60",
+		Open: func(offset, length int64) (io.ReadCloser, error) {
+			return io.NopCloser(io.NewSectionReader(bytes.NewReader(testData), offset, length)), nil
+		},
+	}
+}
+
+func serve(method string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/stream", nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	Serve(rec, req, testContent())
+	return rec
+}
+
+func TestServe(t *testing.T) {
+	lastModified := testModTime.Format(http.TimeFormat)
+	earlier := testModTime.Add(-time.Hour).Format(http.TimeFormat)
+	full := string(testData)
+
+	tests := []struct {
+		name         string
+		method       string
+		headers      map[string]string
+		status       int
+		body         string
+		length       int // of HEAD responses
+		contentRange string
+	}{
+		{name: "full", status: http.StatusOK, body: full},
+		{name: "head", method: http.MethodHead, status: http.StatusOK, length: 26},
+		{name: "closed range", headers: map[string]string{"Range": "bytes=2-5"}, status: http.StatusPartialContent, body: "cdef", contentRange: "bytes 2-5/26"},
+		{name: "single byte", headers: map[string]string{"Range": "bytes=0-0"}, status: http.StatusPartialContent, body: "a", contentRange: "bytes 0-0/26"},
+		{name: "open range", headers: map[string]string{"Range": "bytes=20-"}, status: http.StatusPartialContent, body: "uvwxyz", contentRange: "bytes 20-25/26"},
+		{name: "end past size", headers: map[string]string{"Range": "bytes=10-1000"}, status: http.StatusPartialContent, body: full[10:], contentRange: "bytes 10-25/26"},
+		{name: "suffix", headers: map[string]string{"Range": "bytes=-3"}, status: http.StatusPartialContent, body: "xyz", contentRange: "bytes 23-25/26"},
+		{name: "suffix longer than content", headers: map[string]string{"Range": "bytes=-100"}, status: http.StatusPartialContent, body: full, contentRange: "bytes 0-25/26"},
+		{name: "head range", method: http.MethodHead, headers: map[string]string{"Range": "bytes=2-5"}, status: http.StatusPartialContent, length: 4, contentRange: "bytes 2-5/26"},
+
+		{name: "start at size", headers: map[string]string{"Range": "bytes=26-"}, status: http.StatusRequestedRangeNotSatisfiable, contentRange: "bytes */26"},
+		{name: "start past size", headers: map[string]string{"Range": "bytes=30-40"}, status: http.StatusRequestedRangeNotSatisfiable, contentRange: "bytes */26"},
+		{name: "empty suffix", headers: map[string]string{"Range": "bytes=-0"}, status: http.StatusRequestedRangeNotSatisfiable, contentRange: "bytes */26"},
+		{name: "unsatisfiable with matching if-range", headers: map[string]string{"Range": "bytes=30-", "If-Range": `"v1"`}, status: http.StatusRequestedRangeNotSatisfiable, contentRange: "bytes */26"},
+		{name: "unsatisfiable with stale if-range", headers: map[string]string{"Range": "bytes=30-", "If-Range": `"v0"`}, status: http.StatusOK, body: full},
+
+		{name: "reversed range", headers: map[string]string{"Range": "bytes=5-2"}, status: http.StatusOK, body: full},
+		{name: "other unit", headers: map[string]string{"Range": "items=0-1"}, status: http.StatusOK, body: full},
+		{name: "not a number", headers: map[string]string{"Range": "bytes=a-b"}, status: http.StatusOK, body: full},
+		{name: "no dash", headers: map[string]string{"Range": "bytes=5"}, status: http.StatusOK, body: full},
+		{name: "no specs", headers: map[string]string{"Range": "bytes=,"}, status: http.StatusOK, body: full},
+		{name: "overlapping ranges", headers: map[string]string{"Range": "bytes=0-20,5-25"}, status: http.StatusOK, body: full},
+		{name: "too many ranges", headers: map[string]string{"Range": "bytes=" + strings.Repeat("0-0,", maxRanges) + "1-1"}, status: http.StatusOK, body: full},
+
+		{name: "if-range etag", headers: map[string]string{"Range": "bytes=0-1", "If-Range": `"v1"`}, status: http.StatusPartialContent, body: "ab", contentRange: "bytes 0-1/26"},
+		{name: "if-range stale etag", headers: map[string]string{"Range": "bytes=0-1", "If-Range": `"v0"`}, status: http.StatusOK, body: full},
+		{name: "if-range weak etag", headers: map[string]string{"Range": "bytes=0-1", "If-Range": `W/"v1"`}, status: http.StatusOK, body: full},
+		{name: "if-range date", headers: map[string]string{"Range": "bytes=0-1", "If-Range": lastModified}, status: http.StatusPartialContent, body: "ab", contentRange: "bytes 0-1/26"},
+		{name: "if-range stale date", headers: map[string]string{"Range": "bytes=0-1", "If-Range": earlier}, status: http.StatusOK, body: full},
+
+		{name: "if-none-match", headers: map[string]string{"If-None-Match": `"v1"`}, status: http.StatusNotModified},
+		{name: "if-none-match list", headers: map[string]string{"If-None-Match": `"v0", W/"v1"`}, status: http.StatusNotModified},
+		{name: "if-none-match star", headers: map[string]string{"If-None-Match": "*"}, status: http.StatusNotModified},
+		{name: "if-none-match with range", headers: map[string]string{"If-None-Match": `"v1"`, "Range": "bytes=0-1"}, status: http.StatusNotModified},
+		{name: "if-none-match stale", headers: map[string]string{"If-None-Match": `"v0"`}, status: http.StatusOK, body: full},
+		{name: "if-none-match overrides if-modified-since", headers: map[string]string{"If-None-Match": `"v0"`, "If-Modified-Since": lastModified}, status: http.StatusOK, body: full},
+		{name: "if-modified-since", headers: map[string]string{"If-Modified-Since": lastModified}, status: http.StatusNotModified},
+		{name: "if-modified-since stale", headers: map[string]string{"If-Modified-Since": earlier}, status: http.StatusOK, body: full},
+
+		{name: "if-match", headers: map[string]string{"If-Match": `"v1"`}, status: http.StatusOK, body: full},
+		{name: "if-match stale", headers: map[string]string{"If-Match": `"v0"`}, status: http.StatusPreconditionFailed},
+		{name: "if-match weak", headers: map[string]string{"If-Match": `W/"v1"`}, status: http.StatusPreconditionFailed},
+		{name: "if-unmodified-since", headers: map[string]string{"If-Unmodified-Since": lastModified}, status: http.StatusOK, body: full},
+		{name: "if-unmodified-since stale", headers: map[string]string{"If-Unmodified-Since": earlier}, status: http.StatusPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			rec := serve(method, tt.headers)
+			header := rec.Header()
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status == http.StatusOK || tt.status == http.StatusPartialContent {
+				if got := rec.Body.String(); got != tt.body {
+					t.Errorf("body = %q, want %q", got, tt.body)
+				}
+				wantLength := len(tt.body)
+				if method == http.MethodHead {
+					wantLength = tt.length
+				}
+				if got, want := header.Get("Content-Length"), strconv.Itoa(wantLength); got != want {
+					t.Errorf("Content-Length = %q, want %q", got, want)
+				}
+				if got := header.Get("Content-Type"); got != "audio/mpeg" {
+					t.Errorf("Content-Type = %q", got)
+				}
+			}
+			if got := header.Get("Content-Range"); got != tt.contentRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.contentRange)
+			}
+			if tt.status == http.StatusNotModified {
+				if rec.Body.Len() != 0 || header.Get("Content-Length") != "" || header.Get("Content-Type") != "" {
+					t.Errorf("304 carries a body or representation headers: %v %q", header, rec.Body)
+				}
+			}
+
+			if got := header.Get("Accept-Ranges"); got != "bytes" {
+				t.Errorf("Accept-Ranges = %q", got)
+			}
+			if got := header.Get("ETag"); got != `"v1"` {
+				t.Errorf("ETag = %q", got)
+			}
+			if got := header.Get("Last-Modified"); got != lastModified {
+				t.Errorf("Last-Modified = %q, want %q", got, lastModified)
+			}
+			if got := header.Get("Cache-Control"); got != "private, max-age=60" {
+				t.Errorf("Cache-Control = %q", got)
+			}
+		})
+	}
+}
+
+func TestServeMultipart(t *testing.T) {
+	rec := serve(http.MethodGet, map[string]string{"Range": "bytes=0-1, -2"})
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusPartialContent)
+	}
+	if got, want := rec.Header().Get("Content-Length"), strconv.Itoa(rec.Body.Len()); got != want {
+		t.Errorf("Content-Length = %q, body is %s bytes", got, want)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("Content-Type = %q", rec.Header().Get("Content-Type"))
+	}
+	want := []struct{ contentRange, body string }{
+		{"bytes 0-1/26", "ab"},
+		{"bytes 24-25/26", "yz"},
+	}
+	mr := multipart.NewReader(rec.Body, params["boundary"])
+	for i, w := range want {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		body, _ := io.ReadAll(part)
+		if got := part.Header.Get("Content-Range"); got != w.contentRange {
+			t.Errorf("part %d Content-Range = %q, want %q", i, got, w.contentRange)
+		}
+		if got := part.Header.Get("Content-Type"); got != "audio/mpeg" {
+			t.Errorf("part %d Content-Type = %q", i, got)
+		}
+		if string(body) != w.body {
+			t.Errorf("part %d body = %q, want %q", i, body, w.body)
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("after the last part: %v, want EOF", err)
+	}
+
+	head := serve(http.MethodHead, map[string]string{"Range": "bytes=0-1, -2"})
+	if head.Body.Len() != 0 || head.Header().Get("Content-Length") != rec.Header().Get("Content-Length") {
+		t.Errorf("HEAD = %d bytes, Content-Length %q", head.Body.Len(), head.Header().Get("Content-Length"))
+	}
+}
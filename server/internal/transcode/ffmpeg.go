@@ -0,0 +1,74 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// FFmpeg transcodes by running a local ffmpeg binary.
+type FFmpeg struct {
+	Path string
+}
+
+func NewFFmpeg(path string) *FFmpeg {
+	return &FFmpeg{Path: path}
+}
+
+// Available reports whether the ffmpeg binary can be found.
+func (f *FFmpeg) Available() bool {
+	_, err := exec.LookPath(f.Path)
+	return err == nil
+}
+
+func (f *FFmpeg) Transcode(ctx context.Context, input, output string, profile Profile) error {
+	args := []string{
+		"-hide_banner", "-nostdin", "-loglevel", "error", "-y",
+		"-i", input,
+		"-map", "0:a:0", "-vn", "-map_metadata", "-1",
+		"-c:a", profile.Codec, "-b:a", fmt.Sprintf("%dk", profile.Bitrate),
+	}
+	if profile.Extension == ".m4a" {
+		args = append(args, "-f", "mp4", "-movflags", "+faststart")
+	}
+	args = append(args, output)
+
+	return f.run(ctx, args)
+}
+
+func (f *FFmpeg) Segment(ctx context.Context, input, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	return f.run(ctx, []string{
+		"-hide_banner", "-nostdin", "-loglevel", "error", "-y",
+		"-i", input,
+		"-map", "0:a:0", "-c:a", "copy",
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(int(HLSSegmentDuration.Seconds())),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_type", "mpegts",
+		"-hls_flags", "independent_segments",
+		"-hls_segment_filename", filepath.Join(dir, "seg_%05d.ts"),
+		filepath.Join(dir, HLSPlaylist),
+	})
+}
+
+func (f *FFmpeg) run(ctx context.Context, args []string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, f.Path, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("ffmpeg failed: %w: %s", err, msg)
+		}
+		return fmt.Errorf("ffmpeg failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package transcode
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const queueKey = "transcode:queue"
+
+type Job struct {
+	RenditionID uuid.UUID `json:"rendition_id"`
+}
+
+// Queue is a FIFO of transcode jobs stored in a Redis list.
+type Queue struct {
+	redis *redis.Client
+}
+
+func NewQueue(client *redis.Client) *Queue {
+	return &Queue{redis: client}
+}
+
+func (q *Queue) Push(ctx context.Context, job Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return q.redis.LPush(ctx, queueKey, data).Err()
+}
+
+// Pop blocks for up to timeout waiting for a job. It returns nil when the
+// timeout expires without one.
+func (q *Queue) Pop(ctx context.Context, timeout time.Duration) (*Job, error) {
+	result, err := q.redis.BRPop(ctx, timeout, queueKey).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var job Job
+	if err := json.Unmarshal([]byte(result[1]), &job); err != nil {
+		return nil, fmt.Errorf("invalid transcode job: %w", err)
+	}
+	return &job, nil
+}
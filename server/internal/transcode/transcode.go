@@ -0,0 +1,58 @@
+package transcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrUnknownProfile = errors.New("unknown quality profile")
+
+const (
+	// HLSPlaylist is the file name of the media playlist in an HLS directory.
+	HLSPlaylist = "index.m3u8"
+	// HLSSegmentDuration is the target length of HLS segments.
+	HLSSegmentDuration = 6 * time.Second
+)
+
+// HLSSegment returns the file name of the i-th segment, counting from zero.
+func HLSSegment(i int) string {
+	return fmt.Sprintf("seg_%05d.ts", i)
+}
+
+// Profile describes one rendition produced for every track.
+type Profile struct {
+	Name      string
+	Codec     string
+	Codecs    string // RFC 6381 codec string for HLS playlists
+	Bitrate   int    // kbps
+	Extension string
+	MimeType  string
+}
+
+// Profiles are ordered from the lowest to the highest bitrate.
+var Profiles = []Profile{
+	{Name: "low", Codec: "aac", Codecs: "mp4a.40.2", Bitrate: 96, Extension: ".m4a", MimeType: "audio/mp4"},
+	{Name: "medium", Codec: "aac", Codecs: "mp4a.40.2", Bitrate: 160, Extension: ".m4a", MimeType: "audio/mp4"},
+	{Name: "high", Codec: "aac", Codecs: "mp4a.40.2", Bitrate: 320, Extension: ".m4a", MimeType: "audio/mp4"},
+}
+
+func Lookup(name string) (Profile, error) {
+	for _, p := range Profiles {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return Profile{}, ErrUnknownProfile
+}
+
+// Transcoder converts audio between formats. Implementations work on local
+// files so they can wrap external tools.
+type Transcoder interface {
+	// Transcode converts the file at input into output using profile.
+	Transcode(ctx context.Context, input, output string, profile Profile) error
+	// Segment packages an already transcoded file into an HLS media playlist
+	// named HLSPlaylist and MPEG-TS segments named by HLSSegment in dir.
+	Segment(ctx context.Context, input, dir string) error
+}